@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/yangl900/log2oms/logging"
+	"github.com/yangl900/log2oms/tail"
+)
+
+const (
+	configCheckInterval = time.Second * 5
+)
+
+// agent runs the pipelines of a configuration, and replaces them when the configuration file is
+// reloaded
+type agent struct {
+	tailConfig tail.Config
+
+	mu        sync.Mutex
+	config    *config
+	pipelines []*pipeline
+	stopped   bool
+	// snapshot holds a copy of pipelines, read without waiting for reloads
+	snapshot atomic.Value
+	// running counts the pipelines enqueueing events, and reloads in progress, idle is signaled
+	// when it drops to zero
+	running int
+	idle    *sync.Cond
+}
+
+// newAgent starts the pipelines of c
+func newAgent(c *config, tailConfig tail.Config) (*agent, error) {
+	a := &agent{config: c, tailConfig: tailConfig}
+	a.idle = sync.NewCond(&a.mu)
+
+	for _, pc := range c.Pipelines {
+		p, err := newPipeline(c, pc, tailConfig)
+		if err != nil {
+			for _, p := range a.pipelines {
+				p.stop()
+			}
+			return nil, err
+		}
+
+		a.add(p)
+	}
+
+	return a, nil
+}
+
+// add counts p as running until its input is exhausted, a.mu being held
+func (a *agent) add(p *pipeline) {
+	a.pipelines = append(a.pipelines, p)
+	a.snapshot.Store(append([]*pipeline(nil), a.pipelines...))
+	a.running++
+	go func() {
+		<-p.done
+		a.mu.Lock()
+		a.release()
+		a.mu.Unlock()
+	}()
+}
+
+// release stops counting a pipeline or a reload as running, a.mu being held
+func (a *agent) release() {
+	if a.running--; a.running == 0 {
+		a.idle.Broadcast()
+	}
+}
+
+// current returns the running pipelines
+func (a *agent) current() []*pipeline {
+	pipelines, _ := a.snapshot.Load().([]*pipeline)
+	return pipelines
+}
+
+// stop stops reading the inputs of all pipelines, reloads are ignored from then on
+func (a *agent) stop() {
+	a.mu.Lock()
+	a.stopped = true
+	pipelines := a.pipelines
+	a.mu.Unlock()
+
+	for _, p := range pipelines {
+		p.in.Stop()
+	}
+}
+
+// wait waits until the inputs of all pipelines are exhausted or stopped, reloads are ignored from
+// then on
+func (a *agent) wait() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for a.running > 0 {
+		a.idle.Wait()
+	}
+	a.stopped = true
+}
+
+// close uploads the events still waiting in all pipelines, giving up when ctx is done. It fails
+// when records of any pipeline were not posted.
+func (a *agent) close(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var failed error
+	for _, p := range a.pipelines {
+		if err := p.close(ctx); err != nil {
+			logging.Errorf("%v", err)
+			failed = fmt.Errorf("Records could not be posted")
+		}
+	}
+
+	return failed
+}
+
+// reloadOnChange reloads the configuration file at path on SIGHUP, or when the file is modified
+func (a *agent) reloadOnChange(path string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	modTime := func() time.Time {
+		if info, err := os.Stat(path); err == nil {
+			return info.ModTime()
+		}
+		return time.Time{}
+	}
+	last := modTime()
+
+	for {
+		select {
+		case <-signals:
+		case <-time.After(configCheckInterval):
+			if current := modTime(); current.IsZero() || current.Equal(last) {
+				continue
+			}
+		}
+		last = modTime()
+
+		c, err := loadConfig(path)
+		if err == nil {
+			err = c.validate()
+		}
+		if err == nil {
+			err = a.reload(c)
+		}
+		if err != nil {
+			logging.Errorf("Configuration not reloaded: %v", err)
+		}
+	}
+}
+
+// reload applies the configuration c. Pipelines whose inputs or processors changed get new ones,
+// and their events already read are uploaded by the same batchers unless the outputs changed.
+// Events waiting in the batchers of a removed pipeline, or of changed outputs, are flushed first.
+// Pipelines are swapped holding the lock, and waited for and drained after releasing it so stop()
+// isn't held back by uploads.
+func (a *agent) reload(c *config) error {
+	a.mu.Lock()
+	if a.stopped {
+		a.mu.Unlock()
+		return fmt.Errorf("Stopping")
+	}
+
+	// Check processors before anything is stopped, inputs are only created once the previous
+	// ones are stopped as they may hold the same resources, e.g. a syslog port
+	processings := map[string]*processing{}
+	for _, pc := range c.Pipelines {
+		proc, err := newProcessing(pc.Processors, pc.Routes)
+		if err != nil {
+			a.mu.Unlock()
+			return fmt.Errorf("Invalid processors of pipeline '%s': %v", pc.Name, err)
+		}
+		processings[pc.Name] = proc
+	}
+
+	if c.CheckpointFile != a.config.CheckpointFile || c.DrainTimeout != a.config.DrainTimeout || c.HTTPAddress != a.config.HTTPAddress || c.OTLPEndpoint != a.config.OTLPEndpoint {
+		logging.Warnf("Changes of checkpoint_file, drain_timeout, http_address and otlp_endpoint apply after a restart")
+	}
+
+	// Keeps the reload counted as running while pipelines are replaced
+	a.running++
+	defer func() {
+		a.mu.Lock()
+		a.release()
+		a.mu.Unlock()
+	}()
+
+	notify("RELOADING=1")
+	defer notify("READY=1")
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), a.config.DrainTimeout)
+	defer cancel()
+
+	closing, replacing := a.swap(c, processings)
+	a.mu.Unlock()
+
+	a.drain(drainCtx, closing)
+	for _, p := range replacing {
+		// Acknowledges the lines enqueued so the new inputs resume after them
+		p.wait()
+		p.flush(drainCtx)
+	}
+	if len(replacing) == 0 {
+		return nil
+	}
+
+	// The pipelines whose inputs are replaced kept running with their inputs stopped, so their
+	// outputs are closed on stop meanwhile
+	a.mu.Lock()
+	var pipelines, failed []*pipeline
+	for _, p := range a.pipelines {
+		replaced := replacing[p.config.Name]
+		if replaced == nil || a.stopped {
+			pipelines = append(pipelines, p)
+			continue
+		}
+
+		if err := replaced.start(processings[replaced.config.Name], a.tailConfig); err != nil {
+			logging.Errorf("Failed to start pipeline '%s': %v", replaced.config.Name, err)
+			failed = append(failed, p)
+			continue
+		}
+		pipelines = append(pipelines, replaced)
+	}
+	a.replace(pipelines)
+	a.mu.Unlock()
+
+	a.drain(drainCtx, failed)
+
+	return nil
+}
+
+// swap replaces the pipelines by the ones of c, stopping the inputs of the pipelines changed
+// without waiting for them. It returns the stopped pipelines whose outputs are to be closed, and
+// the pipelines, by name, to start in place of the ones whose inputs only changed once their
+// outputs are flushed.
+func (a *agent) swap(c *config, processings map[string]*processing) (closing []*pipeline, replacing map[string]*pipeline) {
+	current := map[string]*pipeline{}
+	for _, p := range a.pipelines {
+		current[p.config.Name] = p
+	}
+
+	replacing = map[string]*pipeline{}
+	var pipelines []*pipeline
+	for _, pc := range c.Pipelines {
+		settings := newOutputSettings(c, pc)
+
+		p := current[pc.Name]
+		delete(current, pc.Name)
+
+		switch {
+		case p == nil:
+			logging.Infof("Adding pipeline '%s'", pc.Name)
+		case reflect.DeepEqual(p.config, pc) && reflect.DeepEqual(p.settings(), settings):
+			pipelines = append(pipelines, p)
+			continue
+		case reflect.DeepEqual(p.settings(), settings):
+			logging.Infof("Replacing inputs of pipeline '%s'", pc.Name)
+			p.in.Stop()
+			replacing[pc.Name] = &pipeline{config: pc, outputs: p.outputs, metadata: p.metadata, counters: p.counters}
+			pipelines = append(pipelines, p)
+			continue
+		default:
+			logging.Infof("Replacing pipeline '%s'", pc.Name)
+			p.in.Stop()
+			closing = append(closing, p)
+		}
+
+		created := &pipeline{config: pc}
+		err := created.open(c, settings)
+		if err == nil {
+			if err = created.start(processings[pc.Name], a.tailConfig); err != nil {
+				closing = append(closing, created)
+			}
+		}
+		if err != nil {
+			logging.Errorf("Failed to start pipeline '%s': %v", pc.Name, err)
+			continue
+		}
+
+		pipelines = append(pipelines, created)
+	}
+
+	for name, p := range current {
+		logging.Infof("Removing pipeline '%s'", name)
+		p.in.Stop()
+		closing = append(closing, p)
+	}
+
+	a.config = c
+	a.replace(pipelines)
+
+	return closing, replacing
+}
+
+// replace makes pipelines the running ones, the new ones being counted as running
+func (a *agent) replace(pipelines []*pipeline) {
+	previous := map[*pipeline]bool{}
+	for _, p := range a.pipelines {
+		previous[p] = true
+	}
+	a.pipelines = nil
+	for _, p := range pipelines {
+		if previous[p] {
+			a.pipelines = append(a.pipelines, p)
+		} else {
+			a.add(p)
+		}
+	}
+	a.snapshot.Store(append([]*pipeline(nil), a.pipelines...))
+}
+
+// drain waits until the stopped pipelines enqueued their events, and uploads the events waiting in
+// their outputs, giving up when ctx is done
+func (a *agent) drain(ctx context.Context, pipelines []*pipeline) {
+	for _, p := range pipelines {
+		p.wait()
+		if err := p.closeOutputs(ctx); err != nil {
+			logging.Errorf("%v", err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yangl900/log2oms/logging"
+	"github.com/yangl900/log2oms/tail"
+)
+
+func TestReloadStop(t *testing.T) {
+	logging.Default().Configure(logging.LevelError, logging.FormatText)
+	defer logging.Default().Configure(logging.LevelInfo, logging.FormatText)
+
+	tests := []struct {
+		name  string
+		batch batchConfig
+		// blocked is true when the removed pipeline is left blocked enqueueing in a full queue,
+		// instead of draining the events it enqueued
+		blocked bool
+	}{
+		{name: "draining", batch: batchConfig{FlushInterval: time.Hour}},
+		{name: "enqueue blocked", batch: batchConfig{MaxRecords: 1, QueueSize: 1}, blocked: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "log2oms")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "app.log")
+			if err := ioutil.WriteFile(path, []byte("first\nsecond\nthird\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// Uploads are held until released
+			posting, release := make(chan struct{}, 1), make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The empty batch posted at start checks the output
+				if body, _ := ioutil.ReadAll(r.Body); string(body) == "[]" {
+					return
+				}
+				select {
+				case posting <- struct{}{}:
+				default:
+				}
+				<-release
+			}))
+			defer server.Close()
+
+			newConfig := func(name string) *config {
+				c := &config{
+					Batch: test.batch,
+					Pipelines: []*pipelineConfig{{
+						Name:   name,
+						Inputs: inputsConfig{Files: []string{path}},
+						Output: &outputConfig{WorkspaceID: "workspace", WorkspaceSecret: "c2VjcmV0", endpoint: server.URL},
+					}},
+				}
+				if err := c.validate(); err != nil {
+					t.Fatal(err)
+				}
+				return c
+			}
+
+			a, err := newAgent(newConfig("first"), tail.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			removed := a.current()[0]
+			if !test.blocked {
+				waitEnqueued(t, removed, 3)
+			}
+
+			reloaded := make(chan error, 1)
+			go func() { reloaded <- a.reload(newConfig("second")) }()
+			select {
+			case <-posting:
+			case <-time.After(5 * time.Second):
+				t.Fatal("The removed pipeline posted nothing")
+			}
+
+			// The pipelines are swapped without waiting for the removed one
+			for deadline := time.Now().Add(5 * time.Second); a.current()[0] == removed; {
+				if time.Now().After(deadline) {
+					t.Error("The reload waited for the removed pipeline before swapping it")
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			stopped := make(chan struct{})
+			go func() {
+				a.stop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Error("Stopping waited for the reload to drain the removed pipeline")
+			}
+
+			close(release)
+			if err := <-reloaded; err != nil {
+				t.Fatal(err)
+			}
+			a.wait()
+			if err := a.close(context.Background()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestReloadInputs(t *testing.T) {
+	logging.Default().Configure(logging.LevelError, logging.FormatText)
+	defer logging.Default().Configure(logging.LevelInfo, logging.FormatText)
+
+	dir, err := ioutil.TempDir("", "log2oms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var posted int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var records []interface{}
+		if err := json.NewDecoder(r.Body).Decode(&records); err == nil {
+			atomic.AddInt64(&posted, int64(len(records)))
+		}
+	}))
+	defer server.Close()
+
+	newConfig := func(file string) *config {
+		path := filepath.Join(dir, file)
+		if err := ioutil.WriteFile(path, []byte("first\nsecond\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c := &config{
+			Pipelines: []*pipelineConfig{{
+				Name:   "default",
+				Inputs: inputsConfig{Files: []string{path}},
+				Output: &outputConfig{WorkspaceID: "workspace", WorkspaceSecret: "c2VjcmV0", endpoint: server.URL},
+			}},
+		}
+		if err := c.validate(); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	a, err := newAgent(newConfig("first.log"), tail.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := a.current()[0]
+	waitEnqueued(t, previous, 2)
+	if err := a.reload(newConfig("second.log")); err != nil {
+		t.Fatal(err)
+	}
+
+	current := a.current()
+	if len(current) != 1 || current[0] == previous || current[0].outputs[0] != previous.outputs[0] {
+		t.Fatal("Expecting the inputs to be replaced and the outputs kept")
+	}
+
+	// Counters are kept with the outputs
+	waitEnqueued(t, current[0], 4)
+	a.stop()
+	a.wait()
+	if err := a.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&posted); n != 4 {
+		t.Errorf("Posted %d records, expecting 4", n)
+	}
+}
+
+// waitEnqueued waits until p enqueued n events in its outputs
+func waitEnqueued(t *testing.T, p *pipeline, n int64) {
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt64(&p.counters.enqueued) < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Enqueued %d events, expecting %d", atomic.LoadInt64(&p.counters.enqueued), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
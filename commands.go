@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/yangl900/log2oms/logclient"
+	"github.com/yangl900/log2oms/logging"
+	"github.com/yangl900/log2oms/otlp"
+	"github.com/yangl900/log2oms/tail"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// Exit statuses, flags failing to parse exit with exitUsage too
+const (
+	// exitFailure is the status of a command that failed, e.g. logs could not be posted
+	exitFailure = 1
+	// exitUsage is the status of invalid arguments or configuration
+	exitUsage = 2
+)
+
+// exitError is an error exiting with a status of its own
+type exitError struct {
+	status int
+	err    error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// command is a subcommand of log2oms, run with the arguments following its name
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(cmd *command, args []string) error
+}
+
+func commands() []*command {
+	return []*command{
+		{"run", "[flags] [file...]", "Run the pipelines of the configuration, the default command", runCommand},
+		{"tail", "[flags] file...", "Follow files and upload their new lines with the outputs of the configuration", tailCommand},
+		{"send", "[flags] [file...]", "Upload files, or stdin, to their end and exit", sendCommand},
+		{"validate", "[flags]", "Check the configuration and the credentials of the outputs", validateCommand},
+		{"service", "install|uninstall|start|stop [flags]", "Manage the Windows service running log2oms", serviceCommand},
+		{"version", "", "Print the version", versionCommand},
+	}
+}
+
+func main() {
+	args := os.Args[1:]
+
+	// Without a command name the arguments are those of run, as before commands existed
+	cmd := commands()[0]
+	if len(args) > 0 {
+		if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			usage(os.Stdout)
+			return
+		}
+
+		for _, c := range commands() {
+			if c.name == args[0] {
+				cmd, args = c, args[1:]
+				break
+			}
+		}
+	}
+
+	if err := cmd.run(cmd, args); err != nil {
+		logging.Errorf("%v", err)
+
+		status := exitFailure
+		if e, ok := err.(*exitError); ok {
+			status = e.status
+		}
+		os.Exit(status)
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: log2oms <command> [flags] [args]\n\nCommands:\n")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-10s%s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun 'log2oms <command> --help' for the flags of a command.\n")
+}
+
+// options are the flags shared by commands
+type options struct {
+	configPath string
+	logLevel   string
+	logFormat  string
+	profiling  bool
+	pipeline   string
+	daemon     bool
+	pidFile    string
+	logFile    string
+	// messages and payload are posted by send instead of files
+	messages stringList
+	payload  string
+}
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// newFlagSet creates the flags of cmd, the flags a command does not use are not defined
+func newFlagSet(cmd *command) (*flag.FlagSet, *options) {
+	opts := &options{}
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: log2oms %s %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	if cmd.name == "version" {
+		return fs, opts
+	}
+
+	fs.StringVar(&opts.configPath, "config", "", "YAML configuration file, the configuration is read from the environment when not set")
+	fs.StringVar(&opts.logLevel, "log-level", os.Getenv(envLogLevel), "Level of the logs of log2oms: debug, info (default), warn or error")
+	fs.StringVar(&opts.logFormat, "log-format", os.Getenv(envLogFormat), "Format of the logs of log2oms: text (default) or json")
+	fs.BoolVar(&debug, "debug", envBool(envDebug), "Log requests and responses with their headers and bodies, credentials are redacted")
+	if cmd.name == "validate" {
+		return fs, opts
+	}
+
+	fs.BoolVar(&dryRun, "dry-run", envBool(envDryRun), "Print the batches instead of posting them, positions are not saved")
+	if cmd.name == "run" {
+		fs.BoolVar(&opts.profiling, "pprof", envBool(envPprof), "Serve profiles at /debug/pprof/ on the HTTP address, "+defaultPprofAddress+" when not set")
+	} else {
+		fs.StringVar(&opts.pipeline, "pipeline", "", "Pipeline of the configuration file whose processors and outputs are used, the first when not set")
+	}
+	if cmd.name == "run" || cmd.name == "tail" {
+		fs.BoolVar(&opts.daemon, "daemon", envBool(envDaemon), "Run in the background, detached from the terminal")
+		fs.StringVar(&opts.pidFile, "pid-file", os.Getenv(envPIDFile), "Write the PID to this file, failing when the instance it names is running")
+		fs.StringVar(&opts.logFile, "log-file", "", "With --daemon, append the logs of log2oms to this file instead of discarding them")
+	}
+	if cmd.name == "send" {
+		fs.Var(&opts.messages, "message", "Post this message instead of reading files, can be repeated to post several")
+		fs.StringVar(&opts.payload, "file", "", "Post the records of this JSON file, an object or an array of objects, - for stdin")
+	}
+
+	return fs, opts
+}
+
+// parse parses the flags of cmd and configures the logs of log2oms
+func parse(cmd *command, args []string) (*options, []string, error) {
+	fs, opts := newFlagSet(cmd)
+	fs.Parse(args)
+
+	level, err := logging.ParseLevel(opts.logLevel)
+	if err != nil {
+		return nil, nil, &exitError{exitUsage, err}
+	}
+	format, err := logging.ParseFormat(opts.logFormat)
+	if err != nil {
+		return nil, nil, &exitError{exitUsage, err}
+	}
+	logging.Default().Configure(level, format)
+
+	return opts, fs.Args(), nil
+}
+
+// load reads and validates the configuration, files are followed when the environment configures
+// no input
+func (opts *options) load(files []string) (*config, error) {
+	var c *config
+	var err error
+	if opts.configPath != "" {
+		c, err = loadConfig(opts.configPath)
+	} else {
+		c, err = configFromEnv(files)
+	}
+	if err == nil {
+		err = c.validate()
+	}
+	if err != nil {
+		return nil, &exitError{exitUsage, err}
+	}
+
+	return c, nil
+}
+
+// loadPipeline loads the configuration keeping only the pipeline selected with --pipeline, its
+// inputs replaced by files
+func (opts *options) loadPipeline(files []string, once bool) (*config, error) {
+	c, err := opts.load(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected *pipelineConfig
+	for _, p := range c.Pipelines {
+		if opts.pipeline == "" || p.Name == opts.pipeline {
+			selected = p
+			break
+		}
+	}
+	if selected == nil {
+		return nil, &exitError{exitUsage, fmt.Errorf("No pipeline '%s' in the configuration", opts.pipeline)}
+	}
+
+	// The files are read with the line settings of the pipeline
+	inputs := selected.Inputs
+	selected.Inputs = inputsConfig{Files: files, MaxLineSize: inputs.MaxLineSize, LinePolicy: inputs.LinePolicy, LineDelimiter: inputs.LineDelimiter, once: once}
+	c.Pipelines = []*pipelineConfig{selected}
+
+	return c, nil
+}
+
+func runCommand(cmd *command, args []string) error {
+	opts, files, err := parse(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	c, err := opts.load(files)
+	if err != nil {
+		return err
+	}
+	if started, err := opts.start(); started || err != nil {
+		return err
+	}
+	defer removePIDFile()
+
+	return runAgent(c, opts.configPath, opts.profiling)
+}
+
+// start starts the daemon when --daemon is set, returning true in the process to exit, and
+// writes the PID file
+func (opts *options) start() (bool, error) {
+	if started, err := opts.daemonize(); started || err != nil {
+		return started, err
+	}
+
+	return false, writePIDFile(opts.pidFile)
+}
+
+func tailCommand(cmd *command, args []string) error {
+	opts, files, err := parse(cmd, args)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return &exitError{exitUsage, fmt.Errorf("Expecting the files to follow")}
+	}
+
+	c, err := opts.loadPipeline(files, false)
+	if err != nil {
+		return err
+	}
+	if started, err := opts.start(); started || err != nil {
+		return err
+	}
+	defer removePIDFile()
+
+	return runAgent(c, "", false)
+}
+
+func sendCommand(cmd *command, args []string) error {
+	opts, files, err := parse(cmd, args)
+	if err != nil {
+		return err
+	}
+	if len(opts.messages) > 0 || opts.payload != "" {
+		if len(files) > 0 {
+			return &exitError{exitUsage, fmt.Errorf("Files can't be sent with --message or --file")}
+		}
+		return opts.post()
+	}
+	if len(files) == 0 {
+		files = []string{stdinPath}
+	}
+
+	c, err := opts.loadPipeline(files, true)
+	if err != nil {
+		return err
+	}
+	// Exits once the files are uploaded, nothing to serve meanwhile
+	c.HTTPAddress = ""
+
+	return runAgent(c, "", false)
+}
+
+// post posts the messages and the records of the payload file with the outputs of the selected
+// pipeline, processors are not applied
+func (opts *options) post() error {
+	var records []logclient.Record
+	for _, message := range opts.messages {
+		records = append(records, logclient.Record{"message": message})
+	}
+	if opts.payload != "" {
+		payload, err := readPayload(opts.payload)
+		if err != nil {
+			return &exitError{exitUsage, err}
+		}
+		records = append(records, payload...)
+	}
+
+	c, err := opts.loadPipeline([]string{stdinPath}, true)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.DrainTimeout)
+	defer cancel()
+
+	p := c.Pipelines[0]
+	for _, s := range newOutputSettings(c, p) {
+		logger := logging.Default().With("pipeline", p.Name).With("output", s.name)
+
+		meta, err := newMetadata(s.metadata)
+		if err != nil {
+			return &exitError{exitUsage, err}
+		}
+		client, err := newClient(c, &s.output, meta.static, logger)
+		if err != nil {
+			return &exitError{exitUsage, err}
+		}
+
+		if err := client.PostRecordsContext(ctx, records, time.Now()); err != nil {
+			return fmt.Errorf("Failed to post to output '%s': %v", s.name, err)
+		}
+	}
+
+	return nil
+}
+
+// readPayload reads the records of a JSON file holding an object or an array of objects, path
+// "-" reads stdin
+func readPayload(path string) ([]logclient.Record, error) {
+	var data []byte
+	var err error
+	if path == stdinPath {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var record logclient.Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("Invalid JSON in %s: %v", path, err)
+		}
+		return []logclient.Record{record}, nil
+	}
+
+	var records []logclient.Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("Invalid JSON in %s, expecting an object or an array of objects: %v", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("No record in %s", path)
+	}
+
+	return records, nil
+}
+
+func validateCommand(cmd *command, args []string) error {
+	opts, files, err := parse(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	c, err := opts.load(files)
+	if err != nil {
+		return err
+	}
+	for _, p := range c.Pipelines {
+		if _, err := newProcessing(p.Processors, p.Routes); err != nil {
+			return fmt.Errorf("Invalid processors of pipeline '%s': %v", p.Name, err)
+		}
+	}
+	if _, err := newMetadata(c.Metadata); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, p := range c.Pipelines {
+		for _, s := range newOutputSettings(c, p) {
+			logger := logging.Default().With("pipeline", p.Name).With("output", s.name)
+
+			meta, err := newMetadata(s.metadata)
+			if err != nil {
+				return err
+			}
+			client, err := newClient(c, &s.output, meta.static, logger)
+			if err == nil {
+				err = client.Validate(context.Background())
+			}
+			if err != nil {
+				logger.Errorf("Validation failed: %v", err)
+				failed++
+				continue
+			}
+
+			logger.Infof("Validated")
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d output(s) failed validation", failed)
+	}
+
+	logging.Infof("Configuration is valid")
+	return nil
+}
+
+func versionCommand(cmd *command, args []string) error {
+	fs, _ := newFlagSet(cmd)
+	fs.Parse(args)
+
+	fmt.Printf("log2oms %s %s %s/%s\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
+
+// runAgent runs the pipelines of c until their inputs are exhausted or a signal stops them. The
+// configuration is reloaded when configPath changes, profiling serves profiles on the HTTP address.
+// It fails when records were dropped or left unposted, e.g. so send reports failed uploads.
+func runAgent(c *config, configPath string, profiling bool) error {
+	// Templates are checked by validate, values depending on records are printed unexpanded
+	meta, _ := newMetadata(c.Metadata)
+	for m := range meta.static {
+		logging.Infof("%s = %s", m, meta.static[m])
+	}
+	for m := range meta.perRecord {
+		logging.Infof("%s = %s", m, meta.perRecord[m])
+	}
+
+	// Without a checkpoint file, checkpoints let files reopened by a reload resume where they were
+	var tailConfig tail.Config
+	var err error
+	if tailConfig.Checkpoints, err = tail.LoadCheckpoints(c.CheckpointFile); err != nil {
+		return err
+	}
+	defer tailConfig.Checkpoints.Close()
+
+	if dryRun {
+		logging.Infof("Dry run, batches are printed instead of posted")
+	}
+
+	if c.OTLPEndpoint != "" {
+		tracer = otlp.NewExporter(c.OTLPEndpoint, "log2oms", nil)
+		logging.Infof("Exporting traces to %s", c.OTLPEndpoint)
+	}
+
+	agent, err := newAgent(c, tailConfig)
+	if err != nil {
+		return err
+	}
+
+	stopping := stopOnSignal(agent.stop, tailConfig.Checkpoints, c.DrainTimeout)
+	if configPath != "" {
+		go agent.reloadOnChange(configPath)
+	}
+	go agent.reportHealth()
+	go agent.handleUserSignals(tailConfig.Checkpoints)
+	notify("READY=1")
+	if timeout := watchdogInterval(); timeout > 0 {
+		go agent.heartbeat(timeout)
+	}
+	if profiling && c.HTTPAddress == "" {
+		c.HTTPAddress = defaultPprofAddress
+	}
+	if c.HTTPAddress != "" {
+		go serveHTTP(c.HTTPAddress, agent, profiling)
+	}
+
+	agent.wait()
+	notify("STOPPING=1")
+
+	ctx := context.Background()
+	select {
+	case deadline := <-stopping:
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	default:
+	}
+
+	closeErr := agent.close(ctx)
+	if tracer != nil {
+		if err := tracer.Close(ctx); err != nil {
+			logging.Warnf("%v", err)
+		}
+	}
+	if closeErr != nil {
+		return &exitError{exitFailure, closeErr}
+	}
+
+	return nil
+}
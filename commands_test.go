@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yangl900/log2oms/logging"
+)
+
+func TestRunAgentExitStatus(t *testing.T) {
+	logging.Default().Configure(logging.LevelError, logging.FormatText)
+	defer logging.Default().Configure(logging.LevelInfo, logging.FormatText)
+
+	dir, err := ioutil.TempDir("", "log2oms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("first\nsecond\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		status int
+		// failed tells whether the agent is expected to fail
+		failed bool
+	}{
+		{"posted", http.StatusOK, false},
+		{"rejected", http.StatusBadRequest, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(ioutil.Discard, r.Body)
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+
+			c := &config{
+				Pipelines: []*pipelineConfig{{
+					Name:   "default",
+					Inputs: inputsConfig{Files: []string{path}, once: true},
+					Output: &outputConfig{WorkspaceID: "workspace", WorkspaceSecret: "c2VjcmV0", endpoint: server.URL},
+				}},
+			}
+			if err := c.validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			err := runAgent(c, "", false)
+			if !test.failed {
+				if err != nil {
+					t.Fatalf("Expecting the agent to succeed: %v", err)
+				}
+				return
+			}
+
+			e, ok := err.(*exitError)
+			if !ok || e.status != exitFailure {
+				t.Fatalf("Expecting the agent to exit with status %d, got %v", exitFailure, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,877 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	defaultLogType = "container_logs"
+
+	// defaultOutputName names the output configured by the output section
+	defaultOutputName = "default"
+)
+
+// config is the configuration of log2oms, read from the YAML file given with --config or from the
+// environment. The environment configures a single pipeline.
+type config struct {
+	// Metadata fields are added to every record, along with Hostname
+	Metadata map[string]string `yaml:"metadata"`
+	// Output is the workspace logs are sent to by the pipelines not setting their own
+	Output outputConfig `yaml:"output"`
+	// Outputs are more workspaces, by name, pipelines send logs to along with Output
+	Outputs map[string]*outputConfig `yaml:"outputs"`
+	Batch   batchConfig              `yaml:"batch"`
+	Retry   retryConfig              `yaml:"retry"`
+	// CheckpointFile remembers how far each log file was uploaded
+	CheckpointFile string `yaml:"checkpoint_file"`
+	// DrainTimeout is how long logs already read are uploaded for after SIGTERM or SIGINT
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	// HealthLogType is the log type the activity of the outputs is reported as every
+	// HealthInterval, nothing is reported when not set
+	HealthLogType  string        `yaml:"health_log_type"`
+	HealthInterval time.Duration `yaml:"health_interval"`
+	// OTLPEndpoint is the OTLP/HTTP endpoint of the collector posts are traced to
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+	// HTTPAddress is the address metrics are served on, e.g. ":9100"
+	HTTPAddress string            `yaml:"http_address"`
+	Pipelines   []*pipelineConfig `yaml:"pipelines"`
+
+	// fromFile is set when the configuration is read from a file
+	fromFile bool
+}
+
+// outputConfig is a workspace logs are sent to and how
+type outputConfig struct {
+	WorkspaceID         string `yaml:"workspace_id"`
+	WorkspaceSecret     string `yaml:"workspace_secret"`
+	WorkspaceSecretFile string `yaml:"workspace_secret_file"`
+	KeyVaultURL         string `yaml:"keyvault_url"`
+	KeyVaultSecretName  string `yaml:"keyvault_secret_name"`
+	// KeyVaultClientSecretName is the Key Vault secret holding the client secret of the service
+	// principal of AZURE_TENANT_ID and AZURE_CLIENT_ID used by Azure AD authentication
+	KeyVaultClientSecretName string `yaml:"keyvault_client_secret_name"`
+	// Auth is "aad" to authenticate with Azure AD, which only the logs ingestion API of DCEEndpoint
+	// and DCRID accepts and uses by default
+	Auth        string `yaml:"auth"`
+	DCEEndpoint string `yaml:"dce_endpoint"`
+	DCRID       string `yaml:"dcr_id"`
+	ResourceID  string `yaml:"azure_resource_id"`
+	LogType     string `yaml:"log_type"`
+	Compress    bool   `yaml:"compress"`
+
+	RateLimitRecords int64    `yaml:"rate_limit_records"`
+	RateLimitBytes   byteSize `yaml:"rate_limit_bytes"`
+	OversizePolicy   string   `yaml:"oversize_policy"`
+	MaxFieldSize     byteSize `yaml:"max_field_size"`
+
+	// Connections to the workspace, zero values keep the defaults of logclient.TransportConfig
+	KeepAlive           time.Duration `yaml:"keep_alive"`
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout"`
+
+	// endpoint replaces the URL of the workspace, for benchmarks
+	endpoint string
+}
+
+// batchConfig controls how records wait to be uploaded
+type batchConfig struct {
+	// MaxRecords, MaxBytes and FlushInterval trigger uploads, whichever is reached first
+	MaxRecords    int           `yaml:"max_records"`
+	MaxBytes      byteSize      `yaml:"max_bytes"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// Adaptive adjusts the thresholds to the latency and failures of uploads, flushing as often
+	// as every MinFlushInterval
+	Adaptive         bool          `yaml:"adaptive"`
+	MinFlushInterval time.Duration `yaml:"min_flush_interval"`
+	QueueSize        int           `yaml:"queue_size"`
+	QueuePolicy      string        `yaml:"queue_policy"`
+	Workers          int           `yaml:"workers"`
+	SpoolDir         string        `yaml:"spool_dir"`
+	SpoolMaxSize     byteSize      `yaml:"spool_max_size"`
+	DeadLetterDir    string        `yaml:"dead_letter_dir"`
+}
+
+// retryConfig overrides the default retry policy of failed uploads, zero values keep the default
+type retryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+	MaxElapsed  time.Duration `yaml:"max_elapsed"`
+	Multiplier  float64       `yaml:"multiplier"`
+	// Jitter is a pointer as 0 disables it
+	Jitter *float64 `yaml:"jitter"`
+}
+
+// pipelineConfig reads logs from inputs, processes them and sends them to an output
+type pipelineConfig struct {
+	Name       string           `yaml:"name"`
+	Inputs     inputsConfig     `yaml:"inputs"`
+	Processors processorsConfig `yaml:"processors"`
+	// Output replaces the outputs of the configuration
+	Output *outputConfig `yaml:"output"`
+	// Outputs selects by name the outputs logs are sent to, "default" being the output section.
+	// Logs are sent to all of them when not set.
+	Outputs []string `yaml:"outputs"`
+	// LogType overrides the log type of the outputs
+	LogType string `yaml:"log_type"`
+	// Routes send the records they match to other log types of the outputs
+	Routes []routeConfig `yaml:"routes"`
+	// LogTypeField is the field whose value is the log type of records not routed
+	LogTypeField string `yaml:"log_type_field"`
+
+	// targets are the outputs logs are sent to, resolved by validate
+	targets []namedOutput
+}
+
+// namedOutput is an output a pipeline sends logs to
+type namedOutput struct {
+	name   string
+	output outputConfig
+}
+
+// inputsConfig are the sources of logs of a pipeline
+type inputsConfig struct {
+	// Files are paths or glob patterns of files to follow, "-" reads stdin
+	Files      []string               `yaml:"files"`
+	Dir        string                 `yaml:"dir"`
+	DirInclude []string               `yaml:"dir_include"`
+	DirExclude []string               `yaml:"dir_exclude"`
+	Syslog     string                 `yaml:"syslog"`
+	Journal    *journalInputConfig    `yaml:"journal"`
+	Docker     *dockerInputConfig     `yaml:"docker"`
+	Kubernetes *kubernetesInputConfig `yaml:"kubernetes"`
+	EventLog   *eventLogInputConfig   `yaml:"eventlog"`
+	Forward    *forwardInputConfig    `yaml:"forward"`
+	Sidecar    *sidecarInputConfig    `yaml:"sidecar"`
+	// ScanInterval is how often file patterns and directories are scanned for new files
+	ScanInterval time.Duration `yaml:"scan_interval"`
+	// MaxLineSize bounds the lines of followed files, LinePolicy tells what happens to longer
+	// ones: truncate (default), split or drop
+	MaxLineSize byteSize `yaml:"max_line_size"`
+	LinePolicy  string   `yaml:"line_policy"`
+	// LineDelimiter ends the lines of files, stdin and pipes: lf (default), crlf, nul or a
+	// single character
+	LineDelimiter string `yaml:"line_delimiter"`
+
+	// once reads Files to their end instead of following them, for the send command
+	once bool
+}
+
+type journalInputConfig struct {
+	Units      []string `yaml:"units"`
+	CursorFile string   `yaml:"cursor_file"`
+}
+
+type dockerInputConfig struct {
+	Socket         string   `yaml:"socket"`
+	Labels         []string `yaml:"labels"`
+	MetadataLabels []string `yaml:"metadata_labels"`
+	MetadataEnv    []string `yaml:"metadata_env"`
+}
+
+type kubernetesInputConfig struct {
+	LogDir        string   `yaml:"log_dir"`
+	Namespaces    []string `yaml:"namespaces"`
+	LabelSelector string   `yaml:"label_selector"`
+	NodeName      string   `yaml:"node_name"`
+	Metadata      bool     `yaml:"metadata"`
+}
+
+type eventLogInputConfig struct {
+	Channels []string `yaml:"channels"`
+}
+
+type sidecarInputConfig struct {
+	Dir     string `yaml:"dir"`
+	Layout  string `yaml:"layout"`
+	LogType string `yaml:"log_type"`
+}
+
+type forwardInputConfig struct {
+	Address   string `yaml:"address"`
+	SharedKey string `yaml:"shared_key"`
+}
+
+// processorsConfig is how the logs of a pipeline are processed, in the order of the fields
+type processorsConfig struct {
+	Charset        string           `yaml:"charset"`
+	CharsetSources []string         `yaml:"charset_sources"`
+	StripANSI      bool             `yaml:"strip_ansi"`
+	Multiline      *multilineConfig `yaml:"multiline"`
+	JSON           bool             `yaml:"json"`
+	Logfmt         bool             `yaml:"logfmt"`
+	CSV            *csvConfig       `yaml:"csv"`
+	Regex          *patternConfig   `yaml:"regex"`
+	Grok           *patternConfig   `yaml:"grok"`
+	AccessLog      *accessLogConfig `yaml:"access_log"`
+	Timestamp      *timestampConfig `yaml:"timestamp"`
+	Severity       bool             `yaml:"severity"`
+	Include        []filterConfig   `yaml:"include"`
+	Exclude        []filterConfig   `yaml:"exclude"`
+	Sample         *sampleConfig    `yaml:"sample"`
+	DedupWindow    time.Duration    `yaml:"dedup_window"`
+	Redact         *redactConfig    `yaml:"redact"`
+}
+
+type multilineConfig struct {
+	Start   string        `yaml:"start"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type csvConfig struct {
+	Delimiter string   `yaml:"delimiter"`
+	Columns   []string `yaml:"columns"`
+	Sources   []string `yaml:"sources"`
+}
+
+// filterConfig matches logs whose text, or field once parsed, matches a regular expression and/or
+// contains a substring
+type filterConfig struct {
+	Field    string `yaml:"field"`
+	Match    string `yaml:"match"`
+	Contains string `yaml:"contains"`
+}
+
+// sampleConfig keeps a fraction of logs, all by default, with fractions by value of a field
+type sampleConfig struct {
+	Rate  *float64           `yaml:"rate"`
+	Field string             `yaml:"field"`
+	Rates map[string]float64 `yaml:"rates"`
+}
+
+// redactConfig masks sensitive data, with built-in patterns by name and custom regular expressions
+type redactConfig struct {
+	Patterns    []string `yaml:"patterns"`
+	Custom      []string `yaml:"custom"`
+	Placeholder string   `yaml:"placeholder"`
+}
+
+// patternConfig is an expression parsing the logs of some sources, all when Sources is empty
+type patternConfig struct {
+	Expr    string   `yaml:"expr"`
+	Sources []string `yaml:"sources"`
+}
+
+// accessLogConfig parses the web server access logs of some sources, all when Sources is empty
+type accessLogConfig struct {
+	Format  string   `yaml:"format"`
+	Sources []string `yaml:"sources"`
+}
+
+type timestampConfig struct {
+	Field  string `yaml:"field"`
+	Regex  string `yaml:"regex"`
+	Layout string `yaml:"layout"`
+}
+
+// routeConfig sends records whose text or field matches to a log type
+type routeConfig struct {
+	LogType string `yaml:"log_type"`
+	Field   string `yaml:"field"`
+	Match   string `yaml:"match"`
+	Value   string `yaml:"value"`
+}
+
+// byteSize is a size in bytes, written with an optional KB, MB or GB suffix
+type byteSize int64
+
+// UnmarshalYAML parses the size
+func (s *byteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+
+	size, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+
+	*s = byteSize(size)
+	return nil
+}
+
+// envReference matches ${VAR} and ${VAR:-default} in configuration files, and $$
+var envReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// loadConfig reads the configuration file at path, expanding environment variables in its values.
+// The environment variables of the shared settings override the file.
+func loadConfig(path string) (*config, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config file: %v", err)
+	}
+
+	// Values are expanded once parsed, so comments are skipped and values can't change the
+	// structure of the file
+	var tree interface{}
+	if err := yaml.UnmarshalStrict(buf, &tree); err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %v", path, err)
+	}
+	if tree, err = expandEnv(tree); err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %v", path, err)
+	}
+	text, err := yaml.Marshal(tree)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %v", path, err)
+	}
+
+	c := &config{fromFile: true}
+	if err := yaml.UnmarshalStrict(text, c); err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %v", path, err)
+	}
+
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// configFromEnv reads the configuration from the environment, args are log files to follow
+// when no input is configured
+func configFromEnv(args []string) (*config, error) {
+	c := &config{}
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	p := &pipelineConfig{LogTypeField: os.Getenv(envLogTypeField)}
+	p.Inputs = inputsConfig{
+		Files:      splitList(os.Getenv(envLogFile)),
+		Dir:        os.Getenv(envLogDir),
+		DirInclude: splitList(os.Getenv(envLogDirInclude)),
+		DirExclude: splitList(os.Getenv(envLogDirExclude)),
+		Syslog:     os.Getenv(envSyslogAddress),
+	}
+	if envBool(envJournal) {
+		p.Inputs.Journal = &journalInputConfig{Units: splitList(os.Getenv(envJournalUnits)), CursorFile: os.Getenv(envJournalCursorFile)}
+	}
+	if envBool(envDocker) {
+		p.Inputs.Docker = &dockerInputConfig{
+			Socket:         os.Getenv(envDockerSocket),
+			Labels:         splitList(os.Getenv(envDockerLabels)),
+			MetadataLabels: splitList(os.Getenv(envDockerMetadataLabels)),
+			MetadataEnv:    splitList(os.Getenv(envDockerMetadataEnv)),
+		}
+	}
+	if envBool(envKubernetes) {
+		p.Inputs.Kubernetes = &kubernetesInputConfig{
+			LogDir:        os.Getenv(envKubernetesLogDir),
+			Namespaces:    splitList(os.Getenv(envKubernetesNamespaces)),
+			LabelSelector: os.Getenv(envKubernetesLabelSelector),
+			NodeName:      os.Getenv(envNodeName),
+			Metadata:      envBool(envKubernetesMetadata),
+		}
+	}
+	if channels := splitList(os.Getenv(envEventLogChannels)); len(channels) > 0 {
+		p.Inputs.EventLog = &eventLogInputConfig{Channels: channels}
+	}
+	if dir := os.Getenv(envSidecarDir); dir != "" {
+		p.Inputs.Sidecar = &sidecarInputConfig{Dir: dir, Layout: os.Getenv(envSidecarLayout), LogType: os.Getenv(envSidecarLogType)}
+	}
+	if address := os.Getenv(envForwardAddress); address != "" {
+		p.Inputs.Forward = &forwardInputConfig{Address: address, SharedKey: os.Getenv(envForwardSharedKey)}
+	}
+	if value := os.Getenv(envScanInterval); value != "" {
+		var err error
+		if p.Inputs.ScanInterval, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("Invalid '%s': %v", envScanInterval, err)
+		}
+	}
+	if value := os.Getenv(envMaxLineSize); value != "" {
+		size, err := parseSize(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid '%s': %v", envMaxLineSize, err)
+		}
+		p.Inputs.MaxLineSize = byteSize(size)
+	}
+	p.Inputs.LinePolicy = os.Getenv(envLinePolicy)
+	p.Inputs.LineDelimiter = os.Getenv(envLineDelimiter)
+	if !p.Inputs.configured() {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("Neither '%s' environment variable nor command line parameter specified.", envLogFile)
+		}
+
+		p.Inputs.Files = args
+	}
+
+	p.Processors = processorsConfig{
+		Charset:        os.Getenv(envCharset),
+		CharsetSources: splitList(os.Getenv(envCharsetSources)),
+		StripANSI:      envBool(envStripANSI),
+		JSON:           envBool(envParseJSON),
+		Logfmt:         envBool(envParseLogfmt),
+		Severity:       envBool(envSeverity),
+	}
+	if start := os.Getenv(envMultilineStart); start != "" {
+		p.Processors.Multiline = &multilineConfig{Start: start}
+	}
+	if delimiter := os.Getenv(envCSVDelimiter); envBool(envParseCSV) || delimiter != "" {
+		p.Processors.CSV = &csvConfig{Delimiter: delimiter, Columns: splitList(os.Getenv(envCSVColumns)), Sources: splitList(os.Getenv(envCSVSources))}
+	}
+	if expr := os.Getenv(envRegex); expr != "" {
+		p.Processors.Regex = &patternConfig{Expr: expr, Sources: splitList(os.Getenv(envRegexSources))}
+	}
+	if expr := os.Getenv(envGrok); expr != "" {
+		p.Processors.Grok = &patternConfig{Expr: expr, Sources: splitList(os.Getenv(envGrokSources))}
+	}
+	if format := os.Getenv(envAccessLog); format != "" {
+		p.Processors.AccessLog = &accessLogConfig{Format: format, Sources: splitList(os.Getenv(envAccessLogSources))}
+	}
+	if field, regex := os.Getenv(envTimestampField), os.Getenv(envTimestampRegex); field != "" || regex != "" {
+		p.Processors.Timestamp = &timestampConfig{Field: field, Regex: regex, Layout: os.Getenv(envTimestampLayout)}
+	}
+	if match := os.Getenv(envInclude); match != "" {
+		p.Processors.Include = []filterConfig{{Match: match}}
+	}
+	if match := os.Getenv(envExclude); match != "" {
+		p.Processors.Exclude = []filterConfig{{Match: match}}
+	}
+	if rate, rates := os.Getenv(envSampleRate), os.Getenv(envSampleRates); rate != "" || rates != "" {
+		sample, err := sampleFromEnv(rate, rates)
+		if err != nil {
+			return nil, err
+		}
+		p.Processors.Sample = sample
+	}
+	if patterns, custom := splitList(os.Getenv(envRedact)), os.Getenv(envRedactRegex); len(patterns) > 0 || custom != "" {
+		p.Processors.Redact = &redactConfig{Patterns: patterns, Placeholder: os.Getenv(envRedactPlaceholder)}
+		if custom != "" {
+			p.Processors.Redact.Custom = []string{custom}
+		}
+	}
+	c.Pipelines = []*pipelineConfig{p}
+
+	var err error
+	if value := os.Getenv(envDedupWindow); value != "" {
+		if p.Processors.DedupWindow, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("Invalid '%s': %v", envDedupWindow, err)
+		}
+	}
+	if value := os.Getenv(envMultilineTimeout); value != "" && p.Processors.Multiline != nil {
+		if p.Processors.Multiline.Timeout, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("Invalid '%s': %v", envMultilineTimeout, err)
+		}
+	}
+
+	return c, nil
+}
+
+// sampleFromEnv parses the sampling rate and rates by key, e.g. "error=1,info=0.1"
+func sampleFromEnv(rate, rates string) (*sampleConfig, error) {
+	sample := &sampleConfig{Field: os.Getenv(envSampleField), Rates: map[string]float64{}}
+	if rate != "" {
+		value, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid '%s': %v", envSampleRate, err)
+		}
+		sample.Rate = &value
+	}
+
+	for _, item := range splitList(rates) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid '%s': expected key=rate, got '%s'", envSampleRates, item)
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid '%s': %v", envSampleRates, err)
+		}
+		sample.Rates[strings.TrimSpace(parts[0])] = value
+	}
+
+	return sample, nil
+}
+
+// applyEnv overrides the metadata, the default output and the settings shared by the pipelines
+// with the environment variables which are set, so secrets and per host values can be given to a
+// configuration file. Outputs of pipelines are not overridden.
+func (c *config) applyEnv() error {
+	if c.Metadata == nil {
+		c.Metadata = map[string]string{}
+	}
+	for _, e := range os.Environ() {
+		pair := strings.SplitN(e, "=", 2)
+		if len(pair) == 2 && strings.HasPrefix(pair[0], envMetadataPrefix) {
+			c.Metadata[strings.TrimPrefix(pair[0], envMetadataPrefix)] = pair[1]
+		}
+	}
+
+	for _, s := range []struct {
+		name  string
+		value *string
+	}{
+		{envWorkspaceID, &c.Output.WorkspaceID},
+		{envWorkspaceSecret, &c.Output.WorkspaceSecret},
+		{envWorkspaceKeyFile, &c.Output.WorkspaceSecretFile},
+		{envKeyVaultURL, &c.Output.KeyVaultURL},
+		{envKeyVaultSecret, &c.Output.KeyVaultSecretName},
+		{envKeyVaultClientSecret, &c.Output.KeyVaultClientSecretName},
+		{envAuth, &c.Output.Auth},
+		{envDCEEndpoint, &c.Output.DCEEndpoint},
+		{envDCRID, &c.Output.DCRID},
+		{envResourceID, &c.Output.ResourceID},
+		{envLogType, &c.Output.LogType},
+		{envOversizePolicy, &c.Output.OversizePolicy},
+		{envQueuePolicy, &c.Batch.QueuePolicy},
+		{envSpoolDir, &c.Batch.SpoolDir},
+		{envDeadLetterDir, &c.Batch.DeadLetterDir},
+		{envCheckpointFile, &c.CheckpointFile},
+		{envHTTPAddress, &c.HTTPAddress},
+		{envHealthLogType, &c.HealthLogType},
+		{envOTLPEndpoint, &c.OTLPEndpoint},
+	} {
+		if value := os.Getenv(s.name); value != "" {
+			*s.value = value
+		}
+	}
+
+	if os.Getenv(envCompress) != "" {
+		c.Output.Compress = envBool(envCompress)
+	}
+	if os.Getenv(envAdaptiveBatching) != "" {
+		c.Batch.Adaptive = envBool(envAdaptiveBatching)
+	}
+
+	var err error
+	for _, s := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{envDrainTimeout, &c.DrainTimeout},
+		{envHealthInterval, &c.HealthInterval},
+		{envKeepAlive, &c.Output.KeepAlive},
+		{envIdleConnTimeout, &c.Output.IdleConnTimeout},
+		{envTLSHandshakeTimeout, &c.Output.TLSHandshakeTimeout},
+	} {
+		if value := os.Getenv(s.name); value != "" {
+			if *s.value, err = time.ParseDuration(value); err != nil {
+				return fmt.Errorf("Invalid '%s': %v", s.name, err)
+			}
+		}
+	}
+
+	for _, s := range []struct {
+		name  string
+		value *byteSize
+	}{
+		{envSpoolMaxSize, &c.Batch.SpoolMaxSize},
+		{envBatchMaxBytes, &c.Batch.MaxBytes},
+		{envRateLimitBytes, &c.Output.RateLimitBytes},
+		{envMaxFieldSize, &c.Output.MaxFieldSize},
+	} {
+		if value := os.Getenv(s.name); value != "" {
+			size, err := parseSize(value)
+			if err != nil {
+				return fmt.Errorf("Invalid '%s': %v", s.name, err)
+			}
+			*s.value = byteSize(size)
+		}
+	}
+
+	if value := os.Getenv(envQueueSize); value != "" {
+		if c.Batch.QueueSize, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("Invalid '%s': %v", envQueueSize, err)
+		}
+	}
+	if value := os.Getenv(envMaxIdleConns); value != "" {
+		if c.Output.MaxIdleConns, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("Invalid '%s': %v", envMaxIdleConns, err)
+		}
+	}
+	if value := os.Getenv(envBatchMaxRecords); value != "" {
+		if c.Batch.MaxRecords, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("Invalid '%s': %v", envBatchMaxRecords, err)
+		}
+	}
+	if value := os.Getenv(envFlushInterval); value != "" {
+		if c.Batch.FlushInterval, err = time.ParseDuration(value); err != nil {
+			return fmt.Errorf("Invalid '%s': %v", envFlushInterval, err)
+		}
+	}
+	if value := os.Getenv(envMinFlushInterval); value != "" {
+		if c.Batch.MinFlushInterval, err = time.ParseDuration(value); err != nil {
+			return fmt.Errorf("Invalid '%s': %v", envMinFlushInterval, err)
+		}
+	}
+	if value := os.Getenv(envUploadWorkers); value != "" {
+		if c.Batch.Workers, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("Invalid '%s': %v", envUploadWorkers, err)
+		}
+	}
+	if value := os.Getenv(envRateLimitRecords); value != "" {
+		if c.Output.RateLimitRecords, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("Invalid '%s': %v", envRateLimitRecords, err)
+		}
+	}
+
+	return nil
+}
+
+// expandEnv replaces ${VAR} in the string values of a parsed configuration file with the value of
+// the environment variable, or with default for ${VAR:-default} when it is not set. $$ is a
+// literal $. Keys are left as is.
+func expandEnv(value interface{}) (interface{}, error) {
+	var err error
+	switch v := value.(type) {
+	case string:
+		if !strings.Contains(v, "$") {
+			return v, nil
+		}
+		var expanded string
+		if expanded, err = expandString(v); err != nil {
+			return nil, err
+		}
+		return envValue(expanded), nil
+	case map[interface{}]interface{}:
+		for key, item := range v {
+			if v[key], err = expandEnv(item); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if v[i], err = expandEnv(item); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return value, nil
+}
+
+// envValue types an expanded value as YAML does a plain scalar, so e.g. workers: ${WORKERS} is
+// still a number. It stays a string when typing it would change its text, e.g. 0x1F or yes.
+func envValue(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+	switch v.(type) {
+	case int, int64, uint64, float64, bool:
+		if text, err := yaml.Marshal(v); err == nil && strings.TrimSpace(string(text)) == s {
+			return v
+		}
+	}
+
+	return s
+}
+
+// expandString replaces the references to environment variables of a value
+func expandString(text string) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(text, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+
+		match := envReference.FindStringSubmatch(ref)
+		if value, ok := os.LookupEnv(match[1]); ok {
+			return value
+		}
+		if strings.Contains(ref, ":-") {
+			return match[2]
+		}
+
+		if err == nil {
+			err = fmt.Errorf("Environment variable %s is not set", match[1])
+		}
+		return ""
+	})
+
+	return expanded, err
+}
+
+// validate checks the configuration and fills in defaults
+func (c *config) validate() error {
+	if c.Metadata == nil {
+		c.Metadata = map[string]string{}
+	}
+	if _, ok := c.Metadata["Hostname"]; !ok {
+		c.Metadata["Hostname"], _ = os.Hostname()
+	}
+	if _, err := newMetadata(c.Metadata); err != nil {
+		return err
+	}
+
+	if c.DrainTimeout <= 0 {
+		c.DrainTimeout = defaultDrainTimeout
+	}
+	if c.Batch.MaxRecords <= 0 {
+		c.Batch.MaxRecords = batchSizeInLines
+	}
+	if c.Batch.MaxBytes <= 0 {
+		c.Batch.MaxBytes = byteSize(requestSizeLimit)
+	}
+	if c.Batch.FlushInterval <= 0 {
+		c.Batch.FlushInterval = flushInterval
+	}
+	if c.Batch.SpoolMaxSize <= 0 {
+		c.Batch.SpoolMaxSize = byteSize(defaultSpoolMaxSize)
+	}
+	if c.Retry.Multiplier != 0 && c.Retry.Multiplier < 1 {
+		return fmt.Errorf("Invalid retry multiplier %v, expecting 1 or more", c.Retry.Multiplier)
+	}
+	if c.Retry.Jitter != nil && (*c.Retry.Jitter < 0 || *c.Retry.Jitter > 1) {
+		return fmt.Errorf("Invalid retry jitter %v, expecting 0 to 1", *c.Retry.Jitter)
+	}
+	if dryRun {
+		// Lines printed are not uploaded, positions are not saved and spooled batches are kept
+		c.CheckpointFile, c.Batch.SpoolDir, c.Batch.DeadLetterDir = "", "", ""
+	}
+
+	if len(c.Pipelines) == 0 {
+		return fmt.Errorf("No pipeline configured")
+	}
+
+	names := map[string]bool{}
+	for i, p := range c.Pipelines {
+		if p.Name == "" {
+			p.Name = "default"
+			if len(c.Pipelines) > 1 {
+				p.Name = fmt.Sprintf("pipeline%d", i+1)
+			}
+		}
+		if names[p.Name] {
+			return fmt.Errorf("Pipeline name '%s' is used twice", p.Name)
+		}
+		names[p.Name] = true
+
+		if !p.Inputs.configured() {
+			return fmt.Errorf("No input configured for pipeline '%s'", p.Name)
+		}
+		if dryRun && p.Inputs.Journal != nil {
+			p.Inputs.Journal.CursorFile = ""
+		}
+
+		for _, route := range p.Routes {
+			if route.LogType == "" {
+				return fmt.Errorf("Route without log_type in pipeline '%s'", p.Name)
+			}
+			if route.Match == "" && route.Value == "" {
+				return fmt.Errorf("Route to '%s' in pipeline '%s' has neither match nor value", route.LogType, p.Name)
+			}
+		}
+
+		if err := c.resolveOutputs(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveOutputs sets the outputs pipeline p sends logs to
+func (c *config) resolveOutputs(p *pipelineConfig) error {
+	p.targets = nil
+
+	switch {
+	case p.Output != nil:
+		p.targets = append(p.targets, namedOutput{name: defaultOutputName, output: *p.Output})
+	case len(p.Outputs) > 0:
+		for _, name := range p.Outputs {
+			if name == defaultOutputName {
+				p.targets = append(p.targets, namedOutput{name: name, output: c.Output})
+				continue
+			}
+
+			output, ok := c.Outputs[name]
+			if !ok || output == nil {
+				return fmt.Errorf("Unknown output '%s' in pipeline '%s'", name, p.Name)
+			}
+			p.targets = append(p.targets, namedOutput{name: name, output: *output})
+		}
+	default:
+		if c.Output.configured() || len(c.Outputs) == 0 {
+			p.targets = append(p.targets, namedOutput{name: defaultOutputName, output: c.Output})
+		}
+
+		names := make([]string, 0, len(c.Outputs))
+		for name := range c.Outputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if c.Outputs[name] != nil {
+				p.targets = append(p.targets, namedOutput{name: name, output: *c.Outputs[name]})
+			}
+		}
+	}
+
+	for i := range p.targets {
+		target := &p.targets[i]
+		if p.LogType != "" {
+			target.output.LogType = p.LogType
+		}
+		if target.output.LogType == "" {
+			target.output.LogType = defaultLogType
+		}
+
+		if err := target.output.validate(); err != nil {
+			return fmt.Errorf("Invalid output '%s' of pipeline '%s': %v", target.name, p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// validate checks the output is a workspace with credentials, which a dry run doesn't need
+func (o *outputConfig) validate() error {
+	o.Auth = strings.ToLower(o.Auth)
+	ingestion := o.DCEEndpoint != "" && o.DCRID != ""
+	switch {
+	case (o.DCEEndpoint != "") != (o.DCRID != ""):
+		return fmt.Errorf("Logs ingestion API requires both '%s' and '%s'", envDCEEndpoint, envDCRID)
+	case ingestion:
+		o.Auth = authAAD
+	case o.Auth == authAAD:
+		return fmt.Errorf("Azure AD authentication requires the logs ingestion API, set '%s' and '%s', the data collector API only accepts the workspace secret", envDCEEndpoint, envDCRID)
+	}
+	if dryRun {
+		return nil
+	}
+
+	hasKey := o.WorkspaceSecret != "" || o.WorkspaceSecretFile != "" || (o.KeyVaultURL != "" && o.KeyVaultSecretName != "")
+	if !ingestion && (o.WorkspaceID == "" || !hasKey) {
+		return fmt.Errorf("Workspace Id and secret not defined in environment variable '%s' and '%s' or in the config file", envWorkspaceID, envWorkspaceSecret)
+	}
+
+	if o.KeyVaultClientSecretName != "" {
+		switch {
+		case o.KeyVaultURL == "":
+			return fmt.Errorf("Client secret in Key Vault requires '%s'", envKeyVaultURL)
+		case o.Auth != authAAD:
+			return fmt.Errorf("Client secret in Key Vault is only used by Azure AD authentication, set '%s' to %s", envAuth, authAAD)
+		case os.Getenv("AZURE_TENANT_ID") == "" || os.Getenv("AZURE_CLIENT_ID") == "":
+			return fmt.Errorf("Client secret in Key Vault requires the service principal of 'AZURE_TENANT_ID' and 'AZURE_CLIENT_ID'")
+		}
+	}
+
+	return nil
+}
+
+// configured tells whether a workspace or data collection rule is set
+func (o *outputConfig) configured() bool {
+	return o.WorkspaceID != "" || o.DCRID != ""
+}
+
+// configured tells whether any input is configured
+func (c *inputsConfig) configured() bool {
+	return len(c.Files) > 0 || c.Dir != "" || c.Syslog != "" || c.Journal != nil || c.Docker != nil || c.Kubernetes != nil || c.EventLog != nil || c.Forward != nil || c.Sidecar != nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		output outputConfig
+		valid  bool
+		auth   string
+	}{
+		{"workspace key", outputConfig{WorkspaceID: "w", WorkspaceSecret: "s"}, true, ""},
+		{"workspace without key", outputConfig{WorkspaceID: "w"}, false, ""},
+		{"aad with workspace", outputConfig{WorkspaceID: "w", Auth: "AAD"}, false, ""},
+		{"aad with ingestion API", outputConfig{Auth: "aad", DCEEndpoint: "https://dce", DCRID: "dcr"}, true, authAAD},
+		{"ingestion API", outputConfig{DCEEndpoint: "https://dce", DCRID: "dcr"}, true, authAAD},
+		{"rule without endpoint", outputConfig{DCRID: "dcr"}, false, ""},
+		{"endpoint without rule", outputConfig{WorkspaceID: "w", WorkspaceSecret: "s", DCEEndpoint: "https://dce"}, false, ""},
+		{"client secret in Key Vault", outputConfig{DCEEndpoint: "https://dce", DCRID: "dcr", KeyVaultURL: "https://vault", KeyVaultClientSecretName: "sp"}, true, authAAD},
+		{"client secret without Key Vault", outputConfig{DCEEndpoint: "https://dce", DCRID: "dcr", KeyVaultClientSecretName: "sp"}, false, ""},
+		{"client secret with workspace key", outputConfig{WorkspaceID: "w", WorkspaceSecret: "s", KeyVaultURL: "https://vault", KeyVaultClientSecretName: "sp"}, false, ""},
+	}
+	os.Setenv("AZURE_TENANT_ID", "tenant")
+	os.Setenv("AZURE_CLIENT_ID", "client")
+	defer os.Unsetenv("AZURE_TENANT_ID")
+	defer os.Unsetenv("AZURE_CLIENT_ID")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.output.validate()
+			if test.valid && err != nil {
+				t.Fatalf("Expecting the output to be valid: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("Expecting the output to be rejected")
+			}
+			if test.valid && test.output.Auth != test.auth {
+				t.Errorf("Auth is %q, expecting %q", test.output.Auth, test.auth)
+			}
+		})
+	}
+}
+
+func TestRetryConfigValidate(t *testing.T) {
+	zero, half, over := 0.0, 0.5, 1.5
+	tests := []struct {
+		name  string
+		retry retryConfig
+		valid bool
+	}{
+		{"defaults", retryConfig{}, true},
+		{"multiplier", retryConfig{Multiplier: 1.5}, true},
+		{"multiplier below 1", retryConfig{Multiplier: 0.5}, false},
+		{"jitter disabled", retryConfig{Jitter: &zero}, true},
+		{"jitter", retryConfig{Jitter: &half}, true},
+		{"jitter above 1", retryConfig{Jitter: &over}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := config{
+				Output:    outputConfig{WorkspaceID: "w", WorkspaceSecret: "s"},
+				Pipelines: []*pipelineConfig{{Name: "default", Inputs: inputsConfig{Files: []string{"app.log"}}}},
+				Retry:     test.retry,
+			}
+			err := c.validate()
+			if test.valid && err != nil {
+				t.Fatalf("Expecting the retry policy to be valid: %v", err)
+			}
+			if !test.valid && (err == nil || !strings.Contains(err.Error(), "Invalid retry")) {
+				t.Fatalf("Expecting the retry policy to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	os.Setenv("L2O_TEST_SECRET", "a: b # c\nd")
+	os.Setenv("L2O_TEST_WORKERS", "4")
+	os.Setenv("L2O_TEST_ADAPTIVE", "true")
+	os.Setenv("L2O_TEST_HEX", "0x1F")
+	defer func() {
+		for _, name := range []string{"L2O_TEST_SECRET", "L2O_TEST_WORKERS", "L2O_TEST_ADAPTIVE", "L2O_TEST_HEX"} {
+			os.Unsetenv(name)
+		}
+	}()
+
+	tests := []struct {
+		name string
+		yaml string
+		// check returns what is wrong with the configuration loaded, "" when it is expected
+		check func(c *config) string
+		// err is a substring of the error expected, "" when loading succeeds
+		err string
+	}{
+		{
+			name:  "special characters",
+			yaml:  "output:\n  workspace_secret: ${L2O_TEST_SECRET}\n",
+			check: func(c *config) string { return expect(c.Output.WorkspaceSecret, "a: b # c\nd") },
+		},
+		{
+			name:  "number and boolean",
+			yaml:  "batch:\n  workers: ${L2O_TEST_WORKERS}\n  adaptive: ${L2O_TEST_ADAPTIVE}\n",
+			check: func(c *config) string { return expect(c.Batch.Workers, 4) + expect(c.Batch.Adaptive, true) },
+		},
+		{
+			name: "number as string",
+			yaml: "output:\n  workspace_id: ${L2O_TEST_WORKERS}\n  workspace_secret: ${L2O_TEST_HEX}\n",
+			check: func(c *config) string {
+				return expect(c.Output.WorkspaceID, "4") + expect(c.Output.WorkspaceSecret, "0x1F")
+			},
+		},
+		{
+			name: "default and literal",
+			yaml: "output:\n  workspace_id: ${L2O_TEST_UNSET:-fallback}\n  workspace_secret: \"$${L2O_TEST_WORKERS}-$$\"\n",
+			check: func(c *config) string {
+				return expect(c.Output.WorkspaceID, "fallback") + expect(c.Output.WorkspaceSecret, "${L2O_TEST_WORKERS}-$")
+			},
+		},
+		{
+			name:  "comment",
+			yaml:  "# workspace_id: ${L2O_TEST_UNSET}\noutput:\n  workspace_id: w # ${L2O_TEST_UNSET}\n",
+			check: func(c *config) string { return expect(c.Output.WorkspaceID, "w") },
+		},
+		{
+			name:  "key",
+			yaml:  "metadata:\n  ${L2O_TEST_WORKERS}: ${L2O_TEST_WORKERS}\n",
+			check: func(c *config) string { return expect(c.Metadata["${L2O_TEST_WORKERS}"], "4") },
+		},
+		{
+			name: "unset",
+			yaml: "output:\n  workspace_id: ${L2O_TEST_UNSET}\n",
+			err:  "L2O_TEST_UNSET is not set",
+		},
+		{
+			name: "invalid number",
+			yaml: "batch:\n  workers: ${L2O_TEST_SECRET}\n",
+			err:  "cannot unmarshal",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "log2oms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("%d.yaml", i))
+			if err := ioutil.WriteFile(path, []byte(test.yaml), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := loadConfig(path)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expecting an error with %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wrong := test.check(c); wrong != "" {
+				t.Error(wrong)
+			}
+		})
+	}
+}
+
+// expect describes how value differs from expected, "" when they are equal
+func expect(value, expected interface{}) string {
+	if value != expected {
+		return fmt.Sprintf("Got %#v, expecting %#v. ", value, expected)
+	}
+
+	return ""
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yangl900/log2oms/logging"
+)
+
+const (
+	// envDaemonized is set in the environment of the process started by --daemon
+	envDaemonized = "LOG2OMS_DAEMONIZED"
+)
+
+var (
+	// pidFile is the path of the PID file written by this process, removed on exit
+	pidFile   string
+	pidFileMu sync.Mutex
+)
+
+// daemonize starts log2oms again in the background with the same arguments when --daemon is
+// set, it returns true in the process started from the terminal, which then exits
+func (opts *options) daemonize() (bool, error) {
+	if !opts.daemon || os.Getenv(envDaemonized) != "" {
+		return false, nil
+	}
+
+	// Fails before detaching, so the error is seen
+	if pid := runningPID(opts.pidFile); pid != 0 {
+		return false, &exitError{exitFailure, fmt.Errorf("log2oms is already running with pid %d, see %s", pid, opts.pidFile)}
+	}
+
+	pid, err := startDaemon(opts.logFile)
+	if err != nil {
+		return false, err
+	}
+
+	logging.Infof("Started log2oms in the background with pid %d", pid)
+	return true, nil
+}
+
+// writePIDFile writes the PID of the process to path, failing when the PID file of a running
+// instance exists. A PID file left by a process which is gone is replaced.
+func writePIDFile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			if pid := runningPID(path); pid != 0 {
+				return &exitError{exitFailure, fmt.Errorf("log2oms is already running with pid %d, see %s", pid, path)}
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+			return err
+		}
+
+		pidFileMu.Lock()
+		pidFile = path
+		pidFileMu.Unlock()
+		return nil
+	}
+}
+
+// removePIDFile removes the PID file written by this process, if any
+func removePIDFile() {
+	pidFileMu.Lock()
+	defer pidFileMu.Unlock()
+
+	if pidFile != "" {
+		os.Remove(pidFile)
+		pidFile = ""
+	}
+}
+
+// runningPID returns the PID in the file at path when that process is running, zero otherwise
+func runningPID(path string) int {
+	if path == "" {
+		return 0
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 || pid == os.Getpid() || !processExists(pid) {
+		return 0
+	}
+
+	return pid
+}
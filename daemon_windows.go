@@ -0,0 +1,25 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// startDaemon fails, log2oms runs in the background on Windows as a service
+func startDaemon(logFile string) (int, error) {
+	return 0, fmt.Errorf("Daemon mode is not supported on Windows, install log2oms as a service")
+}
+
+// processExists tells whether a process with pid runs
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	p.Release()
+	return true
+}
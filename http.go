@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/yangl900/log2oms/logging"
+	"github.com/yangl900/log2oms/metrics"
+)
+
+const (
+	// spoolPressureLimit is the usage of a spool from which the agent is not ready
+	spoolPressureLimit = 0.9
+	// uploadStallTimeout is how long records may wait to be retried without any successful upload
+	// before the agent is not ready
+	uploadStallTimeout = time.Minute * 5
+)
+
+var (
+	// latencyBounds are the buckets of the delivery latency, in seconds, from under the default
+	// flush interval to the time retries take
+	latencyBounds = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+	// batchSizeBounds are the buckets of the records per batch, up to the default batch size
+	batchSizeBounds = metrics.ExponentialBounds(1, 10, 6)
+)
+
+// healthReport is the status of the agent served by /healthz and /readyz
+type healthReport struct {
+	// Live is false when the input of a pipeline stopped while the agent is running
+	Live bool `json:"live"`
+	// Ready is false when an output cannot keep up, its spool is almost full or its uploads
+	// keep failing
+	Ready     bool             `json:"ready"`
+	Pipelines []pipelineHealth `json:"pipelines"`
+}
+
+type pipelineHealth struct {
+	Name      string         `json:"name"`
+	Running   bool           `json:"running"`
+	LinesRead int64          `json:"lines_read"`
+	Outputs   []outputHealth `json:"outputs"`
+}
+
+type outputHealth struct {
+	Name         string     `json:"name"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	RetryRecords int        `json:"retry_records"`
+	SpoolBytes   int64      `json:"spool_bytes"`
+	SpoolUsage   float64    `json:"spool_usage"`
+	Ready        bool       `json:"ready"`
+}
+
+// serveHTTP serves the metrics and health of the agent on address, and the profiles of
+// net/http/pprof when profiling. It returns when the server fails.
+func serveHTTP(address string, a *agent, profiling bool) {
+	registry := metrics.NewRegistry()
+	registry.Register(a.metrics)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		report := a.health()
+		writeHealth(w, report, report.Live)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		report := a.health()
+		writeHealth(w, report, report.Live && report.Ready)
+	})
+
+	if profiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		logging.Infof("Serving profiles on %s/debug/pprof/", address)
+	}
+
+	logging.Infof("Serving metrics and health on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logging.Errorf("Failed to serve HTTP: %v", err)
+	}
+}
+
+// writeHealth writes the report, with status 503 when not ok
+func writeHealth(w http.ResponseWriter, report healthReport, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+// health reports whether the inputs of the pipelines are running, and whether their outputs keep up
+func (a *agent) health() healthReport {
+	a.mu.Lock()
+	stopped := a.stopped
+	a.mu.Unlock()
+
+	report := healthReport{Live: true, Ready: true}
+	for _, p := range a.current() {
+		ph := pipelineHealth{Name: p.config.Name, Running: true, LinesRead: atomic.LoadInt64(&p.counters.read)}
+		select {
+		case <-p.done:
+			ph.Running = false
+		default:
+		}
+		// Inputs are expected to stop once the agent is stopping
+		if !ph.Running && !stopped {
+			report.Live = false
+		}
+
+		for _, output := range p.outputs {
+			stats, state := output.client.Stats(), output.batcher.RetryState()
+			oh := outputHealth{Name: output.settings.name, RetryRecords: state.Records, SpoolBytes: state.Bytes, Ready: true}
+			if !stats.LastSuccess.IsZero() {
+				oh.LastSuccess = &stats.LastSuccess
+			}
+			if stats.LastError != nil && stats.LastErrorTime.After(stats.LastSuccess) {
+				oh.LastError = stats.LastError.Error()
+			}
+			if max := int64(output.settings.batch.SpoolMaxSize); output.settings.spoolDir != "" && max > 0 {
+				oh.SpoolUsage = float64(state.Bytes) / float64(max)
+			}
+
+			lastSuccess := output.opened
+			if stats.LastSuccess.After(lastSuccess) {
+				lastSuccess = stats.LastSuccess
+			}
+			stalled := state.Records > 0 && time.Since(lastSuccess) > uploadStallTimeout
+			if oh.SpoolUsage >= spoolPressureLimit || stalled {
+				oh.Ready = false
+				report.Ready = false
+			}
+
+			ph.Outputs = append(ph.Outputs, oh)
+		}
+
+		report.Pipelines = append(report.Pipelines, ph)
+	}
+
+	return report
+}
+
+// metrics returns the metrics of the pipelines of the agent, labelled with the names of pipelines
+// and outputs
+func (a *agent) metrics() []metrics.Metric {
+	read := metrics.Metric{Name: "log2oms_lines_read_total", Help: "Lines read by the inputs, before they are processed.", Type: metrics.Counter}
+	enqueued := metrics.Metric{Name: "log2oms_records_enqueued_total", Help: "Records enqueued for upload, after they are processed.", Type: metrics.Counter}
+	sent := metrics.Metric{Name: "log2oms_records_sent_total", Help: "Records uploaded successfully.", Type: metrics.Counter}
+	failed := metrics.Metric{Name: "log2oms_records_failed_total", Help: "Records of uploads which failed, counted again when retried.", Type: metrics.Counter}
+	bytes := metrics.Metric{Name: "log2oms_bytes_sent_total", Help: "Bytes of the requests uploaded successfully.", Type: metrics.Counter}
+	retries := metrics.Metric{Name: "log2oms_retries_total", Help: "Requests retried after a failure.", Type: metrics.Counter}
+	responses := metrics.Metric{Name: "log2oms_responses_total", Help: "Responses by HTTP status code, 0 for requests failed without response.", Type: metrics.Counter}
+	dropped := metrics.Metric{Name: "log2oms_records_dropped_total", Help: "Records given up because a queue was full or the failure was not retryable.", Type: metrics.Counter}
+	oversized := metrics.Metric{Name: "log2oms_records_oversized_total", Help: "Records with a field over the size limit, by action taken.", Type: metrics.Counter}
+	queued := metrics.Metric{Name: "log2oms_queue_records", Help: "Records waiting for the next upload.", Type: metrics.Gauge}
+	retrying := metrics.Metric{Name: "log2oms_retry_records", Help: "Records of failed uploads waiting to be retried, in memory or spooled.", Type: metrics.Gauge}
+	latency := metrics.Metric{Name: "log2oms_delivery_latency_seconds", Help: "Time records take from being read and processed to being uploaded successfully.", Type: metrics.Histogram}
+	batchSize := metrics.Metric{Name: "log2oms_batch_records", Help: "Records per batch uploaded successfully.", Type: metrics.Histogram}
+
+	for _, p := range a.current() {
+		labels := []metrics.Label{{Name: "pipeline", Value: p.config.Name}}
+		read.Samples = append(read.Samples, metrics.Sample{Labels: labels, Value: float64(atomic.LoadInt64(&p.counters.read))})
+		enqueued.Samples = append(enqueued.Samples, metrics.Sample{Labels: labels, Value: float64(atomic.LoadInt64(&p.counters.enqueued))})
+
+		for _, output := range p.outputs {
+			labels := []metrics.Label{{Name: "pipeline", Value: p.config.Name}, {Name: "output", Value: output.settings.name}}
+			stats, oversize := output.batcher.Stats(), output.client.OversizedRecords()
+
+			sent.Samples = append(sent.Samples, metrics.Sample{Labels: labels, Value: float64(stats.Records)})
+			failed.Samples = append(failed.Samples, metrics.Sample{Labels: labels, Value: float64(stats.Failed)})
+			bytes.Samples = append(bytes.Samples, metrics.Sample{Labels: labels, Value: float64(stats.Bytes)})
+			retries.Samples = append(retries.Samples, metrics.Sample{Labels: labels, Value: float64(stats.Retries)})
+			for code, n := range stats.Responses {
+				codeLabels := append(labels[:2:2], metrics.Label{Name: "code", Value: strconv.Itoa(code)})
+				responses.Samples = append(responses.Samples, metrics.Sample{Labels: codeLabels, Value: float64(n)})
+			}
+			dropped.Samples = append(dropped.Samples, metrics.Sample{Labels: labels, Value: float64(stats.Dropped)})
+			for _, action := range []struct {
+				name  string
+				count int64
+			}{{"truncated", oversize.Truncated}, {"split", oversize.Split}, {"dropped", oversize.Dropped}} {
+				actionLabels := append(labels[:2:2], metrics.Label{Name: "action", Value: action.name})
+				oversized.Samples = append(oversized.Samples, metrics.Sample{Labels: actionLabels, Value: float64(action.count)})
+			}
+			queued.Samples = append(queued.Samples, metrics.Sample{Labels: labels, Value: float64(stats.Queued)})
+			retrying.Samples = append(retrying.Samples, metrics.Sample{Labels: labels, Value: float64(stats.Retrying)})
+			latency.Samples = append(latency.Samples, output.latency.Sample(labels))
+			batchSize.Samples = append(batchSize.Samples, output.batchSize.Sample(labels))
+		}
+	}
+
+	return []metrics.Metric{read, enqueued, sent, failed, bytes, retries, responses, dropped, oversized, queued, retrying, latency, batchSize}
+}
@@ -0,0 +1,363 @@
+package input
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDockerSocket   = "/var/run/docker.sock"
+	dockerAPIVersion      = "v1.24"
+	containerScanInterval = time.Second * 10
+)
+
+// DockerConfig selects the containers whose logs DockerInput ships
+type DockerConfig struct {
+	// Socket is the path of the docker daemon unix socket, defaults to DOCKER_HOST when it is a
+	// unix socket, or /var/run/docker.sock
+	Socket string
+	// Labels limits containers to those having all these labels, either "key" or "key=value"
+	Labels []string
+	// MetadataLabels are the labels added to the logs of a container, as fields named after them
+	// with characters other than letters, digits and underscores replaced, e.g. "com.example.team"
+	// as com_example_team. Names can use filepath.Match wildcards, e.g. "com.example.*".
+	MetadataLabels []string
+	// MetadataEnv are the environment variables of a container added to its logs, as fields of the
+	// same names, wildcards as MetadataLabels. Only list variables that hold no secrets.
+	MetadataEnv []string
+}
+
+// dockerContainer is the part of the container list response DockerInput uses
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// DockerInput ships the stdout and stderr of running containers through the docker daemon API.
+// Running containers are listed every 10 seconds, new ones are attached to from the time they
+// are found, and containers that restart are resumed where they stopped. Logs of containers
+// started by docker compose carry their project and service.
+type DockerInput struct {
+	config DockerConfig
+	client *http.Client
+	events chan *Event
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	attached map[string]bool
+	since    map[string]time.Time
+}
+
+// NewDockerInput starts shipping container logs, it fails when the daemon is not reachable
+func NewDockerInput(config DockerConfig) (*DockerInput, error) {
+	if config.Socket == "" {
+		config.Socket = defaultDockerSocket
+		if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, "unix://") {
+			config.Socket = strings.TrimPrefix(host, "unix://")
+		}
+	}
+
+	socket := config.Socket
+	in := &DockerInput{
+		config: config,
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}},
+		events:   make(chan *Event),
+		attached: map[string]bool{},
+		since:    map[string]time.Time{},
+	}
+	in.ctx, in.cancel = context.WithCancel(context.Background())
+
+	containers, err := in.list()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list containers from docker daemon at %s: %v", socket, err)
+	}
+	in.attach(containers)
+
+	in.wg.Add(1)
+	go in.scan()
+
+	go func() {
+		<-in.ctx.Done()
+		in.wg.Wait()
+		close(in.events)
+	}()
+
+	return in, nil
+}
+
+// Events returns the channel events are delivered on
+func (in *DockerInput) Events() <-chan *Event {
+	return in.events
+}
+
+// Stop detaches from all containers
+func (in *DockerInput) Stop() {
+	in.cancel()
+}
+
+func (in *DockerInput) get(path string, query url.Values) (*http.Response, error) {
+	req, _ := http.NewRequest(http.MethodGet, "http://docker/"+dockerAPIVersion+path+"?"+query.Encode(), nil)
+	response, err := in.client.Do(req.WithContext(in.ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		buf, _ := ioutil.ReadAll(response.Body)
+		return nil, fmt.Errorf("Docker request %s failed with status: %d %s", path, response.StatusCode, strings.TrimSpace(string(buf)))
+	}
+
+	return response, nil
+}
+
+// list returns the running containers matching the label filters
+func (in *DockerInput) list() ([]dockerContainer, error) {
+	query := url.Values{}
+	if len(in.config.Labels) > 0 {
+		filters, _ := json.Marshal(map[string][]string{"label": in.config.Labels})
+		query.Set("filters", string(filters))
+	}
+
+	response, err := in.get("/containers/json", query)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var containers []dockerContainer
+	if err := json.NewDecoder(response.Body).Decode(&containers); err != nil {
+		return nil, err
+	}
+
+	return containers, nil
+}
+
+func (in *DockerInput) scan() {
+	defer in.wg.Done()
+
+	for {
+		select {
+		case <-in.ctx.Done():
+			return
+		case <-time.After(containerScanInterval):
+		}
+
+		containers, err := in.list()
+		if err != nil {
+			in.send(&Event{Time: time.Now(), Source: "docker", Err: fmt.Errorf("Failed to list containers: %v", err)})
+			continue
+		}
+
+		in.attach(containers)
+	}
+}
+
+// attach starts streaming the logs of containers not streamed yet
+func (in *DockerInput) attach(containers []dockerContainer) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	for _, c := range containers {
+		if in.attached[c.ID] {
+			continue
+		}
+
+		since, ok := in.since[c.ID]
+		if !ok {
+			since = time.Now()
+		}
+
+		in.attached[c.ID] = true
+		in.wg.Add(1)
+		go in.stream(c, since)
+	}
+}
+
+// stream ships the logs of a container until it stops
+func (in *DockerInput) stream(c dockerContainer, since time.Time) {
+	defer in.wg.Done()
+
+	last := since
+	defer func() {
+		in.mu.Lock()
+		delete(in.attached, c.ID)
+		in.since[c.ID] = last.Add(time.Nanosecond)
+		in.mu.Unlock()
+	}()
+
+	inspect, err := in.get("/containers/"+c.ID+"/json", nil)
+	if err != nil {
+		in.send(&Event{Time: time.Now(), Source: "docker", Err: err})
+		return
+	}
+	var details struct {
+		Config struct {
+			Tty bool
+			Env []string
+		}
+	}
+	json.NewDecoder(inspect.Body).Decode(&details)
+	inspect.Body.Close()
+
+	query := url.Values{
+		"follow":     {"1"},
+		"stdout":     {"1"},
+		"stderr":     {"1"},
+		"timestamps": {"1"},
+		"since":      {fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())},
+	}
+	response, err := in.get("/containers/"+c.ID+"/logs", query)
+	if err != nil {
+		in.send(&Event{Time: time.Now(), Source: "docker", Err: err})
+		return
+	}
+	defer response.Body.Close()
+
+	name := c.ID[:12]
+	if len(c.Names) > 0 {
+		name = strings.TrimPrefix(c.Names[0], "/")
+	}
+
+	metadata := in.metadata(c, details.Config.Env)
+	deliver := func(stream, line string) bool {
+		e := &Event{Time: time.Now(), Text: line, Source: name, Fields: map[string]interface{}{
+			"ContainerID":   c.ID,
+			"ContainerName": name,
+			"Image":         c.Image,
+			"Stream":        stream,
+		}}
+		for k, v := range metadata {
+			e.Fields[k] = v
+		}
+
+		// Each line is prefixed with its RFC3339Nano timestamp
+		if space := strings.IndexByte(line, ' '); space > 0 {
+			if t, err := time.Parse(time.RFC3339Nano, line[:space]); err == nil {
+				e.Time, e.Text = t, line[space+1:]
+				last = t
+			}
+		}
+
+		return in.send(e)
+	}
+
+	if details.Config.Tty {
+		readLines(response.Body, func(line string) bool { return deliver("stdout", line) })
+	} else {
+		readMultiplexed(response.Body, deliver)
+	}
+}
+
+// metadata returns the fields of the compose project and service of a container, and of the labels
+// and environment variables selected by the configuration
+func (in *DockerInput) metadata(c dockerContainer, env []string) map[string]string {
+	metadata := map[string]string{}
+	if project, ok := c.Labels["com.docker.compose.project"]; ok {
+		metadata["ComposeProject"] = project
+		metadata["ComposeService"] = c.Labels["com.docker.compose.service"]
+	}
+
+	for key, value := range c.Labels {
+		if matchName(in.config.MetadataLabels, key) {
+			metadata[invalidLogTypeChars.ReplaceAllString(key, "_")] = value
+		}
+	}
+
+	for _, variable := range env {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) == 2 && matchName(in.config.MetadataEnv, parts[0]) {
+			metadata[parts[0]] = parts[1]
+		}
+	}
+
+	return metadata
+}
+
+// matchName tells whether name matches one of the filepath.Match patterns
+func matchName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readMultiplexed reads a docker log stream where each frame has an 8 byte header holding the
+// stream type and the frame length
+func readMultiplexed(r io.Reader, deliver func(stream, line string) bool) {
+	header := make([]byte, 8)
+	partial := map[string]string{}
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+
+		stream := "stdout"
+		if header[0] == 2 {
+			stream = "stderr"
+		}
+
+		frame := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return
+		}
+
+		text := partial[stream] + string(frame)
+		lines := strings.Split(text, "\n")
+		partial[stream] = lines[len(lines)-1]
+		for _, line := range lines[:len(lines)-1] {
+			if !deliver(stream, strings.TrimSuffix(line, "\r")) {
+				return
+			}
+		}
+	}
+}
+
+// readLines calls deliver with each line of r until it ends or deliver returns false
+func readLines(r io.Reader, deliver func(line string) bool) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" && !deliver(strings.TrimRight(line, "\r\n")) {
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (in *DockerInput) send(e *Event) bool {
+	select {
+	case in.events <- e:
+		return true
+	case <-in.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package input
+
+import "fmt"
+
+// EventLogInput ships the events of Windows Event Log channels, it is only available on Windows
+type EventLogInput struct {
+	events chan *Event
+}
+
+// NewEventLogInput fails as there is no event log outside Windows
+func NewEventLogInput(channels []string) (*EventLogInput, error) {
+	return nil, fmt.Errorf("Windows event log is only supported on Windows")
+}
+
+// Events returns the channel events are delivered on
+func (in *EventLogInput) Events() <-chan *Event {
+	return in.events
+}
+
+// Stop stops reading events
+func (in *EventLogInput) Stop() {
+}
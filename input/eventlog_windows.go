@@ -0,0 +1,217 @@
+//go:build windows
+// +build windows
+
+package input
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventLogPollInterval = time.Second * 5
+	eventLogBatchSize    = 500
+)
+
+// eventLogLevels names the standard event levels
+var eventLogLevels = map[int]string{
+	1: "critical",
+	2: "error",
+	3: "warning",
+	4: "info",
+	5: "verbose",
+}
+
+// EventLogInput ships the events of Windows Event Log channels. Channels are polled with
+// wevtutil for events recorded after the last one read, starting with new events.
+type EventLogInput struct {
+	channels []string
+	events   chan *Event
+	done     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewEventLogInput starts reading events from channels, e.g. Application, System or a custom
+// channel such as Microsoft-Windows-PowerShell/Operational
+func NewEventLogInput(channels []string) (*EventLogInput, error) {
+	in := &EventLogInput{channels: channels, events: make(chan *Event), done: make(chan struct{})}
+
+	last := make([]int64, len(channels))
+	for i, channel := range channels {
+		events, err := queryEventLog(channel, "/c:1", "/rd:true")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read event log channel %s: %v", channel, err)
+		}
+		if len(events) > 0 {
+			last[i] = events[0].System.EventRecordID
+		}
+	}
+
+	for i, channel := range channels {
+		in.wg.Add(1)
+		go in.poll(channel, last[i])
+	}
+
+	go func() {
+		in.wg.Wait()
+		close(in.events)
+	}()
+
+	return in, nil
+}
+
+// Events returns the channel events are delivered on
+func (in *EventLogInput) Events() <-chan *Event {
+	return in.events
+}
+
+// Stop stops reading events
+func (in *EventLogInput) Stop() {
+	in.once.Do(func() {
+		close(in.done)
+	})
+}
+
+func (in *EventLogInput) poll(channel string, last int64) {
+	defer in.wg.Done()
+
+	for {
+		select {
+		case <-in.done:
+			return
+		case <-time.After(eventLogPollInterval):
+		}
+
+		for {
+			query := fmt.Sprintf("/q:*[System[EventRecordID>%d]]", last)
+			events, err := queryEventLog(channel, query, "/c:"+strconv.Itoa(eventLogBatchSize))
+			if err != nil {
+				in.send(&Event{Time: time.Now(), Source: channel, Err: fmt.Errorf("Failed to read event log channel %s: %v", channel, err)})
+				break
+			}
+
+			for _, event := range events {
+				if !in.send(event.event()) {
+					return
+				}
+				last = event.System.EventRecordID
+			}
+
+			if len(events) < eventLogBatchSize {
+				break
+			}
+		}
+	}
+}
+
+func (in *EventLogInput) send(e *Event) bool {
+	select {
+	case in.events <- e:
+		return true
+	case <-in.done:
+		return false
+	}
+}
+
+// eventLogEvent is an event rendered by wevtutil as XML
+type eventLogEvent struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Provider"`
+		EventID     int `xml:"EventID"`
+		Level       int `xml:"Level"`
+		Task        int `xml:"Task"`
+		TimeCreated struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		} `xml:"TimeCreated"`
+		EventRecordID int64  `xml:"EventRecordID"`
+		Channel       string `xml:"Channel"`
+		Computer      string `xml:"Computer"`
+		Security      struct {
+			UserID string `xml:"UserID,attr"`
+		} `xml:"Security"`
+	} `xml:"System"`
+	EventData struct {
+		Data []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Data"`
+	} `xml:"EventData"`
+	RenderingInfo struct {
+		Message string `xml:"Message"`
+	} `xml:"RenderingInfo"`
+}
+
+// event converts the XML event into an event with the system properties and event data as fields
+func (event *eventLogEvent) event() *Event {
+	system := event.System
+
+	e := &Event{Time: time.Now(), Text: strings.TrimSpace(event.RenderingInfo.Message), Source: system.Channel, Fields: map[string]interface{}{
+		"Channel":       system.Channel,
+		"Provider":      system.Provider.Name,
+		"EventID":       system.EventID,
+		"EventRecordID": system.EventRecordID,
+		"Computer":      system.Computer,
+	}}
+
+	if t, err := time.Parse(time.RFC3339Nano, system.TimeCreated.SystemTime); err == nil {
+		e.Time = t
+	}
+	if level, ok := eventLogLevels[system.Level]; ok {
+		e.Fields["Severity"] = level
+	}
+	if system.Security.UserID != "" {
+		e.Fields["UserID"] = system.Security.UserID
+	}
+
+	if len(event.EventData.Data) > 0 {
+		data := map[string]interface{}{}
+		var values []string
+		for i, d := range event.EventData.Data {
+			name := d.Name
+			if name == "" {
+				name = strconv.Itoa(i)
+			}
+			data[name] = d.Value
+			values = append(values, d.Value)
+		}
+		e.Fields["EventData"] = data
+
+		// Events of providers without message files have no rendered message
+		if e.Text == "" {
+			e.Text = strings.Join(values, " ")
+		}
+	}
+
+	return e
+}
+
+// queryEventLog reads events of a channel, oldest first unless reversed with /rd:true
+func queryEventLog(channel string, args ...string) ([]*eventLogEvent, error) {
+	args = append([]string{"qe", channel, "/f:RenderedXml", "/e:Events"}, args...)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("wevtutil", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var events struct {
+		Events []*eventLogEvent `xml:"Event"`
+	}
+	if err := xml.Unmarshal(out, &events); err != nil {
+		return nil, err
+	}
+
+	return events.Events, nil
+}
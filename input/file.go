@@ -0,0 +1,58 @@
+package input
+
+import (
+	"sync"
+
+	"github.com/yangl900/log2oms/tail"
+)
+
+// FileInput delivers the lines of followed files
+type FileInput struct {
+	tailer *tail.MultiTailer
+	events chan *Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewFileInput converts lines from tailer into events, withPath adds the file path of each line
+// as FilePath field, which tells lines of different files apart
+func NewFileInput(tailer *tail.MultiTailer, withPath bool) *FileInput {
+	in := &FileInput{tailer: tailer, events: make(chan *Event), done: make(chan struct{})}
+
+	go func() {
+		defer close(in.events)
+
+		for line := range tailer.Lines {
+			e := &Event{Time: line.Time, Text: line.Text, Source: line.Filename, Err: line.Err, Ack: line.Ack}
+			if withPath {
+				e.Fields = map[string]interface{}{"FilePath": line.Filename}
+			}
+
+			// A line not delivered before Stop is not acknowledged, it is read again after a restart
+			select {
+			case in.events <- e:
+			case <-in.done:
+			}
+		}
+	}()
+
+	return in
+}
+
+// Events returns the channel events are delivered on
+func (in *FileInput) Events() <-chan *Event {
+	return in.events
+}
+
+// Reopen reopens the files followed, e.g. after they are rotated
+func (in *FileInput) Reopen() {
+	in.tailer.Reopen()
+}
+
+// Stop stops following the files
+func (in *FileInput) Stop() {
+	in.once.Do(func() {
+		close(in.done)
+		in.tailer.Stop()
+	})
+}
@@ -0,0 +1,419 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ForwardConfig configures ForwardInput
+type ForwardConfig struct {
+	// Address to listen on over TCP, e.g. ":24224"
+	Address string
+	// SharedKey, when set, is the key clients must authenticate with in the handshake of the
+	// secure forward protocol (fluentd "shared_key", fluent-bit "Shared_Key")
+	SharedKey string
+	// Hostname is the server name sent to clients in the handshake, the host name when empty
+	Hostname string
+}
+
+// ForwardInput receives logs from fluentd and fluent-bit agents over the forward protocol:
+// msgpack encoded messages over TCP in message, forward or (compressed) packed forward mode.
+// Chunks are acknowledged once all their events are, i.e. uploaded or given up, so clients
+// requiring ack responses send again the chunks log2oms stopped before uploading.
+type ForwardInput struct {
+	config   ForwardConfig
+	events   chan *Event
+	done     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+	listener net.Listener
+	connsMu  sync.Mutex
+	conns    map[net.Conn]bool
+}
+
+// NewForwardInput listens for forward protocol connections
+func NewForwardInput(config ForwardConfig) (*ForwardInput, error) {
+	if config.Hostname == "" {
+		config.Hostname, _ = os.Hostname()
+	}
+
+	listener, err := net.Listen("tcp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &ForwardInput{
+		config:   config,
+		events:   make(chan *Event),
+		done:     make(chan struct{}),
+		listener: listener,
+		conns:    map[net.Conn]bool{},
+	}
+
+	in.wg.Add(1)
+	go in.serve()
+
+	go func() {
+		in.wg.Wait()
+		close(in.events)
+	}()
+
+	return in, nil
+}
+
+// Events returns the channel events are delivered on
+func (in *ForwardInput) Events() <-chan *Event {
+	return in.events
+}
+
+// Stop closes the listener and open connections
+func (in *ForwardInput) Stop() {
+	in.once.Do(func() {
+		close(in.done)
+		in.listener.Close()
+
+		in.connsMu.Lock()
+		for conn := range in.conns {
+			conn.Close()
+		}
+		in.connsMu.Unlock()
+	})
+}
+
+func (in *ForwardInput) serve() {
+	defer in.wg.Done()
+
+	for {
+		conn, err := in.listener.Accept()
+		if err != nil {
+			select {
+			case <-in.done:
+				return
+			default:
+				in.send(&Event{Time: time.Now(), Source: "forward", Err: err})
+				time.Sleep(time.Second)
+				continue
+			}
+		}
+
+		in.connsMu.Lock()
+		in.conns[conn] = true
+		in.connsMu.Unlock()
+
+		in.wg.Add(1)
+		go in.serveConn(conn)
+	}
+}
+
+func (in *ForwardInput) serveConn(conn net.Conn) {
+	defer in.wg.Done()
+	defer func() {
+		conn.Close()
+		in.connsMu.Lock()
+		delete(in.conns, conn)
+		in.connsMu.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	if in.config.SharedKey != "" {
+		if err := in.handshake(conn, reader); err != nil {
+			in.send(&Event{Time: time.Now(), Source: "forward", Err: fmt.Errorf("Forward client %s not authenticated: %v", conn.RemoteAddr(), err)})
+			return
+		}
+	}
+
+	// Chunks are acknowledged by the pipeline, while the next messages are read
+	var writeMu sync.Mutex
+	ackChunk := func(chunk string) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		var buf bytes.Buffer
+		encodeMsgpack(&buf, map[string]interface{}{"ack": chunk})
+		conn.Write(buf.Bytes())
+	}
+
+	for {
+		message, err := decodeMsgpack(reader)
+		if err != nil {
+			if err != io.EOF && !in.stopped() {
+				in.send(&Event{Time: time.Now(), Source: "forward", Err: fmt.Errorf("Failed to read from forward client %s: %v", conn.RemoteAddr(), err)})
+			}
+			return
+		}
+
+		events, chunk, err := parseForward(message)
+		if err != nil {
+			in.send(&Event{Time: time.Now(), Source: "forward", Err: fmt.Errorf("Invalid message from forward client %s: %v", conn.RemoteAddr(), err)})
+			return
+		}
+
+		if chunk != "" {
+			if len(events) == 0 {
+				ackChunk(chunk)
+			}
+			chunk, remaining := chunk, int32(len(events))
+			for _, e := range events {
+				e.Ack = func() {
+					if atomic.AddInt32(&remaining, -1) == 0 {
+						ackChunk(chunk)
+					}
+				}
+			}
+		}
+
+		for _, e := range events {
+			e.Fields["SourceAddress"] = conn.RemoteAddr().String()
+			if !in.send(e) {
+				return
+			}
+		}
+	}
+}
+
+// handshake authenticates the client with the shared key: the server sends HELO with a nonce,
+// the client answers PING with a digest of its salt, host name, the nonce and the key, and the
+// server replies PONG with its own digest so the client can check the server knows the key too
+func (in *ForwardInput) handshake(conn net.Conn, reader *bufio.Reader) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	nonceHex := hex.EncodeToString(nonce)
+
+	var buf bytes.Buffer
+	encodeMsgpack(&buf, []interface{}{"HELO", map[string]interface{}{"nonce": nonceHex, "auth": "", "keepalive": true}})
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	message, err := decodeMsgpack(reader)
+	if err != nil {
+		return err
+	}
+
+	ping, ok := message.([]interface{})
+	if !ok || len(ping) < 4 || forwardString(ping[0]) != "PING" {
+		return fmt.Errorf("Expecting PING")
+	}
+	clientHostname, salt, digest := forwardString(ping[1]), forwardString(ping[2]), forwardString(ping[3])
+
+	expected := forwardDigest(salt, clientHostname, nonceHex, in.config.SharedKey)
+	authenticated := subtle.ConstantTimeCompare([]byte(digest), []byte(expected)) == 1
+
+	reason := ""
+	if !authenticated {
+		reason = "shared_key mismatch"
+	}
+
+	buf.Reset()
+	encodeMsgpack(&buf, []interface{}{"PONG", authenticated, reason, in.config.Hostname, forwardDigest(salt, in.config.Hostname, nonceHex, in.config.SharedKey)})
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if !authenticated {
+		return fmt.Errorf("Shared key mismatch from host %s", clientHostname)
+	}
+
+	return nil
+}
+
+func forwardDigest(salt, hostname, nonce, key string) string {
+	sum := sha512.Sum512([]byte(salt + hostname + nonce + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (in *ForwardInput) send(e *Event) bool {
+	select {
+	case in.events <- e:
+		return true
+	case <-in.done:
+		return false
+	}
+}
+
+func (in *ForwardInput) stopped() bool {
+	select {
+	case <-in.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseForward converts a message to events, it returns the chunk id to acknowledge if any. The
+// message is one of [tag, time, record, option], [tag, [[time, record]...], option] or
+// [tag, packed entries, option], the option being optional.
+func parseForward(message interface{}) ([]*Event, string, error) {
+	array, ok := message.([]interface{})
+	if !ok || len(array) < 2 {
+		return nil, "", fmt.Errorf("Expecting an array of at least 2 elements")
+	}
+
+	tag := forwardString(array[0])
+
+	var option map[string]interface{}
+	var events []*Event
+	switch entries := array[1].(type) {
+	case []interface{}:
+		for _, entry := range entries {
+			pair, ok := entry.([]interface{})
+			if !ok || len(pair) < 2 {
+				return nil, "", fmt.Errorf("Expecting entries of [time, record]")
+			}
+			events = append(events, forwardEvent(tag, pair[0], pair[1]))
+		}
+		if len(array) > 2 {
+			option, _ = array[2].(map[string]interface{})
+		}
+	case []byte, string:
+		if len(array) > 2 {
+			option, _ = array[2].(map[string]interface{})
+		}
+
+		packed, err := unpackForward(forwardBytes(entries), forwardString(option["compressed"]))
+		if err != nil {
+			return nil, "", err
+		}
+		for _, pair := range packed {
+			events = append(events, forwardEvent(tag, pair[0], pair[1]))
+		}
+	default:
+		if len(array) < 3 {
+			return nil, "", fmt.Errorf("Expecting [tag, time, record]")
+		}
+		events = append(events, forwardEvent(tag, array[1], array[2]))
+		if len(array) > 3 {
+			option, _ = array[3].(map[string]interface{})
+		}
+	}
+
+	return events, forwardString(option["chunk"]), nil
+}
+
+// unpackForward decodes the concatenated [time, record] entries of the packed forward mode
+func unpackForward(packed []byte, compressed string) ([][]interface{}, error) {
+	var r io.Reader = bytes.NewReader(packed)
+	switch compressed {
+	case "":
+	case "gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	default:
+		return nil, fmt.Errorf("Unsupported compression %s", compressed)
+	}
+
+	reader := bufio.NewReader(r)
+	var entries [][]interface{}
+	for {
+		entry, err := decodeMsgpack(reader)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		pair, ok := entry.([]interface{})
+		if !ok || len(pair) < 2 {
+			return nil, fmt.Errorf("Expecting entries of [time, record]")
+		}
+		entries = append(entries, pair)
+	}
+}
+
+// forwardEvent converts an entry to an event, the text being the "log" or else "message" field
+// of the record as set by fluent-bit and fluentd inputs
+func forwardEvent(tag string, t interface{}, record interface{}) *Event {
+	e := &Event{Time: forwardTime(t), Source: "forward", Fields: map[string]interface{}{"Tag": tag}}
+
+	fields, _ := forwardValue(record).(map[string]interface{})
+	for _, key := range []string{"log", "message"} {
+		if text, ok := fields[key].(string); ok {
+			e.Text = text
+			delete(fields, key)
+			break
+		}
+	}
+	for k, v := range fields {
+		e.Fields[k] = v
+	}
+
+	return e
+}
+
+// forwardTime converts seconds or an EventTime, the current time otherwise
+func forwardTime(t interface{}) time.Time {
+	switch value := t.(type) {
+	case time.Time:
+		return value
+	case int64:
+		return time.Unix(value, 0)
+	case uint64:
+		return time.Unix(int64(value), 0)
+	case float64:
+		return time.Unix(0, int64(value*float64(time.Second)))
+	}
+
+	return time.Now()
+}
+
+// forwardValue converts binaries to strings, which is what fluentd records hold, and times to
+// RFC 3339
+func forwardValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case []byte:
+		return string(value)
+	case time.Time:
+		return value.UTC().Format(time.RFC3339Nano)
+	case msgpackExt:
+		return hex.EncodeToString(value.Data)
+	case []interface{}:
+		for i, e := range value {
+			value[i] = forwardValue(e)
+		}
+	case map[string]interface{}:
+		for k, e := range value {
+			value[k] = forwardValue(e)
+		}
+	}
+
+	return v
+}
+
+func forwardString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	}
+
+	return ""
+}
+
+func forwardBytes(v interface{}) []byte {
+	if s, ok := v.(string); ok {
+		return []byte(s)
+	}
+
+	b, _ := v.([]byte)
+	return b
+}
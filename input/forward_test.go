@@ -0,0 +1,277 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// packMsgpack appends v to buf as forward clients encode it, with the integers, binaries and
+// EventTimes encodeMsgpack doesn't support
+func packMsgpack(buf *bytes.Buffer, v interface{}) {
+	switch value := v.(type) {
+	case int:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, int64(value))
+	case []byte:
+		buf.WriteByte(0xc6)
+		binary.Write(buf, binary.BigEndian, uint32(len(value)))
+		buf.Write(value)
+	case time.Time:
+		buf.Write([]byte{0xd7, eventTimeExt})
+		binary.Write(buf, binary.BigEndian, []uint32{uint32(value.Unix()), uint32(value.Nanosecond())})
+	case []interface{}:
+		writeMsgpackHeader(buf, len(value), 0x90, 0x0f, 0xdc)
+		for _, e := range value {
+			packMsgpack(buf, e)
+		}
+	case map[string]interface{}:
+		writeMsgpackHeader(buf, len(value), 0x80, 0x0f, 0xde)
+		for k, e := range value {
+			packMsgpack(buf, k)
+			packMsgpack(buf, e)
+		}
+	default:
+		encodeMsgpack(buf, v)
+	}
+}
+
+// dialForward connects to in, the connection is to be closed
+func dialForward(t *testing.T, in *ForwardInput) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", in.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, bufio.NewReader(conn)
+}
+
+// writeForward sends messages to the forward input on conn
+func writeForward(t *testing.T, conn net.Conn, messages ...interface{}) {
+	var buf bytes.Buffer
+	for _, message := range messages {
+		packMsgpack(&buf, message)
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveEvents receives n events from in
+func receiveEvents(t *testing.T, in Input, n int) []*Event {
+	var events []*Event
+	for len(events) < n {
+		select {
+		case e := <-in.Events():
+			if e.Err != nil {
+				t.Fatal(e.Err)
+			}
+			events = append(events, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Received %d events, expecting %d", len(events), n)
+		}
+	}
+
+	return events
+}
+
+func TestForwardChunkAck(t *testing.T) {
+	in, err := NewForwardInput(ForwardConfig{Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Stop()
+	conn, reader := dialForward(t, in)
+	defer conn.Close()
+
+	entries := []interface{}{
+		[]interface{}{1527854400, map[string]interface{}{"log": "first"}},
+		[]interface{}{1527854401, map[string]interface{}{"log": "second"}},
+	}
+	writeForward(t, conn, []interface{}{"app", entries, map[string]interface{}{"chunk": "c1"}})
+	events := receiveEvents(t, in, 2)
+
+	// The chunk is not acknowledged until all its events are
+	events[1].Ack()
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if reply, err := decodeMsgpack(reader); err == nil {
+		t.Fatalf("Replied %v before the events were acknowledged", reply)
+	}
+
+	events[0].Ack()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := decodeMsgpack(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack, _ := reply.(map[string]interface{}); forwardString(ack["ack"]) != "c1" {
+		t.Errorf("Replied %v, expecting the ack of c1", reply)
+	}
+}
+
+// packedEntries returns the packed forward entries of the records, gzipped when compressed
+func packedEntries(compressed bool, records ...map[string]interface{}) []byte {
+	var buf bytes.Buffer
+	for i, record := range records {
+		packMsgpack(&buf, []interface{}{1527854400 + i, record})
+	}
+	if !compressed {
+		return buf.Bytes()
+	}
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(buf.Bytes())
+	gz.Close()
+	return gzipped.Bytes()
+}
+
+func TestParseForward(t *testing.T) {
+	eventTime := time.Date(2018, 6, 1, 12, 0, 0, 500, time.UTC)
+	record := func(text string) map[string]interface{} {
+		return map[string]interface{}{"log": text, "level": []byte("info")}
+	}
+
+	tests := []struct {
+		name    string
+		message interface{}
+		// events are formatted as time|text|fields
+		events []string
+		chunk  string
+		err    bool
+	}{
+		{
+			name:    "message",
+			message: []interface{}{"app", 1527854400, record("hello"), map[string]interface{}{"chunk": "c1"}},
+			events:  []string{"2018-06-01T12:00:00Z|hello|map[Tag:app level:info]"},
+			chunk:   "c1",
+		},
+		{
+			name:    "message with event time",
+			message: []interface{}{"app", eventTime, map[string]interface{}{"message": "hello", "log": 1}},
+			events:  []string{"2018-06-01T12:00:00.0000005Z|hello|map[Tag:app log:1]"},
+		},
+		{
+			name:    "forward",
+			message: []interface{}{"app", []interface{}{[]interface{}{1527854400, record("first")}, []interface{}{eventTime, record("second")}}},
+			events:  []string{"2018-06-01T12:00:00Z|first|map[Tag:app level:info]", "2018-06-01T12:00:00.0000005Z|second|map[Tag:app level:info]"},
+		},
+		{
+			name:    "packed forward",
+			message: []interface{}{"app", packedEntries(false, record("first"), record("second")), map[string]interface{}{"chunk": "c2"}},
+			events:  []string{"2018-06-01T12:00:00Z|first|map[Tag:app level:info]", "2018-06-01T12:00:01Z|second|map[Tag:app level:info]"},
+			chunk:   "c2",
+		},
+		{
+			name:    "packed forward in a string",
+			message: []interface{}{"app", string(packedEntries(false, record("first")))},
+			events:  []string{"2018-06-01T12:00:00Z|first|map[Tag:app level:info]"},
+		},
+		{
+			name:    "compressed packed forward",
+			message: []interface{}{"app", packedEntries(true, record("first"), record("second")), map[string]interface{}{"compressed": "gzip", "chunk": "c3"}},
+			events:  []string{"2018-06-01T12:00:00Z|first|map[Tag:app level:info]", "2018-06-01T12:00:01Z|second|map[Tag:app level:info]"},
+			chunk:   "c3",
+		},
+		{name: "unsupported compression", message: []interface{}{"app", packedEntries(false, record("x")), map[string]interface{}{"compressed": "zstd"}}, err: true},
+		{name: "corrupted compression", message: []interface{}{"app", packedEntries(false, record("x")), map[string]interface{}{"compressed": "gzip"}}, err: true},
+		{name: "packed entry not a pair", message: []interface{}{"app", []byte{0x91, 0x01}}, err: true},
+		{name: "entry not a pair", message: []interface{}{"app", []interface{}{1}}, err: true},
+		{name: "message without record", message: []interface{}{"app", 1527854400}, err: true},
+		{name: "not an array", message: "app", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			packMsgpack(&buf, test.message)
+			message, err := decodeMsgpack(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, chunk, err := parseForward(message)
+			if test.err {
+				if err == nil {
+					t.Fatal("Expecting the message to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var events []string
+			for _, e := range parsed {
+				events = append(events, fmt.Sprintf("%s|%s|%v", e.Time.UTC().Format(time.RFC3339Nano), e.Text, e.Fields))
+			}
+			if fmt.Sprintf("%q", events) != fmt.Sprintf("%q", test.events) || chunk != test.chunk {
+				t.Errorf("Parsed %q and chunk %q, expecting %q and %q", events, chunk, test.events, test.chunk)
+			}
+		})
+	}
+}
+
+func TestForwardHandshake(t *testing.T) {
+	tests := []struct {
+		name          string
+		key           string
+		authenticated bool
+	}{
+		{"shared key", "secret", true},
+		{"wrong key", "guess", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in, err := NewForwardInput(ForwardConfig{Address: "127.0.0.1:0", SharedKey: "secret", Hostname: "server"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Stop()
+			conn, reader := dialForward(t, in)
+			defer conn.Close()
+
+			helo, err := decodeMsgpack(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			options, _ := helo.([]interface{})[1].(map[string]interface{})
+			nonce := forwardString(options["nonce"])
+			writeForward(t, conn, []interface{}{"PING", "client", "salt", forwardDigest("salt", "client", nonce, test.key), "", ""})
+
+			// The server proves it knows the key too
+			pong, err := decodeMsgpack(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply := pong.([]interface{})
+			if authenticated, _ := reply[1].(bool); authenticated != test.authenticated || forwardString(reply[4]) != forwardDigest("salt", "server", nonce, "secret") {
+				t.Fatalf("Replied %v", reply)
+			}
+
+			if !test.authenticated {
+				select {
+				case e := <-in.Events():
+					if e.Err == nil || !strings.Contains(e.Err.Error(), "not authenticated") {
+						t.Errorf("Delivered %v, expecting the client to be reported", e)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("The client was not reported")
+				}
+				return
+			}
+
+			writeForward(t, conn, []interface{}{"app", 1527854400, map[string]interface{}{"log": "hello"}})
+			if e := receiveEvents(t, in, 1)[0]; e.Text != "hello" {
+				t.Errorf("Received %q, expecting hello", e.Text)
+			}
+		})
+	}
+}
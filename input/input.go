@@ -0,0 +1,59 @@
+// Package input reads log entries from the sources supported by log2oms and delivers them as
+// events to the upload pipeline.
+package input
+
+import (
+	"time"
+
+	"github.com/yangl900/log2oms/logclient"
+)
+
+// Event is a log entry read by an input
+type Event struct {
+	// Time is when the entry was logged, or read when the source does not tell
+	Time time.Time
+	// Text is the raw entry, sent as the message field
+	Text string
+	// Fields are structured fields of the entry, sent as additional columns
+	Fields map[string]interface{}
+	// Source identifies where the entry comes from, e.g. the file path
+	Source string
+	// LogType, when set, is the log type the entry is sent as instead of the one of the output,
+	// e.g. set by routing rules or by the sidecar input
+	LogType string
+	// Err is set when the input failed to read, the other fields are then meaningless
+	Err error
+	// Ack, when not nil, is to be called once the event is uploaded or given up, so the input
+	// does not deliver it again after a restart
+	Ack func()
+}
+
+// Record converts the event to a log analytics record. The record takes over the fields of the
+// event rather than copying them, the event is not to be processed afterwards.
+func (e *Event) Record() logclient.Record {
+	record := logclient.Record(e.Fields)
+	if record == nil {
+		record = make(logclient.Record, 2)
+	}
+
+	if _, ok := record["message"]; !ok {
+		record["message"] = e.Text
+	}
+	if _, ok := record["Timestamp"]; !ok && !e.Time.IsZero() {
+		record["Timestamp"] = e.Time.UTC().Format(time.RFC3339)
+	}
+
+	return record
+}
+
+// Reopener is an input reading files, which it can open again, e.g. when told they were rotated
+type Reopener interface {
+	Reopen()
+}
+
+// Input produces events until it is stopped or its source is exhausted, Events is closed then.
+// Events produced before Stop are still delivered, so consumers keep reading until it is closed.
+type Input interface {
+	Events() <-chan *Event
+	Stop()
+}
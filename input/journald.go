@@ -0,0 +1,246 @@
+package input
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cursorSaveInterval = time.Second * 5
+)
+
+// journalFields maps well known journal fields to record fields, other trusted fields (starting
+// with "_") are dropped and the remaining ones are kept with their journal names
+var journalFields = map[string]string{
+	"_SYSTEMD_UNIT":     "Unit",
+	"SYSLOG_IDENTIFIER": "AppName",
+	"_PID":              "ProcID",
+	"_HOSTNAME":         "SourceHost",
+	"_COMM":             "Command",
+	"_TRANSPORT":        "Transport",
+	"_BOOT_ID":          "BootID",
+}
+
+// JournalConfig selects the journal entries read by JournalInput
+type JournalConfig struct {
+	// Units limits entries to these systemd units, all entries when empty
+	Units []string
+	// CursorFile persists the position in the journal, reading resumes after it on restart.
+	// Without it reading starts with new entries.
+	CursorFile string
+}
+
+// JournalInput streams systemd journal entries by following `journalctl -o json`
+type JournalInput struct {
+	config JournalConfig
+	cmd    *exec.Cmd
+	events chan *Event
+	done   chan struct{}
+	once   sync.Once
+
+	cursorMu    sync.Mutex
+	cursor      string
+	savedCursor string
+}
+
+// NewJournalInput starts following the journal
+func NewJournalInput(config JournalConfig) (*JournalInput, error) {
+	in := &JournalInput{config: config, events: make(chan *Event), done: make(chan struct{})}
+
+	args := []string{"--output=json", "--follow", "--no-pager", "--all"}
+	for _, unit := range config.Units {
+		args = append(args, "--unit="+unit)
+	}
+
+	if config.CursorFile != "" {
+		if buf, err := ioutil.ReadFile(config.CursorFile); err == nil && len(strings.TrimSpace(string(buf))) > 0 {
+			in.cursor = strings.TrimSpace(string(buf))
+			in.savedCursor = in.cursor
+			args = append(args, "--after-cursor="+in.cursor)
+		}
+	}
+	if in.cursor == "" {
+		args = append(args, "--lines=0")
+	}
+
+	in.cmd = exec.Command("journalctl", args...)
+	stdout, err := in.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := in.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Failed to start journalctl: %v", err)
+	}
+
+	go in.read(stdout)
+	if config.CursorFile != "" {
+		go in.saveCursorPeriodically()
+	}
+
+	return in, nil
+}
+
+// Events returns the channel events are delivered on
+func (in *JournalInput) Events() <-chan *Event {
+	return in.events
+}
+
+// Stop stops following the journal and saves the cursor
+func (in *JournalInput) Stop() {
+	in.once.Do(func() {
+		close(in.done)
+		in.cmd.Process.Kill()
+		in.saveCursor()
+	})
+}
+
+func (in *JournalInput) read(stdout io.Reader) {
+	defer close(in.events)
+	defer in.cmd.Wait()
+
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			e, cursor := parseJournalEntry(line)
+			if !in.send(e) {
+				return
+			}
+
+			if cursor != "" {
+				in.cursorMu.Lock()
+				in.cursor = cursor
+				in.cursorMu.Unlock()
+			}
+		}
+
+		if err != nil {
+			select {
+			case <-in.done:
+			default:
+				in.send(&Event{Time: time.Now(), Source: "journald", Err: fmt.Errorf("journalctl exited: %v", err)})
+			}
+			return
+		}
+	}
+}
+
+func (in *JournalInput) send(e *Event) bool {
+	select {
+	case in.events <- e:
+		return true
+	case <-in.done:
+		return false
+	}
+}
+
+func (in *JournalInput) saveCursorPeriodically() {
+	for {
+		select {
+		case <-in.done:
+			return
+		case <-time.After(cursorSaveInterval):
+			in.saveCursor()
+		}
+	}
+}
+
+// saveCursor writes the cursor of the last delivered entry, replacing the file atomically
+func (in *JournalInput) saveCursor() {
+	if in.config.CursorFile == "" {
+		return
+	}
+
+	in.cursorMu.Lock()
+	defer in.cursorMu.Unlock()
+
+	if in.cursor == in.savedCursor {
+		return
+	}
+
+	tmp := in.config.CursorFile + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(in.config.CursorFile), 0755); err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(tmp, []byte(in.cursor+"\n"), 0644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, in.config.CursorFile); err == nil {
+		in.savedCursor = in.cursor
+	}
+}
+
+// parseJournalEntry converts a journal export JSON object into an event and returns its cursor
+func parseJournalEntry(line []byte) (*Event, string) {
+	var entry map[string]interface{}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return &Event{Time: time.Now(), Source: "journald", Err: fmt.Errorf("Failed to parse journal entry: %v", err)}, ""
+	}
+
+	e := &Event{Time: time.Now(), Source: "journald", Fields: map[string]interface{}{}}
+	cursor, _ := entry["__CURSOR"].(string)
+
+	for key, value := range entry {
+		text := journalValue(value)
+
+		switch {
+		case key == "MESSAGE":
+			e.Text = text
+		case key == "__REALTIME_TIMESTAMP":
+			if usec, err := strconv.ParseInt(text, 10, 64); err == nil {
+				e.Time = time.Unix(0, usec*int64(time.Microsecond))
+			}
+		case key == "PRIORITY":
+			if priority, err := strconv.Atoi(text); err == nil && priority >= 0 && priority < len(syslogSeverities) {
+				e.Fields["Severity"] = syslogSeverities[priority]
+			}
+		case journalFields[key] != "":
+			e.Fields[journalFields[key]] = text
+		case strings.HasPrefix(key, "_"):
+		default:
+			e.Fields[key] = text
+		}
+	}
+
+	return e, cursor
+}
+
+// journalValue converts a field value, binary values are exported as arrays of bytes and
+// repeated fields as arrays of values
+func journalValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		if len(v) > 0 {
+			if _, ok := v[0].(float64); ok {
+				buf := make([]byte, 0, len(v))
+				for _, b := range v {
+					n, _ := b.(float64)
+					buf = append(buf, byte(n))
+				}
+				return string(buf)
+			}
+		}
+
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, journalValue(item))
+		}
+		return strings.Join(values, "\n")
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,433 @@
+package input
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yangl900/log2oms/tail"
+)
+
+const (
+	defaultPodLogDir    = "/var/log/pods"
+	serviceAccountDir   = "/var/run/secrets/kubernetes.io/serviceaccount"
+	podListCacheTimeout = time.Second * 10
+)
+
+// containerLogName matches the <pod>_<namespace>_<container>-<container id>.log links of
+// /var/log/containers
+var containerLogName = regexp.MustCompile(`^([^_]+)_([^_]+)_(.+)-([0-9a-f]{64})\.log$`)
+
+// KubernetesConfig selects the pods whose logs KubernetesInput ships
+type KubernetesConfig struct {
+	// LogDir is where the kubelet writes pod logs, defaults to /var/log/pods. /var/log/containers,
+	// where the logs are linked as <pod>_<namespace>_<container>-<container id>.log, works too.
+	LogDir string
+	// Namespaces limits pods to these namespaces, all namespaces when empty
+	Namespaces []string
+	// LabelSelector limits pods to those matching a kubernetes label selector, e.g.
+	// "app=web,tier!=cache". It requires access to the API server to list pods.
+	LabelSelector string
+	// NodeName limits the pods listed from the API server to the node log2oms runs on
+	NodeName string
+	// Metadata adds the labels, node and container image of pods, looked up from the API server
+	Metadata bool
+	// Tail configures how log files are followed
+	Tail tail.Config
+}
+
+// podRef identifies a pod
+type podRef struct {
+	Namespace string
+	Name      string
+	UID       string
+	Labels    map[string]string
+	NodeName  string
+	// Images are the images of the containers by name
+	Images map[string]string
+}
+
+// key identifies the pod in the cache of the pods listed, by UID when known since the pods of a
+// stateful set are recreated with the same name
+func (ref podRef) key() string {
+	if ref.UID != "" {
+		return ref.UID
+	}
+
+	return ref.Namespace + "/" + ref.Name
+}
+
+// containerLog identifies the container a log file belongs to
+type containerLog struct {
+	Pod       podRef
+	Container string
+	// ContainerID is only known from the links of /var/log/containers
+	ContainerID string
+}
+
+// KubernetesInput tails the logs the kubelet writes for pods on the node, laid out as
+// <namespace>_<pod>_<uid>/<container>/<restart>.log, in CRI or docker json-file format. It is
+// meant to run as a DaemonSet with the log directory mounted, and can add the metadata of pods
+// from the API server.
+type KubernetesInput struct {
+	config  KubernetesConfig
+	tailer  *tail.MultiTailer
+	api     *kubeClient
+	events  chan *Event
+	done    chan struct{}
+	once    sync.Once
+	partial map[string]string
+
+	mu       sync.Mutex
+	pods     map[string]podRef
+	listedAt time.Time
+}
+
+// NewKubernetesInput starts tailing pod logs
+func NewKubernetesInput(config KubernetesConfig) (*KubernetesInput, error) {
+	if config.LogDir == "" {
+		config.LogDir = defaultPodLogDir
+	}
+
+	in := &KubernetesInput{config: config, events: make(chan *Event), done: make(chan struct{}), partial: map[string]string{}}
+
+	if config.LabelSelector != "" || config.Metadata {
+		api, err := newInClusterClient()
+		if err != nil {
+			return nil, fmt.Errorf("Label selector and pod metadata require access to the API server: %v", err)
+		}
+		in.api = api
+	}
+
+	tailer, err := tail.WatchDir(config.LogDir, tail.DirConfig{Include: []string{"*.log"}, Filter: in.selects}, config.Tail)
+	if err != nil {
+		return nil, err
+	}
+	in.tailer = tailer
+
+	go in.run()
+
+	return in, nil
+}
+
+// Events returns the channel events are delivered on
+func (in *KubernetesInput) Events() <-chan *Event {
+	return in.events
+}
+
+// Stop stops tailing pod logs
+func (in *KubernetesInput) Stop() {
+	in.once.Do(func() {
+		close(in.done)
+		in.tailer.Stop()
+	})
+}
+
+// Reopen reopens the log files followed
+func (in *KubernetesInput) Reopen() {
+	in.tailer.Reopen()
+}
+
+func (in *KubernetesInput) run() {
+	defer close(in.events)
+
+	for line := range in.tailer.Lines {
+		if line.Err != nil {
+			in.send(&Event{Time: line.Time, Source: line.Filename, Err: line.Err})
+			continue
+		}
+
+		e, complete, err := in.parse(line)
+		if err != nil {
+			in.send(&Event{Time: time.Now(), Source: line.Filename, Err: fmt.Errorf("Failed to list pods: %v", err)})
+		}
+		if complete {
+			in.send(e)
+		}
+	}
+}
+
+// send delivers an event unless stopped, the lines of an event not delivered are read again after a
+// restart since they are not acknowledged
+func (in *KubernetesInput) send(e *Event) {
+	select {
+	case in.events <- e:
+	case <-in.done:
+	}
+}
+
+// parse converts a container log line, it returns false while the line is a partial one. The
+// error is the one of listing pods for their metadata, the event is complete nonetheless.
+func (in *KubernetesInput) parse(line *tail.Line) (*Event, bool, error) {
+	log := parsePodLogPath(in.config.LogDir, line.Filename)
+
+	e, partial := parseContainerLine(line.Text)
+	if partial {
+		in.partial[line.Filename] += e.Text
+		return nil, false, nil
+	}
+
+	e.Text = in.partial[line.Filename] + e.Text
+	delete(in.partial, line.Filename)
+
+	e.Source = line.Filename
+	e.Ack = line.Ack
+	e.Fields["Namespace"] = log.Pod.Namespace
+	e.Fields["PodName"] = log.Pod.Name
+	e.Fields["ContainerName"] = log.Container
+	if log.Pod.UID != "" {
+		e.Fields["PodUID"] = log.Pod.UID
+	}
+	if log.ContainerID != "" {
+		e.Fields["ContainerID"] = log.ContainerID
+	}
+
+	if !in.config.Metadata {
+		return e, true, nil
+	}
+
+	pod, found, err := in.lookup(log.Pod)
+	if found {
+		e.Fields["PodUID"] = pod.UID
+		e.Fields["NodeName"] = pod.NodeName
+		if len(pod.Labels) > 0 {
+			e.Fields["PodLabels"] = pod.Labels
+		}
+		if image, ok := pod.Images[log.Container]; ok {
+			e.Fields["ContainerImage"] = image
+		}
+	}
+
+	return e, true, err
+}
+
+// selects tells whether the log file at path belongs to a selected pod
+func (in *KubernetesInput) selects(path string) bool {
+	ref := parsePodLogPath(in.config.LogDir, path).Pod
+	if ref.Namespace == "" {
+		return false
+	}
+
+	if len(in.config.Namespaces) > 0 && !contains(in.config.Namespaces, ref.Namespace) {
+		return false
+	}
+
+	if in.config.LabelSelector == "" {
+		return true
+	}
+
+	// Only the pods matching the label selector are listed
+	_, found, _ := in.lookup(ref)
+	return found
+}
+
+// lookup returns the pod listed from the API server. Pods are listed again when not found, at
+// most every podListCacheTimeout, the error is the one of listing them then.
+func (in *KubernetesInput) lookup(ref podRef) (podRef, bool, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	pod, found := in.pods[ref.key()]
+	if found || time.Since(in.listedAt) < podListCacheTimeout {
+		return pod, found, nil
+	}
+
+	pods, err := in.api.listPods(in.config.LabelSelector, in.config.NodeName)
+	in.listedAt = time.Now()
+	if err != nil {
+		return podRef{}, false, err
+	}
+
+	in.pods = map[string]podRef{}
+	for _, pod := range pods {
+		in.pods[pod.UID] = pod
+		in.pods[pod.Namespace+"/"+pod.Name] = pod
+	}
+
+	pod, found = in.pods[ref.key()]
+	return pod, found, nil
+}
+
+// parsePodLogPath extracts the pod and container from <dir>/<namespace>_<pod>_<uid>/<container>/<n>.log,
+// or <dir>/<pod>_<namespace>_<container>-<container id>.log
+func parsePodLogPath(dir, path string) containerLog {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return containerLog{}
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) == 1 {
+		m := containerLogName.FindStringSubmatch(parts[0])
+		if m == nil {
+			return containerLog{}
+		}
+
+		return containerLog{Pod: podRef{Namespace: m[2], Name: m[1]}, Container: m[3], ContainerID: m[4]}
+	}
+	if len(parts) != 3 {
+		return containerLog{}
+	}
+
+	pod := strings.SplitN(parts[0], "_", 3)
+	if len(pod) != 3 {
+		return containerLog{}
+	}
+
+	return containerLog{Pod: podRef{Namespace: pod[0], Name: pod[1], UID: pod[2]}, Container: parts[1]}
+}
+
+// parseContainerLine parses a CRI log line "<time> <stream> <P|F> <log>" or a docker json-file
+// line {"log":"...","stream":"...","time":"..."}, and tells whether the line is partial
+func parseContainerLine(text string) (*Event, bool) {
+	e := &Event{Time: time.Now(), Text: text, Fields: map[string]interface{}{}}
+
+	if strings.HasPrefix(text, "{") {
+		var entry struct {
+			Log    string    `json:"log"`
+			Stream string    `json:"stream"`
+			Time   time.Time `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(text), &entry); err == nil {
+			e.Time, e.Fields["Stream"] = entry.Time, entry.Stream
+			e.Text = strings.TrimSuffix(entry.Log, "\n")
+			return e, !strings.HasSuffix(entry.Log, "\n")
+		}
+
+		return e, false
+	}
+
+	parts := strings.SplitN(text, " ", 4)
+	if len(parts) < 3 {
+		return e, false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return e, false
+	}
+
+	e.Time, e.Fields["Stream"], e.Text = t, parts[1], ""
+	if len(parts) == 4 {
+		e.Text = parts[3]
+	}
+
+	return e, parts[2] == "P"
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// kubeClient is a minimal API server client using the in-cluster service account
+type kubeClient struct {
+	host   string
+	token  string
+	client *http.Client
+}
+
+func newInClusterClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("Not running in a kubernetes cluster")
+	}
+
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+
+	return &kubeClient{
+		host:  "https://" + net.JoinHostPort(host, port),
+		token: strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Timeout:   time.Second * 30,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// listPods lists pods matching labelSelector, on nodeName when it is not empty
+func (k *kubeClient) listPods(labelSelector, nodeName string) ([]podRef, error) {
+	query := url.Values{}
+	if labelSelector != "" {
+		query.Set("labelSelector", labelSelector)
+	}
+	if nodeName != "" {
+		query.Set("fieldSelector", "spec.nodeName="+nodeName)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, k.host+"/api/v1/pods?"+query.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer "+k.token)
+
+	response, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		buf, _ := ioutil.ReadAll(response.Body)
+		return nil, fmt.Errorf("List pods failed with status: %d %s", response.StatusCode, string(buf))
+	}
+
+	type container struct {
+		Name  string `json:"name"`
+		Image string `json:"image"`
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Namespace string            `json:"namespace"`
+				Name      string            `json:"name"`
+				UID       string            `json:"uid"`
+				Labels    map[string]string `json:"labels"`
+			} `json:"metadata"`
+			Spec struct {
+				NodeName       string      `json:"nodeName"`
+				Containers     []container `json:"containers"`
+				InitContainers []container `json:"initContainers"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	pods := make([]podRef, 0, len(list.Items))
+	for _, item := range list.Items {
+		m := item.Metadata
+		images := map[string]string{}
+		for _, c := range append(item.Spec.InitContainers, item.Spec.Containers...) {
+			images[c.Name] = c.Image
+		}
+		pods = append(pods, podRef{Namespace: m.Namespace, Name: m.Name, UID: m.UID, Labels: m.Labels, NodeName: item.Spec.NodeName, Images: images})
+	}
+
+	return pods, nil
+}
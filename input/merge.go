@@ -0,0 +1,59 @@
+package input
+
+import (
+	"sync"
+)
+
+// merged delivers the events of several inputs
+type merged struct {
+	inputs []Input
+	events chan *Event
+}
+
+// Merge combines inputs into one, its events channel is closed once all inputs are exhausted
+func Merge(inputs ...Input) Input {
+	if len(inputs) == 1 {
+		return inputs[0]
+	}
+
+	m := &merged{inputs: inputs, events: make(chan *Event)}
+
+	var wg sync.WaitGroup
+	for _, in := range inputs {
+		wg.Add(1)
+		go func(in Input) {
+			defer wg.Done()
+			for e := range in.Events() {
+				m.events <- e
+			}
+		}(in)
+	}
+
+	go func() {
+		wg.Wait()
+		close(m.events)
+	}()
+
+	return m
+}
+
+// Events returns the channel events are delivered on
+func (m *merged) Events() <-chan *Event {
+	return m.events
+}
+
+// Reopen reopens the files of the inputs reading files
+func (m *merged) Reopen() {
+	for _, in := range m.inputs {
+		if r, ok := in.(Reopener); ok {
+			r.Reopen()
+		}
+	}
+}
+
+// Stop stops all inputs
+func (m *merged) Stop() {
+	for _, in := range m.inputs {
+		in.Stop()
+	}
+}
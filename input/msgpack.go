@@ -0,0 +1,261 @@
+package input
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	// maxMsgpackLength bounds the length of strings, binaries, arrays and maps, so a corrupt or
+	// hostile peer cannot make the decoder allocate without limit
+	maxMsgpackLength = 64 * 1024 * 1024
+	// maxMsgpackDepth bounds the nesting of arrays and maps
+	maxMsgpackDepth = 64
+
+	// eventTimeExt is the extension type of the EventTime of the fluentd forward protocol
+	eventTimeExt = 0
+)
+
+// msgpackExt is an extension value of a type other than EventTime
+type msgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+// decodeMsgpack reads a value. Strings are decoded as string, binaries as []byte, maps as
+// map[string]interface{} with their keys formatted as strings, integers as int64 or uint64 and
+// EventTime extensions as time.Time.
+func decodeMsgpack(r io.Reader) (interface{}, error) {
+	return decodeMsgpackValue(r, 0)
+}
+
+func decodeMsgpackValue(r io.Reader, depth int) (interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, fmt.Errorf("Msgpack value nested too deeply")
+	}
+
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0x80 && c <= 0x8f:
+		return decodeMsgpackMap(r, int(c&0x0f), depth)
+	case c >= 0x90 && c <= 0x9f:
+		return decodeMsgpackArray(r, int(c&0x0f), depth)
+	case c >= 0xa0 && c <= 0xbf:
+		buf, err := readMsgpackBytes(r, int(c&0x1f))
+		return string(buf), err
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readMsgpackLength(r, c-0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackBytes(r, n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := readMsgpackLength(r, c-0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackExt(r, n)
+	case 0xca:
+		buf, err := readMsgpackBytes(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(buf))), nil
+	case 0xcb:
+		buf, err := readMsgpackBytes(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		buf, err := readMsgpackBytes(r, 1<<(c-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return bigEndianUint(buf), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		buf, err := readMsgpackBytes(r, 1<<(c-0xd0))
+		if err != nil {
+			return nil, err
+		}
+		// Sign extends the value from its size
+		shift := uint(64 - 8*len(buf))
+		return int64(bigEndianUint(buf)<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return readMsgpackExt(r, 1<<(c-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := readMsgpackLength(r, c-0xd9)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := readMsgpackBytes(r, n)
+		return string(buf), err
+	case 0xdc, 0xdd:
+		n, err := readMsgpackLength(r, c-0xdc+1)
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackArray(r, n, depth)
+	case 0xde, 0xdf:
+		n, err := readMsgpackLength(r, c-0xde+1)
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackMap(r, n, depth)
+	}
+
+	return nil, fmt.Errorf("Invalid msgpack type 0x%x", c)
+}
+
+// readMsgpackLength reads a length of 1, 2 or 4 bytes for size 0, 1 or 2
+func readMsgpackLength(r io.Reader, size byte) (int, error) {
+	buf, err := readMsgpackBytes(r, 1<<size)
+	if err != nil {
+		return 0, err
+	}
+
+	n := bigEndianUint(buf)
+	if n > maxMsgpackLength {
+		return 0, fmt.Errorf("Msgpack length %d is over the limit of %d", n, maxMsgpackLength)
+	}
+
+	return int(n), nil
+}
+
+func readMsgpackBytes(r io.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func readMsgpackExt(r io.Reader, n int) (interface{}, error) {
+	buf, err := readMsgpackBytes(r, n+1)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := msgpackExt{Type: int8(buf[0]), Data: buf[1:]}
+	if ext.Type == eventTimeExt && len(ext.Data) == 8 {
+		return time.Unix(int64(binary.BigEndian.Uint32(ext.Data[:4])), int64(binary.BigEndian.Uint32(ext.Data[4:]))), nil
+	}
+
+	return ext, nil
+}
+
+func decodeMsgpackArray(r io.Reader, n int, depth int) ([]interface{}, error) {
+	// Grows as elements are read, the length may be bogus
+	var array []interface{}
+	for i := 0; i < n; i++ {
+		v, err := decodeMsgpackValue(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, v)
+	}
+
+	return array, nil
+}
+
+func decodeMsgpackMap(r io.Reader, n int, depth int) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	for i := 0; i < n; i++ {
+		k, err := decodeMsgpackValue(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := decodeMsgpackValue(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key := k.(type) {
+		case string:
+			m[key] = v
+		case []byte:
+			m[string(key)] = v
+		default:
+			m[fmt.Sprint(key)] = v
+		}
+	}
+
+	return m, nil
+}
+
+func bigEndianUint(buf []byte) uint64 {
+	var n uint64
+	for _, b := range buf {
+		n = n<<8 | uint64(b)
+	}
+
+	return n
+}
+
+// encodeMsgpack appends v to buf, only the types of the replies of the forward protocol are
+// supported: nil, bool, string, []interface{} and map[string]interface{}
+func encodeMsgpack(buf *bytes.Buffer, v interface{}) {
+	switch value := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if value {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case string:
+		writeMsgpackHeader(buf, len(value), 0xa0, 0x1f, 0xda)
+		buf.WriteString(value)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(value), 0x90, 0x0f, 0xdc)
+		for _, e := range value {
+			encodeMsgpack(buf, e)
+		}
+	case map[string]interface{}:
+		writeMsgpackHeader(buf, len(value), 0x80, 0x0f, 0xde)
+		for k, e := range value {
+			encodeMsgpack(buf, k)
+			encodeMsgpack(buf, e)
+		}
+	default:
+		panic(fmt.Sprintf("Unsupported msgpack type %T", v))
+	}
+}
+
+// writeMsgpackHeader writes the fix format of a string, array or map, or its 16 bits format
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, format16 byte) {
+	if n <= fixMax {
+		buf.WriteByte(fix | byte(n))
+		return
+	}
+
+	buf.WriteByte(format16)
+	binary.Write(buf, binary.BigEndian, uint16(n))
+}
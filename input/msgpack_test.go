@@ -0,0 +1,93 @@
+package input
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeMsgpack(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected interface{}
+	}{
+		{"positive fixint", []byte{0x7f}, int64(127)},
+		{"negative fixint", []byte{0xff}, int64(-1)},
+		{"uint16", []byte{0xcd, 0x01, 0x00}, uint64(256)},
+		{"int8", []byte{0xd0, 0x80}, int64(-128)},
+		{"int32", []byte{0xd2, 0xff, 0xff, 0xff, 0xfe}, int64(-2)},
+		{"float32", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, 1.5},
+		{"float64", []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, 1.5},
+		{"nil", []byte{0xc0}, nil},
+		{"bool", []byte{0xc3}, true},
+		{"fixstr", []byte{0xa2, 'h', 'i'}, "hi"},
+		{"str8", []byte{0xd9, 0x02, 'h', 'i'}, "hi"},
+		{"bin8", []byte{0xc4, 0x02, 'h', 'i'}, []byte("hi")},
+		{"array", []byte{0x92, 0x01, 0xa1, 'a'}, []interface{}{int64(1), "a"}},
+		{"map with integer key", []byte{0x81, 0x01, 0xa1, 'a'}, map[string]interface{}{"1": "a"}},
+		{"event time", []byte{0xd7, 0x00, 0x5b, 0x11, 0x34, 0x40, 0x00, 0x00, 0x01, 0xf4}, time.Unix(1527854144, 500)},
+		{"ext", []byte{0xd4, 0x05, 0x2a}, msgpackExt{Type: 5, Data: []byte{0x2a}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := decodeMsgpack(bytes.NewReader(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%#v", v) != fmt.Sprintf("%#v", test.expected) {
+				t.Errorf("Decoded %#v, expecting %#v", v, test.expected)
+			}
+		})
+	}
+}
+
+func TestDecodeMsgpackInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"truncated string", []byte{0xa5, 'h', 'i'}, io.ErrUnexpectedEOF.Error()},
+		{"truncated length", []byte{0xda, 0x01}, io.ErrUnexpectedEOF.Error()},
+		{"truncated array", []byte{0x93, 0x01}, "EOF"},
+		{"string over length", []byte{0xdb, 0xff, 0xff, 0xff, 0xff}, "over the limit"},
+		{"binary over length", []byte{0xc6, 0x04, 0x00, 0x00, 0x01}, "over the limit"},
+		{"array over length", []byte{0xdd, 0x7f, 0xff, 0xff, 0xff}, "over the limit"},
+		{"map over length", []byte{0xdf, 0xff, 0xff, 0xff, 0xff}, "over the limit"},
+		{"nested too deeply", bytes.Repeat([]byte{0x91}, maxMsgpackDepth+2), "nested too deeply"},
+		{"invalid type", []byte{0xc1}, "Invalid msgpack type"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeMsgpack(bytes.NewReader(test.data))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Failed with %v, expecting %q", err, test.err)
+			}
+		})
+	}
+
+	// A long array of a claimed length is not allocated up front
+	if _, err := decodeMsgpack(bytes.NewReader([]byte{0xdd, 0x03, 0xff, 0xff, 0xff})); err != io.EOF {
+		t.Errorf("Failed with %v, expecting EOF", err)
+	}
+}
+
+func TestEncodeMsgpack(t *testing.T) {
+	value := []interface{}{"PONG", true, nil, map[string]interface{}{"ack": strings.Repeat("c", 40)}}
+
+	var buf bytes.Buffer
+	encodeMsgpack(&buf, value)
+	decoded, err := decodeMsgpack(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%#v", decoded) != fmt.Sprintf("%#v", value) {
+		t.Errorf("Decoded %#v, expecting %#v", decoded, value)
+	}
+}
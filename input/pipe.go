@@ -0,0 +1,160 @@
+package input
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yangl900/log2oms/tail"
+)
+
+const (
+	pipeRetryInterval = time.Second
+)
+
+// PipeInput delivers the lines written to a named pipe (FIFO). The pipe is opened again when
+// the writer closes it, so the writing application can be restarted.
+type PipeInput struct {
+	path   string
+	config tail.Config
+	events chan *Event
+	done   chan struct{}
+	once   sync.Once
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewPipeInput reads lines from the named pipe at path, ending and bounded as the line settings of
+// config tell
+func NewPipeInput(path string, config tail.Config) (*PipeInput, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s is not a named pipe", path)
+	}
+
+	in := &PipeInput{path: path, config: config, events: make(chan *Event), done: make(chan struct{})}
+	go in.run()
+
+	return in, nil
+}
+
+// IsNamedPipe tells whether path is a named pipe
+func IsNamedPipe(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// Events returns the channel events are delivered on
+func (in *PipeInput) Events() <-chan *Event {
+	return in.events
+}
+
+// Stop stops reading the pipe
+func (in *PipeInput) Stop() {
+	in.once.Do(func() {
+		close(in.done)
+
+		in.mu.Lock()
+		if in.file != nil {
+			in.file.Close()
+		}
+		in.mu.Unlock()
+
+		// Opening the write side releases an open waiting for a writer
+		if w, err := os.OpenFile(in.path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			w.Close()
+		}
+	})
+}
+
+func (in *PipeInput) run() {
+	defer close(in.events)
+
+	for {
+		// Blocks until a writer opens the pipe
+		f, err := os.Open(in.path)
+		if in.stopped() {
+			if f != nil {
+				f.Close()
+			}
+			return
+		}
+		if err != nil {
+			if !in.send(&Event{Time: time.Now(), Source: in.path, Err: err}) {
+				return
+			}
+
+			select {
+			case <-in.done:
+				return
+			case <-time.After(pipeRetryInterval):
+			}
+			continue
+		}
+
+		in.mu.Lock()
+		in.file = f
+		in.mu.Unlock()
+
+		ok := in.read(f)
+
+		in.mu.Lock()
+		in.file = nil
+		in.mu.Unlock()
+		f.Close()
+
+		if !ok || in.stopped() {
+			return
+		}
+	}
+}
+
+// read delivers lines until the writer closes the pipe, a last line without delimiter is
+// delivered as is
+func (in *PipeInput) read(f *os.File) bool {
+	reader := tail.NewLineReader(f, in.config)
+	for {
+		text, err := reader.ReadLine()
+		if _, dropped := err.(*tail.DroppedLineError); dropped {
+			if !in.send(&Event{Time: time.Now(), Source: in.path, Err: err}) {
+				return false
+			}
+			continue
+		}
+
+		if err != nil {
+			if err != io.EOF && !in.stopped() {
+				return in.send(&Event{Time: time.Now(), Source: in.path, Err: err})
+			}
+			return true
+		}
+		if !in.send(&Event{Time: time.Now(), Text: text, Source: in.path}) {
+			return false
+		}
+	}
+}
+
+func (in *PipeInput) stopped() bool {
+	select {
+	case <-in.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (in *PipeInput) send(e *Event) bool {
+	select {
+	case in.events <- e:
+		return true
+	case <-in.done:
+		return false
+	}
+}
@@ -0,0 +1,140 @@
+package input
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yangl900/log2oms/tail"
+)
+
+// ReaderInput delivers the lines of a stream, e.g. stdin, until it ends
+type ReaderInput struct {
+	name     string
+	withPath bool
+	events   chan *Event
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewReaderInput reads newline delimited lines from r, name is used as the source of the events
+func NewReaderInput(r io.Reader, name string) *ReaderInput {
+	return newReaderInput(r, nil, name, false, tail.Config{})
+}
+
+// ReadFile reads the lines of the file at path to its end instead of following it, ending and
+// bounded as the line settings of config tell. withPath adds the path as FilePath field, as
+// NewFileInput does.
+func ReadFile(path string, withPath bool, config tail.Config) (*ReaderInput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReaderInput(f, f, path, withPath, config), nil
+}
+
+// newReaderInput reads lines from r, closer is closed once r ends or the input is stopped
+func newReaderInput(r io.Reader, closer io.Closer, name string, withPath bool, config tail.Config) *ReaderInput {
+	in := &ReaderInput{name: name, withPath: withPath, events: make(chan *Event), done: make(chan struct{})}
+
+	go func() {
+		defer close(in.events)
+		if closer != nil {
+			defer closer.Close()
+		}
+
+		// Lines are read apart, so that stopping doesn't wait for a read which may never return
+		lines := make(chan readLine)
+		go in.read(tail.NewLineReader(r, config), lines)
+
+		for {
+			var text string
+			var err error
+			select {
+			case line := <-lines:
+				text, err = line.text, line.err
+			case <-in.done:
+				return
+			}
+
+			if _, dropped := err.(*tail.DroppedLineError); dropped {
+				if !in.send(&Event{Time: time.Now(), Source: in.name, Err: err}) {
+					return
+				}
+				continue
+			}
+
+			if err != nil {
+				if err != io.EOF {
+					in.send(&Event{Time: time.Now(), Source: in.name, Err: err})
+				}
+				return
+			}
+			if !in.send(in.event(text)) {
+				return
+			}
+		}
+	}()
+
+	return in
+}
+
+// NewStdinInput reads lines from the standard input, ending and bounded as the line settings of
+// config tell
+func NewStdinInput(config tail.Config) *ReaderInput {
+	return newReaderInput(os.Stdin, nil, "stdin", false, config)
+}
+
+// readLine is a line read, or the error reading it
+type readLine struct {
+	text string
+	err  error
+}
+
+// read passes the lines of reader to lines until it fails or the input is stopped
+func (in *ReaderInput) read(reader *tail.LineReader, lines chan<- readLine) {
+	for {
+		text, err := reader.ReadLine()
+		select {
+		case lines <- readLine{text, err}:
+		case <-in.done:
+			return
+		}
+
+		if _, dropped := err.(*tail.DroppedLineError); err != nil && !dropped {
+			return
+		}
+	}
+}
+
+func (in *ReaderInput) event(text string) *Event {
+	e := &Event{Time: time.Now(), Text: text, Source: in.name}
+	if in.withPath {
+		e.Fields = map[string]interface{}{"FilePath": in.name}
+	}
+
+	return e
+}
+
+func (in *ReaderInput) send(e *Event) bool {
+	select {
+	case in.events <- e:
+		return true
+	case <-in.done:
+		return false
+	}
+}
+
+// Events returns the channel events are delivered on
+func (in *ReaderInput) Events() <-chan *Event {
+	return in.events
+}
+
+// Stop stops delivering events and closes the events channel, a read in progress is abandoned
+func (in *ReaderInput) Stop() {
+	in.once.Do(func() {
+		close(in.done)
+	})
+}
@@ -0,0 +1,69 @@
+package input
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yangl900/log2oms/tail"
+)
+
+func TestReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	content := "first\r\n" + strings.Repeat("x", 100) + "\r\nlast"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := ReadFile(path, true, tail.Config{MaxLineSize: 10, LinePolicy: tail.LineDrop, LineEnd: tail.LineEnd{StripCR: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var texts []string
+	dropped := 0
+	for e := range in.Events() {
+		if _, ok := e.Err.(*tail.DroppedLineError); ok {
+			dropped++
+			continue
+		}
+		if e.Err != nil {
+			t.Fatal(e.Err)
+		}
+		if e.Fields["FilePath"] != path {
+			t.Errorf("FilePath is %v, expecting %s", e.Fields["FilePath"], path)
+		}
+		texts = append(texts, e.Text)
+	}
+
+	if strings.Join(texts, ",") != "first,last" || dropped != 1 {
+		t.Errorf("Read %q and dropped %d lines, expecting first and last and a dropped line", texts, dropped)
+	}
+}
+
+func TestReaderInputStop(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	// The events channel is closed even though the read in progress never returns
+	in := NewReaderInput(r, "pipe")
+	in.Stop()
+	select {
+	case e, ok := <-in.Events():
+		if ok {
+			t.Errorf("Delivered %v once stopped", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("The events channel is still open once stopped")
+	}
+}
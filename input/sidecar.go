@@ -0,0 +1,207 @@
+package input
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/yangl900/log2oms/tail"
+)
+
+const (
+	// defaultSidecarLayout names the first directory level after the container writing the logs
+	defaultSidecarLayout = "{ContainerName}/*"
+)
+
+var (
+	sidecarPlaceholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	// invalidLogTypeChars are replaced in log types expanded from paths
+	invalidLogTypeChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+)
+
+// SidecarConfig selects the logs SidecarInput ships from a volume shared with app containers
+type SidecarConfig struct {
+	// Dir is where the volume is mounted
+	Dir string
+	// Layout is the slash separated path of the log files relative to Dir. {Name} matches a
+	// path segment or part of one and adds its value as field Name, * and ? match as in
+	// filepath.Match, e.g. "{ContainerName}/*.log" or "{App}-{Instance}.log". Defaults to
+	// "{ContainerName}/*".
+	Layout string
+	// LogType is the log type of the logs of a file, {Name} is replaced by the value of the
+	// placeholder in its path and characters not allowed in log types by underscores, e.g.
+	// "{ContainerName}". The log type of the output is used when empty.
+	LogType string
+	// Tail configures how the files are followed
+	Tail tail.Config
+}
+
+// SidecarInput follows the log files that several app containers write to a shared volume, e.g.
+// an emptyDir mounted in each container of a pod, adding the metadata derived from their paths
+type SidecarInput struct {
+	config SidecarConfig
+	layout *regexp.Regexp
+	tailer *tail.MultiTailer
+	events chan *Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewSidecarInput starts following the files of the volume matching the layout
+func NewSidecarInput(config SidecarConfig) (*SidecarInput, error) {
+	if config.Layout == "" {
+		config.Layout = defaultSidecarLayout
+	}
+
+	layout, err := compileLayout(config.Layout)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sidecarPlaceholder.FindAllStringSubmatch(config.LogType, -1) {
+		if layout.SubexpIndex(name[1]) < 0 {
+			return nil, fmt.Errorf("Log type %s uses {%s} which is not in layout %s", config.LogType, name[1], config.Layout)
+		}
+	}
+
+	in := &SidecarInput{config: config, layout: layout, events: make(chan *Event), done: make(chan struct{})}
+
+	tailer, err := tail.WatchDir(config.Dir, tail.DirConfig{Filter: in.selects}, config.Tail)
+	if err != nil {
+		return nil, err
+	}
+	in.tailer = tailer
+
+	go in.run()
+
+	return in, nil
+}
+
+// compileLayout converts a layout to a regular expression matching slash separated relative
+// paths, with a named group per placeholder
+func compileLayout(layout string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	seen := map[string]bool{}
+	rest := layout
+	for rest != "" {
+		loc := sidecarPlaceholder.FindStringSubmatchIndex(rest)
+		literal := rest
+		if loc != nil {
+			literal = rest[:loc[0]]
+		}
+
+		for _, c := range literal {
+			switch c {
+			case '*':
+				expr.WriteString(`[^/]*`)
+			case '?':
+				expr.WriteString(`[^/]`)
+			default:
+				expr.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		if loc == nil {
+			break
+		}
+
+		name := rest[loc[2]:loc[3]]
+		if seen[name] {
+			return nil, fmt.Errorf("Layout %s uses {%s} twice", layout, name)
+		}
+		seen[name] = true
+		fmt.Fprintf(&expr, `(?P<%s>[^/]+?)`, name)
+		rest = rest[loc[1]:]
+	}
+	expr.WriteString("$")
+
+	return regexp.Compile(expr.String())
+}
+
+// Events returns the channel events are delivered on
+func (in *SidecarInput) Events() <-chan *Event {
+	return in.events
+}
+
+// Reopen reopens the files followed
+func (in *SidecarInput) Reopen() {
+	in.tailer.Reopen()
+}
+
+// Stop stops following the files
+func (in *SidecarInput) Stop() {
+	in.once.Do(func() {
+		close(in.done)
+		in.tailer.Stop()
+	})
+}
+
+// selects tells whether the file at path matches the layout
+func (in *SidecarInput) selects(path string) bool {
+	return in.match(path) != nil
+}
+
+// match returns the values of the placeholders in path, nil when it does not match the layout
+func (in *SidecarInput) match(path string) map[string]string {
+	rel, err := filepath.Rel(in.config.Dir, path)
+	if err != nil {
+		return nil
+	}
+
+	submatches := in.layout.FindStringSubmatch(filepath.ToSlash(rel))
+	if submatches == nil {
+		return nil
+	}
+
+	values := map[string]string{}
+	for i, name := range in.layout.SubexpNames() {
+		if name != "" {
+			values[name] = submatches[i]
+		}
+	}
+
+	return values
+}
+
+func (in *SidecarInput) run() {
+	defer close(in.events)
+
+	// Paths are matched once per file
+	matches := map[string]map[string]string{}
+	for line := range in.tailer.Lines {
+		e := &Event{Time: line.Time, Text: line.Text, Source: line.Filename, Err: line.Err, Ack: line.Ack}
+		if line.Err == nil {
+			values, ok := matches[line.Filename]
+			if !ok {
+				values = in.match(line.Filename)
+				matches[line.Filename] = values
+			}
+
+			e.Fields = map[string]interface{}{"FilePath": line.Filename}
+			for name, value := range values {
+				e.Fields[name] = value
+			}
+			e.LogType = in.logType(values)
+		}
+
+		select {
+		case in.events <- e:
+		case <-in.done:
+		}
+	}
+}
+
+// logType expands the log type of a file from the values of its placeholders
+func (in *SidecarInput) logType(values map[string]string) string {
+	if in.config.LogType == "" {
+		return ""
+	}
+
+	expanded := sidecarPlaceholder.ReplaceAllStringFunc(in.config.LogType, func(placeholder string) string {
+		return values[placeholder[1:len(placeholder)-1]]
+	})
+
+	return strings.Trim(invalidLogTypeChars.ReplaceAllString(expanded, "_"), "_")
+}
@@ -0,0 +1,330 @@
+package input
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxSyslogMessageSize = 64 * 1024
+)
+
+var (
+	syslogFacilities = []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+	syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+)
+
+// SyslogInput receives RFC 3164 and RFC 5424 syslog messages over UDP and TCP. TCP messages are
+// either newline delimited or octet counted (RFC 6587).
+type SyslogInput struct {
+	events   chan *Event
+	done     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+	udp      net.PacketConn
+	tcp      net.Listener
+	connsMu  sync.Mutex
+	conns    map[net.Conn]bool
+	location *time.Location
+}
+
+// NewSyslogInput listens on address, e.g. ":514", for UDP and TCP syslog messages
+func NewSyslogInput(address string) (*SyslogInput, error) {
+	udp, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	tcp, err := net.Listen("tcp", address)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+
+	in := &SyslogInput{
+		events:   make(chan *Event),
+		done:     make(chan struct{}),
+		udp:      udp,
+		tcp:      tcp,
+		conns:    map[net.Conn]bool{},
+		location: time.Local,
+	}
+
+	in.wg.Add(2)
+	go in.serveUDP()
+	go in.serveTCP()
+
+	go func() {
+		in.wg.Wait()
+		close(in.events)
+	}()
+
+	return in, nil
+}
+
+// Events returns the channel events are delivered on
+func (in *SyslogInput) Events() <-chan *Event {
+	return in.events
+}
+
+// Stop closes the listeners and open connections
+func (in *SyslogInput) Stop() {
+	in.once.Do(func() {
+		close(in.done)
+		in.udp.Close()
+		in.tcp.Close()
+
+		in.connsMu.Lock()
+		for conn := range in.conns {
+			conn.Close()
+		}
+		in.connsMu.Unlock()
+	})
+}
+
+func (in *SyslogInput) serveUDP() {
+	defer in.wg.Done()
+
+	buf := make([]byte, maxSyslogMessageSize)
+	for {
+		n, addr, err := in.udp.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-in.done:
+				return
+			default:
+				in.send(&Event{Time: time.Now(), Source: "syslog", Err: err})
+				continue
+			}
+		}
+
+		// Some senders end datagrams with a newline as they would over TCP
+		if !in.send(in.parse(strings.TrimRight(string(buf[:n]), "\r\n"), addr)) {
+			return
+		}
+	}
+}
+
+func (in *SyslogInput) serveTCP() {
+	defer in.wg.Done()
+
+	for {
+		conn, err := in.tcp.Accept()
+		if err != nil {
+			select {
+			case <-in.done:
+				return
+			default:
+				in.send(&Event{Time: time.Now(), Source: "syslog", Err: err})
+				time.Sleep(time.Second)
+				continue
+			}
+		}
+
+		in.connsMu.Lock()
+		in.conns[conn] = true
+		in.connsMu.Unlock()
+
+		in.wg.Add(1)
+		go in.serveConn(conn)
+	}
+}
+
+func (in *SyslogInput) serveConn(conn net.Conn) {
+	defer in.wg.Done()
+	defer func() {
+		conn.Close()
+		in.connsMu.Lock()
+		delete(in.conns, conn)
+		in.connsMu.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		message, err := readFrame(reader)
+		if message != "" && !in.send(in.parse(message, conn.RemoteAddr())) {
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readFrame reads an octet counted or a newline delimited message
+func readFrame(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return "", err
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil || n > maxSyslogMessageSize {
+			// Not a frame length, treat it as the beginning of a newline delimited message
+			rest, err := reader.ReadString('\n')
+			return strings.TrimRight(length+rest, "\r\n"), err
+		}
+
+		buf := make([]byte, n)
+		read, err := io.ReadFull(reader, buf)
+		return strings.TrimRight(string(buf[:read]), "\r\n"), err
+	}
+
+	message, err := reader.ReadString('\n')
+	return strings.TrimRight(message, "\r\n"), err
+}
+
+func (in *SyslogInput) send(e *Event) bool {
+	select {
+	case in.events <- e:
+		return true
+	case <-in.done:
+		return false
+	}
+}
+
+// parse converts a syslog message to an event, a message not following either RFC is kept as is
+func (in *SyslogInput) parse(message string, addr net.Addr) *Event {
+	e := &Event{Time: time.Now(), Text: message, Source: "syslog", Fields: map[string]interface{}{}}
+	if addr != nil {
+		e.Fields["SourceAddress"] = addr.String()
+	}
+
+	if !strings.HasPrefix(message, "<") {
+		return e
+	}
+
+	end := strings.IndexByte(message, '>')
+	if end < 2 || end > 4 {
+		return e
+	}
+
+	priority, err := strconv.Atoi(message[1:end])
+	if err != nil || priority > 191 {
+		return e
+	}
+
+	e.Fields["Facility"] = syslogFacilities[priority/8]
+	e.Fields["Severity"] = syslogSeverities[priority%8]
+
+	rest := message[end+1:]
+	e.Text = rest
+	if strings.HasPrefix(rest, "1 ") {
+		parseRFC5424(e, rest[2:])
+	} else {
+		parseRFC3164(e, rest, in.location)
+	}
+
+	return e
+}
+
+// parseRFC5424 parses "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG"
+func parseRFC5424(e *Event, rest string) {
+	parts := strings.SplitN(rest, " ", 6)
+	if len(parts) < 6 {
+		return
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, parts[0]); err == nil {
+		e.Time = t
+	}
+
+	for i, field := range []string{"SourceHost", "AppName", "ProcID", "MsgID"} {
+		if parts[i+1] != "-" {
+			e.Fields[field] = parts[i+1]
+		}
+	}
+
+	data, msg := splitStructuredData(parts[5])
+	if data != "-" && data != "" {
+		e.Fields["StructuredData"] = data
+	}
+
+	e.Text = strings.TrimPrefix(msg, "\ufeff")
+}
+
+// splitStructuredData separates the structured data elements from the message
+func splitStructuredData(s string) (string, string) {
+	if strings.HasPrefix(s, "-") {
+		return "-", strings.TrimPrefix(s[1:], " ")
+	}
+
+	depth, escaped := 0, false
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ' ' && depth == 0:
+			return s[:i], s[i+1:]
+		}
+	}
+
+	if depth == 0 {
+		return s, ""
+	}
+
+	return "", s
+}
+
+// parseRFC3164 parses "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG", the year is assumed to be the
+// current one
+func parseRFC3164(e *Event, rest string, location *time.Location) {
+	const stampLength = len(time.Stamp)
+	if len(rest) < stampLength+1 {
+		return
+	}
+
+	t, err := time.ParseInLocation(time.Stamp, rest[:stampLength], location)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().In(location)
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.AddDate(0, 1, 0)) {
+		// December messages received in January
+		t = t.AddDate(-1, 0, 0)
+	}
+	e.Time = t
+
+	rest = strings.TrimPrefix(rest[stampLength:], " ")
+	if space := strings.IndexByte(rest, ' '); space > 0 {
+		e.Fields["SourceHost"] = rest[:space]
+		rest = rest[space+1:]
+	}
+
+	if colon := strings.Index(rest, ": "); colon > 0 && !strings.ContainsAny(rest[:colon], " ") {
+		tag := rest[:colon]
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			e.Fields["ProcID"] = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+
+		e.Fields["AppName"] = tag
+		rest = rest[colon+2:]
+	}
+
+	e.Text = rest
+}
@@ -0,0 +1,159 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		text    string
+		fields  map[string]interface{}
+		// time is the time of the event in RFC3339, or formatted as time.Stamp for RFC 3164, ""
+		// when it is the time received
+		time string
+	}{
+		{
+			name:    "rfc5424",
+			message: `<165>1 2018-06-01T12:00:00.5Z host app 42 ID47 [exampleSDID@32473 iut="3" eventSource="App\]"][x@1 a="b c"] ` + "\ufeffstarted",
+			text:    "started",
+			fields: map[string]interface{}{
+				"Facility": "local4", "Severity": "notice", "SourceHost": "host", "AppName": "app", "ProcID": "42", "MsgID": "ID47",
+				"StructuredData": `[exampleSDID@32473 iut="3" eventSource="App\]"][x@1 a="b c"]`,
+			},
+			time: "2018-06-01T12:00:00.5Z",
+		},
+		{
+			name:    "rfc5424 nil values",
+			message: "<14>1 - - - - - - message with spaces",
+			text:    "message with spaces",
+			fields:  map[string]interface{}{"Facility": "user", "Severity": "info"},
+		},
+		{
+			name:    "rfc5424 without message",
+			message: "<14>1 2018-06-01T12:00:00Z host app - - [a@1 k=\"v\"]",
+			text:    "",
+			fields:  map[string]interface{}{"Facility": "user", "Severity": "info", "SourceHost": "host", "AppName": "app", "StructuredData": `[a@1 k="v"]`},
+			time:    "2018-06-01T12:00:00Z",
+		},
+		{
+			name:    "rfc3164",
+			message: "<34>Oct 11 22:14:15 mymachine su[230]: 'su root' failed on /dev/pts/8",
+			text:    "'su root' failed on /dev/pts/8",
+			fields:  map[string]interface{}{"Facility": "auth", "Severity": "crit", "SourceHost": "mymachine", "AppName": "su", "ProcID": "230"},
+			time:    "Oct 11 22:14:15",
+		},
+		{
+			name:    "rfc3164 without tag",
+			message: "<13>Jun  1 08:00:00 host free text: here",
+			text:    "free text: here",
+			fields:  map[string]interface{}{"Facility": "user", "Severity": "notice", "SourceHost": "host"},
+			time:    "Jun  1 08:00:00",
+		},
+		{
+			name:    "rfc3164 without timestamp",
+			message: "<13>some text",
+			text:    "some text",
+			fields:  map[string]interface{}{"Facility": "user", "Severity": "notice"},
+		},
+		{name: "without priority", message: "plain text", text: "plain text", fields: map[string]interface{}{}},
+		{name: "invalid priority", message: "<192>1 - - - - - - x", text: "<192>1 - - - - - - x", fields: map[string]interface{}{}},
+		{name: "unterminated priority", message: "<13 text", text: "<13 text", fields: map[string]interface{}{}},
+	}
+
+	in := &SyslogInput{location: time.UTC}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received := time.Now()
+			e := in.parse(test.message, nil)
+			if e.Text != test.text {
+				t.Errorf("Text is %q, expecting %q", e.Text, test.text)
+			}
+			if !reflect.DeepEqual(e.Fields, test.fields) {
+				t.Errorf("Parsed %v, expecting %v", e.Fields, test.fields)
+			}
+
+			switch {
+			case test.time == "":
+				if e.Time.Before(received) {
+					t.Errorf("Time is %v, expecting the time received", e.Time)
+				}
+			case strings.HasPrefix(test.message[strings.IndexByte(test.message, '>')+1:], "1 "):
+				if expected, _ := time.Parse(time.RFC3339Nano, test.time); !e.Time.Equal(expected) {
+					t.Errorf("Time is %v, expecting %s", e.Time, test.time)
+				}
+			default:
+				if stamp := e.Time.Format(time.Stamp); stamp != test.time || e.Time.After(received.AddDate(0, 1, 0)) {
+					t.Errorf("Time is %v, expecting %s of the past year", e.Time, test.time)
+				}
+			}
+		})
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		frames []string
+	}{
+		{"newline delimited", "<13>first\r\n<13>second\nlast", []string{"<13>first", "<13>second", "last"}},
+		{"octet counted", "9 <13>first10 <13>second\n", []string{"<13>first", "<13>second"}},
+		{"octet counted with newlines", "14 <13>two\nlines\n", []string{"<13>two\nlines"}},
+		{"mixed", "<13>first\n9 <13>third", []string{"<13>first", "<13>third"}},
+		{"digits without length", "12:00 started\n", []string{"12:00 started"}},
+		{"length over the limit", fmt.Sprintf("%d x\n", maxSyslogMessageSize+1), []string{fmt.Sprintf("%d x", maxSyslogMessageSize+1)}},
+		{"truncated frame", "20 <13>short", []string{"<13>short"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(test.stream))
+			var frames []string
+			for {
+				frame, err := readFrame(reader)
+				if frame != "" {
+					frames = append(frames, frame)
+				}
+				if err != nil {
+					break
+				}
+			}
+
+			if fmt.Sprintf("%q", frames) != fmt.Sprintf("%q", test.frames) {
+				t.Errorf("Read %q, expecting %q", frames, test.frames)
+			}
+		})
+	}
+}
+
+func TestSyslogInput(t *testing.T) {
+	in, err := NewSyslogInput("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Stop()
+
+	for network, addr := range map[string]net.Addr{"udp": in.udp.LocalAddr(), "tcp": in.tcp.Addr()} {
+		conn, err := net.Dial(network, addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("<14>1 - host app - - - over " + network + "\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		e := receiveEvents(t, in, 1)[0]
+		if e.Text != "over "+network || e.Fields["SourceHost"] != "host" || e.Fields["SourceAddress"] == nil {
+			t.Errorf("Received %q with %v over %s", e.Text, e.Fields, network)
+		}
+	}
+}
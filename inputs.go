@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yangl900/log2oms/input"
+	"github.com/yangl900/log2oms/logging"
+	"github.com/yangl900/log2oms/tail"
+)
+
+const (
+	// stdinPath as log file reads logs from stdin
+	stdinPath = "-"
+)
+
+// newInput creates the inputs configured by c, combined into one. Files are followed with
+// tailConfig.
+func newInput(c inputsConfig, tailConfig tail.Config) (input.Input, error) {
+	var inputs []input.Input
+	tailConfig.ScanInterval = c.ScanInterval
+	tailConfig.MaxLineSize = int(c.MaxLineSize)
+
+	switch policy := strings.ToLower(c.LinePolicy); policy {
+	case "", "truncate":
+		tailConfig.LinePolicy = tail.LineTruncate
+	case "split":
+		tailConfig.LinePolicy = tail.LineSplit
+	case "drop":
+		tailConfig.LinePolicy = tail.LineDrop
+	default:
+		return nil, fmt.Errorf("Invalid line policy %s, expecting truncate, split or drop", policy)
+	}
+
+	// Container logs are newline delimited whatever the files of the host are
+	containerTail := tailConfig
+	var err error
+	if tailConfig.LineEnd, err = parseLineEnd(c.LineDelimiter); err != nil {
+		return nil, err
+	}
+
+	fileInput, err := newFileInput(c, tailConfig)
+	if err != nil {
+		return nil, err
+	}
+	if fileInput != nil {
+		inputs = append(inputs, fileInput)
+	}
+
+	if c.Syslog != "" {
+		syslog, err := input.NewSyslogInput(c.Syslog)
+		if err != nil {
+			stopAll(inputs)
+			return nil, fmt.Errorf("Failed to listen for syslog on %s: %v", c.Syslog, err)
+		}
+
+		logging.Infof("Start receiving syslog on: %s", c.Syslog)
+		inputs = append(inputs, syslog)
+	}
+
+	if c.Journal != nil {
+		journal, err := input.NewJournalInput(input.JournalConfig{
+			Units:      c.Journal.Units,
+			CursorFile: c.Journal.CursorFile,
+		})
+		if err != nil {
+			stopAll(inputs)
+			return nil, err
+		}
+
+		logging.Infof("Start reading systemd journal")
+		inputs = append(inputs, journal)
+	}
+
+	if c.Docker != nil {
+		docker, err := input.NewDockerInput(input.DockerConfig{
+			Socket:         c.Docker.Socket,
+			Labels:         c.Docker.Labels,
+			MetadataLabels: c.Docker.MetadataLabels,
+			MetadataEnv:    c.Docker.MetadataEnv,
+		})
+		if err != nil {
+			stopAll(inputs)
+			return nil, err
+		}
+
+		logging.Infof("Start shipping docker container logs")
+		inputs = append(inputs, docker)
+	}
+
+	if c.Kubernetes != nil {
+		kubernetes, err := input.NewKubernetesInput(input.KubernetesConfig{
+			LogDir:        c.Kubernetes.LogDir,
+			Namespaces:    c.Kubernetes.Namespaces,
+			LabelSelector: c.Kubernetes.LabelSelector,
+			NodeName:      c.Kubernetes.NodeName,
+			Metadata:      c.Kubernetes.Metadata,
+			Tail:          containerTail,
+		})
+		if err != nil {
+			stopAll(inputs)
+			return nil, err
+		}
+
+		logging.Infof("Start shipping kubernetes pod logs")
+		inputs = append(inputs, kubernetes)
+	}
+
+	if c.EventLog != nil && len(c.EventLog.Channels) > 0 {
+		eventLog, err := input.NewEventLogInput(c.EventLog.Channels)
+		if err != nil {
+			stopAll(inputs)
+			return nil, err
+		}
+
+		logging.Infof("Start reading windows event log channels: %s", strings.Join(c.EventLog.Channels, ", "))
+		inputs = append(inputs, eventLog)
+	}
+
+	if c.Sidecar != nil {
+		sidecar, err := input.NewSidecarInput(input.SidecarConfig{
+			Dir:     c.Sidecar.Dir,
+			Layout:  c.Sidecar.Layout,
+			LogType: c.Sidecar.LogType,
+			Tail:    tailConfig,
+		})
+		if err != nil {
+			stopAll(inputs)
+			return nil, err
+		}
+
+		logging.Infof("Start shipping the logs of containers under: %s", c.Sidecar.Dir)
+		inputs = append(inputs, sidecar)
+	}
+
+	if c.Forward != nil {
+		forward, err := input.NewForwardInput(input.ForwardConfig{
+			Address:   c.Forward.Address,
+			SharedKey: c.Forward.SharedKey,
+		})
+		if err != nil {
+			stopAll(inputs)
+			return nil, fmt.Errorf("Failed to listen for fluentd forward on %s: %v", c.Forward.Address, err)
+		}
+
+		logging.Infof("Start receiving fluentd forward on: %s", c.Forward.Address)
+		inputs = append(inputs, forward)
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("No input configured")
+	}
+
+	return input.Merge(inputs...), nil
+}
+
+// newFileInput creates the input reading logs from the directory tree, or the files or stdin,
+// it returns nil when none is configured
+func newFileInput(c inputsConfig, tailConfig tail.Config) (input.Input, error) {
+	if c.Dir != "" {
+		t, err := tail.WatchDir(c.Dir, tail.DirConfig{
+			Include: c.DirInclude,
+			Exclude: c.DirExclude,
+		}, tailConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		logging.Infof("Start tail logs under: %s", c.Dir)
+		return input.NewFileInput(t, true), nil
+	}
+
+	patterns := c.Files
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	if c.once {
+		return readFiles(patterns, tailConfig)
+	}
+
+	if len(patterns) == 1 && patterns[0] == stdinPath {
+		logging.Infof("Start reading logs from stdin")
+		return input.NewStdinInput(tailConfig), nil
+	}
+
+	if len(patterns) == 1 && input.IsNamedPipe(patterns[0]) {
+		pipe, err := input.NewPipeInput(patterns[0], tailConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		logging.Infof("Start reading logs from pipe: %s", patterns[0])
+		return pipe, nil
+	}
+
+	t, err := tail.FollowGlob(patterns, tailConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Infof("Start tail logs from: %s", strings.Join(patterns, ", "))
+
+	// Lines of several files go to the same table, tell them apart by path
+	multiFile := len(patterns) > 1 || strings.ContainsAny(patterns[0], "*?[")
+
+	return input.NewFileInput(t, multiFile), nil
+}
+
+// readFiles creates the input reading the files matching patterns to their end, or stdin, with
+// lines ending and bounded as the line settings of tailConfig tell
+func readFiles(patterns []string, tailConfig tail.Config) (input.Input, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		if pattern == stdinPath {
+			paths = append(paths, pattern)
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No file matches %s", pattern)
+		}
+		paths = append(paths, matches...)
+	}
+
+	var inputs []input.Input
+	for _, path := range paths {
+		if path == stdinPath {
+			inputs = append(inputs, input.NewStdinInput(tailConfig))
+			continue
+		}
+
+		in, err := input.ReadFile(path, len(paths) > 1, tailConfig)
+		if err != nil {
+			stopAll(inputs)
+			return nil, err
+		}
+		inputs = append(inputs, in)
+	}
+
+	logging.Infof("Start reading logs from: %s", strings.Join(paths, ", "))
+	return input.Merge(inputs...), nil
+}
+
+// parseLineEnd parses a line delimiter: lf, the default, crlf removing the carriage return
+// before newlines, nul or \0, or a single character which may be escaped, e.g. \x1e or ;
+func parseLineEnd(delimiter string) (tail.LineEnd, error) {
+	switch strings.ToLower(delimiter) {
+	case "", "lf":
+		return tail.LineEnd{}, nil
+	case "crlf":
+		return tail.LineEnd{StripCR: true}, nil
+	case "nul", `\0`:
+		return tail.LineEnd{Delimiter: "\x00"}, nil
+	}
+
+	unquoted, err := strconv.Unquote(`"` + delimiter + `"`)
+	if err != nil || len(unquoted) != 1 {
+		return tail.LineEnd{}, fmt.Errorf("Invalid line delimiter %s, expecting lf, crlf, nul or a single character", delimiter)
+	}
+
+	return tail.LineEnd{Delimiter: unquoted}, nil
+}
+
+func stopAll(inputs []input.Input) {
+	for _, in := range inputs {
+		in.Stop()
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/yangl900/log2oms/tail"
+)
+
+func TestParseLineEnd(t *testing.T) {
+	tests := []struct {
+		delimiter string
+		lineEnd   tail.LineEnd
+		valid     bool
+	}{
+		{"", tail.LineEnd{}, true},
+		{"LF", tail.LineEnd{}, true},
+		{"crlf", tail.LineEnd{StripCR: true}, true},
+		{"nul", tail.LineEnd{Delimiter: "\x00"}, true},
+		{`\0`, tail.LineEnd{Delimiter: "\x00"}, true},
+		{`\x1e`, tail.LineEnd{Delimiter: "\x1e"}, true},
+		{";", tail.LineEnd{Delimiter: ";"}, true},
+		{`\t`, tail.LineEnd{Delimiter: "\t"}, true},
+		{"ab", tail.LineEnd{}, false},
+		{"é", tail.LineEnd{}, false},
+		{`\`, tail.LineEnd{}, false},
+	}
+
+	for _, test := range tests {
+		lineEnd, err := parseLineEnd(test.delimiter)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("Expecting %q to be rejected", test.delimiter)
+			}
+			continue
+		}
+		if err != nil || lineEnd != test.lineEnd {
+			t.Errorf("Parsed %q as %+v (%v), expecting %+v", test.delimiter, lineEnd, err, test.lineEnd)
+		}
+	}
+}
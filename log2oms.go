@@ -3,111 +3,183 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/hpcloud/tail"
-	"github.com/yangl900/log2oms/logclient"
+	"github.com/yangl900/log2oms/otlp"
 )
 
 const (
-	envLogFile         = "LOG2OMS_LOG_FILE"
-	envLogType         = "LOG2OMS_LOG_TYPE"
-	envWorkspaceID     = "LOG2OMS_WORKSPACE_ID"
-	envWorkspaceSecret = "LOG2OMS_WORKSPACE_SECRET"
-	envMetadataPrefix  = "LOG2OMS_METADATA_"
+	envLogFile                 = "LOG2OMS_LOG_FILE"
+	envLogDir                  = "LOG2OMS_LOG_DIR"
+	envLogDirInclude           = "LOG2OMS_LOG_DIR_INCLUDE"
+	envLogDirExclude           = "LOG2OMS_LOG_DIR_EXCLUDE"
+	envSyslogAddress           = "LOG2OMS_SYSLOG_ADDRESS"
+	envJournal                 = "LOG2OMS_JOURNAL"
+	envJournalUnits            = "LOG2OMS_JOURNAL_UNITS"
+	envJournalCursorFile       = "LOG2OMS_JOURNAL_CURSOR_FILE"
+	envDocker                  = "LOG2OMS_DOCKER"
+	envDockerSocket            = "LOG2OMS_DOCKER_SOCKET"
+	envDockerLabels            = "LOG2OMS_DOCKER_LABELS"
+	envDockerMetadataLabels    = "LOG2OMS_DOCKER_METADATA_LABELS"
+	envDockerMetadataEnv       = "LOG2OMS_DOCKER_METADATA_ENV"
+	envKubernetes              = "LOG2OMS_KUBERNETES"
+	envKubernetesLogDir        = "LOG2OMS_KUBERNETES_LOG_DIR"
+	envKubernetesNamespaces    = "LOG2OMS_KUBERNETES_NAMESPACES"
+	envKubernetesLabelSelector = "LOG2OMS_KUBERNETES_LABEL_SELECTOR"
+	envKubernetesMetadata      = "LOG2OMS_KUBERNETES_METADATA"
+	envEventLogChannels        = "LOG2OMS_EVENTLOG_CHANNELS"
+	envSidecarDir              = "LOG2OMS_SIDECAR_DIR"
+	envSidecarLayout           = "LOG2OMS_SIDECAR_LAYOUT"
+	envSidecarLogType          = "LOG2OMS_SIDECAR_LOG_TYPE"
+	envForwardAddress          = "LOG2OMS_FORWARD_ADDRESS"
+	envForwardSharedKey        = "LOG2OMS_FORWARD_SHARED_KEY"
+	envNodeName                = "NODE_NAME"
+	envNotifySocket            = "NOTIFY_SOCKET"
+	envWatchdogUsec            = "WATCHDOG_USEC"
+	envWatchdogPID             = "WATCHDOG_PID"
+	envCharset                 = "LOG2OMS_CHARSET"
+	envCharsetSources          = "LOG2OMS_CHARSET_SOURCES"
+	envStripANSI               = "LOG2OMS_STRIP_ANSI"
+	envMultilineStart          = "LOG2OMS_MULTILINE_START"
+	envMultilineTimeout        = "LOG2OMS_MULTILINE_TIMEOUT"
+	envParseLogfmt             = "LOG2OMS_PARSE_LOGFMT"
+	envParseCSV                = "LOG2OMS_PARSE_CSV"
+	envCSVDelimiter            = "LOG2OMS_CSV_DELIMITER"
+	envCSVColumns              = "LOG2OMS_CSV_COLUMNS"
+	envCSVSources              = "LOG2OMS_CSV_SOURCES"
+	envRegex                   = "LOG2OMS_REGEX"
+	envRegexSources            = "LOG2OMS_REGEX_SOURCES"
+	envGrok                    = "LOG2OMS_GROK"
+	envGrokSources             = "LOG2OMS_GROK_SOURCES"
+	envAccessLog               = "LOG2OMS_ACCESS_LOG"
+	envAccessLogSources        = "LOG2OMS_ACCESS_LOG_SOURCES"
+	envParseJSON               = "LOG2OMS_PARSE_JSON"
+	envTimestampField          = "LOG2OMS_TIMESTAMP_FIELD"
+	envTimestampRegex          = "LOG2OMS_TIMESTAMP_REGEX"
+	envTimestampLayout         = "LOG2OMS_TIMESTAMP_LAYOUT"
+	envSeverity                = "LOG2OMS_SEVERITY"
+	envInclude                 = "LOG2OMS_INCLUDE"
+	envExclude                 = "LOG2OMS_EXCLUDE"
+	envSampleRate              = "LOG2OMS_SAMPLE_RATE"
+	envSampleRates             = "LOG2OMS_SAMPLE_RATES"
+	envSampleField             = "LOG2OMS_SAMPLE_FIELD"
+	envDedupWindow             = "LOG2OMS_DEDUP_WINDOW"
+	envRedact                  = "LOG2OMS_REDACT"
+	envRedactRegex             = "LOG2OMS_REDACT_REGEX"
+	envRedactPlaceholder       = "LOG2OMS_REDACT_PLACEHOLDER"
+	envSpoolDir                = "LOG2OMS_SPOOL_DIR"
+	envSpoolMaxSize            = "LOG2OMS_SPOOL_MAX_SIZE"
+	envQueueSize               = "LOG2OMS_QUEUE_SIZE"
+	envUploadWorkers           = "LOG2OMS_UPLOAD_WORKERS"
+	envBatchMaxRecords         = "LOG2OMS_BATCH_MAX_RECORDS"
+	envBatchMaxBytes           = "LOG2OMS_BATCH_MAX_BYTES"
+	envFlushInterval           = "LOG2OMS_FLUSH_INTERVAL"
+	envAdaptiveBatching        = "LOG2OMS_ADAPTIVE_BATCHING"
+	envMinFlushInterval        = "LOG2OMS_MIN_FLUSH_INTERVAL"
+	envQueuePolicy             = "LOG2OMS_QUEUE_POLICY"
+	envRateLimitRecords        = "LOG2OMS_RATE_LIMIT_RECORDS"
+	envRateLimitBytes          = "LOG2OMS_RATE_LIMIT_BYTES"
+	envDeadLetterDir           = "LOG2OMS_DEAD_LETTER_DIR"
+	envOversizePolicy          = "LOG2OMS_OVERSIZE_POLICY"
+	envMaxFieldSize            = "LOG2OMS_MAX_FIELD_SIZE"
+	envKeepAlive               = "LOG2OMS_KEEP_ALIVE"
+	envMaxIdleConns            = "LOG2OMS_MAX_IDLE_CONNS"
+	envIdleConnTimeout         = "LOG2OMS_IDLE_CONN_TIMEOUT"
+	envTLSHandshakeTimeout     = "LOG2OMS_TLS_HANDSHAKE_TIMEOUT"
+	envCheckpointFile          = "LOG2OMS_CHECKPOINT_FILE"
+	envScanInterval            = "LOG2OMS_SCAN_INTERVAL"
+	envMaxLineSize             = "LOG2OMS_MAX_LINE_SIZE"
+	envLinePolicy              = "LOG2OMS_LINE_POLICY"
+	envLineDelimiter           = "LOG2OMS_LINE_DELIMITER"
+	envDrainTimeout            = "LOG2OMS_DRAIN_TIMEOUT"
+	envHTTPAddress             = "LOG2OMS_HTTP_ADDRESS"
+	envPprof                   = "LOG2OMS_PPROF"
+	envDebug                   = "LOG2OMS_DEBUG"
+	envDryRun                  = "LOG2OMS_DRY_RUN"
+	envDaemon                  = "LOG2OMS_DAEMON"
+	envPIDFile                 = "LOG2OMS_PID_FILE"
+	envLogLevel                = "LOG2OMS_LOG_LEVEL"
+	envLogFormat               = "LOG2OMS_LOG_FORMAT"
+	envHealthLogType           = "LOG2OMS_HEALTH_LOG_TYPE"
+	envHealthInterval          = "LOG2OMS_HEALTH_INTERVAL"
+	envOTLPEndpoint            = "LOG2OMS_OTLP_ENDPOINT"
+	envLogType                 = "LOG2OMS_LOG_TYPE"
+	envLogTypeField            = "LOG2OMS_LOG_TYPE_FIELD"
+	envWorkspaceID             = "LOG2OMS_WORKSPACE_ID"
+	envWorkspaceSecret         = "LOG2OMS_WORKSPACE_SECRET"
+	envWorkspaceKeyFile        = "LOG2OMS_WORKSPACE_SECRET_FILE"
+	envKeyVaultURL             = "LOG2OMS_KEYVAULT_URL"
+	envKeyVaultSecret          = "LOG2OMS_KEYVAULT_SECRET_NAME"
+	envKeyVaultClientSecret    = "LOG2OMS_KEYVAULT_CLIENT_SECRET_NAME"
+	envMetadataPrefix          = "LOG2OMS_METADATA_"
+	envCompress                = "LOG2OMS_COMPRESS"
+	envAuth                    = "LOG2OMS_AUTH"
+	envDCEEndpoint             = "LOG2OMS_DCE_ENDPOINT"
+	envDCRID                   = "LOG2OMS_DCR_ID"
+	envResourceID              = "LOG2OMS_AZURE_RESOURCE_ID"
+
+	authAAD = "aad"
+
+	// defaultPprofAddress only accepts local connections, profiles are not meant to be exposed
+	defaultPprofAddress = "localhost:6060"
 )
 
 var (
 	batchSizeInLines = 100000
 	requestSizeLimit = 1024 * 1024 * 8
-)
+	flushInterval    = time.Second * 5
 
-func logLines(client *logclient.LogClient, lines []string) {
-	err := client.PostMessages(lines, time.Now().UTC())
-	if err != nil {
-		fmt.Println(err)
-	}
-}
+	circuitBreakerThreshold = 5
+	circuitBreakerCooldown  = time.Minute
 
-func metadata() map[string]string {
-	metadata := make(map[string]string)
-	metadata["Hostname"], _ = os.Hostname()
+	defaultSpoolMaxSize = int64(1024 * 1024 * 1024)
 
-	for _, e := range os.Environ() {
-		pair := strings.Split(e, "=")
+	// tracer traces the posts of all clients when set
+	tracer *otlp.Exporter
 
-		if strings.HasPrefix(pair[0], envMetadataPrefix) {
-			key := strings.TrimPrefix(pair[0], envMetadataPrefix)
-			metadata[key] = pair[1]
-		}
-	}
+	// debug dumps the requests and responses of all clients
+	debug bool
 
-	return metadata
-}
+	// dryRun prints the batches of all clients instead of posting them
+	dryRun bool
+)
 
-func main() {
-	workspaceID, workspaceSecret := os.Getenv(envWorkspaceID), os.Getenv(envWorkspaceSecret)
-	if workspaceID == "" || workspaceSecret == "" {
-		fmt.Printf("Workspace Id and secret not defined in environment variable '%s' and '%s'\n", envWorkspaceID, envWorkspaceSecret)
-		return
-	}
+// envBool reads a boolean environment variable, it is false when not set or invalid
+func envBool(name string) bool {
+	value, _ := strconv.ParseBool(os.Getenv(name))
+	return value
+}
 
-	logfile := os.Getenv(envLogFile)
-	if logfile == "" {
-		if len(os.Args) < 2 {
-			fmt.Printf("Neither '%s' environment variable nor command line parameter specified.\n", envLogFile)
-			return
+// splitList splits a comma separated environment variable value
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
-
-		logfile = os.Args[1]
-	}
-
-	logType := os.Getenv(envLogType)
-	if logType == "" {
-		logType = "container_logs"
 	}
 
-	metadata := metadata()
-	for m := range metadata {
-		fmt.Printf("[LOG2OMS][%s] %s = %s\n", time.Now().UTC().Format(time.RFC3339), m, metadata[m])
-	}
+	return items
+}
 
-	fmt.Printf("[LOG2OMS][%s] Start tail logs from: %s\n", time.Now().UTC().Format(time.RFC3339), logfile)
+// parseSize parses a size in bytes with an optional KB, MB or GB suffix
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
 
-	client := logclient.NewLogClient(workspaceID, workspaceSecret, logType, metadata)
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(value, suffix) {
+			value, multiplier = strings.TrimSpace(strings.TrimSuffix(value, suffix)), m
+			break
+		}
+	}
 
-	t, err := tail.TailFile(logfile, tail.Config{ReOpen: true, Follow: true})
+	size, err := strconv.ParseInt(strings.TrimSuffix(value, "B"), 10, 64)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return 0, fmt.Errorf("Invalid size %s", value)
 	}
 
-	lines := []string{}
-	byteCount := 0
-	for {
-		select {
-		case line := <-t.Lines:
-			if line.Err != nil {
-				fmt.Println(line.Err)
-			} else {
-				fmt.Printf("[%s] %s\n", line.Time.UTC().Format(time.RFC3339), line.Text)
-			}
-
-			lines = append(lines, line.Text)
-			byteCount += len(line.Text)
-
-			if len(lines) >= batchSizeInLines || byteCount >= requestSizeLimit {
-				logLines(&client, lines)
-				lines = []string{}
-				byteCount = 0
-			}
-		case <-time.After(time.Second * 5):
-			if len(lines) > 0 {
-				logLines(&client, lines)
-				lines = []string{}
-				byteCount = 0
-			}
-		}
-	}
+	return size * multiplier, nil
 }
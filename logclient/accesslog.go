@@ -0,0 +1,148 @@
+package logclient
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// AccessEntry describes a request served, Record converts it to an access log record
+type AccessEntry struct {
+	// Start is when the request was received, and Duration how long serving it took
+	Start    time.Time
+	Duration time.Duration
+	Method   string
+	Host     string
+	Path     string
+	Protocol string
+	// Status is the status code of the response, and Bytes the size of its body
+	Status int
+	Bytes  int64
+	// RemoteAddr is the address of the client, without port
+	RemoteAddr string
+	UserAgent  string
+}
+
+// Record converts the entry to a record, the duration in milliseconds
+func (e AccessEntry) Record() Record {
+	return Record{
+		"Timestamp":   e.Start.UTC().Format(time.RFC3339Nano),
+		"method":      e.Method,
+		"host":        e.Host,
+		"path":        e.Path,
+		"protocol":    e.Protocol,
+		"status":      e.Status,
+		"bytes":       e.Bytes,
+		"duration_ms": float64(e.Duration) / float64(time.Millisecond),
+		"remote_addr": e.RemoteAddr,
+		"user_agent":  e.UserAgent,
+	}
+}
+
+// AccessLog queues a record per request served in a batcher
+type AccessLog struct {
+	batcher *Batcher
+}
+
+// NewAccessLog creates an access log queueing in batcher, use a batcher of its own so access logs
+// are sent as their own log type
+func NewAccessLog(batcher *Batcher) *AccessLog {
+	return &AccessLog{batcher: batcher}
+}
+
+// Log queues the record of a request, e.g. from the middleware of a web framework
+func (a *AccessLog) Log(entry AccessEntry) {
+	a.batcher.EnqueueRecord(entry.Record())
+}
+
+// Middleware logs the requests served by next
+func (a *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		a.Log(AccessEntry{
+			Start:      start,
+			Duration:   time.Since(start),
+			Method:     r.Method,
+			Host:       r.Host,
+			Path:       r.URL.Path,
+			Protocol:   r.Proto,
+			Status:     recorder.status(),
+			Bytes:      recorder.bytes,
+			RemoteAddr: remoteHost(r.RemoteAddr),
+			UserAgent:  r.UserAgent(),
+		})
+	})
+}
+
+// remoteHost strips the port of a remote address
+func remoteHost(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+
+	return address
+}
+
+// responseRecorder records the status code and body size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+
+	return n, err
+}
+
+// status returns the status code sent, 200 when the handler wrote nothing
+func (r *responseRecorder) status() int {
+	if r.code == 0 {
+		return http.StatusOK
+	}
+
+	return r.code
+}
+
+// Flush flushes the response when the underlying writer supports it, e.g. for streaming
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack takes over the connection when the underlying writer supports it, e.g. for websockets
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("Response writer does not support hijacking")
+	}
+	if r.code == 0 {
+		r.code = http.StatusSwitchingProtocols
+	}
+
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,105 @@
+package logclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAsyncQueueSize = 100
+)
+
+// ErrClosed is returned when posting to a closed client
+var ErrClosed = errors.New("Log client is closed")
+
+// asyncBatch is a batch queued by PostAsync
+type asyncBatch struct {
+	ctx       context.Context
+	records   []Record
+	timestamp time.Time
+	result    chan error
+}
+
+// asyncPoster posts queued batches in order on a single background goroutine
+type asyncPoster struct {
+	size    int
+	start   sync.Once
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan asyncBatch
+	stopped chan struct{}
+}
+
+// PostAsync queues records to be posted in the background and returns immediately unless the
+// queue is full, in which case it waits until there is room or ctx is done. Batches are posted
+// in the order they are queued, including retries. The returned channel receives the outcome
+// of the batch once and does not need to be read.
+func (c *LogClient) PostAsync(ctx context.Context, records []Record, timestamp time.Time) <-chan error {
+	result := make(chan error, 1)
+
+	p := c.async
+	p.start.Do(func() {
+		p.queue = make(chan asyncBatch, p.size)
+		p.stopped = make(chan struct{})
+		go p.run(c)
+	})
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		result <- ErrClosed
+		return result
+	}
+
+	select {
+	case p.queue <- asyncBatch{ctx: ctx, records: records, timestamp: timestamp, result: result}:
+	case <-ctx.Done():
+		result <- ctx.Err()
+	}
+
+	return result
+}
+
+// Close stops accepting asynchronous batches and waits until the queued ones are posted or
+// ctx is done
+func (c *LogClient) Close(ctx context.Context) error {
+	p := c.async
+	p.start.Do(func() {})
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	if p.queue == nil {
+		return nil
+	}
+
+	close(p.queue)
+
+	select {
+	case <-p.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *asyncPoster) run(c *LogClient) {
+	defer close(p.stopped)
+
+	for batch := range p.queue {
+		if err := batch.ctx.Err(); err != nil {
+			batch.result <- err
+			continue
+		}
+
+		batch.result <- c.PostRecordsContext(batch.ctx, batch.records, batch.timestamp)
+	}
+}
@@ -0,0 +1,870 @@
+package logclient
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QueuePolicy tells what a Batcher does with records enqueued while its queue is full
+type QueuePolicy int
+
+const (
+	// QueueBlock makes enqueueing wait until the queue is flushed, slowing down inputs
+	QueueBlock QueuePolicy = iota
+	// QueueDropOldest drops the oldest pending record to make room
+	QueueDropOldest
+	// QueueDropNewest drops the record being enqueued
+	QueueDropNewest
+)
+
+// BatchConfig controls when a Batcher flushes accumulated records. A zero value disables the
+// corresponding threshold.
+type BatchConfig struct {
+	// MaxRecords flushes when this many records are pending
+	MaxRecords int
+	// MaxBytes flushes when the size of pending records, serialized as JSON with the metadata of
+	// the client, reaches this many bytes. Requests are split at the request size limit of the
+	// client, set it to MaxBytes for batches to be posted in requests as close to it as possible.
+	MaxBytes int
+	// Interval flushes pending records periodically
+	Interval time.Duration
+	// MaxRetryBatches is how many failed batches are kept, in order, to be posted again before
+	// newer ones on the next flush. The oldest batch is dropped when the queue is full, 0 drops
+	// failed batches right away.
+	MaxRetryBatches int
+	// Spool keeps batches on disk instead of memory until they are posted, MaxRetryBatches is
+	// then ignored in favor of the size limit of the spool
+	Spool *Spool
+	// DeadLetter receives the batches which are dropped instead of losing them
+	DeadLetter *DeadLetter
+	// QueueSize bounds how many records wait to be flushed, QueuePolicy applies when it is
+	// reached. 0 means no bound.
+	QueueSize   int
+	QueuePolicy QueuePolicy
+	// Workers is how many batches are posted concurrently, 1 when 0. With more than one worker,
+	// records accumulated while posting are split in batches of MaxRecords and MaxBytes, which
+	// are posted in parallel. Records are still acknowledged in the order they are enqueued.
+	Workers int
+	// Adaptive adjusts MaxRecords, MaxBytes and Interval to the posts: batches shrink, down to a
+	// sixteenth, after posts failing or slower than 2 seconds and grow back as full batches are
+	// posted quickly. The interval follows the latency of posts, from MinInterval, 1 second by
+	// default, up to Interval after failures. The configured values are the largest used.
+	Adaptive    bool
+	MinInterval time.Duration
+	// Delivered, when set, is called after each batch is posted with its number of records and
+	// the mean time they were enqueued at, e.g. to measure delivery latency. The time is zero for
+	// batches recovered from the spool of a previous process.
+	Delivered func(records int, enqueued time.Time)
+}
+
+// DefaultBatchConfig flushes every 5 seconds or 10000 records, and keeps up to 10 failed batches
+// to be posted again, it is used by the adapters of the package, e.g. NewWriter
+var DefaultBatchConfig = BatchConfig{
+	MaxRecords:      10000,
+	Interval:        time.Second * 5,
+	MaxRetryBatches: 10,
+}
+
+const (
+	// adaptiveTargetLatency is the post latency above which adaptive batches shrink
+	adaptiveTargetLatency = time.Second * 2
+	// adaptiveMaxShrink bounds how many times smaller than configured adaptive batches get
+	adaptiveMaxShrink = 16
+	// adaptiveIntervalFactor is how many times the post latency the adaptive interval is
+	adaptiveIntervalFactor = 4
+	// defaultMinInterval is the shortest adaptive interval when MinInterval is not set
+	defaultMinInterval = time.Second
+)
+
+// RetryState describes the failed batches held by a Batcher
+type RetryState struct {
+	// Batches and Records count what is waiting in the retry queue
+	Batches int
+	Records int
+	// Bytes is the size of the spool, 0 without spool
+	Bytes int64
+	// Dropped counts the records given up because the queue was full or the failure was not retryable
+	Dropped int
+	// LastError is the error of the last failed post
+	LastError error
+}
+
+// queuedBatch is a batch waiting in the retry queue with the acknowledgements of its records
+type queuedBatch struct {
+	records []Record
+	acks    []func()
+	// enqueued is the mean time the records were enqueued at
+	enqueued time.Time
+}
+
+// ack calls the acknowledgements of the batch
+func (q *queuedBatch) ack() {
+	for _, ack := range q.acks {
+		ack()
+	}
+}
+
+// batchLimits are the thresholds flushing pending records
+type batchLimits struct {
+	records  int
+	bytes    int
+	interval time.Duration
+}
+
+// Batcher accumulates records and posts them with a LogClient in batches
+type Batcher struct {
+	client *LogClient
+	config BatchConfig
+
+	// metadataSize is the serialized size the metadata of the client adds to each record
+	metadataSize int
+
+	mu       sync.Mutex
+	pending  []Record
+	acks     []func()
+	size     int
+	drained  *sync.Cond
+	closed   bool
+	overflow int
+	// firstEnqueued is when the first pending record was enqueued, and enqueuedOffsets the sum of
+	// the times the others were enqueued after it
+	firstEnqueued   time.Time
+	enqueuedOffsets time.Duration
+	// limits are those of the config unless batching is adaptive, they are then divided by
+	// shrink. latency is the moving average of the posts, posts and failed count those since
+	// the limits were adapted.
+	limits  batchLimits
+	shrink  int
+	latency time.Duration
+	posts   int
+	failed  bool
+
+	// flushMu serializes flushes, retryQueue and spooled are only touched while holding it
+	flushMu    sync.Mutex
+	retryQueue []*queuedBatch
+	// spooled is the mean time the records of the batches spooled by the batcher were enqueued at
+	spooled    map[string]time.Time
+	stateMu    sync.Mutex
+	retryState RetryState
+
+	flush chan struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+	// ctx bounds the flushes of the background flusher, it is cancelled when closing gives up
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewBatcher creates a batcher and starts its background flusher, call Close to stop it
+func NewBatcher(client *LogClient, config BatchConfig) *Batcher {
+	b := &Batcher{
+		client: client,
+		config: config,
+		limits: batchLimits{records: config.MaxRecords, bytes: config.MaxBytes, interval: config.Interval},
+		shrink: 1,
+		flush:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	if config.Spool != nil {
+		b.spooled = map[string]time.Time{}
+	}
+	// Adaptive batching starts flushing often, as if the workspace were fast
+	if config.Adaptive && b.minInterval() < config.Interval {
+		b.limits.interval = b.minInterval()
+	}
+	for k, v := range client.metadata {
+		b.metadataSize += jsonStringSize(k) + 1 + jsonStringSize(v) + 1
+	}
+	b.drained = sync.NewCond(&b.mu)
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+
+	b.wg.Add(1)
+	go b.run()
+
+	return b
+}
+
+// Enqueue adds a message to the pending batch
+func (b *Batcher) Enqueue(message string) {
+	b.EnqueueRecord(Record{"message": message})
+}
+
+// EnqueueAt adds a message logged at the given time to the pending batch
+func (b *Batcher) EnqueueAt(message string, timestamp time.Time) {
+	b.EnqueueRecord(TimedMessage{Message: message, Time: timestamp}.record())
+}
+
+// EnqueueRecord adds a structured record to the pending batch. A record without Timestamp field
+// is stamped with the time it is enqueued, on a copy as the record is not modified. When the
+// queue is full it waits for a flush or drops a record, according to the queue policy.
+func (b *Batcher) EnqueueRecord(record Record) {
+	b.EnqueueRecordWithAck(record, nil)
+}
+
+// EnqueueRecordWithAck adds a structured record to the pending batch like EnqueueRecord, ack is
+// called once the record is posted, spooled or given up. Acknowledgements are called in the
+// order records are enqueued, e.g. to checkpoint the position reached in the source of records.
+func (b *Batcher) EnqueueRecordWithAck(record Record, ack func()) {
+	now := time.Now()
+	b.mu.Lock()
+	full := b.enqueue(record, ack, now, "")
+	b.mu.Unlock()
+
+	if full {
+		b.requestFlush()
+	}
+}
+
+// EnqueueRecordsWithAcks adds records to the pending batch like EnqueueRecordWithAck, acks[i]
+// being the acknowledgement of records[i], nil for none. The batch is locked and the time is
+// read once for all of them. Neither slice is kept.
+func (b *Batcher) EnqueueRecordsWithAcks(records []Record, acks []func()) {
+	now := time.Now()
+	timestamp := now.UTC().Format(time.RFC3339)
+	full := false
+
+	b.mu.Lock()
+	for i, record := range records {
+		var ack func()
+		if i < len(acks) {
+			ack = acks[i]
+		}
+		if b.enqueue(record, ack, now, timestamp) {
+			full = true
+		}
+	}
+	b.mu.Unlock()
+
+	if full {
+		b.requestFlush()
+	}
+}
+
+// enqueue adds a record enqueued at now to the pending batch, stamped with timestamp, or now
+// when empty, if it has no Timestamp field. The record is stamped on a copy, callers may share
+// or reuse it. It tells whether the batch is to be flushed. Must be called holding mu.
+func (b *Batcher) enqueue(record Record, ack func(), now time.Time, timestamp string) bool {
+	if _, ok := record["Timestamp"]; !ok {
+		if timestamp == "" {
+			timestamp = now.UTC().Format(time.RFC3339)
+		}
+		stamped := make(Record, len(record)+1)
+		for k, v := range record {
+			stamped[k] = v
+		}
+		stamped["Timestamp"] = timestamp
+		record = stamped
+	}
+
+	for b.config.QueueSize > 0 && len(b.pending) >= b.config.QueueSize && !b.closed {
+		if b.config.QueuePolicy == QueueDropNewest {
+			b.overflow++
+			return false
+		}
+		if b.config.QueuePolicy == QueueDropOldest {
+			b.size -= b.recordSize(b.pending[0])
+			b.pending = b.pending[1:]
+			b.overflow++
+			break
+		}
+
+		b.requestFlush()
+		b.drained.Wait()
+	}
+
+	if len(b.pending) == 0 {
+		b.firstEnqueued, b.enqueuedOffsets = now, 0
+	} else {
+		b.enqueuedOffsets += now.Sub(b.firstEnqueued)
+	}
+	b.pending = append(b.pending, record)
+	if ack != nil {
+		b.acks = append(b.acks, ack)
+	}
+	b.size += b.recordSize(record)
+
+	return b.full()
+}
+
+// full tells whether the pending records reach the limits, must be called holding mu
+func (b *Batcher) full() bool {
+	return (b.limits.records > 0 && len(b.pending) >= b.limits.records) ||
+		(b.limits.bytes > 0 && b.size >= b.limits.bytes)
+}
+
+// requestFlush wakes up the background flusher
+func (b *Batcher) requestFlush() {
+	select {
+	case b.flush <- struct{}{}:
+	default:
+	}
+}
+
+// Flush posts the batches in the retry queue followed by all pending records. It stops at the
+// first batch failing with a retryable error, which stays queued with the ones after it, as do
+// the batches failing once ctx is done.
+func (b *Batcher) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	full, limits := b.full(), b.limits
+	defer b.adapt(full)
+	next := &queuedBatch{records: b.pending, acks: b.acks}
+	if len(b.pending) > 0 {
+		next.enqueued = b.firstEnqueued.Add(b.enqueuedOffsets / time.Duration(len(b.pending)))
+	}
+	b.pending, b.acks = nil, nil
+	b.size = 0
+	overflow := b.overflow
+	b.overflow = 0
+	b.drained.Broadcast()
+	b.mu.Unlock()
+
+	if overflow > 0 {
+		errorf(b.client.logger, "Dropped %d records, queue is full.", overflow)
+		b.stateMu.Lock()
+		b.retryState.Dropped += overflow
+		b.stateMu.Unlock()
+	}
+
+	if b.config.Spool != nil {
+		return b.flushSpool(ctx, next, limits)
+	}
+
+	if len(next.records) > 0 || len(next.acks) > 0 {
+		b.retryQueue = append(b.retryQueue, b.split(next, limits)...)
+	}
+
+	var err error
+	for len(b.retryQueue) > 0 {
+		window := b.retryQueue
+		if len(window) > b.workers() {
+			window = window[:b.workers()]
+		}
+
+		errs := b.post(ctx, len(window), func(i int) []Record { return window[i].records })
+
+		// Batches done are emptied, they are acknowledged once the ones before them are done too
+		retrying := false
+		for i, batch := range window {
+			switch {
+			case len(batch.records) == 0:
+			case errs[i] == nil:
+				b.delivered(len(batch.records), batch.enqueued)
+				batch.records = nil
+			case !isRetryable(errs[i]) && ctx.Err() == nil:
+				b.drop(&queuedBatch{records: batch.records}, errs[i], "failure is not retryable")
+				b.updateState(len(batch.records), errs[i])
+				batch.records = nil
+			default:
+				if !retrying {
+					err = errs[i]
+				}
+				retrying = true
+			}
+		}
+
+		b.ackRetried()
+
+		if retrying {
+			break
+		}
+	}
+
+	// Only the batches still to post count, the oldest is dropped first. It is the first of the
+	// queue as the batches done before it were acknowledged.
+	dropped := 0
+	for queued := b.queuedRetries(); queued > b.config.MaxRetryBatches; queued-- {
+		b.drop(b.retryQueue[0], err, "retry queue is full")
+		dropped += len(b.retryQueue[0].records)
+		b.retryQueue = b.retryQueue[1:]
+		b.ackRetried()
+	}
+
+	b.updateState(dropped, err)
+
+	return err
+}
+
+// queuedRetries counts the batches of the retry queue still to post, must be called holding
+// flushMu
+func (b *Batcher) queuedRetries() int {
+	queued := 0
+	for _, batch := range b.retryQueue {
+		if len(batch.records) > 0 {
+			queued++
+		}
+	}
+
+	return queued
+}
+
+// ackRetried acknowledges and removes the batches done at the front of the retry queue, those
+// after a batch still to post wait for it. Must be called holding flushMu.
+func (b *Batcher) ackRetried() {
+	for len(b.retryQueue) > 0 && len(b.retryQueue[0].records) == 0 {
+		b.retryQueue[0].ack()
+		b.retryQueue = b.retryQueue[1:]
+	}
+}
+
+// flushSpool spools the next batch then posts the spooled batches oldest first, it stops at the
+// first batch failing with a retryable error. Records are acknowledged once spooled. Must be
+// called holding flushMu.
+func (b *Batcher) flushSpool(ctx context.Context, next *queuedBatch, limits batchLimits) error {
+	spool := b.config.Spool
+
+	dropped := 0
+	batches := b.split(next, limits)
+	for i, batch := range batches {
+		if len(batch.records) == 0 {
+			continue
+		}
+
+		if id, err := spool.push(batch.records); err != nil {
+			// Without disk the records are posted right away, and lost if that fails
+			errorf(b.client.logger, "%v", err)
+			if err := b.client.PostRecordsContext(ctx, batch.records, time.Time{}); err != nil {
+				rest := &queuedBatch{acks: next.acks}
+				for _, batch := range batches[i:] {
+					rest.records = append(rest.records, batch.records...)
+				}
+				b.drop(rest, err, "spool is not writable")
+				b.updateState(len(rest.records), err)
+				return err
+			}
+			b.delivered(len(batch.records), batch.enqueued)
+		} else {
+			b.spooled[id] = batch.enqueued
+		}
+
+		for spool.Overflowing() {
+			records, id, err := spool.Peek()
+			if id != "" {
+				b.drop(&queuedBatch{records: records}, err, "spool is full")
+				b.removeSpooled(id)
+				dropped += len(records)
+			}
+		}
+	}
+	next.ack()
+
+	var err error
+	for {
+		batches, ids, peekErr := spool.peek(b.workers())
+		if peekErr != nil {
+			errorf(b.client.logger, "%v", peekErr)
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		// Records are acknowledged once spooled, batches posted are removed in any order
+		errs := b.post(ctx, len(batches), func(i int) []Record { return batches[i] })
+
+		retrying := false
+		for i, batch := range batches {
+			switch {
+			case errs[i] == nil:
+				b.delivered(len(batch), b.spooled[ids[i]])
+				b.removeSpooled(ids[i])
+			case !isRetryable(errs[i]) && ctx.Err() == nil:
+				b.drop(&queuedBatch{records: batch}, errs[i], "failure is not retryable")
+				b.removeSpooled(ids[i])
+				dropped += len(batch)
+			default:
+				if !retrying {
+					err = errs[i]
+				}
+				retrying = true
+			}
+		}
+
+		if retrying {
+			break
+		}
+	}
+
+	b.updateState(dropped, err)
+
+	return err
+}
+
+// workers returns how many batches are posted concurrently
+func (b *Batcher) workers() int {
+	if b.config.Workers < 1 {
+		return 1
+	}
+
+	return b.config.Workers
+}
+
+// post posts n batches, concurrently up to the number of workers, and returns their errors
+func (b *Batcher) post(ctx context.Context, n int, records func(i int) []Record) []error {
+	errs := make([]error, n)
+	if n == 1 {
+		if batch := records(0); len(batch) > 0 {
+			errs[0] = b.postBatch(ctx, batch)
+		}
+		return errs
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		batch := records(i)
+		if len(batch) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = b.postBatch(ctx, batch)
+		}(i)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// postBatch posts a batch, its latency and outcome adapt the limits when batching is adaptive
+func (b *Batcher) postBatch(ctx context.Context, batch []Record) error {
+	start := time.Now()
+	err := b.client.PostRecordsContext(ctx, batch, time.Time{})
+	if !b.config.Adaptive {
+		return err
+	}
+
+	elapsed := time.Since(start)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.latency == 0 {
+		b.latency = elapsed
+	} else {
+		b.latency += (elapsed - b.latency) / 4
+	}
+	b.posts++
+	b.failed = b.failed || err != nil
+
+	return err
+}
+
+// adapt adjusts the limits to the posts of a flush when batching is adaptive, full tells whether
+// the batch flushed had reached them. Batches shrink after posts failing or slower than the target
+// and grow back when full ones are posted in time. The interval follows the latency of posts,
+// and is the longest after failures so a struggling workspace gets fewer requests.
+func (b *Batcher) adapt(full bool) {
+	if !b.config.Adaptive {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.posts == 0 {
+		return
+	}
+	failed := b.failed
+	b.posts, b.failed = 0, false
+
+	switch {
+	case failed || b.latency > adaptiveTargetLatency:
+		if b.shrink < adaptiveMaxShrink {
+			b.shrink *= 2
+		}
+	case full && b.shrink > 1:
+		b.shrink /= 2
+	}
+	b.limits.records = shrinkLimit(b.config.MaxRecords, b.shrink)
+	b.limits.bytes = shrinkLimit(b.config.MaxBytes, b.shrink)
+
+	if b.config.Interval <= 0 {
+		return
+	}
+	interval := adaptiveIntervalFactor * b.latency
+	switch {
+	case failed || interval > b.config.Interval:
+		interval = b.config.Interval
+	case interval < b.minInterval():
+		interval = b.minInterval()
+	}
+	b.limits.interval = interval
+}
+
+// minInterval returns the shortest interval of adaptive batching
+func (b *Batcher) minInterval() time.Duration {
+	if b.config.MinInterval <= 0 {
+		return defaultMinInterval
+	}
+
+	return b.config.MinInterval
+}
+
+// shrinkLimit divides a limit, keeping it at least 1 unless it is disabled
+func shrinkLimit(limit, shrink int) int {
+	if limit <= 0 {
+		return limit
+	}
+	if limit/shrink < 1 {
+		return 1
+	}
+
+	return limit / shrink
+}
+
+// split cuts a batch in batches of the records and bytes of limits to be posted by several
+// workers, the acknowledgements all go to the last one so they are called once the whole batch
+// is done
+func (b *Batcher) split(batch *queuedBatch, limits batchLimits) []*queuedBatch {
+	if b.workers() == 1 {
+		return []*queuedBatch{batch}
+	}
+
+	var batches []*queuedBatch
+	current := &queuedBatch{enqueued: batch.enqueued}
+	size := 0
+	for _, record := range batch.records {
+		recordSize := b.recordSize(record)
+		full := (limits.records > 0 && len(current.records) >= limits.records) ||
+			(limits.bytes > 0 && len(current.records) > 0 && size+recordSize > limits.bytes)
+		if full {
+			batches = append(batches, current)
+			current, size = &queuedBatch{enqueued: batch.enqueued}, 0
+		}
+
+		current.records = append(current.records, record)
+		size += recordSize
+	}
+	current.acks = batch.acks
+
+	return append(batches, current)
+}
+
+// removeSpooled removes a batch from the spool, must be called holding flushMu
+func (b *Batcher) removeSpooled(id string) {
+	b.config.Spool.Remove(id)
+	delete(b.spooled, id)
+}
+
+// delivered reports a batch posted successfully
+func (b *Batcher) delivered(records int, enqueued time.Time) {
+	if b.config.Delivered != nil && records > 0 {
+		b.config.Delivered(records, enqueued)
+	}
+}
+
+// drop gives up a batch failed with err, it is written to the dead letter if any. The records
+// are acknowledged as there is nothing more to do with them.
+func (b *Batcher) drop(batch *queuedBatch, err error, reason string) {
+	errorf(b.client.logger, "Dropped %d records, %s.", len(batch.records), reason)
+
+	if b.config.DeadLetter != nil {
+		if err := b.config.DeadLetter.Write(batch.records, err); err != nil {
+			errorf(b.client.logger, "%v", err)
+		}
+	}
+
+	batch.ack()
+}
+
+// BatcherStats describes the records handled by a Batcher, along with the Stats of its client
+type BatcherStats struct {
+	Stats
+	// Queued counts the records waiting for the next flush
+	Queued int
+	// Retrying counts the records of failed batches waiting to be posted again, in memory or
+	// spooled
+	Retrying int
+	// Dropped counts the records given up
+	Dropped int
+	// BatchRecords, BatchBytes and FlushInterval are the limits flushing records, adjusted by
+	// adaptive batching
+	BatchRecords  int
+	BatchBytes    int
+	FlushInterval time.Duration
+}
+
+// Pending returns the number of records waiting for the next flush
+func (b *Batcher) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.pending)
+}
+
+// Stats returns the stats of the batcher and its client
+func (b *Batcher) Stats() BatcherStats {
+	state := b.RetryState()
+	b.mu.Lock()
+	limits := b.limits
+	b.mu.Unlock()
+
+	return BatcherStats{Stats: b.client.Stats(), Queued: b.Pending(), Retrying: state.Records, Dropped: state.Dropped,
+		BatchRecords: limits.records, BatchBytes: limits.bytes, FlushInterval: limits.interval}
+}
+
+// RetryState returns the current state of the retry queue
+func (b *Batcher) RetryState() RetryState {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	return b.retryState
+}
+
+// updateState refreshes the retry state after a flush, must be called holding flushMu
+func (b *Batcher) updateState(dropped int, err error) {
+	batches, records, size := b.queuedRetries(), 0, int64(0)
+	for _, batch := range b.retryQueue {
+		records += len(batch.records)
+	}
+	if b.config.Spool != nil {
+		batches, records, size = b.config.Spool.State()
+	}
+
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	b.retryState.Batches = batches
+	b.retryState.Records = records
+	b.retryState.Bytes = size
+	b.retryState.Dropped += dropped
+	if err != nil {
+		b.retryState.LastError = err
+	}
+}
+
+// Close stops the background flusher and posts the remaining records. Posts and their retries,
+// those of a flush in progress included, are abandoned once ctx is done. The records left stay in
+// the spool if any, otherwise they are reported and not acknowledged.
+func (b *Batcher) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.drained.Broadcast()
+	b.mu.Unlock()
+
+	close(b.done)
+	stopped := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		b.cancel()
+		<-stopped
+	}
+	defer b.cancel()
+
+	err := b.Flush(ctx)
+	if left := b.RetryState().Records; left > 0 {
+		if b.config.Spool != nil {
+			warnf(b.client.logger, "Closing with %d records spooled, they are posted once restarted.", left)
+		} else {
+			errorf(b.client.logger, "Closing with %d records not posted.", left)
+		}
+	}
+
+	return err
+}
+
+func (b *Batcher) run() {
+	defer b.wg.Done()
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	interval := time.Duration(0)
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+
+	for {
+		// Adaptive batching changes the interval between flushes
+		b.mu.Lock()
+		current := b.limits.interval
+		b.mu.Unlock()
+		if current != interval {
+			if ticker != nil {
+				ticker.Stop()
+			}
+			ticker, tick, interval = nil, nil, current
+			if interval > 0 {
+				ticker = time.NewTicker(interval)
+				tick = ticker.C
+			}
+		}
+
+		select {
+		case <-b.done:
+			return
+		case <-b.flush:
+		case <-tick:
+		}
+
+		if err := b.Flush(b.ctx); err != nil {
+			warnf(b.client.logger, "%v", err)
+		}
+	}
+}
+
+// recordSize computes the size of a record serialized as JSON along with the metadata of the
+// client, including the comma separating it from the previous record
+func (b *Batcher) recordSize(record Record) int {
+	size := 2 + b.metadataSize
+	for k, v := range record {
+		size += jsonStringSize(k) + 1 + jsonSize(v) + 1
+	}
+
+	return size
+}
+
+// jsonSize computes the size of a value serialized as JSON, marshaling only the types other than
+// strings, numbers and booleans
+func jsonSize(v interface{}) int {
+	switch value := v.(type) {
+	case string:
+		return jsonStringSize(value)
+	case nil:
+		return 4
+	case bool:
+		if value {
+			return 4
+		}
+		return 5
+	case int:
+		return len(strconv.Itoa(value))
+	case int64:
+		return len(strconv.FormatInt(value, 10))
+	case float64:
+		return len(strconv.FormatFloat(value, 'g', -1, 64))
+	case json.Number:
+		return len(value)
+	}
+
+	buf, _ := json.Marshal(v)
+	return len(buf)
+}
+
+// jsonStringSize computes the size of a JSON string as encoding/json escapes it
+func jsonStringSize(s string) int {
+	size := 2
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\' || c == '\b' || c == '\f' || c == '\n' || c == '\r' || c == '\t':
+			size += 2
+		case c < 0x20 || c == '<' || c == '>' || c == '&':
+			size += 6
+		default:
+			size++
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,433 @@
+package logclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// discard accepts every post
+func discard(w http.ResponseWriter, r *http.Request) {
+	io.Copy(ioutil.Discard, r.Body)
+}
+
+// unavailable fails every post with a retryable error
+func unavailable(w http.ResponseWriter, r *http.Request) {
+	io.Copy(ioutil.Discard, r.Body)
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+// recordPosts returns a handler sending the number of records of each post to the channel
+func recordPosts(posts chan<- int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var records []interface{}
+		json.NewDecoder(r.Body).Decode(&records)
+		posts <- len(records)
+	}
+}
+
+func TestBatcherFlushLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		config BatchConfig
+		// records are enqueued one at a time, posted is how many are posted together once the
+		// last one is enqueued, 0 when none is expected before closing
+		records int
+		posted  int
+	}{
+		{"no limit", BatchConfig{}, 3, 0},
+		{"records", BatchConfig{MaxRecords: 3}, 3, 3},
+		{"bytes", BatchConfig{MaxBytes: 1}, 1, 1},
+		{"interval", BatchConfig{Interval: 10 * time.Millisecond}, 1, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			posts := make(chan int, 10)
+			client, server := newTestClient(t, recordPosts(posts))
+			defer server.Close()
+
+			batcher := NewBatcher(client, test.config)
+			for i := 1; i < test.records; i++ {
+				batcher.EnqueueRecord(Record{"message": "hello"})
+				select {
+				case n := <-posts:
+					t.Fatalf("Posted %d records before reaching the limits", n)
+				case <-time.After(20 * time.Millisecond):
+				}
+			}
+			batcher.EnqueueRecord(Record{"message": "hello"})
+
+			wait := 5 * time.Second
+			if test.posted == 0 {
+				wait = 50 * time.Millisecond
+			}
+			select {
+			case n := <-posts:
+				if n != test.posted {
+					t.Errorf("Posted %d records, expecting %d", n, test.posted)
+				}
+			case <-time.After(wait):
+				if test.posted > 0 {
+					t.Fatalf("Posted no record, expecting %d", test.posted)
+				}
+			}
+
+			// Closing posts the rest
+			if err := batcher.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			close(posts)
+			posted := test.posted
+			for n := range posts {
+				posted += n
+			}
+			if posted != test.records {
+				t.Errorf("Posted %d records once closed, expecting %d", posted, test.records)
+			}
+		})
+	}
+}
+
+func TestBatcherQueuePolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy QueuePolicy
+		// posted are the messages posted in order
+		posted  string
+		dropped int
+	}{
+		{"block", QueueBlock, "[a b c d]", 0},
+		{"drop oldest", QueueDropOldest, "[c d]", 2},
+		{"drop newest", QueueDropNewest, "[a b]", 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			posted := make(chan string, 10)
+			client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				var records []map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&records)
+				for _, record := range records {
+					posted <- fmt.Sprint(record["message"])
+				}
+			})
+			defer server.Close()
+
+			// Without limits, records wait for closing unless the queue is full and blocks
+			batcher := NewBatcher(client, BatchConfig{QueueSize: 2, QueuePolicy: test.policy})
+			for _, message := range []string{"a", "b", "c", "d"} {
+				batcher.Enqueue(message)
+			}
+			if err := batcher.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			close(posted)
+			var messages []string
+			for message := range posted {
+				messages = append(messages, message)
+			}
+			if fmt.Sprint(messages) != test.posted {
+				t.Errorf("Posted %v, expecting %s", messages, test.posted)
+			}
+			if dropped := batcher.Stats().Dropped; dropped != test.dropped {
+				t.Errorf("Dropped %d records, expecting %d", dropped, test.dropped)
+			}
+		})
+	}
+}
+
+func TestBatcherAdapt(t *testing.T) {
+	tests := []struct {
+		name string
+		// shrink, posts, failed and latency are those of the batcher before adapting
+		shrink  int
+		posts   int
+		failed  bool
+		latency time.Duration
+		full    bool
+		// records is the batch size once adapted
+		records  int
+		interval time.Duration
+	}{
+		{name: "no post", shrink: 2, records: 1024, interval: time.Second},
+		{name: "failed", shrink: 1, posts: 1, failed: true, latency: 100 * time.Millisecond, records: 512, interval: 10 * time.Second},
+		{name: "slow", shrink: 1, posts: 1, latency: 3 * time.Second, records: 512, interval: 10 * time.Second},
+		{name: "most shrunk", shrink: 16, posts: 1, failed: true, records: 64, interval: 10 * time.Second},
+		{name: "full", shrink: 8, posts: 2, latency: 500 * time.Millisecond, full: true, records: 256, interval: 2 * time.Second},
+		{name: "not full", shrink: 8, posts: 2, latency: 100 * time.Millisecond, records: 128, interval: time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := newTestClient(t, discard)
+			defer server.Close()
+
+			// Starts flushing at the shortest interval
+			batcher := NewBatcher(client, BatchConfig{MaxRecords: 1024, MaxBytes: 16384, Interval: 10 * time.Second, Adaptive: true})
+			defer batcher.Close(context.Background())
+
+			batcher.mu.Lock()
+			batcher.shrink, batcher.posts, batcher.failed, batcher.latency = test.shrink, test.posts, test.failed, test.latency
+			batcher.mu.Unlock()
+			batcher.adapt(test.full)
+
+			stats := batcher.Stats()
+			if stats.BatchRecords != test.records || stats.BatchBytes != 16*test.records || stats.FlushInterval != test.interval {
+				t.Errorf("Adapted to %d records, %d bytes and %v, expecting %d records and %v", stats.BatchRecords, stats.BatchBytes, stats.FlushInterval, test.records, test.interval)
+			}
+		})
+	}
+}
+
+func TestShrinkLimit(t *testing.T) {
+	tests := []struct {
+		limit, shrink, expected int
+	}{
+		{1000, 1, 1000},
+		{1000, 16, 62},
+		{10, 16, 1},
+		{0, 16, 0},
+	}
+
+	for _, test := range tests {
+		if limit := shrinkLimit(test.limit, test.shrink); limit != test.expected {
+			t.Errorf("shrinkLimit(%d, %d) is %d, expecting %d", test.limit, test.shrink, limit, test.expected)
+		}
+	}
+}
+
+func TestBatcherCloseDeadline(t *testing.T) {
+	tests := []struct {
+		name string
+		// inProgress tells whether a flush of the background flusher is retrying when closing
+		inProgress bool
+	}{
+		{"final flush", false},
+		{"flush in progress", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := RetryPolicy{BaseDelay: time.Hour}
+			client, server := newTestClient(t, unavailable, WithRetryPolicy(policy))
+			defer server.Close()
+
+			config := BatchConfig{MaxRetryBatches: 10}
+			if test.inProgress {
+				config.MaxRecords = 1
+			}
+			batcher := NewBatcher(client, config)
+
+			acked := int32(0)
+			for i := 0; i < 3; i++ {
+				batcher.EnqueueRecordWithAck(Record{"message": "hello"}, func() { atomic.AddInt32(&acked, 1) })
+			}
+			if test.inProgress {
+				// Waits for the first post of the background flusher, which then retries in an hour
+				for client.Stats().Responses[http.StatusServiceUnavailable] == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			if err := batcher.Close(ctx); err == nil {
+				t.Error("Expecting the records not to be posted")
+			}
+
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Closed in %v, after the deadline", elapsed)
+			}
+			if state := batcher.RetryState(); state.Records != 3 || state.Dropped != 0 {
+				t.Errorf("%d records left and %d dropped, expecting 3 left", state.Records, state.Dropped)
+			}
+			if acked != 0 {
+				t.Errorf("%d records acknowledged, expecting none", acked)
+			}
+		})
+	}
+}
+
+func TestBatcherRetryQueue(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxRetryBatches int
+		// dropped is how many records are dropped by the failing flush, acked how many are
+		// acknowledged by then
+		dropped int
+		acked   int32
+	}{
+		{"posted batches not counted", 2, 0, 0},
+		{"oldest dropped", 1, 1, 0},
+		{"failed batches not kept", 0, 2, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Records with the message "fail" are rejected until the workspace recovers
+			recovered := int32(0)
+			client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				var records []map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&records)
+				if atomic.LoadInt32(&recovered) == 0 && len(records) > 0 && records[0]["message"] == "fail" {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}, WithRetryPolicy(NoRetry))
+			defer server.Close()
+
+			// A batch per record, the first two are posted together
+			batcher := NewBatcher(client, BatchConfig{MaxRecords: 1, Workers: 2, MaxRetryBatches: test.maxRetryBatches})
+			acked := int32(0)
+			ack := func() { atomic.AddInt32(&acked, 1) }
+			records := []Record{{"message": "fail"}, {"message": "ok"}, {"message": "fail"}}
+			batcher.EnqueueRecordsWithAcks(records, []func(){ack, ack, ack})
+
+			if err := batcher.Flush(context.Background()); err == nil {
+				t.Fatal("Expecting the flush to fail")
+			}
+			state := batcher.RetryState()
+			if state.Dropped != test.dropped || state.Records != 2-test.dropped || state.Batches != 2-test.dropped {
+				t.Errorf("%d records dropped, %d in %d batches left, expecting %d dropped", state.Dropped, state.Records, state.Batches, test.dropped)
+			}
+			// The records are acknowledged together once all of them are done
+			if n := atomic.LoadInt32(&acked); n != test.acked {
+				t.Errorf("%d records acknowledged, expecting %d", n, test.acked)
+			}
+
+			atomic.StoreInt32(&recovered, 1)
+			if err := batcher.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if state := batcher.RetryState(); state.Dropped != test.dropped || state.Records != 0 {
+				t.Errorf("%d records dropped and %d left once recovered, expecting %d dropped", state.Dropped, state.Records, test.dropped)
+			}
+			if n := atomic.LoadInt32(&acked); n != 3 {
+				t.Errorf("%d records acknowledged once recovered, expecting 3", n)
+			}
+		})
+	}
+}
+
+func TestBatcherRetryOrder(t *testing.T) {
+	// The workspace fails until recovered, then records the messages posted in order
+	recovered := int32(0)
+	posted := make(chan string, 10)
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var records []map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&records)
+		if atomic.LoadInt32(&recovered) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		for _, record := range records {
+			posted <- fmt.Sprint(record["message"])
+		}
+	}, WithRetryPolicy(NoRetry))
+	defer server.Close()
+
+	batcher := NewBatcher(client, BatchConfig{MaxRetryBatches: 10})
+	for _, message := range []string{"first", "second"} {
+		batcher.Enqueue(message)
+		if err := batcher.Flush(context.Background()); err == nil {
+			t.Fatal("Expecting the flush to fail")
+		}
+	}
+	if state := batcher.RetryState(); state.Batches != 2 || state.Records != 2 {
+		t.Errorf("%d records in %d batches to retry, expecting 2 batches", state.Records, state.Batches)
+	}
+
+	atomic.StoreInt32(&recovered, 1)
+	batcher.Enqueue("third")
+	if err := batcher.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(posted)
+	var messages []string
+	for message := range posted {
+		messages = append(messages, message)
+	}
+	if fmt.Sprint(messages) != "[first second third]" {
+		t.Errorf("Posted %v, expecting the batches retried first", messages)
+	}
+}
+
+func TestBatcherEnqueueShared(t *testing.T) {
+	posted := make(chan map[string]interface{}, 2)
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var records []map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&records)
+		for _, record := range records {
+			posted <- record
+		}
+	})
+	defer server.Close()
+
+	// A record shared by batchers is stamped by each without being modified
+	record := Record{"message": "hello"}
+	for _, batcher := range []*Batcher{NewBatcher(client, BatchConfig{}), NewBatcher(client, BatchConfig{})} {
+		batcher.EnqueueRecordsWithAcks([]Record{record}, nil)
+		if err := batcher.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if stamped := <-posted; stamped["Timestamp"] == nil {
+			t.Errorf("Posted %v without Timestamp", stamped)
+		}
+	}
+	if len(record) != 1 {
+		t.Errorf("Record modified to %v", record)
+	}
+}
+
+func benchRecord(i int) Record {
+	return Record{
+		"level":       "info",
+		"path":        "/api/orders",
+		"status":      200,
+		"duration_ms": float64(i % 250),
+		"message":     fmt.Sprintf("Order %d created for user%d@example.com", i, i%1000),
+	}
+}
+
+func BenchmarkBatcherEnqueue(b *testing.B) {
+	client, server := newTestClient(b, discard)
+	defer server.Close()
+	batcher := NewBatcher(client, BatchConfig{MaxRecords: 10000, MaxBytes: MaxIngestionRequestSize})
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		batcher.EnqueueRecordWithAck(benchRecord(i), func() {})
+	}
+	if err := batcher.Close(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkBatcherEnqueueRecords(b *testing.B) {
+	client, server := newTestClient(b, discard)
+	defer server.Close()
+	batcher := NewBatcher(client, BatchConfig{MaxRecords: 10000, MaxBytes: MaxIngestionRequestSize})
+	records, acks := make([]Record, 256), make([]func(), 256)
+	for i := range acks {
+		acks[i] = func() {}
+	}
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i += len(records) {
+		for j := range records {
+			records[j] = benchRecord(i + j)
+		}
+		batcher.EnqueueRecordsWithAcks(records, acks)
+	}
+	if err := batcher.Close(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,75 @@
+package logclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without sending a request while the circuit breaker is open
+var ErrCircuitOpen = errors.New("Circuit breaker is open, log analytics is failing consistently")
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker stops posting after consecutive failures. Once the cooldown has elapsed a
+// single probe request is let through, it closes the circuit on success or opens it again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow tells whether a request may be sent
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+
+		b.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// A probe is in flight
+		return false
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of an allowed request, failures that are not
+// retryable, such as a malformed batch, don't indicate an outage and are ignored
+func (b *circuitBreaker) record(err error) (opened bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil || !isRetryable(err) {
+		b.state = circuitClosed
+		b.failures = 0
+		return false
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		opened = b.state != circuitOpen
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+	}
+
+	return opened
+}
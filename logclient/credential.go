@@ -0,0 +1,244 @@
+package logclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MonitorScope is the Azure AD scope of Azure Monitor ingestion
+	MonitorScope = "https://monitor.azure.com/.default"
+
+	defaultAuthorityHost = "https://login.microsoftonline.com"
+	imdsTokenURL         = "http://169.254.169.254/metadata/identity/oauth2/token"
+	tokenRefreshMargin   = time.Minute * 5
+)
+
+// AccessToken is an Azure AD access token
+type AccessToken struct {
+	Token     string
+	ExpiresOn time.Time
+}
+
+// TokenCredential acquires Azure AD access tokens for a scope
+type TokenCredential interface {
+	Token(ctx context.Context, scope string) (AccessToken, error)
+}
+
+// ManagedIdentityCredential acquires tokens from the managed identity endpoint of the host,
+// either App Service style (IDENTITY_ENDPOINT) or Azure Instance Metadata Service.
+type ManagedIdentityCredential struct {
+	clientID   string
+	httpClient *http.Client
+}
+
+// NewManagedIdentityCredential creates a managed identity credential, clientID selects a user
+// assigned identity and can be empty for the system assigned one
+func NewManagedIdentityCredential(clientID string) *ManagedIdentityCredential {
+	return &ManagedIdentityCredential{
+		clientID:   clientID,
+		httpClient: &http.Client{Timeout: time.Second * 30},
+	}
+}
+
+// Token acquires a token from the managed identity endpoint
+func (c *ManagedIdentityCredential) Token(ctx context.Context, scope string) (AccessToken, error) {
+	resource := strings.TrimSuffix(scope, "/.default")
+
+	var req *http.Request
+	if endpoint, header := os.Getenv("IDENTITY_ENDPOINT"), os.Getenv("IDENTITY_HEADER"); endpoint != "" && header != "" {
+		query := url.Values{"api-version": {"2019-08-01"}, "resource": {resource}}
+		if c.clientID != "" {
+			query.Set("client_id", c.clientID)
+		}
+
+		req, _ = http.NewRequest(http.MethodGet, endpoint+"?"+query.Encode(), nil)
+		req.Header.Set("X-IDENTITY-HEADER", header)
+	} else {
+		query := url.Values{"api-version": {"2018-02-01"}, "resource": {resource}}
+		if c.clientID != "" {
+			query.Set("client_id", c.clientID)
+		}
+
+		req, _ = http.NewRequest(http.MethodGet, imdsTokenURL+"?"+query.Encode(), nil)
+		req.Header.Set("Metadata", "true")
+	}
+
+	return requestToken(c.httpClient, req.WithContext(ctx))
+}
+
+// SecretProvider supplies a secret which may change over time, e.g. KeyVaultSecret
+type SecretProvider interface {
+	Value(ctx context.Context) (string, error)
+}
+
+// StaticSecret is a secret that never changes
+type StaticSecret string
+
+// Value returns the secret itself
+func (s StaticSecret) Value(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// ClientSecretCredential acquires tokens for a service principal with a client secret
+type ClientSecretCredential struct {
+	tenantID      string
+	clientID      string
+	clientSecret  SecretProvider
+	authorityHost string
+	httpClient    *http.Client
+}
+
+// NewClientSecretCredential creates a service principal credential
+func NewClientSecretCredential(tenantID, clientID, clientSecret string) *ClientSecretCredential {
+	return NewClientSecretProviderCredential(tenantID, clientID, StaticSecret(clientSecret))
+}
+
+// NewClientSecretProviderCredential creates a service principal credential whose client secret
+// is read from clientSecret for every token, so it can be kept in Key Vault and rotated
+func NewClientSecretProviderCredential(tenantID, clientID string, clientSecret SecretProvider) *ClientSecretCredential {
+	authorityHost := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authorityHost == "" {
+		authorityHost = defaultAuthorityHost
+	}
+
+	return &ClientSecretCredential{
+		tenantID:      tenantID,
+		clientID:      clientID,
+		clientSecret:  clientSecret,
+		authorityHost: strings.TrimSuffix(authorityHost, "/"),
+		httpClient:    &http.Client{Timeout: time.Second * 30},
+	}
+}
+
+// Token acquires a token with the client credentials grant
+func (c *ClientSecretCredential) Token(ctx context.Context, scope string) (AccessToken, error) {
+	clientSecret, err := c.clientSecret.Value(ctx)
+	if err != nil {
+		return AccessToken{}, fmt.Errorf("Failed to get client secret: %v", err)
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.clientID},
+		"client_secret": {clientSecret},
+		"scope":         {scope},
+	}
+
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", c.authorityHost, c.tenantID)
+	req, _ := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return requestToken(c.httpClient, req.WithContext(ctx))
+}
+
+// ChainedCredential tries each credential in order and returns the first token acquired
+type ChainedCredential struct {
+	credentials []TokenCredential
+}
+
+// NewChainedCredential creates a credential chain
+func NewChainedCredential(credentials ...TokenCredential) *ChainedCredential {
+	return &ChainedCredential{credentials: credentials}
+}
+
+// Token returns the token of the first credential that succeeds
+func (c *ChainedCredential) Token(ctx context.Context, scope string) (AccessToken, error) {
+	var errs []string
+	for _, credential := range c.credentials {
+		token, err := credential.Token(ctx, scope)
+		if err == nil {
+			return token, nil
+		}
+
+		errs = append(errs, err.Error())
+	}
+
+	return AccessToken{}, fmt.Errorf("No credential in the chain acquired a token: %s", strings.Join(errs, "; "))
+}
+
+// NewDefaultCredential uses a service principal when AZURE_TENANT_ID, AZURE_CLIENT_ID and
+// AZURE_CLIENT_SECRET are set, and falls back to managed identity, which honors AZURE_CLIENT_ID
+// for a user assigned identity.
+func NewDefaultCredential() TokenCredential {
+	tenantID, clientID, clientSecret := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET")
+
+	var credentials []TokenCredential
+	if tenantID != "" && clientID != "" && clientSecret != "" {
+		credentials = append(credentials, NewClientSecretCredential(tenantID, clientID, clientSecret))
+	}
+	credentials = append(credentials, NewManagedIdentityCredential(clientID))
+
+	return NewChainedCredential(credentials...)
+}
+
+// cachedCredential reuses a token until it is about to expire
+type cachedCredential struct {
+	credential TokenCredential
+
+	mu     sync.Mutex
+	tokens map[string]AccessToken
+}
+
+func newCachedCredential(credential TokenCredential) *cachedCredential {
+	return &cachedCredential{credential: credential, tokens: map[string]AccessToken{}}
+}
+
+func (c *cachedCredential) Token(ctx context.Context, scope string) (AccessToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if token, ok := c.tokens[scope]; ok && time.Until(token.ExpiresOn) > tokenRefreshMargin {
+		return token, nil
+	}
+
+	token, err := c.credential.Token(ctx, scope)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	c.tokens[scope] = token
+	return token, nil
+}
+
+// requestToken sends a token request and parses the response of either the Azure AD or the
+// managed identity endpoint
+func requestToken(httpClient *http.Client, req *http.Request) (AccessToken, error) {
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return AccessToken{}, fmt.Errorf("Failed to request token: %v", err)
+	}
+	defer response.Body.Close()
+
+	buf, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return AccessToken{}, fmt.Errorf("Token request failed with status: %d %s", response.StatusCode, string(buf))
+	}
+
+	var result struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+		ExpiresOn   json.Number `json:"expires_on"`
+	}
+	if err := json.Unmarshal(buf, &result); err != nil {
+		return AccessToken{}, fmt.Errorf("Failed to parse token response: %v", err)
+	}
+
+	token := AccessToken{Token: result.AccessToken, ExpiresOn: time.Now().Add(time.Hour)}
+	if expiresOn, err := strconv.ParseInt(result.ExpiresOn.String(), 10, 64); err == nil {
+		token.ExpiresOn = time.Unix(expiresOn, 0)
+	} else if expiresIn, err := strconv.ParseInt(result.ExpiresIn.String(), 10, 64); err == nil {
+		token.ExpiresOn = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+
+	return token, nil
+}
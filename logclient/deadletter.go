@@ -0,0 +1,77 @@
+package logclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DeadLetter keeps the batches a Batcher gives up on, so they can be inspected and posted again
+// later. Each batch is written to its own file in a directory as a DeadLetterBatch.
+type DeadLetter struct {
+	dir string
+
+	mu  sync.Mutex
+	seq int
+}
+
+// DeadLetterBatch is the content of a dead letter file
+type DeadLetterBatch struct {
+	Time    time.Time `json:"time"`
+	Error   string    `json:"error"`
+	Records []Record  `json:"records"`
+}
+
+// NewDeadLetter creates a dead letter writing to dir, creating it if needed
+func NewDeadLetter(dir string) (*DeadLetter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create dead letter directory: %v", err)
+	}
+
+	return &DeadLetter{dir: dir}, nil
+}
+
+// Write saves a batch given up because of reason
+func (d *DeadLetter) Write(records []Record, reason error) error {
+	batch := DeadLetterBatch{Time: time.Now().UTC(), Records: records}
+	if reason != nil {
+		batch.Error = reason.Error()
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.seq++
+	name := fmt.Sprintf("%s-%d.json", batch.Time.Format("20060102T150405.000000000Z"), d.seq)
+	d.mu.Unlock()
+
+	tmp := filepath.Join(d.dir, name+".tmp")
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Failed to write dead letter: %v", err)
+	}
+
+	return os.Rename(tmp, filepath.Join(d.dir, name))
+}
+
+// ReadDeadLetter reads a dead letter file
+func ReadDeadLetter(path string) (*DeadLetterBatch, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var batch DeadLetterBatch
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("Failed to read dead letter %s: %v", path, err)
+	}
+
+	return &batch, nil
+}
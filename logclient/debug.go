@@ -0,0 +1,75 @@
+package logclient
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxDebugBodySize bounds how much of a body is dumped
+	maxDebugBodySize = 64 * 1024
+)
+
+// WithDebug dumps every request and response to the logger of the client, with their headers and
+// bodies, to troubleshoot rejected requests. Credentials are redacted.
+func WithDebug(debug bool) Option {
+	return func(c *LogClient) {
+		c.debug = debug
+	}
+}
+
+// dumpRequest dumps a request with its body before compression
+func (c *LogClient) dumpRequest(req *http.Request, body []byte) {
+	c.logger.Printf("Request %s %s\n%s\n%s", req.Method, req.URL, dumpHeaders(req.Header), dumpBody(body))
+}
+
+// dumpResponse dumps a response with its body
+func (c *LogClient) dumpResponse(response *http.Response, body []byte) {
+	c.logger.Printf("Response %s\n%s\n%s", response.Status, dumpHeaders(response.Header), dumpBody(body))
+}
+
+// dumpHeaders formats headers sorted by name, redacting credentials
+func dumpHeaders(header http.Header) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		for _, value := range header[name] {
+			lines = append(lines, fmt.Sprintf("%s: %s", name, redactHeader(name, value)))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// redactHeader hides the signature or token of the Authorization header, the workspace ID of a
+// shared key signature is kept to spot a mismatched workspace
+func redactHeader(name, value string) string {
+	if !strings.EqualFold(name, "Authorization") {
+		return value
+	}
+
+	scheme := strings.SplitN(value, " ", 2)
+	if len(scheme) == 2 && scheme[0] == "SharedKey" {
+		if i := strings.LastIndex(scheme[1], ":"); i >= 0 {
+			return "SharedKey " + scheme[1][:i] + ":[REDACTED]"
+		}
+	}
+
+	return scheme[0] + " [REDACTED]"
+}
+
+// dumpBody formats a body, truncated to maxDebugBodySize
+func dumpBody(body []byte) string {
+	if len(body) > maxDebugBodySize {
+		return fmt.Sprintf("%s... (%d bytes truncated)", body[:maxDebugBodySize], len(body)-maxDebugBodySize)
+	}
+
+	return string(body)
+}
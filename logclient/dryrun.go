@@ -0,0 +1,32 @@
+package logclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// WithDryRun writes the serialized batches to w instead of posting them, so the records produced
+// are checked before anything is ingested. Batches are reported as posted and no credential is
+// acquired.
+func WithDryRun(w io.Writer) Option {
+	return func(c *LogClient) {
+		c.dryRun = w
+	}
+}
+
+// writeDryRun writes a batch of logType indented, in a single write so batches of clients sharing
+// the writer are not interleaved
+func (c *LogClient) writeDryRun(logType string, body []byte) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[LOG2OMS][%s] Dry run, %d bytes as %s:\n", time.Now().UTC().Format(time.RFC3339), len(body), logType)
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		buf.Write(body)
+	}
+	buf.WriteString("\n")
+
+	_, err := c.dryRun.Write(buf.Bytes())
+	return err
+}
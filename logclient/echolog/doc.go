@@ -0,0 +1,2 @@
+// Package echolog logs the requests served by Echo, built with the echo tag.
+package echolog
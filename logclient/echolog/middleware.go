@@ -0,0 +1,42 @@
+//go:build echo
+// +build echo
+
+package echolog
+
+import (
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/yangl900/log2oms/logclient"
+)
+
+// Middleware logs each request with accessLog once the handlers after it returned. Errors are
+// handled first, like the logger middleware of Echo does, so their status code is logged. The
+// remote address is the real IP resolved by Echo.
+func Middleware(accessLog *logclient.AccessLog) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			req, res := c.Request(), c.Response()
+			accessLog.Log(logclient.AccessEntry{
+				Start:      start,
+				Duration:   time.Since(start),
+				Method:     req.Method,
+				Host:       req.Host,
+				Path:       req.URL.Path,
+				Protocol:   req.Proto,
+				Status:     res.Status,
+				Bytes:      res.Size,
+				RemoteAddr: c.RealIP(),
+				UserAgent:  req.UserAgent(),
+			})
+
+			return err
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package logclient
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Logs are encoded by hand for the values most records are made of, strings and numbers, which
+// encoding/json spends most of its time reflecting on. Other values are left to encoding/json,
+// the output is the same as json.Marshal.
+
+// appendLog appends log encoded as a JSON object with sorted keys to buf, keys is reused to sort
+// them. It fails like json.Marshal on values which cannot be encoded.
+func appendLog(buf []byte, log map[string]interface{}, keys []string) ([]byte, []string, error) {
+	keys = keys[:0]
+	for k := range log {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf = append(buf, '{')
+	for i, k := range keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendString(buf, k)
+		buf = append(buf, ':')
+
+		var err error
+		if buf, err = appendValue(buf, log[k]); err != nil {
+			return buf, keys, err
+		}
+	}
+
+	return append(buf, '}'), keys, nil
+}
+
+// appendValue appends v encoded as JSON to buf
+func appendValue(buf []byte, v interface{}) ([]byte, error) {
+	switch value := v.(type) {
+	case string:
+		return appendString(buf, value), nil
+	case nil:
+		return append(buf, "null"...), nil
+	case bool:
+		return strconv.AppendBool(buf, value), nil
+	case int:
+		return strconv.AppendInt(buf, int64(value), 10), nil
+	case int64:
+		return strconv.AppendInt(buf, value, 10), nil
+	case float64:
+		// Integers are formatted the same in any notation below 1e21, json.Marshal switches to
+		// exponents for other magnitudes
+		if value == math.Trunc(value) && math.Abs(value) < 1e21 {
+			return strconv.AppendFloat(buf, value, 'f', -1, 64), nil
+		}
+	case json.Number:
+		if isInteger(string(value)) {
+			return append(buf, value...), nil
+		}
+	}
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return buf, err
+	}
+
+	return append(buf, encoded...), nil
+}
+
+// appendString appends s as a JSON string to buf, escaped like json.Marshal does. Strings which
+// are not ASCII, whose invalid UTF-8 and line separators are to be replaced, are left to it.
+func appendString(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			encoded, _ := json.Marshal(s)
+			return append(buf, encoded...)
+		}
+	}
+
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+			continue
+		}
+
+		buf = append(buf, s[start:i]...)
+		switch c {
+		case '"', '\\':
+			buf = append(buf, '\\', c)
+		case '\b':
+			buf = append(buf, '\\', 'b')
+		case '\f':
+			buf = append(buf, '\\', 'f')
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		default:
+			buf = append(buf, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+		}
+		start = i + 1
+	}
+	buf = append(buf, s[start:]...)
+
+	return append(buf, '"')
+}
+
+// hexDigits are the digits of the \u escapes of control characters
+const hexDigits = "0123456789abcdef"
+
+// isInteger tells whether s is a JSON integer, e.g. -12
+func isInteger(s string) bool {
+	if len(s) > 0 && s[0] == '-' {
+		s = s[1:]
+	}
+	if len(s) == 0 || (s[0] == '0' && len(s) > 1) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,140 @@
+package logclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestAppendLog(t *testing.T) {
+	tests := []struct {
+		name string
+		log  map[string]interface{}
+	}{
+		{"empty", map[string]interface{}{}},
+		{"strings", map[string]interface{}{"message": "hello", "b": "", "a": "x"}},
+		{"escapes", map[string]interface{}{"message": "quote \" backslash \\ tab \t newline \n cr \r bell \a html <a&b>"}},
+		{"unicode", map[string]interface{}{"message": "héllo   wörld", "invalid": "a\xffb"}},
+		{"numbers", map[string]interface{}{"int": 42, "int64": int64(-7), "float": 1.5, "whole": 3.0, "huge": 1e21, "tiny": 1e-7}},
+		{"json numbers", map[string]interface{}{"int": json.Number("12"), "negative": json.Number("-3"), "float": json.Number("1.25e3")}},
+		{"others", map[string]interface{}{"nil": nil, "true": true, "false": false, "list": []interface{}{"a", 1.0}, "object": map[string]interface{}{"k": "v"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected, err := json.Marshal(test.log)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			encoded, _, err := appendLog(nil, test.log, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(encoded) != string(expected) {
+				t.Errorf("Encoded %s, expecting %s", encoded, expected)
+			}
+		})
+	}
+}
+
+func TestAppendLogInvalid(t *testing.T) {
+	if _, _, err := appendLog(nil, map[string]interface{}{"nan": math.NaN()}, nil); err == nil {
+		t.Error("Expecting NaN to fail like json.Marshal")
+	}
+}
+
+func TestIsInteger(t *testing.T) {
+	tests := map[string]bool{"0": true, "12": true, "-3": true, "": false, "-": false, "01": false, "1.5": false, "1e3": false}
+	for s, expected := range tests {
+		if isInteger(s) != expected {
+			t.Errorf("isInteger(%q) is %v, expecting %v", s, !expected, expected)
+		}
+	}
+}
+
+func TestChunk(t *testing.T) {
+	var logs []map[string]interface{}
+	for i := 0; i < 10; i++ {
+		logs = append(logs, map[string]interface{}{"message": fmt.Sprintf("line %d", i)})
+	}
+
+	// Each log is 20 bytes, a body of 3 logs is 63 bytes with the brackets and commas
+	bodies, counts := chunk(logs, 64)
+	if len(bodies) != 4 || fmt.Sprint(counts) != "[3 3 3 1]" {
+		t.Fatalf("Chunked %v, expecting [3 3 3 1]", counts)
+	}
+
+	var decoded []map[string]interface{}
+	for _, body := range bodies {
+		if body.Len() > 64 {
+			t.Errorf("Body of %d bytes, larger than 64", body.Len())
+		}
+
+		var logs []map[string]interface{}
+		if err := json.Unmarshal(body.Bytes(), &logs); err != nil {
+			t.Fatal(err)
+		}
+		decoded = append(decoded, logs...)
+	}
+	if len(decoded) != 10 || decoded[9]["message"] != "line 9" {
+		t.Errorf("Decoded %v", decoded)
+	}
+}
+
+// benchLog is a typical record with the metadata of a pipeline
+func benchLog(i int) map[string]interface{} {
+	return map[string]interface{}{
+		"Hostname":    "web-1",
+		"Timestamp":   "2018-06-01T12:00:00Z",
+		"SourceFile":  "/var/log/app.log",
+		"level":       "info",
+		"method":      "GET",
+		"path":        "/api/orders",
+		"status":      json.Number("200"),
+		"duration_ms": float64(i % 250),
+		"message":     fmt.Sprintf("Order %d created for user%d@example.com by \"Mozilla/5.0 (X11; Linux x86_64)\"", i, i%1000),
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	log := benchLog(42)
+	encoded, keys, _ := appendLog(nil, log, nil)
+	b.SetBytes(int64(len(encoded)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		encoded, keys, _ = appendLog(encoded[:0], log, keys)
+	}
+}
+
+func BenchmarkEncodeMarshal(b *testing.B) {
+	log := benchLog(42)
+	encoded, _ := json.Marshal(log)
+	b.SetBytes(int64(len(encoded)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		json.Marshal(log)
+	}
+}
+
+func BenchmarkChunk(b *testing.B) {
+	logs := make([]map[string]interface{}, 1000)
+	size := int64(0)
+	for i := range logs {
+		logs[i] = benchLog(i)
+		encoded, _ := json.Marshal(logs[i])
+		size += int64(len(encoded))
+	}
+	b.SetBytes(size)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		bodies, _ := chunk(logs, MaxIngestionRequestSize)
+		for _, body := range bodies {
+			putBuffer(body)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package logclient
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// IngestError is returned when log analytics service rejects a post request
+type IngestError struct {
+	StatusCode int
+	Body       string
+	Retryable  bool
+	// RetryAfter is how long the service asked to wait before retrying, when throttling
+	RetryAfter time.Duration
+}
+
+func (e *IngestError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("Post log request failed with status: %d %s, retry after %v", e.StatusCode, e.Body, e.RetryAfter)
+	}
+
+	return fmt.Sprintf("Post log request failed with status: %d %s", e.StatusCode, e.Body)
+}
+
+// Throttled tells whether the service rejected the request because too many were sent
+func (e *IngestError) Throttled() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+}
+
+// RequestError is returned when a post request could not be sent or no response was received
+type RequestError struct {
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("Failed to post request: %v", e.Err)
+}
+
+// Unwrap returns the underlying transport error
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// CredentialError is returned when the credentials of a request could not be acquired, e.g. a
+// token or the workspace key, or the client has none. It is not retried, as the configuration is
+// most likely wrong.
+type CredentialError struct {
+	Err error
+}
+
+func (e *CredentialError) Error() string {
+	return fmt.Sprintf("Failed to acquire credentials: %v", e.Err)
+}
+
+// Unwrap returns the error of the credential
+func (e *CredentialError) Unwrap() error {
+	return e.Err
+}
+
+// isRetryableStatus tells whether a request failed with given status code is worth retrying
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode >= http.StatusInternalServerError
+}
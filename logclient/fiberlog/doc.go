@@ -0,0 +1,2 @@
+// Package fiberlog logs the requests served by Fiber, built with the fiber tag.
+package fiberlog
@@ -0,0 +1,50 @@
+//go:build fiber
+// +build fiber
+
+package fiberlog
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yangl900/log2oms/logclient"
+)
+
+// Middleware logs each request with accessLog once the handlers after it returned. A handler
+// error is logged with the status code of a *fiber.Error, 500 otherwise, as the error handler of
+// the app only runs afterwards.
+func Middleware(accessLog *logclient.AccessLog) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			if e, ok := err.(*fiber.Error); ok {
+				status = e.Code
+			}
+		}
+
+		// Values of the context are reused once the handler returns, they are copied
+		accessLog.Log(logclient.AccessEntry{
+			Start:      start,
+			Duration:   time.Since(start),
+			Method:     copyString(c.Method()),
+			Host:       copyString(c.Hostname()),
+			Path:       copyString(c.Path()),
+			Protocol:   string(c.Request().Header.Protocol()),
+			Status:     status,
+			Bytes:      int64(len(c.Response().Body())),
+			RemoteAddr: copyString(c.IP()),
+			UserAgent:  copyString(c.Get(fiber.HeaderUserAgent)),
+		})
+
+		return err
+	}
+}
+
+// copyString copies a string which may share the memory of a reused buffer
+func copyString(s string) string {
+	return string([]byte(s))
+}
@@ -0,0 +1,2 @@
+// Package ginlog logs the requests served by Gin, built with the gin tag.
+package ginlog
@@ -0,0 +1,38 @@
+//go:build gin
+// +build gin
+
+package ginlog
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yangl900/log2oms/logclient"
+)
+
+// Middleware logs each request with accessLog once the handlers after it returned. The remote
+// address is the client IP resolved by Gin, according to its trusted proxies.
+func Middleware(accessLog *logclient.AccessLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		bytes := int64(c.Writer.Size())
+		if bytes < 0 {
+			bytes = 0
+		}
+
+		accessLog.Log(logclient.AccessEntry{
+			Start:      start,
+			Duration:   time.Since(start),
+			Method:     c.Request.Method,
+			Host:       c.Request.Host,
+			Path:       c.Request.URL.Path,
+			Protocol:   c.Request.Proto,
+			Status:     c.Writer.Status(),
+			Bytes:      bytes,
+			RemoteAddr: c.ClientIP(),
+			UserAgent:  c.Request.UserAgent(),
+		})
+	}
+}
@@ -0,0 +1,2 @@
+// Package grpclog provides gRPC server interceptors logging each RPC, built with the grpc tag.
+package grpclog
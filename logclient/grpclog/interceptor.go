@@ -0,0 +1,65 @@
+//go:build grpc
+// +build grpc
+
+package grpclog
+
+import (
+	"context"
+	"time"
+
+	"github.com/yangl900/log2oms/logclient"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor queues in batcher a record per unary RPC, with its method, status code,
+// duration and peer
+func UnaryServerInterceptor(batcher *logclient.Batcher) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		batcher.EnqueueRecord(rpcRecord(ctx, info.FullMethod, "unary", start, err))
+
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor queues in batcher a record per streaming RPC once it ends, with its
+// method, status code, duration and peer
+func StreamServerInterceptor(batcher *logclient.Batcher) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+
+		kind := "bidi_stream"
+		switch {
+		case info.IsClientStream && !info.IsServerStream:
+			kind = "client_stream"
+		case !info.IsClientStream && info.IsServerStream:
+			kind = "server_stream"
+		}
+		batcher.EnqueueRecord(rpcRecord(ss.Context(), info.FullMethod, kind, start, err))
+
+		return err
+	}
+}
+
+// rpcRecord creates the record of an RPC started at start and ended with err
+func rpcRecord(ctx context.Context, method, kind string, start time.Time, err error) logclient.Record {
+	record := logclient.Record{
+		"Timestamp":   start.UTC().Format(time.RFC3339Nano),
+		"method":      method,
+		"type":        kind,
+		"code":        status.Code(err).String(),
+		"duration_ms": float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		record["peer"] = p.Addr.String()
+	}
+	if err != nil {
+		record["error"] = status.Convert(err).Message()
+	}
+
+	return record
+}
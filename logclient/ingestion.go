@@ -0,0 +1,15 @@
+package logclient
+
+import (
+	"strings"
+)
+
+// streamName maps a log type to a stream of a data collection rule, the same way the data
+// collector API maps a log type to a custom table
+func streamName(logType string) string {
+	if strings.HasPrefix(logType, "Custom-") || strings.HasPrefix(logType, "Microsoft-") {
+		return logType
+	}
+
+	return "Custom-" + strings.TrimSuffix(logType, "_CL") + "_CL"
+}
@@ -0,0 +1,101 @@
+package logclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+)
+
+// JSONKeys are the keys of the time, message and level of the objects written by a JSON logger
+type JSONKeys struct {
+	Time    string
+	Message string
+	Level   string
+}
+
+// ZapJSONKeys are the keys of the JSON encoder of zap with its production configuration
+var ZapJSONKeys = JSONKeys{Time: "ts", Message: "msg", Level: "level"}
+
+// ZerologJSONKeys are the default keys of zerolog
+var ZerologJSONKeys = JSONKeys{Time: "time", Message: "message", Level: "level"}
+
+// NewJSONWriter creates a writer queueing in batcher a record per JSON object written, one per
+// line, e.g. as the sink of a zap core:
+//
+//	core := zapcore.NewCore(zapcore.NewJSONEncoder(config), logclient.NewJSONWriter(batcher, logclient.ZapJSONKeys), level)
+//
+// Fields are sent as columns, the time, message and level keys as Timestamp, message and level.
+// Times are RFC 3339 strings or numbers of seconds, milliseconds, microseconds or nanoseconds
+// since the epoch. Lines which are not JSON objects are sent as messages.
+func NewJSONWriter(batcher *Batcher, keys JSONKeys) *Writer {
+	w := NewBatcherWriter(batcher)
+	w.record = func(line []byte) Record {
+		return jsonRecord(line, keys)
+	}
+
+	return w
+}
+
+// jsonRecord converts a JSON object to a record
+func jsonRecord(line []byte, keys JSONKeys) Record {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil || fields == nil {
+		return Record{"message": string(line)}
+	}
+
+	record := make(Record, len(fields))
+	for k, v := range fields {
+		switch k {
+		case keys.Time:
+			if t, ok := jsonTime(v); ok {
+				record["Timestamp"] = t.UTC().Format(time.RFC3339Nano)
+				continue
+			}
+		case keys.Message:
+			record["message"] = v
+			continue
+		case keys.Level:
+			record["level"] = v
+			continue
+		}
+
+		record[strings.Replace(k, ".", "_", -1)] = v
+	}
+
+	return record
+}
+
+// jsonTime parses a time, the unit of numbers is guessed from their magnitude
+func jsonTime(v interface{}) (time.Time, bool) {
+	switch value := v.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		return t, err == nil
+	case json.Number:
+		f, err := value.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		switch abs := math.Abs(f); {
+		case abs < 1e11:
+			return time.Unix(0, int64(f*1e9)), true
+		case abs < 1e14:
+			return time.Unix(0, int64(f*1e6)), true
+		case abs < 1e17:
+			return time.Unix(0, int64(f*1e3)), true
+		default:
+			if n, err := value.Int64(); err == nil {
+				return time.Unix(0, n), true
+			}
+			return time.Unix(0, int64(f)), true
+		}
+	}
+
+	return time.Time{}, false
+}
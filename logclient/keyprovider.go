@@ -0,0 +1,102 @@
+package logclient
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialProvider supplies the base64 encoded workspace key used to sign requests. It is
+// called for every request so a provider can rotate the key without restarting the process.
+type CredentialProvider interface {
+	WorkspaceKey(ctx context.Context) (string, error)
+}
+
+// StaticKey is a workspace key that never changes
+type StaticKey string
+
+// WorkspaceKey returns the key itself
+func (k StaticKey) WorkspaceKey(ctx context.Context) (string, error) {
+	return string(k), nil
+}
+
+// FileKeyProvider reads the workspace key from a file, e.g. a mounted kubernetes secret, and
+// reloads it whenever the file changes
+type FileKeyProvider struct {
+	path string
+
+	mu      sync.Mutex
+	key     string
+	modTime time.Time
+	size    int64
+}
+
+// NewFileKeyProvider creates a provider reading the workspace key from path
+func NewFileKeyProvider(path string) *FileKeyProvider {
+	return &FileKeyProvider{path: path}
+}
+
+// WorkspaceKey returns the content of the key file, re-reading it if it was modified
+func (p *FileKeyProvider) WorkspaceKey(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		if p.key != "" {
+			return p.key, nil
+		}
+
+		return "", fmt.Errorf("Failed to read workspace key file: %v", err)
+	}
+
+	if p.key != "" && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.key, nil
+	}
+
+	buf, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read workspace key file: %v", err)
+	}
+
+	p.key = strings.TrimSpace(string(buf))
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+
+	return p.key, nil
+}
+
+// signingKey caches the decoded form of the last workspace key returned by a provider
+type signingKey struct {
+	provider CredentialProvider
+
+	mu      sync.Mutex
+	encoded string
+	decoded []byte
+}
+
+func (k *signingKey) get(ctx context.Context) ([]byte, error) {
+	encoded, err := k.provider.WorkspaceKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if encoded != k.encoded || k.decoded == nil {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("Workspace key is not valid base64: %v", err)
+		}
+
+		k.encoded, k.decoded = encoded, decoded
+	}
+
+	return k.decoded, nil
+}
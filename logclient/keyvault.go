@@ -0,0 +1,112 @@
+package logclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keyVaultScope      = "https://vault.azure.net/.default"
+	keyVaultAPIVersion = "7.4"
+
+	// DefaultKeyVaultRefresh is how often a Key Vault secret is fetched again
+	DefaultKeyVaultRefresh = time.Hour
+)
+
+// KeyVaultSecret reads a secret from Azure Key Vault, authenticated with an Azure AD token
+// credential such as a managed identity. The secret is cached and fetched again after the
+// refresh interval. It can be used as CredentialProvider when it holds the workspace key, or as
+// the SecretProvider of a ClientSecretCredential when it holds a client secret.
+type KeyVaultSecret struct {
+	vaultURL   string
+	name       string
+	credential TokenCredential
+	refresh    time.Duration
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	value     string
+	fetchedAt time.Time
+}
+
+// NewKeyVaultSecret creates a Key Vault secret source, vaultURL is like "https://myvault.vault.azure.net".
+// A refresh of 0 uses DefaultKeyVaultRefresh.
+func NewKeyVaultSecret(vaultURL, name string, credential TokenCredential, refresh time.Duration) *KeyVaultSecret {
+	if refresh <= 0 {
+		refresh = DefaultKeyVaultRefresh
+	}
+
+	return &KeyVaultSecret{
+		vaultURL:   strings.TrimSuffix(vaultURL, "/"),
+		name:       name,
+		credential: newCachedCredential(credential),
+		refresh:    refresh,
+		httpClient: &http.Client{Timeout: time.Second * 30},
+	}
+}
+
+// Value returns the latest version of the secret. When fetching a new value fails the
+// previously fetched one is returned.
+func (s *KeyVaultSecret) Value(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.value != "" && time.Since(s.fetchedAt) < s.refresh {
+		return s.value, nil
+	}
+
+	value, err := s.fetch(ctx)
+	if err != nil {
+		if s.value != "" {
+			return s.value, nil
+		}
+
+		return "", err
+	}
+
+	s.value, s.fetchedAt = value, time.Now()
+	return s.value, nil
+}
+
+// WorkspaceKey returns the secret as the workspace key
+func (s *KeyVaultSecret) WorkspaceKey(ctx context.Context) (string, error) {
+	return s.Value(ctx)
+}
+
+func (s *KeyVaultSecret) fetch(ctx context.Context) (string, error) {
+	token, err := s.credential.Token(ctx, keyVaultScope)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get Key Vault token: %v", err)
+	}
+
+	secretURL := fmt.Sprintf("%s/secrets/%s?api-version=%s", s.vaultURL, url.PathEscape(s.name), keyVaultAPIVersion)
+	req, _ := http.NewRequest(http.MethodGet, secretURL, nil)
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+
+	response, err := s.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("Failed to get Key Vault secret: %v", err)
+	}
+	defer response.Body.Close()
+
+	buf, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Get Key Vault secret '%s' failed with status: %d %s", s.name, response.StatusCode, string(buf))
+	}
+
+	var secret struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(buf, &secret); err != nil {
+		return "", fmt.Errorf("Failed to parse Key Vault secret: %v", err)
+	}
+
+	return strings.TrimSpace(secret.Value), nil
+}
@@ -2,17 +2,41 @@ package logclient
 
 import (
 	"bytes"
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	defaultAPIVersion          = "2016-04-01"
+	defaultIngestionAPIVersion = "2023-01-01"
+
+	// MaxRequestSize is the maximum body size accepted by the data collector API
+	MaxRequestSize = 30 * 1024 * 1024
+
+	// MaxIngestionRequestSize is the maximum body size accepted by the logs ingestion API
+	MaxIngestionRequestSize = 1024 * 1024
+
+	// LogTypeField is the field of a record choosing its log type instead of the one of the
+	// client, the field is not sent
+	LogTypeField = "_LogType"
+)
+
 var (
 	locationGMT = time.FixedZone("GMT", 0)
+
+	// validLogType matches the log types accepted by the data collector API
+	validLogType = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,100}$`)
+
+	errNoTokenCredential = errors.New("Logs ingestion API requires a token credential")
 )
 
 // LogClient is the client for log analytics
@@ -21,13 +45,32 @@ type LogClient struct {
 	workspaceSecret string
 	logType         string
 	httpClient      *http.Client
-	signingKey      []byte
-	apiLogsURL      string
+	signingKey      *signingKey
+	endpoint        string
+	apiVersion      string
+	retryPolicy     RetryPolicy
+	maxRequestSize  int
+	compress        bool
+	credential      TokenCredential
+	tokenScope      string
+	ingestionRuleID string
+	resourceID      string
+	async           *asyncPoster
+	logger          Logger
+	breaker         *circuitBreaker
+	recordLimiter   *rateLimiter
+	byteLimiter     *rateLimiter
+	throttle        *throttle
+	oversize        *oversize
 	metadata        map[string]string
+	stats           *stats
+	tracer          Tracer
+	debug           bool
+	dryRun          io.Writer
 }
 
-// NewLogClient creates a log client
-func NewLogClient(workspaceID, workspaceSecret, logType string, metadata map[string]string) LogClient {
+// NewLogClient creates a log client, options are applied after the defaults are set
+func NewLogClient(workspaceID, workspaceSecret, logType string, metadata map[string]string, opts ...Option) LogClient {
 	client := LogClient{
 		workspaceID:     workspaceID,
 		workspaceSecret: workspaceSecret,
@@ -39,74 +82,436 @@ func NewLogClient(workspaceID, workspaceSecret, logType string, metadata map[str
 		client.metadata = map[string]string{}
 	}
 
-	client.httpClient = &http.Client{Timeout: time.Second * 30}
-	client.signingKey, _ = base64.StdEncoding.DecodeString(workspaceSecret)
-	client.apiLogsURL = fmt.Sprintf("https://%s.ods.opinsights.azure.com/api/logs?api-version=2016-04-01", workspaceID)
+	client.httpClient = &http.Client{Timeout: time.Second * 30, Transport: NewTransport(TransportConfig{})}
+	client.signingKey = &signingKey{provider: StaticKey(workspaceSecret)}
+	client.retryPolicy = DefaultRetryPolicy
+	client.tokenScope = MonitorScope
+	client.async = &asyncPoster{size: defaultAsyncQueueSize}
+	client.logger = stdoutLogger{}
+	client.throttle = &throttle{}
+	client.oversize = &oversize{policy: OversizeTruncate, maxSize: MaxFieldSize}
+	client.stats = &stats{}
+	client.tracer = nopTracer{}
+
+	for _, opt := range opts {
+		opt(&client)
+	}
+
+	requestSizeLimit := MaxRequestSize
+	if client.ingestionRuleID != "" {
+		requestSizeLimit = MaxIngestionRequestSize
+		if client.apiVersion == "" {
+			client.apiVersion = defaultIngestionAPIVersion
+		}
+	} else {
+		if client.endpoint == "" {
+			client.endpoint = fmt.Sprintf("https://%s.ods.opinsights.azure.com", workspaceID)
+		}
+		if client.apiVersion == "" {
+			client.apiVersion = defaultAPIVersion
+		}
+	}
+
+	if client.maxRequestSize == 0 || client.maxRequestSize > requestSizeLimit {
+		client.maxRequestSize = requestSizeLimit
+	}
 
 	return client
 }
 
+// NewCheckedLogClient creates a log client as NewLogClient does, it fails when the options are
+// inconsistent. Token credentials are only accepted by the logs ingestion API, which requires
+// them, the data collector API only accepts requests signed with the workspace key.
+func NewCheckedLogClient(workspaceID, workspaceSecret, logType string, metadata map[string]string, opts ...Option) (LogClient, error) {
+	client := NewLogClient(workspaceID, workspaceSecret, logType, metadata, opts...)
+
+	if client.ingestionRuleID == "" && client.credential != nil {
+		return client, fmt.Errorf("Token credentials require the logs ingestion API, the data collector API only accepts the workspace key")
+	}
+	if client.ingestionRuleID != "" && client.credential == nil {
+		return client, errNoTokenCredential
+	}
+
+	return client, nil
+}
+
+// logsURL returns the URL records of logType are posted to, with the logs ingestion API each log
+// type is a stream of the data collection rule
+func (c *LogClient) logsURL(logType string) string {
+	if c.ingestionRuleID != "" {
+		return fmt.Sprintf("%s/dataCollectionRules/%s/streams/%s?api-version=%s",
+			strings.TrimSuffix(c.endpoint, "/"), url.PathEscape(c.ingestionRuleID), url.PathEscape(streamName(logType)), url.QueryEscape(c.apiVersion))
+	}
+
+	return fmt.Sprintf("%s/api/logs?api-version=%s", strings.TrimSuffix(c.endpoint, "/"), url.QueryEscape(c.apiVersion))
+}
+
 // PostMessage logs a single message to log analytics service
 func (c *LogClient) PostMessage(message string, timestamp time.Time) error {
-	return c.PostMessages([]string{message}, timestamp)
+	return c.PostMessagesContext(context.Background(), []string{message}, timestamp)
+}
+
+// PostMessageContext logs a single message to log analytics service, the request is bound to ctx
+func (c *LogClient) PostMessageContext(ctx context.Context, message string, timestamp time.Time) error {
+	return c.PostMessagesContext(ctx, []string{message}, timestamp)
 }
 
 // PostMessages logs an array of messages to log analytics service
 func (c *LogClient) PostMessages(messages []string, timestamp time.Time) error {
+	return c.PostMessagesContext(context.Background(), messages, timestamp)
+}
+
+// PostMessagesContext logs an array of messages to log analytics service. The request is
+// aborted when ctx is cancelled or its deadline expires, and no further retry is attempted.
+func (c *LogClient) PostMessagesContext(ctx context.Context, messages []string, timestamp time.Time) error {
+	records := make([]Record, 0, len(messages))
+	for _, m := range messages {
+		records = append(records, Record{"message": m})
+	}
+
+	return c.PostRecordsContext(ctx, records, timestamp)
+}
+
+// PostTimedMessages logs an array of messages keeping the time of each message
+func (c *LogClient) PostTimedMessages(messages []TimedMessage) error {
+	return c.PostTimedMessagesContext(context.Background(), messages)
+}
+
+// PostTimedMessagesContext logs an array of messages keeping the time of each message, messages
+// with a zero time are stamped with the current time
+func (c *LogClient) PostTimedMessagesContext(ctx context.Context, messages []TimedMessage) error {
+	records := make([]Record, 0, len(messages))
+	for _, m := range messages {
+		records = append(records, m.record())
+	}
+
+	return c.PostRecordsContext(ctx, records, time.Time{})
+}
+
+// PostRecords logs an array of structured records to log analytics service
+func (c *LogClient) PostRecords(records []Record, timestamp time.Time) error {
+	return c.PostRecordsContext(context.Background(), records, timestamp)
+}
+
+// PostRecordsContext logs an array of structured records to log analytics service. Every field
+// of a record becomes a column, metadata is added to each record unless the record defines the
+// same field. Records without a Timestamp field are stamped with timestamp. Failed requests are
+// retried according to the retry policy of the client, a *RetryError is returned when the batch
+// is abandoned. ErrCircuitOpen is returned without posting while the circuit breaker is open.
+// Batches larger than the request size limit are split into several requests, posting stops at
+// the first request that fails. Posting waits as long as needed to stay within the rate limits, and
+// is paused for the delay given by Retry-After when the service throttles requests. Records with a
+// string field larger than the field size limit are handled by the oversize policy. Records with
+// a LogTypeField are sent as that log type, in a request per log type.
+func (c *LogClient) PostRecordsContext(ctx context.Context, records []Record, timestamp time.Time) error {
 	if timestamp.IsZero() {
 		timestamp = time.Now().UTC()
 	}
 
-	var logs []map[string]string
-	for _, m := range messages {
-		log := make(map[string]string, len(c.metadata)+1)
+	var oversized OversizeStats
+	// Records are grouped by log type keeping their order, logTypes are the types in order of
+	// their first record
+	groups := map[string][]map[string]interface{}{}
+	var logTypes []string
+	invalidLogTypes, count := 0, 0
+	for _, r := range records {
+		log := getLog(len(c.metadata) + len(r) + 1)
 		for item := range c.metadata {
 			log[item] = c.metadata[item]
 		}
-		log["message"] = m
-		log["Timestamp"] = timestamp.Format(time.RFC3339)
+		for field := range r {
+			log[field] = r[field]
+		}
+		if _, ok := log["Timestamp"]; !ok {
+			log["Timestamp"] = timestamp.Format(time.RFC3339)
+		}
+		if _, ok := log["TimeGenerated"]; !ok && c.ingestionRuleID != "" {
+			log["TimeGenerated"] = log["Timestamp"]
+		}
+
+		logType := c.logType
+		if value, ok := log[LogTypeField]; ok {
+			delete(log, LogTypeField)
+			if s, _ := value.(string); validLogType.MatchString(s) {
+				logType = s
+			} else {
+				invalidLogTypes++
+			}
+		}
+
+		applied := c.oversize.apply(log, &oversized)
+		if _, ok := groups[logType]; !ok {
+			logTypes = append(logTypes, logType)
+		}
+		groups[logType] = append(groups[logType], applied...)
+		count += len(applied)
+	}
+
+	if invalidLogTypes > 0 {
+		warnf(c.logger, "Records with an invalid %s sent as %s: %d.", LogTypeField, c.logType, invalidLogTypes)
+	}
+
+	if oversized != (OversizeStats{}) {
+		c.oversize.add(oversized)
+		warnf(c.logger, "Records with fields larger than %d bytes: %d truncated, %d split, %d dropped.",
+			c.oversize.maxSize, oversized.Truncated, oversized.Split, oversized.Dropped)
+	}
 
-		logs = append(logs, log)
+	if c.recordLimiter != nil {
+		if err := c.recordLimiter.wait(ctx, count); err != nil {
+			return err
+		}
 	}
 
-	body, _ := json.Marshal(logs)
-	req, _ := http.NewRequest(http.MethodPost, c.apiLogsURL, bytes.NewReader(body))
+	// Logs are not referenced once encoded, bodies once posted unless the transport may still be
+	// reading them after a failed request
+	defer func() {
+		for _, logs := range groups {
+			for _, log := range logs {
+				putLog(log)
+			}
+		}
+	}()
+
+	for _, logType := range logTypes {
+		bodies, counts := chunk(groups[logType], c.maxRequestSize)
+		reusable := make([]bool, len(bodies))
+		defer func() {
+			for i, body := range bodies {
+				if reusable[i] {
+					putBuffer(body)
+				}
+			}
+		}()
+
+		for i, buf := range bodies {
+			body := buf.Bytes()
+			reusable[i] = true
+
+			if c.breaker != nil && !c.breaker.allow() {
+				return ErrCircuitOpen
+			}
+
+			postCtx, span := c.tracer.Start(ctx, "log2oms.post")
+			span.SetAttribute("log2oms.log_type", logType)
+			span.SetAttribute("log2oms.records", counts[i])
+			span.SetAttribute("log2oms.bytes", len(body))
+
+			attempts := 0
+			err := c.retryPolicy.do(postCtx, c.logger, func() error {
+				attempts++
+				err := c.send(postCtx, logType, body)
+				if _, failed := err.(*RequestError); failed {
+					reusable[i] = false
+				}
+				return err
+			})
+			span.SetAttribute("log2oms.attempts", attempts)
+			span.End(err)
+
+			c.stats.retried(attempts - 1)
+			if err == nil {
+				c.stats.sent(counts[i])
+			} else {
+				c.stats.failed(counts[i], err)
+			}
+
+			if c.breaker != nil && c.breaker.record(err) {
+				warnf(c.logger, "Circuit breaker opened, posting is paused for %v.", c.breaker.cooldown)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	c.logger.Printf("Posted %d messages.", count)
+
+	return nil
+}
+
+// Stats returns the counts of records, bytes and requests posted by the client, and the outcome of
+// the last posts
+func (c *LogClient) Stats() Stats {
+	return c.stats.get()
+}
+
+// OversizedRecords returns the counts of records which had a field larger than the field size limit
+func (c *LogClient) OversizedRecords() OversizeStats {
+	return c.oversize.stats()
+}
+
+// chunk serializes logs into JSON arrays no larger than maxSize bytes each, and returns the
+// number of logs of each. A single log larger than maxSize is sent on its own. Each log is
+// encoded into a scratch buffer then appended to the request body it fits in. Logs which cannot
+// be encoded are skipped. Bodies are to be given back with putBuffer.
+func chunk(logs []map[string]interface{}, maxSize int) ([]*bytes.Buffer, []int) {
+	var bodies []*bytes.Buffer
+	var counts []int
+
+	var encoded []byte
+	var keys []string
+
+	body := getBuffer()
+	body.WriteByte('[')
+	count := 0
+	for _, log := range logs {
+		var err error
+		if encoded, keys, err = appendLog(encoded[:0], log, keys); err != nil {
+			continue
+		}
+
+		if count > 0 && maxSize > 0 && body.Len()+1+len(encoded)+1 > maxSize {
+			body.WriteByte(']')
+			bodies, counts = append(bodies, body), append(counts, count)
+
+			body, count = getBuffer(), 0
+			body.WriteByte('[')
+		}
+		if count > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(encoded)
+		count++
+	}
+
+	if count > 0 {
+		body.WriteByte(']')
+		bodies, counts = append(bodies, body), append(counts, count)
+	} else {
+		putBuffer(body)
+	}
+
+	return bodies, counts
+}
+
+// send signs and posts a serialized batch of logType once
+func (c *LogClient) send(ctx context.Context, logType string, body []byte) error {
+	return c.request(ctx, logType, body, true)
+}
+
+// request signs and posts body as a batch of logType. Requests not counted, e.g. probes, are
+// neither throttled nor rate limited, and their responses are left out of the stats.
+func (c *LogClient) request(ctx context.Context, logType string, body []byte, counted bool) (err error) {
+	ctx, span := c.tracer.Start(ctx, "log2oms.request")
+	defer func() {
+		span.End(err)
+	}()
+
+	if c.dryRun != nil {
+		return c.writeDryRun(logType, body)
+	}
+
+	if counted {
+		if err := c.throttle.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	plain := body
+	if c.compress {
+		// Given back after the response body is closed, unless the transport may still read it
+		compressed := gzipBuffer(body)
+		defer func() {
+			if _, failed := err.(*RequestError); !failed {
+				putBuffer(compressed)
+			}
+		}()
+		body = compressed.Bytes()
+	}
+
+	if c.byteLimiter != nil && counted {
+		if err := c.byteLimiter.wait(ctx, len(body)); err != nil {
+			return err
+		}
+	}
+
+	logsURL := c.logsURL(logType)
+	req, err := http.NewRequest(http.MethodPost, logsURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Invalid endpoint %s: %v", logsURL, err)
+	}
+	req = req.WithContext(ctx)
 
 	date := time.Now().In(locationGMT).Format(time.RFC1123)
-	stringToSign := "POST\n" + strconv.FormatInt(req.ContentLength, 10) + "\napplication/json\n" + "x-ms-date:" + date + "\n/api/logs"
+	if c.ingestionRuleID != "" {
+		if c.credential == nil {
+			return &CredentialError{Err: errNoTokenCredential}
+		}
+		token, err := c.credential.Token(ctx, c.tokenScope)
+		if err != nil {
+			return &CredentialError{Err: err}
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+	} else {
+		stringToSign := "POST\n" + strconv.FormatInt(req.ContentLength, 10) + "\napplication/json\n" + "x-ms-date:" + date + "\n/api/logs"
+		key, err := c.signingKey.get(ctx)
+		if err != nil {
+			return &CredentialError{Err: err}
+		}
 
-	signature := computeHmac256(stringToSign, c.signingKey)
+		signature := computeHmac256(stringToSign, key)
+
+		req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", c.workspaceID, signature))
+	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", c.workspaceID, signature))
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Log-Type", c.logType)
-	req.Header.Set("x-ms-date", date)
-	req.Header.Set("time-generated-field", "Timestamp")
+	if c.ingestionRuleID == "" {
+		req.Header.Set("Log-Type", logType)
+		req.Header.Set("x-ms-date", date)
+		req.Header.Set("time-generated-field", "Timestamp")
+		if c.resourceID != "" {
+			req.Header.Set("x-ms-AzureResourceId", c.resourceID)
+		}
+	}
+	if c.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.debug {
+		c.dumpRequest(req, plain)
+	}
 
 	response, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("Failed to post request: %v", err)
+		if counted {
+			c.stats.response(0, 0)
+		}
+		return &RequestError{Err: err}
+	}
+	defer func() {
+		// Reading what is left of the response lets the connection be reused by the next request
+		io.Copy(ioutil.Discard, io.LimitReader(response.Body, maxDrainedResponse))
+		response.Body.Close()
+	}()
+	if counted {
+		c.stats.response(response.StatusCode, len(body))
 	}
+	span.SetAttribute("http.status_code", response.StatusCode)
 
-	if response.StatusCode != 200 {
-		defer response.Body.Close()
+	if c.debug {
 		buf, _ := ioutil.ReadAll(response.Body)
+		c.dumpResponse(response, buf)
+		response.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	}
 
-		time.AfterFunc(
-			time.Second*15,
-			func() {
-				err := c.PostMessages(messages, timestamp)
-				if err != nil {
-					fmt.Printf("[LOG2OMS][%s] Retry failed, will keep retrying", time.Now().UTC().Format(time.RFC3339))
-				} else {
-					fmt.Printf("[LOG2OMS][%s] Posted %d messages.\n", time.Now().UTC().Format(time.RFC3339), len(logs))
-				}
-			})
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		buf, _ := ioutil.ReadAll(response.Body)
 
-		return fmt.Errorf("[LOG2OMS][%s] Post log request failed with status: %d %s", time.Now().UTC().Format(time.RFC3339), response.StatusCode, string(buf))
-	}
+		ingestErr := &IngestError{
+			StatusCode: response.StatusCode,
+			Body:       string(buf),
+			Retryable:  isRetryableStatus(response.StatusCode),
+		}
+		if ingestErr.Throttled() && counted {
+			if ingestErr.RetryAfter = parseRetryAfter(response.Header); ingestErr.RetryAfter > 0 {
+				c.throttle.pause(ingestErr.RetryAfter)
+			}
+		}
 
-	fmt.Printf("[LOG2OMS][%s] Posted %d messages.\n", time.Now().UTC().Format(time.RFC3339), len(logs))
+		return ingestErr
+	}
 
 	return nil
 }
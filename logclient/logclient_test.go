@@ -0,0 +1,206 @@
+package logclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staticCredential returns the same token for any scope
+type staticCredential string
+
+func (c staticCredential) Token(ctx context.Context, scope string) (AccessToken, error) {
+	return AccessToken{Token: string(c), ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// newTestClient creates a client posting to a local server answering with handler, the server
+// is to be closed
+func newTestClient(tb testing.TB, handler http.HandlerFunc, opts ...Option) (*LogClient, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	opts = append([]Option{WithEndpoint(server.URL), WithLogger(NopLogger)}, opts...)
+	client := NewLogClient("workspace", "c2VjcmV0", "Test", nil, opts...)
+
+	return &client, server
+}
+
+func TestNewLogClientAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		// authorization is the prefix of the Authorization header posted, "" when the client is
+		// rejected
+		authorization string
+	}{
+		{"workspace key", nil, "SharedKey workspace:"},
+		{"ingestion API with token", []Option{WithIngestionAPI("", "dcr-1"), WithTokenCredential(staticCredential("token"))}, "Bearer token"},
+		{"token without ingestion API", []Option{WithTokenCredential(staticCredential("token"))}, ""},
+		{"ingestion API without token", []Option{WithIngestionAPI("", "dcr-1")}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var authorization string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization = r.Header.Get("Authorization")
+			}))
+			defer server.Close()
+
+			opts := append(test.opts, WithEndpoint(server.URL), WithLogger(NopLogger))
+			client, err := NewCheckedLogClient("workspace", "c2VjcmV0", "Test", nil, opts...)
+			if test.authorization == "" {
+				if err == nil {
+					t.Fatal("Expecting the client to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := client.PostMessage("hello", time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(authorization, test.authorization) {
+				t.Errorf("Authorization is %q, expecting %q", authorization, test.authorization)
+			}
+		})
+	}
+}
+
+// failingCredential fails to supply tokens and workspace keys, counting the calls
+type failingCredential struct {
+	calls int
+}
+
+func (c *failingCredential) Token(ctx context.Context, scope string) (AccessToken, error) {
+	c.calls++
+	return AccessToken{}, errors.New("Forbidden")
+}
+
+func (c *failingCredential) WorkspaceKey(ctx context.Context) (string, error) {
+	c.calls++
+	return "", errors.New("Forbidden")
+}
+
+func TestCredentialError(t *testing.T) {
+	tests := []struct {
+		name       string
+		credential func(c *failingCredential) []Option
+	}{
+		{"token", func(c *failingCredential) []Option {
+			return []Option{WithIngestionAPI("", "dcr-1"), WithTokenCredential(c)}
+		}},
+		{"workspace key", func(c *failingCredential) []Option { return []Option{WithCredentialProvider(c)} }},
+		{"ingestion API without token", func(c *failingCredential) []Option { return []Option{WithIngestionAPI("", "dcr-1")} }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			posted := 0
+			credential := &failingCredential{}
+			opts := append(test.credential(credential), WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+			client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) { posted++ }, opts...)
+			defer server.Close()
+
+			// Credentials failing are not retried, nothing is posted without them
+			err := client.PostMessage("hello", time.Now())
+			if _, ok := err.(*CredentialError); !ok {
+				t.Fatalf("Failed with %#v, expecting a *CredentialError", err)
+			}
+			if credential.calls > 1 || posted > 0 {
+				t.Errorf("Credential called %d times and %d requests posted, expecting no retry", credential.calls, posted)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		status int
+		valid  bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusBadRequest, true},
+		{http.StatusForbidden, false},
+		{http.StatusNotFound, false},
+		{http.StatusTooManyRequests, false},
+	}
+
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "60")
+				w.WriteHeader(test.status)
+			})
+			defer server.Close()
+
+			err := client.Validate(context.Background())
+			if _, ok := err.(*ValidationError); test.valid != (err == nil) || (err != nil && !ok) {
+				t.Errorf("Validation failed with %v", err)
+			}
+
+			// The probe is neither counted nor throttling the posts which follow
+			if responses := client.Stats().Responses; len(responses) > 0 {
+				t.Errorf("Counted responses %v", responses)
+			}
+			if until := client.throttle.until; !until.IsZero() {
+				t.Errorf("Throttled until %v", until)
+			}
+		})
+	}
+}
+
+func TestClientSecretProviderCredential(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/secrets/client-secret":
+			if r.Header.Get("Authorization") != "Bearer vault-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"value": "from-vault"}`))
+		case "/tenant/oauth2/v2.0/token":
+			if r.FormValue("client_id") != "client" || r.FormValue("client_secret") != "from-vault" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"access_token": "token", "expires_in": 3600}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		vaultToken string
+		// err is part of the error expected, the token is acquired when empty
+		err string
+	}{
+		{"secret in Key Vault", "vault-token", ""},
+		{"Key Vault forbidden", "other-token", "Failed to get client secret"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secret := NewKeyVaultSecret(server.URL, "client-secret", staticCredential(test.vaultToken), 0)
+			credential := NewClientSecretProviderCredential("tenant", "client", secret)
+			credential.authorityHost = server.URL
+
+			token, err := credential.Token(context.Background(), MonitorScope)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("Failed with %v, expecting %q", err, test.err)
+				}
+				return
+			}
+			if err != nil || token.Token != "token" {
+				t.Errorf("Acquired %q (%v), expecting token", token.Token, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package logclient
+
+import (
+	"fmt"
+	"time"
+)
+
+// Logger receives the diagnostics of the client, *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdoutLogger prints diagnostics to stdout prefixed with the log2oms tag and current time
+type stdoutLogger struct{}
+
+func (stdoutLogger) Printf(format string, v ...interface{}) {
+	fmt.Printf("[LOG2OMS][%s] %s\n", time.Now().UTC().Format(time.RFC3339), fmt.Sprintf(format, v...))
+}
+
+// nopLogger discards diagnostics
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...interface{}) {}
+
+// NopLogger silences the client when passed to WithLogger
+var NopLogger Logger = nopLogger{}
+
+// LevelLogger is a Logger telling warnings and errors apart, the client logs failed attempts as
+// warnings and records it gives up on as errors when its logger implements it
+type LevelLogger interface {
+	Logger
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// warnf logs a warning, or a diagnostic when logger has no levels
+func warnf(logger Logger, format string, v ...interface{}) {
+	if l, ok := logger.(LevelLogger); ok {
+		l.Warnf(format, v...)
+		return
+	}
+
+	logger.Printf(format, v...)
+}
+
+// errorf logs an error, or a diagnostic when logger has no levels
+func errorf(logger Logger, format string, v ...interface{}) {
+	if l, ok := logger.(LevelLogger); ok {
+		l.Errorf(format, v...)
+		return
+	}
+
+	logger.Printf(format, v...)
+}
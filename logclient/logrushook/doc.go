@@ -0,0 +1,2 @@
+// Package logrushook forwards the entries of logrus loggers to a batcher, built with the logrus tag.
+package logrushook
@@ -0,0 +1,66 @@
+//go:build logrus
+// +build logrus
+
+package logrushook
+
+import (
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yangl900/log2oms/logclient"
+)
+
+// reserved are the columns set from the entry itself, fields of the same names are prefixed with
+// "fields_" like logrus does for its formatters
+var reserved = map[string]bool{"Timestamp": true, "message": true, "level": true, "source_file": true, "source_line": true, "source_function": true}
+
+// Hook queues the entries of its levels in a batcher, with their time, level, message and fields
+// as columns. Entries are posted in batches in the background, close the batcher before exiting so
+// the queued entries are not lost.
+type Hook struct {
+	batcher *logclient.Batcher
+	levels  []logrus.Level
+}
+
+// New creates a hook queueing entries in batcher, of all levels unless levels are given
+func New(batcher *logclient.Batcher, levels ...logrus.Level) *Hook {
+	if len(levels) == 0 {
+		levels = logrus.AllLevels
+	}
+
+	return &Hook{batcher: batcher, levels: levels}
+}
+
+// Levels returns the levels of the entries the hook fires for
+func (h *Hook) Levels() []logrus.Level {
+	return h.levels
+}
+
+// Fire queues an entry, errors in fields are sent as their message
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	record := make(logclient.Record, len(entry.Data)+3)
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+
+		column := strings.Replace(k, ".", "_", -1)
+		if reserved[column] {
+			column = "fields_" + column
+		}
+		record[column] = v
+	}
+
+	record["message"] = entry.Message
+	record["level"] = entry.Level.String()
+	if !entry.Time.IsZero() {
+		record["Timestamp"] = entry.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if entry.HasCaller() {
+		record["source_file"], record["source_line"], record["source_function"] = entry.Caller.File, entry.Caller.Line, entry.Caller.Function
+	}
+
+	h.batcher.EnqueueRecord(record)
+	return nil
+}
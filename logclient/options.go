@@ -0,0 +1,182 @@
+package logclient
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures optional settings of a LogClient
+type Option func(*LogClient)
+
+// WithHTTPClient sends requests with the given http client instead of the default one
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *LogClient) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
+
+// WithTransport sends requests through the given round tripper, keeping the default client timeout
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *LogClient) {
+		c.httpClient = &http.Client{Timeout: c.httpClient.Timeout, Transport: transport}
+	}
+}
+
+// WithTransportConfig tunes the connections of the default transport, keeping the default client
+// timeout
+func WithTransportConfig(config TransportConfig) Option {
+	return WithTransport(NewTransport(config))
+}
+
+// WithEndpoint sends requests to the given base URL, e.g. "http://localhost:8080", instead of
+// "https://{workspaceID}.ods.opinsights.azure.com"
+func WithEndpoint(endpoint string) Option {
+	return func(c *LogClient) {
+		if endpoint != "" {
+			c.endpoint = endpoint
+		}
+	}
+}
+
+// WithAPIVersion overrides the api-version query parameter of the API in use
+func WithAPIVersion(apiVersion string) Option {
+	return func(c *LogClient) {
+		if apiVersion != "" {
+			c.apiVersion = apiVersion
+		}
+	}
+}
+
+// WithRetryPolicy overrides DefaultRetryPolicy for failed post requests
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *LogClient) {
+		c.retryPolicy = policy
+	}
+}
+
+// WithMaxRequestSize splits batches into requests no larger than maxSize bytes, capped at the
+// limit of the API in use
+func WithMaxRequestSize(maxSize int) Option {
+	return func(c *LogClient) {
+		if maxSize > 0 {
+			c.maxRequestSize = maxSize
+		}
+	}
+}
+
+// WithCompression gzips request bodies, the request signature covers the compressed body.
+// The request size limit still applies to the uncompressed payload.
+func WithCompression(enabled bool) Option {
+	return func(c *LogClient) {
+		c.compress = enabled
+	}
+}
+
+// WithTokenCredential authenticates requests to the logs ingestion API with Azure AD bearer
+// tokens acquired from credential. Tokens are cached until shortly before they expire. Requires
+// WithIngestionAPI, the data collector API only accepts the workspace key.
+func WithTokenCredential(credential TokenCredential) Option {
+	return func(c *LogClient) {
+		if credential != nil {
+			c.credential = newCachedCredential(credential)
+		}
+	}
+}
+
+// WithTokenScope overrides MonitorScope when acquiring tokens, e.g. for sovereign clouds
+func WithTokenScope(scope string) Option {
+	return func(c *LogClient) {
+		if scope != "" {
+			c.tokenScope = scope
+		}
+	}
+}
+
+// WithIngestionAPI posts to the Logs Ingestion API through the data collection endpoint and the
+// immutable ID of a data collection rule, instead of the HTTP data collector API. The log type
+// selects the stream of the rule: "nginx" posts to "Custom-nginx_CL", names starting with
+// "Custom-" or "Microsoft-" are used as is. Requires WithTokenCredential.
+func WithIngestionAPI(endpoint, ruleID string) Option {
+	return func(c *LogClient) {
+		c.endpoint = endpoint
+		c.ingestionRuleID = ruleID
+	}
+}
+
+// WithCredentialProvider signs requests with the workspace key supplied by provider instead of
+// the fixed workspace secret, so the key can be rotated while the client is running
+func WithCredentialProvider(provider CredentialProvider) Option {
+	return func(c *LogClient) {
+		if provider != nil {
+			c.signingKey = &signingKey{provider: provider}
+		}
+	}
+}
+
+// WithAzureResourceID associates posted records with an Azure resource, e.g.
+// "/subscriptions/{id}/resourceGroups/{group}/providers/Microsoft.Web/sites/{name}", so they
+// are visible with resource-context access in log analytics. Only the data collector API
+// supports it.
+func WithAzureResourceID(resourceID string) Option {
+	return func(c *LogClient) {
+		c.resourceID = resourceID
+	}
+}
+
+// WithAsyncQueueSize sets how many batches PostAsync queues before it blocks
+func WithAsyncQueueSize(size int) Option {
+	return func(c *LogClient) {
+		if size > 0 {
+			c.async.size = size
+		}
+	}
+}
+
+// WithLogger routes the diagnostics of the client to logger instead of stdout, use NopLogger to
+// silence them
+func WithLogger(logger Logger) Option {
+	return func(c *LogClient) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+// WithCircuitBreaker stops posting after threshold consecutive batches failed with a retryable
+// error, posts fail with ErrCircuitOpen until cooldown has elapsed and a probe batch succeeds
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *LogClient) {
+		if threshold > 0 {
+			c.breaker = newCircuitBreaker(threshold, cooldown)
+		}
+	}
+}
+
+// WithRateLimit limits how many records and bytes are posted per second on average, e.g. to stay
+// within ingestion quotas or spare a small network link, 0 means no limit. Bytes are counted as
+// sent, after compression, including retries.
+func WithRateLimit(recordsPerSecond, bytesPerSecond float64) Option {
+	return func(c *LogClient) {
+		c.recordLimiter, c.byteLimiter = nil, nil
+		if recordsPerSecond > 0 {
+			c.recordLimiter = newRateLimiter(recordsPerSecond)
+		}
+		if bytesPerSecond > 0 {
+			c.byteLimiter = newRateLimiter(bytesPerSecond)
+		}
+	}
+}
+
+// WithOversizePolicy sets what to do with records having a string field larger than maxFieldSize
+// bytes, MaxFieldSize when 0. Oversized fields are truncated by default.
+func WithOversizePolicy(policy OversizePolicy, maxFieldSize int) Option {
+	return func(c *LogClient) {
+		if maxFieldSize <= 0 {
+			maxFieldSize = MaxFieldSize
+		}
+
+		c.oversize = &oversize{policy: policy, maxSize: maxFieldSize}
+	}
+}
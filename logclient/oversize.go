@@ -0,0 +1,136 @@
+package logclient
+
+import (
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// MaxFieldSize is the largest field value accepted by log analytics, longer values are truncated
+// by the service
+const MaxFieldSize = 32 * 1024
+
+// OversizePolicy tells what to do with records having a string field larger than the field size
+// limit
+type OversizePolicy int
+
+const (
+	// OversizeTruncate cuts oversized fields at the limit
+	OversizeTruncate OversizePolicy = iota
+	// OversizeSplit splits an oversized message into several records numbered by PartIndex and
+	// PartCount, other oversized fields are truncated
+	OversizeSplit
+	// OversizeDrop drops records having an oversized field
+	OversizeDrop
+)
+
+// OversizeStats counts the records having an oversized field
+type OversizeStats struct {
+	Truncated int64
+	Split     int64
+	Dropped   int64
+}
+
+// oversize applies the oversize policy of a client and counts the records it changed
+type oversize struct {
+	policy  OversizePolicy
+	maxSize int
+
+	truncated int64
+	split     int64
+	dropped   int64
+}
+
+// apply returns the records to post in place of log, counting the change in counts
+func (o *oversize) apply(log map[string]interface{}, counts *OversizeStats) []map[string]interface{} {
+	oversized := false
+	for _, value := range log {
+		if s, ok := value.(string); ok && len(s) > o.maxSize {
+			oversized = true
+			break
+		}
+	}
+	if !oversized {
+		return []map[string]interface{}{log}
+	}
+
+	switch o.policy {
+	case OversizeDrop:
+		counts.Dropped++
+		return nil
+	case OversizeSplit:
+		if message, ok := log["message"].(string); ok && len(message) > o.maxSize {
+			counts.Split++
+			return o.splitMessage(log, message)
+		}
+	}
+
+	counts.Truncated++
+	return []map[string]interface{}{o.truncate(log)}
+}
+
+// truncate cuts the oversized string fields of log
+func (o *oversize) truncate(log map[string]interface{}) map[string]interface{} {
+	for field, value := range log {
+		if s, ok := value.(string); ok && len(s) > o.maxSize {
+			log[field] = s[:cutIndex(s, o.maxSize)]
+		}
+	}
+
+	return log
+}
+
+// splitMessage copies log once per part of message, the other fields are repeated in each part
+func (o *oversize) splitMessage(log map[string]interface{}, message string) []map[string]interface{} {
+	var parts []string
+	for len(message) > o.maxSize {
+		i := cutIndex(message, o.maxSize)
+		parts = append(parts, message[:i])
+		message = message[i:]
+	}
+	parts = append(parts, message)
+
+	base := o.truncate(log)
+	logs := make([]map[string]interface{}, 0, len(parts))
+	for i, part := range parts {
+		copied := make(map[string]interface{}, len(base)+2)
+		for field, value := range base {
+			copied[field] = value
+		}
+		copied["message"] = part
+		copied["PartIndex"] = i + 1
+		copied["PartCount"] = len(parts)
+
+		logs = append(logs, copied)
+	}
+
+	return logs
+}
+
+// add adds counts to the counters
+func (o *oversize) add(counts OversizeStats) {
+	atomic.AddInt64(&o.truncated, counts.Truncated)
+	atomic.AddInt64(&o.split, counts.Split)
+	atomic.AddInt64(&o.dropped, counts.Dropped)
+}
+
+// stats returns the counters
+func (o *oversize) stats() OversizeStats {
+	return OversizeStats{
+		Truncated: atomic.LoadInt64(&o.truncated),
+		Split:     atomic.LoadInt64(&o.split),
+		Dropped:   atomic.LoadInt64(&o.dropped),
+	}
+}
+
+// cutIndex returns the largest index not above n which does not cut a UTF-8 sequence of s
+func cutIndex(s string, n int) int {
+	i := n
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	if i == 0 {
+		return n
+	}
+
+	return i
+}
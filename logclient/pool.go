@@ -0,0 +1,64 @@
+package logclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync"
+)
+
+// Request bodies, their compression and the logs they are encoded from are reused across posts,
+// so shipping at a sustained rate does not allocate them over and over
+
+const (
+	// maxPooledBufferSize is the largest buffer kept for reuse, larger ones are left to the
+	// garbage collector so a single huge record does not stay in memory
+	maxPooledBufferSize = MaxRequestSize * 2
+)
+
+var (
+	bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	gzipPool   = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	logPool    sync.Pool
+)
+
+// getBuffer returns an empty buffer, to be given back with putBuffer once its bytes are no longer used
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		bufferPool.Put(buf)
+	}
+}
+
+// getLog returns an empty log, to be given back with putLog once posted
+func getLog(size int) map[string]interface{} {
+	if log, ok := logPool.Get().(map[string]interface{}); ok {
+		return log
+	}
+
+	return make(map[string]interface{}, size)
+}
+
+func putLog(log map[string]interface{}) {
+	for k := range log {
+		delete(log, k)
+	}
+	logPool.Put(log)
+}
+
+// gzipBuffer compresses a request body into a buffer to be given back with putBuffer
+func gzipBuffer(body []byte) *bytes.Buffer {
+	buf := getBuffer()
+	w := gzipPool.Get().(*gzip.Writer)
+	w.Reset(buf)
+	w.Write(body)
+	w.Close()
+	gzipPool.Put(w)
+
+	return buf
+}
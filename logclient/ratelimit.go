@@ -0,0 +1,54 @@
+package logclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket refilled at rate per second and holding up to one second of
+// tokens. A request larger than the bucket is let through once the bucket is full and leaves it
+// in debt, so the average rate is kept whatever the size of requests.
+type rateLimiter struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// wait blocks until n tokens can be taken or ctx is done
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+		l.last = now
+
+		if l.tokens >= float64(n) || l.tokens >= l.rate {
+			l.tokens -= float64(n)
+			l.mu.Unlock()
+			return nil
+		}
+
+		needed := float64(n)
+		if needed > l.rate {
+			needed = l.rate
+		}
+		delay := time.Duration((needed - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
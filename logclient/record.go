@@ -0,0 +1,25 @@
+package logclient
+
+import (
+	"time"
+)
+
+// Record is a structured log entry, each field is sent as a column to log analytics.
+// Values must be JSON serializable.
+type Record map[string]interface{}
+
+// TimedMessage is a message with the time it was originally logged
+type TimedMessage struct {
+	Message string
+	Time    time.Time
+}
+
+// record converts a timed message to a record, a zero time is left for the poster to stamp
+func (m TimedMessage) record() Record {
+	record := Record{"message": m.Message}
+	if !m.Time.IsZero() {
+		record["Timestamp"] = m.Time.UTC().Format(time.RFC3339)
+	}
+
+	return record
+}
@@ -0,0 +1,131 @@
+package logclient
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how failed post requests are retried. Delay before the n-th retry is
+// BaseDelay * Multiplier^(n-1), capped at MaxDelay and randomized by +/- Jitter fraction. A longer
+// delay asked by the service with Retry-After is honored instead.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one, 0 means unlimited
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts, 0 means no cap
+	MaxDelay time.Duration
+	// Multiplier grows the delay after each retry, values below 1 are treated as 1
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction, between 0 and 1
+	Jitter float64
+	// MaxElapsed stops retrying when the next attempt would start later than this after the first one, 0 means no limit
+	MaxElapsed time.Duration
+}
+
+var (
+	// DefaultRetryPolicy is used by clients created without WithRetryPolicy
+	DefaultRetryPolicy = RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second * 2,
+		MaxDelay:    time.Minute,
+		Multiplier:  2,
+		Jitter:      0.2,
+	}
+
+	// NoRetry posts each request only once
+	NoRetry = RetryPolicy{MaxAttempts: 1}
+)
+
+// RetryError is returned when a batch is abandoned after retrying
+type RetryError struct {
+	Attempts int
+	Elapsed  time.Duration
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("Batch abandoned after %d attempts in %v: %v", e.Attempts, e.Elapsed.Round(time.Millisecond), e.Err)
+}
+
+// Unwrap returns the error of the last attempt
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// Delay returns the delay before the given retry, starting from 1
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(p.BaseDelay)
+	for i := 1; i < retry; i++ {
+		delay *= multiplier
+		if p.MaxDelay > 0 && delay >= float64(p.MaxDelay) {
+			break
+		}
+	}
+
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// do runs attempt until it succeeds, fails with a non retryable error, the policy gives up or ctx is done
+func (p RetryPolicy) do(ctx context.Context, logger Logger, attempt func() error) error {
+	start := time.Now()
+
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+
+		if !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := p.Delay(n)
+		if after := retryAfter(err); after > delay {
+			delay = after
+		}
+		elapsed := time.Since(start)
+		if (p.MaxAttempts > 0 && n >= p.MaxAttempts) || (p.MaxElapsed > 0 && elapsed+delay > p.MaxElapsed) {
+			return &RetryError{Attempts: n, Elapsed: elapsed, Err: err}
+		}
+
+		warnf(logger, "Attempt %d failed, retry in %v: %v", n, delay.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &RetryError{Attempts: n, Elapsed: time.Since(start), Err: err}
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryable tells whether a failed attempt is worth retrying
+func isRetryable(err error) bool {
+	switch e := err.(type) {
+	case *IngestError:
+		return e.Retryable
+	case *RequestError:
+		return true
+	case *RetryError:
+		return isRetryable(e.Err)
+	}
+
+	return err == ErrCircuitOpen
+}
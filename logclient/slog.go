@@ -0,0 +1,192 @@
+//go:build go1.21
+// +build go1.21
+
+package logclient
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SlogHandler is a slog.Handler queueing each record in a batcher, with its time, level, message
+// and attributes as columns. Attributes of groups are prefixed with the group names joined by
+// underscores, e.g. "request_method", as column names cannot hold dots.
+type SlogHandler struct {
+	batcher *Batcher
+	opts    slog.HandlerOptions
+	// attrs are the columns added by WithAttrs, prefix the one of the groups opened by WithGroup
+	attrs  Record
+	prefix string
+	groups []string
+}
+
+// NewSlogHandler creates a handler queueing records in batcher, opts may be nil. Records below
+// opts.Level, info by default, are discarded.
+func NewSlogHandler(batcher *Batcher, opts *slog.HandlerOptions) *SlogHandler {
+	h := &SlogHandler{batcher: batcher, attrs: Record{}}
+	if opts != nil {
+		h.opts = *opts
+	}
+
+	return h
+}
+
+// Enabled tells whether records of level are handled
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	min := slog.LevelInfo
+	if h.opts.Level != nil {
+		min = h.opts.Level.Level()
+	}
+
+	return level >= min
+}
+
+// Handle queues a record
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	record := make(Record, len(h.attrs)+r.NumAttrs()+4)
+	for k, v := range h.attrs {
+		record[k] = v
+	}
+
+	builtins := []slog.Attr{slog.String(slog.MessageKey, r.Message), slog.Any(slog.LevelKey, r.Level)}
+	if !r.Time.IsZero() {
+		builtins = append(builtins, slog.Time(slog.TimeKey, r.Time))
+	}
+	if h.opts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		builtins = append(builtins, slog.Any(slog.SourceKey, &slog.Source{Function: frame.Function, File: frame.File, Line: frame.Line}))
+	}
+	for _, a := range builtins {
+		h.addBuiltin(record, a)
+	}
+
+	r.Attrs(func(a slog.Attr) bool {
+		h.add(record, h.prefix, h.groups, a)
+		return true
+	})
+
+	h.batcher.EnqueueRecord(record)
+	return nil
+}
+
+// WithAttrs returns a handler adding attrs to each record
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	copied := *h
+	copied.attrs = make(Record, len(h.attrs)+len(attrs))
+	for k, v := range h.attrs {
+		copied.attrs[k] = v
+	}
+	for _, a := range attrs {
+		h.add(copied.attrs, h.prefix, h.groups, a)
+	}
+
+	return &copied
+}
+
+// WithGroup returns a handler prefixing the attributes added from then on with name
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	copied := *h
+	copied.prefix = h.prefix + name + "_"
+	copied.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+
+	return &copied
+}
+
+// addBuiltin adds the time, level, message or source of a record, renamed as the fields of the
+// records sent by the client
+func (h *SlogHandler) addBuiltin(record Record, a slog.Attr) {
+	if h.opts.ReplaceAttr != nil {
+		a = h.opts.ReplaceAttr(nil, a)
+	}
+	a.Value = a.Value.Resolve()
+	if a.Key == "" {
+		return
+	}
+
+	switch a.Key {
+	case slog.TimeKey:
+		if t, ok := a.Value.Any().(time.Time); ok {
+			record["Timestamp"] = t.UTC().Format(time.RFC3339Nano)
+			return
+		}
+	case slog.MessageKey:
+		record["message"] = slogValue(a.Value)
+		return
+	case slog.SourceKey:
+		if source, ok := a.Value.Any().(*slog.Source); ok {
+			record["source_file"], record["source_line"], record["source_function"] = source.File, source.Line, source.Function
+			return
+		}
+	}
+
+	record[columnName(a.Key)] = slogValue(a.Value)
+}
+
+// add adds an attribute as a column, the attributes of groups are flattened
+func (h *SlogHandler) add(record Record, prefix string, groups []string, a slog.Attr) {
+	if h.opts.ReplaceAttr != nil && a.Value.Kind() != slog.KindGroup {
+		a = h.opts.ReplaceAttr(groups, a)
+	}
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if a.Key != "" {
+			prefix, groups = prefix+a.Key+"_", append(groups[:len(groups):len(groups)], a.Key)
+		}
+		for _, ga := range attrs {
+			h.add(record, prefix, groups, ga)
+		}
+		return
+	}
+
+	record[columnName(prefix+a.Key)] = slogValue(a.Value)
+}
+
+// columnName replaces the dots of an attribute key
+func columnName(key string) string {
+	return strings.Replace(key, ".", "_", -1)
+}
+
+// slogValue converts a value to one serialized as a column, errors as their message
+func slogValue(v slog.Value) interface{} {
+	switch v.Kind() {
+	case slog.KindString:
+		return v.String()
+	case slog.KindInt64:
+		return v.Int64()
+	case slog.KindUint64:
+		return v.Uint64()
+	case slog.KindFloat64:
+		return v.Float64()
+	case slog.KindBool:
+		return v.Bool()
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	}
+
+	switch value := v.Any().(type) {
+	case error:
+		return value.Error()
+	case slog.Level:
+		return value.String()
+	default:
+		return value
+	}
+}
@@ -0,0 +1,241 @@
+package logclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	spoolFileSuffix = ".json"
+	// spoolTempSuffix is the suffix of batches being written, renamed once complete
+	spoolTempSuffix = ".tmp"
+)
+
+// Spool keeps batches of records on disk until they are posted, so they survive restarts and
+// long outages without holding them in memory. Each batch is a file named after its sequence
+// number and its count of records. The spool may grow beyond its size limit, the oldest batches
+// are to be dropped while it is Overflowing.
+type Spool struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	seq     uint64
+	batches []spoolBatch
+	size    int64
+}
+
+// spoolBatch is a batch file in the spool
+type spoolBatch struct {
+	name    string
+	records int
+	size    int64
+}
+
+// NewSpool opens the spool in dir, creating it if needed. Batches left by a previous run are
+// recovered, the ones it was still writing are deleted. maxBytes is the size limit of the spool,
+// 0 means no limit.
+func NewSpool(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create spool directory: %v", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read spool directory: %v", err)
+	}
+
+	s := &Spool{dir: dir, maxBytes: maxBytes}
+	for _, f := range files {
+		if name := strings.TrimSuffix(f.Name(), spoolTempSuffix); name != f.Name() {
+			if _, _, ok := parseSpoolName(name); ok {
+				os.Remove(filepath.Join(dir, f.Name()))
+			}
+			continue
+		}
+
+		seq, records, ok := parseSpoolName(f.Name())
+		if !ok {
+			continue
+		}
+
+		s.batches = append(s.batches, spoolBatch{name: f.Name(), records: records, size: f.Size()})
+		s.size += f.Size()
+		if seq >= s.seq {
+			s.seq = seq + 1
+		}
+	}
+
+	sort.Slice(s.batches, func(i, j int) bool { return s.batches[i].name < s.batches[j].name })
+
+	return s, nil
+}
+
+// Push writes a batch to the spool
+func (s *Spool) Push(records []Record) error {
+	_, err := s.push(records)
+	return err
+}
+
+// push writes a batch to the spool and returns its id
+func (s *Spool) push(records []Record) (string, error) {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := fmt.Sprintf("%020d-%d%s", s.seq, len(records), spoolFileSuffix)
+	tmp := filepath.Join(s.dir, name+spoolTempSuffix)
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("Failed to write spool: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("Failed to write spool: %v", err)
+	}
+
+	s.seq++
+	s.batches = append(s.batches, spoolBatch{name: name, records: len(records), size: int64(len(body))})
+	s.size += int64(len(body))
+
+	return name, nil
+}
+
+// Overflowing tells whether the spool is beyond its size limit, the last batch is always kept
+func (s *Spool) Overflowing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.maxBytes > 0 && s.size > s.maxBytes && len(s.batches) > 1
+}
+
+// Peek reads the oldest batch, it returns an empty id when the spool is empty. A batch which
+// cannot be read is removed.
+func (s *Spool) Peek() ([]Record, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.batches) == 0 {
+		return nil, "", nil
+	}
+
+	batch := s.batches[0]
+	records, err := s.read(batch.name)
+	if err == nil {
+		return records, batch.name, nil
+	}
+
+	s.remove(0)
+	return nil, "", fmt.Errorf("Dropped corrupted spool batch %s: %v", batch.name, err)
+}
+
+// peek reads up to n of the oldest batches, like Peek. Batches which cannot be read are removed,
+// the error tells about the last one.
+func (s *Spool) peek(n int) ([][]Record, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var batches [][]Record
+	var ids []string
+	var err error
+	for i := 0; i < len(s.batches) && len(ids) < n; {
+		batch := s.batches[i]
+		records, readErr := s.read(batch.name)
+		if readErr != nil {
+			s.remove(i)
+			err = fmt.Errorf("Dropped corrupted spool batch %s: %v", batch.name, readErr)
+			continue
+		}
+
+		batches, ids = append(batches, records), append(ids, batch.name)
+		i++
+	}
+
+	return batches, ids, err
+}
+
+// read reads the records of the batch file name
+func (s *Spool) read(name string) ([]Record, error) {
+	body, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var records []Record
+	if err := decoder.Decode(&records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Remove deletes a batch returned by Peek once it is posted
+func (s *Spool) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, batch := range s.batches {
+		if batch.name == id {
+			s.remove(i)
+			return
+		}
+	}
+}
+
+// State returns how many batches and records are spooled, and their size in bytes
+func (s *Spool) State() (int, int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := 0
+	for _, batch := range s.batches {
+		records += batch.records
+	}
+
+	return len(s.batches), records, s.size
+}
+
+// parseSpoolName parses the sequence number and count of records of a batch file name
+func parseSpoolName(name string) (uint64, int, bool) {
+	if !strings.HasSuffix(name, spoolFileSuffix) {
+		return 0, 0, false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(name, spoolFileSuffix), "-")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	seq, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	records, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return seq, records, true
+}
+
+// remove deletes the i-th batch, must be called holding mu
+func (s *Spool) remove(i int) {
+	os.Remove(filepath.Join(s.dir, s.batches[i].name))
+	s.size -= s.batches[i].size
+	s.batches = append(s.batches[:i], s.batches[i+1:]...)
+}
@@ -0,0 +1,175 @@
+package logclient
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// spoolMessages returns the messages of the batches spooled in s, oldest first
+func spoolMessages(t *testing.T, s *Spool) []string {
+	batches, _, err := s.peek(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	for _, batch := range batches {
+		for _, record := range batch {
+			messages = append(messages, fmt.Sprint(record["message"]))
+		}
+	}
+
+	return messages
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, batch := range [][]Record{{{"message": "a"}, {"message": "b"}}, {{"message": "c"}}} {
+		if err := s.Push(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if batches, records, _ := s.State(); batches != 2 || records != 3 {
+		t.Errorf("%d records in %d batches, expecting 3 in 2", records, batches)
+	}
+
+	// The oldest batch is peeked until removed
+	_, id, err := s.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Remove(id)
+	if messages := fmt.Sprint(spoolMessages(t, s)); messages != "[c]" {
+		t.Errorf("Spooled %s once the oldest batch is removed, expecting [c]", messages)
+	}
+
+	// Batches are recovered in order after a restart, and new ones follow them
+	if s, err = NewSpool(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Push([]Record{{"message": "d"}}); err != nil {
+		t.Fatal(err)
+	}
+	if messages := fmt.Sprint(spoolMessages(t, s)); messages != "[c d]" {
+		t.Errorf("Spooled %s after a restart, expecting [c d]", messages)
+	}
+
+	// Files which are not batches are ignored, corrupted batches are dropped and the ones left
+	// unfinished by a crash are deleted
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt.tmp"), []byte("x"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "00000000000000000000-1.json"), []byte("[{"), 0644)
+	unfinished := filepath.Join(dir, "00000000000000000009-1.json.tmp")
+	ioutil.WriteFile(unfinished, []byte("[{"), 0644)
+	if s, err = NewSpool(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(unfinished); !os.IsNotExist(err) {
+		t.Error("Expecting the unfinished batch to be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt.tmp")); err != nil {
+		t.Error("Expecting files which are not batches to be kept")
+	}
+	if _, _, err := s.Peek(); err == nil {
+		t.Error("Expecting the corrupted batch to be reported")
+	}
+	if messages := fmt.Sprint(spoolMessages(t, s)); messages != "[c d]" {
+		t.Errorf("Spooled %s once the corrupted batch is dropped, expecting [c d]", messages)
+	}
+}
+
+func TestSpoolOverflowing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A batch of one record is 17 bytes
+	s, err := NewSpool(dir, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, overflowing := range []bool{false, true, true} {
+		if err := s.Push([]Record{{"message": "a"}}); err != nil {
+			t.Fatal(err)
+		}
+		if s.Overflowing() != overflowing {
+			t.Errorf("Overflowing is %v with %d batches", !overflowing, i+1)
+		}
+	}
+
+	// The last batch is kept whatever its size
+	s, err = NewSpool(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s.Overflowing() {
+		_, id, _ := s.Peek()
+		s.Remove(id)
+	}
+	if batches, _, _ := s.State(); batches != 1 {
+		t.Errorf("%d batches left, expecting 1", batches)
+	}
+}
+
+func TestBatcherSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := NewSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := newTestClient(t, unavailable, WithRetryPolicy(NoRetry))
+	batcher := NewBatcher(client, BatchConfig{Spool: spool})
+
+	// Records are acknowledged once spooled, even though they are not posted
+	acked := int32(0)
+	for i := 0; i < 3; i++ {
+		batcher.EnqueueRecordWithAck(Record{"message": "hello"}, func() { atomic.AddInt32(&acked, 1) })
+	}
+	batcher.Close(context.Background())
+	server.Close()
+	if acked != 3 {
+		t.Errorf("%d records acknowledged, expecting 3", acked)
+	}
+	if state := batcher.RetryState(); state.Records != 3 || state.Dropped != 0 {
+		t.Errorf("%d records spooled and %d dropped, expecting 3 spooled", state.Records, state.Dropped)
+	}
+
+	// The spool is posted by the batcher of the next run
+	if spool, err = NewSpool(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	posts := make(chan int, 10)
+	client, server = newTestClient(t, recordPosts(posts))
+	defer server.Close()
+	batcher = NewBatcher(client, BatchConfig{Spool: spool})
+	if err := batcher.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-posts; n != 3 {
+		t.Errorf("Posted %d records, expecting 3", n)
+	}
+	if batches, _, _ := spool.State(); batches != 0 {
+		t.Errorf("%d batches left in the spool", batches)
+	}
+}
@@ -0,0 +1,88 @@
+package logclient
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats counts what a LogClient posted since it was created, e.g. for applications embedding it to
+// report its health
+type Stats struct {
+	// Records counts the records posted successfully
+	Records int64
+	// Failed counts the records of posts which failed, records posted again are counted again
+	Failed int64
+	// Bytes counts the size of the request bodies posted successfully, once compressed
+	Bytes int64
+	// Retries counts the attempts made again after a failure
+	Retries int64
+	// Responses counts the responses by HTTP status code, 0 counts the requests which failed
+	// without response
+	Responses map[int]int64
+	// LastSuccess is when a request last succeeded, zero if none did
+	LastSuccess time.Time
+	// LastError is the error of the last failed post, and LastErrorTime when it failed
+	LastError     error
+	LastErrorTime time.Time
+}
+
+// stats accumulates the Stats of a client
+type stats struct {
+	mu sync.Mutex
+	s  Stats
+}
+
+// sent counts records posted successfully
+func (s *stats) sent(records int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.s.Records += int64(records)
+}
+
+// failed counts records of a failed post
+func (s *stats) failed(records int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.s.Failed += int64(records)
+	s.s.LastError = err
+	s.s.LastErrorTime = time.Now()
+}
+
+// retried counts attempts made again
+func (s *stats) retried(retries int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.s.Retries += int64(retries)
+}
+
+// response counts a response with status code, and the bytes of a successful request
+func (s *stats) response(code, bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.s.Responses == nil {
+		s.s.Responses = map[int]int64{}
+	}
+	s.s.Responses[code]++
+	if code >= 200 && code <= 299 {
+		s.s.Bytes += int64(bytes)
+		s.s.LastSuccess = time.Now()
+	}
+}
+
+// get returns a copy of the stats
+func (s *stats) get() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := s.s
+	copied.Responses = make(map[int]int64, len(s.s.Responses))
+	for code, n := range s.s.Responses {
+		copied.Responses[code] = n
+	}
+
+	return copied
+}
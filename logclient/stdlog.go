@@ -0,0 +1,44 @@
+package logclient
+
+import (
+	"io"
+	"log"
+)
+
+// NewLogger creates a logger sending each line with client, batched according to
+// DefaultBatchConfig. Lines start with prefix and have a level column unless level is empty, e.g.
+// "info". Records have their own Timestamp so the logger writes no date, close the returned writer
+// before exiting so the queued lines are not lost.
+func NewLogger(client *LogClient, prefix, level string) (*log.Logger, *Writer) {
+	w := newLevelWriter(client, level)
+	return log.New(w, prefix, 0), w
+}
+
+// RedirectLog sends the lines of the standard logger with client too, batched according to
+// DefaultBatchConfig and with a level column unless level is empty. The lines are still written to
+// the previous output of the standard logger, close the returned writer before exiting so the
+// queued lines are not lost:
+//
+//	w := logclient.RedirectLog(&client, "info")
+//	defer w.Close(context.Background())
+func RedirectLog(client *LogClient, level string) *Writer {
+	w := newLevelWriter(client, level)
+	log.SetOutput(io.MultiWriter(log.Writer(), w))
+
+	return w
+}
+
+// newLevelWriter creates a writer adding level to each line unless it is empty
+func newLevelWriter(client *LogClient, level string) *Writer {
+	w := NewWriter(client)
+	if level != "" {
+		record := w.record
+		w.record = func(line []byte) Record {
+			r := record(line)
+			r["level"] = level
+			return r
+		}
+	}
+
+	return w
+}
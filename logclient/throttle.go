@@ -0,0 +1,85 @@
+package logclient
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttle pauses every request of a client while log analytics asks to back off. It is shared by
+// copies of the client, so a throttled batch also holds back the batches posted concurrently.
+type throttle struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// pause holds back requests for d, an earlier pause lasting longer is kept
+func (t *throttle) pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// wait blocks until the pause is over or ctx is done
+func (t *throttle) wait(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		delay := time.Until(t.until)
+		t.mu.Unlock()
+
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// parseRetryAfter reads the Retry-After header, given either in seconds or as an HTTP date. It
+// returns 0 when the header is missing or invalid.
+func parseRetryAfter(header http.Header) time.Duration {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
+
+// retryAfter returns how long log analytics asked to wait before retrying after err, 0 when it
+// did not tell
+func retryAfter(err error) time.Duration {
+	switch e := err.(type) {
+	case *IngestError:
+		return e.RetryAfter
+	case *RetryError:
+		return retryAfter(e.Err)
+	}
+
+	return 0
+}
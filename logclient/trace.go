@@ -0,0 +1,39 @@
+package logclient
+
+import (
+	"context"
+)
+
+// Tracer starts the spans of the posts of a client, e.g. to export them with OpenTelemetry. A post
+// span covers a request with its retries, and has a request span per attempt.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced operation
+type Span interface {
+	SetAttribute(key string, value interface{})
+	// End ends the span, err is the error of the operation if it failed
+	End(err error)
+}
+
+// nopTracer starts spans which are not recorded
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key string, value interface{}) {}
+func (nopSpan) End(err error)                              {}
+
+// WithTracer traces the posts of the client
+func WithTracer(tracer Tracer) Option {
+	return func(c *LogClient) {
+		if tracer != nil {
+			c.tracer = tracer
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package logclient
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Defaults of TransportConfig, connections are kept open between batches so posting at a high rate
+// does not open a connection and go through a TLS handshake per request
+const (
+	defaultKeepAlive           = time.Second * 30
+	defaultMaxIdleConns        = 16
+	defaultIdleConnTimeout     = time.Second * 90
+	defaultTLSHandshakeTimeout = time.Second * 10
+
+	// maxDrainedResponse is how much of a response is read to reuse its connection, the connection
+	// of a larger response is closed instead
+	maxDrainedResponse = 64 * 1024
+)
+
+// TransportConfig tunes the connections of the HTTP transport of a LogClient, zero values keep the
+// defaults
+type TransportConfig struct {
+	// KeepAlive is the interval of the TCP keep-alive probes of connections, 30s by default,
+	// negative disables them
+	KeepAlive time.Duration
+	// MaxIdleConns is how many idle connections are kept open for reuse, 16 by default. Keep it
+	// at least the number of batches posted concurrently.
+	MaxIdleConns int
+	// IdleConnTimeout closes connections idle for this long, 90s by default
+	IdleConnTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake of new connections, 10s by default
+	TLSHandshakeTimeout time.Duration
+	// DisableKeepAlives closes connections after each request
+	DisableKeepAlives bool
+}
+
+// NewTransport creates an HTTP transport configured by config, using the proxy of the environment
+// like http.DefaultTransport
+func NewTransport(config TransportConfig) *http.Transport {
+	if config.KeepAlive == 0 {
+		config.KeepAlive = defaultKeepAlive
+	}
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = defaultMaxIdleConns
+	}
+	if config.IdleConnTimeout <= 0 {
+		config.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if config.TLSHandshakeTimeout <= 0 {
+		config.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: time.Second * 30, KeepAlive: config.KeepAlive}
+
+	return &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DialContext:       dialer.DialContext,
+		ForceAttemptHTTP2: true,
+		MaxIdleConns:      config.MaxIdleConns,
+		// Requests of a client go to one host, all idle connections are for it
+		MaxIdleConnsPerHost:   config.MaxIdleConns,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableKeepAlives:     config.DisableKeepAlives,
+	}
+}
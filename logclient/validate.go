@@ -0,0 +1,63 @@
+package logclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ValidationError describes why a client is not able to post logs
+type ValidationError struct {
+	// Hint is an actionable description of the problem
+	Hint string
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Hint, e.Err)
+}
+
+// Unwrap returns the error of the validation request
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// validateTimeout bounds the request of Validate, shorter than the timeout of posts
+const validateTimeout = time.Second * 10
+
+// Validate posts an empty batch to check that the endpoint is reachable and the credentials
+// are accepted. It returns nil or a *ValidationError, the request is not retried and gives up
+// after 10s. It is neither throttled nor counted in the stats of the client.
+func (c *LogClient) Validate(ctx context.Context) error {
+	if c.dryRun != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, validateTimeout)
+	defer cancel()
+	err := c.request(ctx, c.logType, []byte("[]"), false)
+
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *RequestError:
+		return &ValidationError{Hint: fmt.Sprintf("Endpoint %s is not reachable, check the workspace ID, network connectivity and proxy settings", c.endpoint), Err: err}
+	case *CredentialError:
+		return &ValidationError{Hint: "Credentials could not be acquired, check the workspace key, the Key Vault secret or the Azure AD identity", Err: err}
+	case *IngestError:
+		switch {
+		case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+			return &ValidationError{Hint: "Credentials are rejected, check the workspace key, or the role assignments of the Azure AD identity, and the system clock", Err: err}
+		case e.StatusCode == http.StatusNotFound:
+			return &ValidationError{Hint: "Workspace, data collection rule or stream is not found, check the workspace ID or the rule ID and log type", Err: err}
+		case e.StatusCode == http.StatusBadRequest:
+			// Authentication succeeded, only the empty payload is rejected
+			return nil
+		default:
+			return &ValidationError{Hint: "Service returned an unexpected status, it may be unavailable", Err: err}
+		}
+	default:
+		return &ValidationError{Hint: "Client is misconfigured", Err: err}
+	}
+}
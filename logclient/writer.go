@@ -0,0 +1,95 @@
+package logclient
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+const (
+	// maxWriterLineSize bounds a line waiting for its end, longer lines are sent in parts
+	maxWriterLineSize = 1024 * 1024
+)
+
+// Writer is an io.Writer sending each line written as a message, e.g. to ship the logs of the
+// standard library with log.SetOutput. Lines are queued and posted in batches in the background,
+// call Close before exiting so the queued lines are not lost.
+type Writer struct {
+	batcher *Batcher
+	// record converts a line to the record queued
+	record func(line []byte) Record
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+// NewWriter creates a writer posting with client, batched according to DefaultBatchConfig
+func NewWriter(client *LogClient) *Writer {
+	return NewBatcherWriter(NewBatcher(client, DefaultBatchConfig))
+}
+
+// NewBatcherWriter creates a writer queueing lines in batcher, e.g. configured with a spool.
+// Closing the writer closes the batcher.
+func NewBatcherWriter(batcher *Batcher) *Writer {
+	return &Writer{batcher: batcher, record: func(line []byte) Record {
+		return Record{"message": string(line)}
+	}}
+}
+
+// Write queues the complete lines of p, without their line ending. Empty lines are skipped and
+// the end of p after its last line feed waits for the next write.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.enqueue(w.partial[:i])
+		w.partial = w.partial[i+1:]
+	}
+
+	if len(w.partial) >= maxWriterLineSize {
+		w.enqueue(w.partial)
+		w.partial = nil
+	}
+	if len(w.partial) == 0 {
+		// Releases the buffer of the lines queued
+		w.partial = nil
+	}
+
+	return len(p), nil
+}
+
+// enqueue queues a line, must be called holding mu
+func (w *Writer) enqueue(line []byte) {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if len(line) > 0 {
+		w.batcher.EnqueueRecord(w.record(line))
+	}
+}
+
+// Flush posts the lines queued so far
+func (w *Writer) Flush(ctx context.Context) error {
+	return w.batcher.Flush(ctx)
+}
+
+// Sync posts the lines queued so far, so a Writer is a zapcore.WriteSyncer
+func (w *Writer) Sync() error {
+	return w.Flush(context.Background())
+}
+
+// Close queues the last line even if it does not end with a line feed, then posts the lines
+// queued, giving up when ctx is done
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	w.enqueue(w.partial)
+	w.partial = nil
+	w.mu.Unlock()
+
+	return w.batcher.Close(ctx)
+}
@@ -0,0 +1,2 @@
+// Package zerologwriter sends the events of zerolog loggers to a batcher, built with the zerolog tag.
+package zerologwriter
@@ -0,0 +1,49 @@
+//go:build zerolog
+// +build zerolog
+
+package zerologwriter
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+	"github.com/yangl900/log2oms/logclient"
+)
+
+// Writer is a zerolog.LevelWriter queueing the events of its level and above in a batcher, with
+// their fields as columns. Events are posted in batches in the background, call Close before
+// exiting so the queued events are not lost.
+type Writer struct {
+	writer *logclient.Writer
+	level  zerolog.Level
+}
+
+// New creates a writer queueing in batcher the events of level and above, the events are expected
+// to use the default field names of zerolog
+func New(batcher *logclient.Batcher, level zerolog.Level) *Writer {
+	return &Writer{writer: logclient.NewJSONWriter(batcher, logclient.ZerologJSONKeys), level: level}
+}
+
+// Write queues an event whatever its level
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.writer.Write(p)
+}
+
+// WriteLevel queues an event unless it is below the level of the writer
+func (w *Writer) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if level < w.level && level != zerolog.NoLevel {
+		return len(p), nil
+	}
+
+	return w.writer.Write(p)
+}
+
+// Flush posts the events queued so far
+func (w *Writer) Flush(ctx context.Context) error {
+	return w.writer.Flush(ctx)
+}
+
+// Close posts the events queued, giving up when ctx is done
+func (w *Writer) Close(ctx context.Context) error {
+	return w.writer.Close(ctx)
+}
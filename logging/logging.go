@@ -0,0 +1,244 @@
+// Package logging writes the diagnostics of log2oms itself, as text or JSON lines, so its own
+// output can be collected and parsed like the logs it ships.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of an entry
+type Level int
+
+// Levels, entries below the level of a logger are discarded
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return strconv.Itoa(int(l))
+	}
+
+	return levelNames[l]
+}
+
+// ParseLevel parses debug, info, warn (or warning) or error
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+
+	return LevelInfo, fmt.Errorf("Invalid log level %s, expecting debug, info, warn or error", s)
+}
+
+// Format is how entries are written
+type Format int
+
+// Formats
+const (
+	// FormatText writes "[LOG2OMS][time][level] message key=value"
+	FormatText Format = iota
+	// FormatJSON writes an object per line with time, level, msg and the fields
+	FormatJSON
+)
+
+// ParseFormat parses text or json
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+
+	return FormatText, fmt.Errorf("Invalid log format %s, expecting text or json", s)
+}
+
+// sink is where the loggers derived from the same logger write
+type sink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  Level
+	format Format
+}
+
+// field is a key and value added to every entry of a logger
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger writes leveled entries with fields, it is safe for concurrent use. Loggers returned by
+// With share the writer, level and format of their parent.
+type Logger struct {
+	sink   *sink
+	fields []field
+}
+
+// New creates a logger writing entries of level and above to w
+func New(w io.Writer, level Level, format Format) *Logger {
+	return &Logger{sink: &sink{w: w, level: level, format: format}}
+}
+
+var std = New(os.Stdout, LevelInfo, FormatText)
+
+// Default returns the logger of the process, writing text to stdout at info level until configured
+func Default() *Logger {
+	return std
+}
+
+// Configure sets the level and format of l and of the loggers derived from it
+func (l *Logger) Configure(level Level, format Format) {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	l.sink.level, l.sink.format = level, format
+}
+
+// Enabled tells whether entries of level are written
+func (l *Logger) Enabled(level Level) bool {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	return level >= l.sink.level
+}
+
+// With returns a logger adding key and value to each entry, e.g. the pipeline an entry is about
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+
+	return &Logger{sink: l.sink, fields: append(fields, field{key: key, value: value})}
+}
+
+// Debugf writes an entry at debug level
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.log(LevelDebug, format, v)
+}
+
+// Infof writes an entry at info level
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.log(LevelInfo, format, v)
+}
+
+// Warnf writes an entry at warn level
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.log(LevelWarn, format, v)
+}
+
+// Errorf writes an entry at error level
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.log(LevelError, format, v)
+}
+
+// Printf writes an entry at info level, so l is a logclient.Logger
+func (l *Logger) Printf(format string, v ...interface{}) {
+	l.log(LevelInfo, format, v)
+}
+
+func (l *Logger) log(level Level, format string, v []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	now := time.Now().UTC()
+	message := strings.TrimSuffix(fmt.Sprintf(format, v...), "\n")
+
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	var buf bytes.Buffer
+	if l.sink.format == FormatJSON {
+		writeJSON(&buf, now, level, message, l.fields)
+	} else {
+		writeText(&buf, now, level, message, l.fields)
+	}
+	l.sink.w.Write(buf.Bytes())
+}
+
+// writeText writes an entry as text, values containing spaces or quotes are quoted
+func writeText(buf *bytes.Buffer, now time.Time, level Level, message string, fields []field) {
+	fmt.Fprintf(buf, "[LOG2OMS][%s][%s] %s", now.Format(time.RFC3339), strings.ToUpper(level.String()), message)
+	for _, f := range fields {
+		value := fmt.Sprint(f.value)
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(buf, " %s=%s", f.key, value)
+	}
+	buf.WriteString("\n")
+}
+
+// writeJSON writes an entry as a JSON object on a single line, fields can't replace time, level
+// and msg
+func writeJSON(buf *bytes.Buffer, now time.Time, level Level, message string, fields []field) {
+	buf.WriteString(`{"time":`)
+	writeJSONValue(buf, now.Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(buf, message)
+	for _, f := range fields {
+		if f.key == "time" || f.key == "level" || f.key == "msg" {
+			continue
+		}
+		buf.WriteString(",")
+		writeJSONValue(buf, f.key)
+		buf.WriteString(":")
+		writeJSONValue(buf, f.value)
+	}
+	buf.WriteString("}\n")
+}
+
+// writeJSONValue writes a value as JSON, errors and values failing to encode as their text
+func writeJSONValue(buf *bytes.Buffer, value interface{}) {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(encoded)
+}
+
+// Debugf writes an entry at debug level with the default logger
+func Debugf(format string, v ...interface{}) {
+	std.log(LevelDebug, format, v)
+}
+
+// Infof writes an entry at info level with the default logger
+func Infof(format string, v ...interface{}) {
+	std.log(LevelInfo, format, v)
+}
+
+// Warnf writes an entry at warn level with the default logger
+func Warnf(format string, v ...interface{}) {
+	std.log(LevelWarn, format, v)
+}
+
+// Errorf writes an entry at error level with the default logger
+func Errorf(format string, v ...interface{}) {
+	std.log(LevelError, format, v)
+}
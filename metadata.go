@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	templateparse "text/template/parse"
+)
+
+const (
+	// maxMetadataSources bounds the number of sources whose metadata values are remembered
+	maxMetadataSources = 10000
+)
+
+// metadata expands the templates of metadata values, e.g. {{hostname}}, {{env "REGION"}} or
+// {{filepath}}. Values not depending on the record are expanded once, the others once per source
+// of records.
+type metadata struct {
+	// static are the values expanded at startup
+	static map[string]string
+	// perRecord are the templates depending on the source of records
+	perRecord map[string]string
+
+	mu       sync.Mutex
+	bySource map[string]map[string]string
+}
+
+// newMetadata parses the metadata templates of values
+func newMetadata(values map[string]string) (*metadata, error) {
+	m := &metadata{static: map[string]string{}, perRecord: map[string]string{}, bySource: map[string]map[string]string{}}
+
+	for name, value := range values {
+		if !strings.Contains(value, "{{") {
+			m.static[name] = value
+			continue
+		}
+
+		expanded, perRecord, err := expandMetadata(value, "")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid metadata '%s': %v", name, err)
+		}
+
+		if perRecord {
+			m.perRecord[name] = value
+		} else {
+			m.static[name] = expanded
+		}
+	}
+
+	return m, nil
+}
+
+// record returns the values of the metadata depending on source, nil if there is none
+func (m *metadata) record(source string) map[string]string {
+	if len(m.perRecord) == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if values, ok := m.bySource[source]; ok {
+		return values
+	}
+	// Forgets the sources seen so far when they keep changing, e.g. short lived containers
+	if len(m.bySource) >= maxMetadataSources {
+		m.bySource = map[string]map[string]string{}
+	}
+
+	values := map[string]string{}
+	for name, value := range m.perRecord {
+		// Templates are checked by newMetadata
+		values[name], _, _ = expandMetadata(value, source)
+	}
+	m.bySource[source] = values
+
+	return values
+}
+
+// expandMetadata expands a metadata template for records of source, and tells whether the value
+// depends on the source, i.e. calls filepath or filename in any branch
+func expandMetadata(value, source string) (string, bool, error) {
+	funcs := template.FuncMap{
+		"hostname": func() string {
+			hostname, _ := os.Hostname()
+			return hostname
+		},
+		"env": os.Getenv,
+		"filepath": func() string {
+			return source
+		},
+		"filename": func() string {
+			if source == "" {
+				return ""
+			}
+			return filepath.Base(source)
+		},
+	}
+
+	tmpl, err := template.New("metadata").Funcs(funcs).Parse(value)
+	if err != nil {
+		return "", false, err
+	}
+
+	perRecord := false
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && usesSource(t.Tree.Root) {
+			perRecord = true
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return "", false, err
+	}
+
+	return buf.String(), perRecord, nil
+}
+
+// usesSource tells whether node calls filepath or filename
+func usesSource(node templateparse.Node) bool {
+	switch n := node.(type) {
+	case *templateparse.IdentifierNode:
+		return n.Ident == "filepath" || n.Ident == "filename"
+	case *templateparse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if usesSource(child) {
+				return true
+			}
+		}
+	case *templateparse.ActionNode:
+		return usesSource(n.Pipe)
+	case *templateparse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if usesSource(cmd) {
+				return true
+			}
+		}
+	case *templateparse.CommandNode:
+		for _, arg := range n.Args {
+			if usesSource(arg) {
+				return true
+			}
+		}
+	case *templateparse.IfNode:
+		return usesSource(&n.BranchNode)
+	case *templateparse.RangeNode:
+		return usesSource(&n.BranchNode)
+	case *templateparse.WithNode:
+		return usesSource(&n.BranchNode)
+	case *templateparse.BranchNode:
+		return usesSource(n.Pipe) || usesSource(n.List) || usesSource(n.ElseList)
+	case *templateparse.TemplateNode:
+		return usesSource(n.Pipe)
+	case *templateparse.ChainNode:
+		return usesSource(n.Node)
+	}
+
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	hostname, _ := os.Hostname()
+	os.Setenv("LOG2OMS_TEST_REGION", "westus")
+	defer os.Unsetenv("LOG2OMS_TEST_REGION")
+
+	tests := []struct {
+		name  string
+		value string
+		// static is the value expanded at startup, record the one for /var/log/app.log when the
+		// value depends on the source
+		static string
+		record string
+	}{
+		{name: "plain", value: "production", static: "production"},
+		{name: "hostname", value: "{{hostname}}", static: hostname},
+		{name: "env", value: `{{env "LOG2OMS_TEST_REGION"}}`, static: "westus"},
+		{name: "filepath", value: "{{filepath}}", record: "/var/log/app.log"},
+		{name: "filename in a pipeline", value: `{{filename | printf "%s!"}}`, record: "app.log!"},
+		{name: "branch not taken at startup", value: `{{if env "LOG2OMS_TEST_UNSET"}}{{filename}}{{else}}none{{end}}`, record: "none"},
+		{name: "condition", value: `{{if filepath}}file{{end}}`, record: "file"},
+		{name: "defined template", value: `{{define "name"}}{{filename}}{{end}}{{template "name"}}`, record: "app.log"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := newMetadata(map[string]string{"value": test.value})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if static, ok := m.static["value"]; ok != (test.record == "") || static != test.static {
+				t.Errorf("Expanded %q at startup, expecting %q", static, test.static)
+			}
+			if record := m.record("/var/log/app.log")["value"]; record != test.record {
+				t.Errorf("Expanded %q for the record, expecting %q", record, test.record)
+			}
+		})
+	}
+
+	if _, err := newMetadata(map[string]string{"value": "{{unknown}}"}); err == nil {
+		t.Error("Expecting an unknown function to be reported")
+	}
+}
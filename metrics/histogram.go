@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+)
+
+// HistogramValue accumulates observations in buckets, it is safe for concurrent use
+type HistogramValue struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a histogram with buckets of the given upper bounds, in increasing order.
+// Observations above the last bound are only counted by the implicit +Inf bucket.
+func NewHistogram(bounds ...float64) *HistogramValue {
+	return &HistogramValue{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+// ExponentialBounds returns count bounds starting at start, each factor times the previous one
+func ExponentialBounds(start, factor float64, count int) []float64 {
+	bounds := make([]float64, count)
+	for i := range bounds {
+		bounds[i] = start
+		start *= factor
+	}
+
+	return bounds
+}
+
+// Observe adds an observation
+func (h *HistogramValue) Observe(v float64) {
+	h.ObserveN(v, 1)
+}
+
+// ObserveN adds n observations of the same value, e.g. the latency of the records of a batch
+func (h *HistogramValue) ObserveN(v float64, n uint64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if i < len(h.counts) {
+		h.counts[i] += n
+	}
+	h.count += n
+	h.sum += v * float64(n)
+}
+
+// Sample returns the distribution observed so far with cumulative buckets
+func (h *HistogramValue) Sample(labels []Label) Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := Sample{Labels: labels, Buckets: make([]Bucket, len(h.bounds)), Count: h.count, Sum: h.sum}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		s.Buckets[i] = Bucket{UpperBound: bound, Count: cumulative}
+	}
+
+	return s
+}
@@ -0,0 +1,196 @@
+// Package metrics exposes metrics in the Prometheus text format, so log2oms can be monitored like
+// any other infrastructure component.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Type is the type of a metric
+type Type string
+
+// Metric types
+const (
+	Counter   Type = "counter"
+	Gauge     Type = "gauge"
+	Histogram Type = "histogram"
+)
+
+// Label is a dimension of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a value of a metric, or the distribution of a histogram
+type Sample struct {
+	Labels []Label
+	Value  float64
+	// Buckets, Count and Sum are the distribution of a histogram, Value is then ignored
+	Buckets []Bucket
+	Count   uint64
+	Sum     float64
+}
+
+// Bucket counts the observations of a histogram less than or equal to its upper bound
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// Metric is a named set of samples
+type Metric struct {
+	Name    string
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+// Collector returns the current value of metrics, it is called on each scrape
+type Collector func() []Metric
+
+// Registry serves the metrics of its collectors. Samples of metrics of the same name returned by
+// several collectors are merged.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a collector to the registry
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Gather collects the metrics of all collectors, sorted by name
+func (r *Registry) Gather() []Metric {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	byName := map[string]*Metric{}
+	var names []string
+	for _, collect := range collectors {
+		for _, m := range collect() {
+			if merged, ok := byName[m.Name]; ok {
+				merged.Samples = append(merged.Samples, m.Samples...)
+				continue
+			}
+
+			m := m
+			byName[m.Name] = &m
+			names = append(names, m.Name)
+		}
+	}
+	sort.Strings(names)
+
+	metrics := make([]Metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, *byName[name])
+	}
+
+	return metrics
+}
+
+// Write writes the metrics of all collectors in the Prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	for _, m := range r.Gather() {
+		fmt.Fprintf(buf, "# HELP %s %s\n", m.Name, escape(m.Help, false))
+		fmt.Fprintf(buf, "# TYPE %s %s\n", m.Name, m.Type)
+		for _, s := range m.Samples {
+			if m.Type == Histogram {
+				writeHistogram(buf, m.Name, s)
+				continue
+			}
+
+			writeSample(buf, m.Name, s.Labels, s.Value)
+		}
+	}
+
+	return buf.Flush()
+}
+
+// ServeHTTP serves the metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+func writeSample(w *bufio.Writer, name string, labels []Label, value float64) {
+	w.WriteString(name)
+	writeLabels(w, labels)
+	w.WriteByte(' ')
+	w.WriteString(formatValue(value))
+	w.WriteByte('\n')
+}
+
+// writeHistogram writes the cumulative buckets of a histogram, ending with +Inf, then its sum and
+// count
+func writeHistogram(w *bufio.Writer, name string, s Sample) {
+	for _, b := range s.Buckets {
+		labels := append(s.Labels[:len(s.Labels):len(s.Labels)], Label{Name: "le", Value: formatValue(b.UpperBound)})
+		writeSample(w, name+"_bucket", labels, float64(b.Count))
+	}
+	labels := append(s.Labels[:len(s.Labels):len(s.Labels)], Label{Name: "le", Value: "+Inf"})
+	writeSample(w, name+"_bucket", labels, float64(s.Count))
+	writeSample(w, name+"_sum", s.Labels, s.Sum)
+	writeSample(w, name+"_count", s.Labels, float64(s.Count))
+}
+
+func writeLabels(w *bufio.Writer, labels []Label) {
+	if len(labels) == 0 {
+		return
+	}
+
+	w.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(l.Name)
+		w.WriteString(`="`)
+		w.WriteString(escape(l.Value, true))
+		w.WriteByte('"')
+	}
+	w.WriteByte('}')
+}
+
+// escape escapes backslashes and line feeds, and double quotes of label values
+func escape(s string, quotes bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quotes {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+
+	return s
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
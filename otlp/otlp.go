@@ -0,0 +1,280 @@
+// Package otlp exports the spans of log clients to an OpenTelemetry collector with OTLP over HTTP,
+// in its JSON encoding, so shipping latency can be correlated with application traces.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yangl900/log2oms/logclient"
+	"github.com/yangl900/log2oms/logging"
+)
+
+const (
+	// defaultExportInterval is how often finished spans are exported
+	defaultExportInterval = time.Second * 5
+	// maxQueuedSpans bounds the finished spans waiting to be exported, more are dropped
+	maxQueuedSpans = 2048
+
+	spanKindClient  = 3
+	statusCodeOK    = 1
+	statusCodeError = 2
+)
+
+// spanContext identifies a span within a trace
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+type contextKey struct{}
+
+// ContextWithTraceParent returns a context whose spans are children of the span of a W3C
+// traceparent header, e.g. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", so the
+// posts of an application are part of its traces. ctx is returned unchanged when traceparent is
+// not valid.
+func ContextWithTraceParent(ctx context.Context, traceparent string) context.Context {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+
+	var sc spanContext
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// Exporter is a logclient.Tracer exporting spans periodically to a collector
+type Exporter struct {
+	url         string
+	serviceName string
+	headers     map[string]string
+	httpClient  *http.Client
+
+	mu    sync.Mutex
+	spans []*span
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// NewExporter creates an exporter posting to the OTLP/HTTP endpoint of a collector, e.g.
+// "http://localhost:4318", with headers added to each request. Close it to export the last spans.
+func NewExporter(endpoint, serviceName string, headers map[string]string) *Exporter {
+	e := &Exporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		headers:     headers,
+		httpClient:  &http.Client{Timeout: time.Second * 10},
+		done:        make(chan struct{}),
+	}
+
+	e.wg.Add(1)
+	go e.run()
+
+	return e
+}
+
+// Start starts a span, child of the span of ctx if any
+func (e *Exporter) Start(ctx context.Context, name string) (context.Context, logclient.Span) {
+	s := &span{exporter: e, name: name, start: time.Now()}
+	if parent, ok := ctx.Value(contextKey{}).(spanContext); ok {
+		s.context.traceID, s.parent = parent.traceID, parent.spanID[:]
+	} else {
+		rand.Read(s.context.traceID[:])
+	}
+	rand.Read(s.context.spanID[:])
+
+	return context.WithValue(ctx, contextKey{}, s.context), s
+}
+
+// finish queues a finished span for export
+func (e *Exporter) finish(s *span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.spans) < maxQueuedSpans {
+		e.spans = append(e.spans, s)
+	}
+}
+
+func (e *Exporter) run() {
+	defer e.wg.Done()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-time.After(defaultExportInterval):
+			if err := e.Flush(context.Background()); err != nil {
+				logging.Warnf("%v", err)
+			}
+		}
+	}
+}
+
+// Flush exports the finished spans
+func (e *Exporter) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Invalid OTLP endpoint %s: %v", e.url, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	response, err := e.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to export %d spans: %v", len(spans), err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		buf, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("Failed to export %d spans: %d %s", len(spans), response.StatusCode, buf)
+	}
+
+	return nil
+}
+
+// Close stops exporting periodically and exports the last spans
+func (e *Exporter) Close(ctx context.Context) error {
+	e.once.Do(func() {
+		close(e.done)
+	})
+	e.wg.Wait()
+
+	return e.Flush(ctx)
+}
+
+// request builds the body of an export request
+func (e *Exporter) request(spans []*span) interface{} {
+	encoded := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, s.encode())
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []interface{}{attribute("service.name", e.serviceName)},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "github.com/yangl900/log2oms"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+// span is a logclient.Span recorded by an Exporter
+type span struct {
+	exporter *Exporter
+	context  spanContext
+	parent   []byte
+	name     string
+	start    time.Time
+
+	mu         sync.Mutex
+	attributes []interface{}
+	end        time.Time
+	err        error
+}
+
+// SetAttribute sets an attribute of the span
+func (s *span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attributes = append(s.attributes, attribute(key, value))
+}
+
+// End ends the span and queues it for export
+func (s *span) End(err error) {
+	s.mu.Lock()
+	s.end, s.err = time.Now(), err
+	s.mu.Unlock()
+
+	s.exporter.finish(s)
+}
+
+// encode encodes the span in the OTLP JSON format
+func (s *span) encode() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := map[string]interface{}{"code": statusCodeOK}
+	if s.err != nil {
+		status = map[string]interface{}{"code": statusCodeError, "message": s.err.Error()}
+	}
+
+	encoded := map[string]interface{}{
+		"traceId":           hex.EncodeToString(s.context.traceID[:]),
+		"spanId":            hex.EncodeToString(s.context.spanID[:]),
+		"name":              s.name,
+		"kind":              spanKindClient,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        s.attributes,
+		"status":            status,
+	}
+	if s.parent != nil {
+		encoded["parentSpanId"] = hex.EncodeToString(s.parent)
+	}
+
+	return encoded
+}
+
+// attribute encodes a key value pair in the OTLP JSON format
+func attribute(key string, value interface{}) map[string]interface{} {
+	var encoded map[string]interface{}
+	switch v := value.(type) {
+	case string:
+		encoded = map[string]interface{}{"stringValue": v}
+	case bool:
+		encoded = map[string]interface{}{"boolValue": v}
+	case int:
+		encoded = map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		encoded = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		encoded = map[string]interface{}{"doubleValue": v}
+	default:
+		encoded = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+
+	return map[string]interface{}{"key": key, "value": encoded}
+}
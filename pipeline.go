@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yangl900/log2oms/input"
+	"github.com/yangl900/log2oms/logclient"
+	"github.com/yangl900/log2oms/logging"
+	"github.com/yangl900/log2oms/metrics"
+	"github.com/yangl900/log2oms/processor"
+	"github.com/yangl900/log2oms/tail"
+)
+
+// maxRunBatch is how many events a pipeline enqueues at once
+const maxRunBatch = 256
+
+// pipeline uploads the events of its input to one or more workspaces
+type pipeline struct {
+	config  *pipelineConfig
+	outputs []*pipelineOutput
+	// metadata adds the metadata values depending on the source of events
+	metadata *metadata
+	in       input.Input
+	// source is the input before processing, which files are reopened through
+	source input.Input
+	// done is closed once the events of in are all enqueued
+	done chan struct{}
+	// counters are kept when the inputs of the pipeline are replaced
+	counters *pipelineCounters
+}
+
+// pipelineCounters count the events of a pipeline
+type pipelineCounters struct {
+	// read counts the events read by the inputs, before they are processed
+	read int64
+	// enqueued counts the events enqueued in the outputs
+	enqueued int64
+}
+
+// pipelineOutput uploads the events of a pipeline to a workspace
+type pipelineOutput struct {
+	settings outputSettings
+	client   *logclient.LogClient
+	batcher  *logclient.Batcher
+	// opened is when the output was created
+	opened time.Time
+	// latency is the distribution of the seconds records take from being enqueued to being
+	// posted, and batchSize of the records per batch posted
+	latency   *metrics.HistogramValue
+	batchSize *metrics.HistogramValue
+}
+
+// outputSettings are the settings of the client and batcher of an output, a pipeline keeps its
+// batchers across configuration reloads unless they change
+type outputSettings struct {
+	name          string
+	output        outputConfig
+	metadata      map[string]string
+	retry         retryConfig
+	batch         batchConfig
+	spoolDir      string
+	deadLetterDir string
+}
+
+// newPipeline creates the pipeline configured by p, shared settings are taken from c. The spool
+// and dead letter directories get a subdirectory per pipeline and output with a configuration
+// file.
+func newPipeline(c *config, p *pipelineConfig, tailConfig tail.Config) (*pipeline, error) {
+	proc, err := newProcessing(p.Processors, p.Routes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid processors of pipeline '%s': %v", p.Name, err)
+	}
+
+	pl := &pipeline{config: p}
+	if err := pl.open(c, newOutputSettings(c, p)); err != nil {
+		return nil, err
+	}
+
+	if err := pl.start(proc, tailConfig); err != nil {
+		pl.closeOutputs(context.Background())
+		return nil, err
+	}
+
+	return pl, nil
+}
+
+// newOutputSettings returns the settings of the outputs of pipeline p
+func newOutputSettings(c *config, p *pipelineConfig) []outputSettings {
+	var settings []outputSettings
+	for _, target := range p.targets {
+		settings = append(settings, outputSettings{
+			name:          target.name,
+			output:        target.output,
+			metadata:      c.Metadata,
+			retry:         c.Retry,
+			batch:         c.Batch,
+			spoolDir:      c.pipelineDir(c.Batch.SpoolDir, p, target.name),
+			deadLetterDir: c.pipelineDir(c.Batch.DeadLetterDir, p, target.name),
+		})
+	}
+
+	return settings
+}
+
+// open creates the outputs of the pipeline
+func (pl *pipeline) open(c *config, settings []outputSettings) error {
+	meta, err := newMetadata(c.Metadata)
+	if err != nil {
+		return err
+	}
+	pl.metadata = meta
+
+	for _, s := range settings {
+		output, err := openOutput(c, s, logging.Default().With("pipeline", pl.config.Name).With("output", s.name))
+		if err != nil {
+			pl.closeOutputs(context.Background())
+			return err
+		}
+		pl.outputs = append(pl.outputs, output)
+	}
+
+	return nil
+}
+
+// openOutput creates the client and batcher of an output, the client logs with logger
+func openOutput(c *config, settings outputSettings, logger *logging.Logger) (*pipelineOutput, error) {
+	meta, err := newMetadata(settings.metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(c, &settings.output, meta.static, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked in the background, so starting and reloading don't wait for the endpoint
+	go func() {
+		if err := client.Validate(context.Background()); err != nil {
+			logger.Warnf("Validation failed, logs may not be delivered. %v", err)
+		}
+	}()
+
+	batchConfig, err := newBatchConfig(c, settings.spoolDir, settings.deadLetterDir)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &pipelineOutput{
+		settings:  settings,
+		client:    client,
+		opened:    time.Now(),
+		latency:   metrics.NewHistogram(latencyBounds...),
+		batchSize: metrics.NewHistogram(batchSizeBounds...),
+	}
+	batchConfig.Delivered = func(records int, enqueued time.Time) {
+		output.batchSize.Observe(float64(records))
+		if !enqueued.IsZero() {
+			output.latency.ObserveN(time.Since(enqueued).Seconds(), uint64(records))
+		}
+	}
+	output.batcher = logclient.NewBatcher(client, batchConfig)
+
+	return output, nil
+}
+
+// start creates the input of the pipeline and starts uploading its events
+func (pl *pipeline) start(proc *processing, tailConfig tail.Config) error {
+	in, err := newInput(pl.config.Inputs, tailConfig)
+	if err != nil {
+		return err
+	}
+
+	if pl.counters == nil {
+		pl.counters = &pipelineCounters{}
+	}
+	counted := processor.Apply(in, processor.Func(func(e *input.Event) bool {
+		atomic.AddInt64(&pl.counters.read, 1)
+		return true
+	}))
+
+	pl.source, pl.in, pl.done = in, proc.apply(counted), make(chan struct{})
+	go pl.run()
+
+	return nil
+}
+
+// settings returns the settings of the outputs of the pipeline
+func (pl *pipeline) settings() []outputSettings {
+	var settings []outputSettings
+	for _, output := range pl.outputs {
+		settings = append(settings, output.settings)
+	}
+
+	return settings
+}
+
+// newClient creates the client uploading to output, adding metadata to each record and logging
+// with logger
+func newClient(c *config, output *outputConfig, metadata map[string]string, logger logclient.Logger) (*logclient.LogClient, error) {
+	opts := []logclient.Option{
+		logclient.WithLogger(logger),
+		logclient.WithCompression(output.Compress),
+		logclient.WithAzureResourceID(output.ResourceID),
+		logclient.WithCircuitBreaker(circuitBreakerThreshold, circuitBreakerCooldown),
+		logclient.WithRateLimit(float64(output.RateLimitRecords), float64(output.RateLimitBytes)),
+		logclient.WithDebug(debug),
+		logclient.WithEndpoint(output.endpoint),
+	}
+
+	if tracer != nil {
+		opts = append(opts, logclient.WithTracer(tracer))
+	}
+	if dryRun {
+		opts = append(opts, logclient.WithDryRun(os.Stdout))
+	}
+
+	policy := logclient.DefaultRetryPolicy
+	if c.Retry.MaxAttempts != 0 {
+		policy.MaxAttempts = c.Retry.MaxAttempts
+	}
+	if c.Retry.BaseDelay > 0 {
+		policy.BaseDelay = c.Retry.BaseDelay
+	}
+	if c.Retry.MaxDelay > 0 {
+		policy.MaxDelay = c.Retry.MaxDelay
+	}
+	if c.Retry.MaxElapsed > 0 {
+		policy.MaxElapsed = c.Retry.MaxElapsed
+	}
+	if c.Retry.Multiplier > 0 {
+		policy.Multiplier = c.Retry.Multiplier
+	}
+	if c.Retry.Jitter != nil {
+		policy.Jitter = *c.Retry.Jitter
+	}
+	opts = append(opts, logclient.WithRetryPolicy(policy))
+	opts = append(opts, logclient.WithTransportConfig(logclient.TransportConfig{
+		KeepAlive:           output.KeepAlive,
+		MaxIdleConns:        output.MaxIdleConns,
+		IdleConnTimeout:     output.IdleConnTimeout,
+		TLSHandshakeTimeout: output.TLSHandshakeTimeout,
+	}))
+
+	switch strings.ToLower(output.OversizePolicy) {
+	case "", "truncate":
+		opts = append(opts, logclient.WithOversizePolicy(logclient.OversizeTruncate, int(output.MaxFieldSize)))
+	case "split":
+		opts = append(opts, logclient.WithOversizePolicy(logclient.OversizeSplit, int(output.MaxFieldSize)))
+	case "drop":
+		opts = append(opts, logclient.WithOversizePolicy(logclient.OversizeDrop, int(output.MaxFieldSize)))
+	default:
+		return nil, fmt.Errorf("Invalid oversize policy %s, expecting truncate, split or drop", output.OversizePolicy)
+	}
+
+	if output.Auth == authAAD {
+		opts = append(opts, logclient.WithTokenCredential(aadCredential(output)))
+	}
+	if output.WorkspaceSecretFile != "" {
+		opts = append(opts, logclient.WithCredentialProvider(logclient.NewFileKeyProvider(output.WorkspaceSecretFile)))
+	}
+	if output.KeyVaultURL != "" && output.KeyVaultSecretName != "" {
+		secret := logclient.NewKeyVaultSecret(output.KeyVaultURL, output.KeyVaultSecretName, logclient.NewDefaultCredential(), 0)
+		opts = append(opts, logclient.WithCredentialProvider(secret))
+	}
+	if output.DCEEndpoint != "" && output.DCRID != "" {
+		opts = append(opts, logclient.WithIngestionAPI(output.DCEEndpoint, output.DCRID))
+	}
+
+	client, err := logclient.NewCheckedLogClient(output.WorkspaceID, output.WorkspaceSecret, output.LogType, metadata, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// aadCredential is the credential of Azure AD authentication: the service principal of
+// AZURE_TENANT_ID and AZURE_CLIENT_ID when its client secret is in Key Vault, which is read with
+// the system assigned managed identity, otherwise the default credential
+func aadCredential(output *outputConfig) logclient.TokenCredential {
+	if output.KeyVaultClientSecretName == "" {
+		return logclient.NewDefaultCredential()
+	}
+
+	secret := logclient.NewKeyVaultSecret(output.KeyVaultURL, output.KeyVaultClientSecretName, logclient.NewManagedIdentityCredential(""), 0)
+	return logclient.NewClientSecretProviderCredential(os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), secret)
+}
+
+// newBatchConfig creates the batching of a pipeline spooling to spoolDir
+func newBatchConfig(c *config, spoolDir, deadLetterDir string) (logclient.BatchConfig, error) {
+	batchConfig := logclient.BatchConfig{
+		MaxRecords:  c.Batch.MaxRecords,
+		MaxBytes:    int(c.Batch.MaxBytes),
+		Interval:    c.Batch.FlushInterval,
+		Adaptive:    c.Batch.Adaptive,
+		MinInterval: c.Batch.MinFlushInterval,
+		// Keeps up to 80MB of logs with the default size while log analytics is unreachable
+		MaxRetryBatches: 10,
+		QueueSize:       c.Batch.QueueSize,
+		Workers:         c.Batch.Workers,
+	}
+
+	switch policy := strings.ToLower(c.Batch.QueuePolicy); policy {
+	case "", "block":
+		batchConfig.QueuePolicy = logclient.QueueBlock
+	case "drop-oldest":
+		batchConfig.QueuePolicy = logclient.QueueDropOldest
+	case "drop-newest":
+		batchConfig.QueuePolicy = logclient.QueueDropNewest
+	default:
+		return batchConfig, fmt.Errorf("Invalid queue policy %s, expecting block, drop-oldest or drop-newest", policy)
+	}
+
+	var err error
+	if spoolDir != "" {
+		if batchConfig.Spool, err = logclient.NewSpool(spoolDir, int64(c.Batch.SpoolMaxSize)); err != nil {
+			return batchConfig, err
+		}
+
+		batches, records, _ := batchConfig.Spool.State()
+		logging.Infof("Spooling logs under: %s, %d records in %d batches recovered", spoolDir, records, batches)
+	}
+
+	if deadLetterDir != "" {
+		if batchConfig.DeadLetter, err = logclient.NewDeadLetter(deadLetterDir); err != nil {
+			return batchConfig, err
+		}
+	}
+
+	return batchConfig, nil
+}
+
+// pipelineDir returns the subdirectory of dir for an output of pipeline p with a configuration
+// file, so pipelines and outputs added by a reload don't share it
+func (c *config) pipelineDir(dir string, p *pipelineConfig, output string) string {
+	if dir == "" || !c.fromFile {
+		return dir
+	}
+
+	return filepath.Join(dir, p.Name, output)
+}
+
+// run uploads the events of the pipeline until its input is exhausted or stopped. Events already
+// waiting are taken along with the first one, up to maxRunBatch, and enqueued together.
+func (p *pipeline) run() {
+	defer close(p.done)
+
+	events := p.in.Events()
+	batch := make([]*input.Event, 0, maxRunBatch)
+	records := make([]logclient.Record, 0, maxRunBatch)
+	acks := make([]func(), 0, maxRunBatch)
+	for e := range events {
+		batch = append(batch[:0], e)
+		for waiting := true; waiting && len(batch) < maxRunBatch; {
+			select {
+			case e, ok := <-events:
+				if waiting = ok; ok {
+					batch = append(batch, e)
+				}
+			default:
+				waiting = false
+			}
+		}
+
+		records, acks = p.enqueue(batch, records[:0], acks[:0])
+	}
+}
+
+// enqueue converts a batch of events to records and enqueues them in the outputs, records and
+// acks are appended to and returned to be reused. Events whose log type is set, by routes or the
+// log type field, are sent as that log type by the outputs. With several outputs an event is
+// acknowledged once all of them acknowledged it.
+func (p *pipeline) enqueue(batch []*input.Event, records []logclient.Record, acks []func()) ([]logclient.Record, []func()) {
+	verbose := logging.Default().Enabled(logging.LevelDebug)
+	// Consecutive events mostly come from the same source, its metadata is looked up once
+	var source string
+	var values map[string]string
+	for i, e := range batch {
+		if e.Err != nil {
+			logging.Errorf("%v", e.Err)
+			if e.Ack != nil {
+				e.Ack()
+			}
+			continue
+		}
+
+		if verbose {
+			logging.Default().With("source", e.Source).With("timestamp", e.Time.UTC().Format(time.RFC3339)).Debugf("%s", e.Text)
+		}
+
+		logType := p.logType(e)
+		record, ack := e.Record(), e.Ack
+		if i == 0 || e.Source != source {
+			source, values = e.Source, p.metadata.record(e.Source)
+		}
+		for name, value := range values {
+			if _, ok := record[name]; !ok {
+				record[name] = value
+			}
+		}
+		if logType != "" {
+			record[logclient.LogTypeField] = logType
+		}
+
+		if ack != nil && len(p.outputs) > 1 {
+			ack = ackAll(ack, len(p.outputs))
+		}
+		records, acks = append(records, record), append(acks, ack)
+	}
+
+	// Records are shared by the outputs, batchers don't modify them
+	for _, output := range p.outputs {
+		output.batcher.EnqueueRecordsWithAcks(records, acks)
+	}
+	atomic.AddInt64(&p.counters.enqueued, int64(len(records)))
+
+	return records, acks
+}
+
+// logType returns the log type of e, set by routes or taken from the log type field of the
+// pipeline, empty to use the one of the outputs
+func (p *pipeline) logType(e *input.Event) string {
+	if e.LogType != "" || p.config.LogTypeField == "" {
+		return e.LogType
+	}
+
+	logType, _ := e.Fields[p.config.LogTypeField].(string)
+	return logType
+}
+
+// ackAll returns an acknowledgement calling ack once it is called n times
+func ackAll(ack func(), n int) func() {
+	remaining := int32(n)
+	return func() {
+		if atomic.AddInt32(&remaining, -1) == 0 {
+			ack()
+		}
+	}
+}
+
+// flush uploads or spools the events enqueued in the outputs of the pipeline
+func (p *pipeline) flush(ctx context.Context) {
+	for _, output := range p.outputs {
+		if err := output.batcher.Flush(ctx); err != nil {
+			logging.Warnf("%v", err)
+		}
+	}
+}
+
+// stop stops the input of the pipeline and waits until its events are all enqueued
+func (p *pipeline) stop() {
+	p.in.Stop()
+	p.wait()
+}
+
+// wait waits until the events of the pipeline are all enqueued once its input is stopped, a
+// pipeline not started has none
+func (p *pipeline) wait() {
+	if p.done != nil {
+		<-p.done
+	}
+}
+
+// close stops the pipeline and uploads the events still waiting, giving up when ctx is done. It
+// fails when records of the outputs were not posted.
+func (p *pipeline) close(ctx context.Context) error {
+	p.stop()
+	return p.closeOutputs(ctx)
+}
+
+// closeOutputs uploads the events still waiting in the outputs, giving up when ctx is done. It
+// fails when records were dropped, dead lettered included, or are left queued or spooled.
+func (p *pipeline) closeOutputs(ctx context.Context) error {
+	var failed []string
+	for _, output := range p.outputs {
+		if err := output.batcher.Close(ctx); err != nil {
+			logging.Warnf("%v", err)
+		}
+
+		stats := output.batcher.Stats()
+		if undelivered := stats.Dropped + stats.Retrying + stats.Queued; undelivered > 0 {
+			failed = append(failed, fmt.Sprintf("%d records of output '%s'", undelivered, output.settings.name))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Pipeline '%s' did not post %s", p.config.Name, strings.Join(failed, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yangl900/log2oms/logging"
+	"github.com/yangl900/log2oms/tail"
+)
+
+// benchRegex parses the lines of benchText
+const benchRegex = `^(?P<time>\S+) (?P<level>\w+) (?P<method>\w+) (?P<path>\S+) status=(?P<status>\d+) duration=(?P<duration>\d+)ms`
+
+var benchPaths = []string{"/api/orders", "/api/items/42", "/healthz", "/api/users/me", "/static/app.js"}
+
+func benchText(buf []byte, i int) []byte {
+	buf = append(buf, "2018-06-01T12:00:00Z INFO GET "...)
+	buf = append(buf, benchPaths[i%len(benchPaths)]...)
+	buf = append(buf, fmt.Sprintf(" status=%d duration=%dms request=%08x user=user%d@example.com agent=\"Mozilla/5.0 (X11; Linux x86_64)\"", 200+i%3*100, i%250, i, i%1000)...)
+	return buf
+}
+
+func benchJSON(buf []byte, i int) []byte {
+	buf = append(buf, `{"time":"2018-06-01T12:00:00Z","level":"info","method":"GET","path":"`...)
+	buf = append(buf, benchPaths[i%len(benchPaths)]...)
+	buf = append(buf, fmt.Sprintf(`","status":%d,"duration_ms":%d,"request":"%08x","user":"user%d@example.com","agent":"Mozilla/5.0 (X11; Linux x86_64)"}`, 200+i%3*100, i%250, i, i%1000)...)
+	return buf
+}
+
+// BenchmarkPipeline uploads a file of b.N generated lines with a pipeline: the lines are read by
+// the file input, processed, batched, encoded and posted to a local endpoint discarding them
+func BenchmarkPipeline(b *testing.B) {
+	scenarios := []struct {
+		name       string
+		line       func(buf []byte, i int) []byte
+		processors processorsConfig
+		compress   bool
+	}{
+		{"text", benchText, processorsConfig{}, false},
+		{"json", benchJSON, processorsConfig{JSON: true}, false},
+		{"json-gzip", benchJSON, processorsConfig{JSON: true}, true},
+		{"regex", benchText, processorsConfig{Regex: &patternConfig{Expr: benchRegex}}, false},
+	}
+
+	// Posts are logged at info, which would be most of the work measured
+	logging.Default().Configure(logging.LevelWarn, logging.FormatText)
+	defer logging.Default().Configure(logging.LevelInfo, logging.FormatText)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+	}))
+	defer server.Close()
+
+	for _, s := range scenarios {
+		b.Run(s.name, func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "log2oms-bench")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, s.name+".log")
+			f, err := os.Create(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			w := bufio.NewWriter(f)
+			var line []byte
+			size := int64(0)
+			for i := 0; i < b.N; i++ {
+				line = append(s.line(line[:0], i), '\n')
+				w.Write(line)
+				size += int64(len(line))
+			}
+			if err := w.Flush(); err != nil {
+				b.Fatal(err)
+			}
+			f.Close()
+
+			c := &config{
+				Pipelines: []*pipelineConfig{{
+					Name:       s.name,
+					Inputs:     inputsConfig{Files: []string{path}, once: true},
+					Processors: s.processors,
+					Output:     &outputConfig{WorkspaceID: "bench", WorkspaceSecret: "YmVuY2g=", Compress: s.compress, endpoint: server.URL},
+				}},
+			}
+			if err := c.validate(); err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(size / int64(b.N))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			pl, err := newPipeline(c, c.Pipelines[0], tail.Config{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			<-pl.done
+			pl.closeOutputs(context.Background())
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+const (
+	// accessLogTimeLayout is the time of access logs, e.g. 10/Oct/2000:13:55:36 -0700
+	accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+	// accessLogCommon are the fields of the Common Log Format, which the other formats start with
+	accessLogCommon = `(?P<ClientIP>\S+) (?P<Ident>\S+) (?P<RemoteUser>\S+) \[(?P<time>[^\]]+)\] ` +
+		`"(?P<request>(?:[^"\\]|\\.)*)" (?P<Status>\d{3}) (?P<BytesSent>\d+|-)`
+)
+
+// AccessLogFormats are the regular expressions of the access log formats of web servers, by name.
+// Content following the format, e.g. a response time added at the end, is ignored.
+var AccessLogFormats = map[string]string{
+	// common is the Common Log Format of Apache and nginx
+	"common": "^" + accessLogCommon,
+	// combined adds the referer and the user agent, it is the combined format of Apache and nginx
+	"combined": "^" + accessLogCommon + " " + accessLogQuoted("Referer") + " " + accessLogQuoted("UserAgent"),
+	// nginx is the main format of the default configuration of nginx, combined with X-Forwarded-For
+	"nginx": "^" + accessLogCommon + " " + accessLogQuoted("Referer") + " " + accessLogQuoted("UserAgent") + " " + accessLogQuoted("ForwardedFor"),
+	// vhost_combined is the combined format prefixed with the virtual host and port, as Apache names it
+	"vhost_combined": `^(?P<VirtualHost>[^\s:]+):(?P<Port>\d+) ` + accessLogCommon + " " + accessLogQuoted("Referer") + " " + accessLogQuoted("UserAgent"),
+}
+
+// accessLogQuoted is a quoted value captured as name, quotes inside are escaped as \" by Apache
+func accessLogQuoted(name string) string {
+	return `"(?P<` + name + `>(?:[^"\\]|\\.)*)"`
+}
+
+// AccessLog parses the lines of web server access logs, e.g.
+// `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326 "-" "curl/7.1"`,
+// into the fields ClientIP, Ident, RemoteUser, Method, Path, Protocol, Status, BytesSent, Referer
+// and UserAgent, with ForwardedFor, VirtualHost and Port in the formats logging them. Values
+// logged as "-" are skipped, a request line which is not a method, path and protocol is kept as
+// Request. The time of the event is the time of the request. Lines not matching the format are
+// left unchanged.
+type AccessLog struct {
+	re *regexp.Regexp
+}
+
+// NewAccessLog creates a processor parsing the access log format of AccessLogFormats named format
+func NewAccessLog(format string) (*AccessLog, error) {
+	expr, ok := AccessLogFormats[strings.ToLower(format)]
+	if !ok {
+		var names []string
+		for name := range AccessLogFormats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return nil, fmt.Errorf("Unknown access log format %s, expecting one of %s", format, strings.Join(names, ", "))
+	}
+
+	return &AccessLog{re: regexp.MustCompile(expr)}, nil
+}
+
+// Process extracts fields from the text of e
+func (p *AccessLog) Process(e *input.Event) bool {
+	match := p.re.FindStringSubmatchIndex(e.Text)
+	if match == nil {
+		return true
+	}
+
+	for i, name := range p.re.SubexpNames() {
+		if name == "" || match[2*i] < 0 {
+			continue
+		}
+
+		value := e.Text[match[2*i]:match[2*i+1]]
+		switch name {
+		case "time":
+			if t, err := time.Parse(accessLogTimeLayout, value); err == nil {
+				e.Time = t
+			}
+		case "request":
+			setRequest(e, value)
+		case "Status", "Port":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				setField(e, name, n)
+			}
+		case "BytesSent":
+			// The Common Log Format logs no bytes sent as -
+			n, _ := strconv.ParseInt(value, 10, 64)
+			setField(e, name, n)
+		default:
+			if value != "-" {
+				setField(e, name, value)
+			}
+		}
+	}
+
+	return true
+}
+
+// setRequest sets the method, path and protocol of a request line, e.g. GET /a.gif HTTP/1.0,
+// HTTP/0.9 requests having no protocol
+func setRequest(e *input.Event, request string) {
+	parts := strings.Split(request, " ")
+	switch {
+	case request == "-" || request == "":
+	case len(parts) == 3 || (len(parts) == 2 && !strings.HasPrefix(parts[1], "HTTP/")):
+		setField(e, "Method", parts[0])
+		setField(e, "Path", parts[1])
+		if len(parts) == 3 {
+			setField(e, "Protocol", parts[2])
+		}
+	default:
+		setField(e, "Request", request)
+	}
+}
@@ -0,0 +1,30 @@
+package processor
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// ansiSequence matches CSI sequences such as colors and cursor moves, OSC sequences such as
+// window titles and hyperlinks, other escape sequences and the remaining control characters
+var ansiSequence = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[ -/]*[0-~]|[\x00-\x08\x0b-\x1f\x7f]`)
+
+// StripANSI removes ANSI color and control sequences from the text of events, as written by
+// command line tools to a terminal
+type StripANSI struct{}
+
+// Process removes the sequences from the text of e
+func (StripANSI) Process(e *input.Event) bool {
+	if strings.IndexFunc(e.Text, isControl) >= 0 {
+		e.Text = ansiSequence.ReplaceAllString(e.Text, "")
+	}
+
+	return true
+}
+
+// isControl tells whether r is a control character other than tab
+func isControl(r rune) bool {
+	return (r < 0x20 && r != '\t') || r == 0x7f
+}
@@ -0,0 +1,132 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/yangl900/log2oms/input"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// charsets are the supported encodings by lower cased name
+var charsets = map[string]encoding.Encoding{
+	"latin1":       charmap.ISO8859_1,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"iso-8859-2":   charmap.ISO8859_2,
+	"iso-8859-5":   charmap.ISO8859_5,
+	"iso-8859-7":   charmap.ISO8859_7,
+	"iso-8859-9":   charmap.ISO8859_9,
+	"iso-8859-15":  charmap.ISO8859_15,
+	"windows-1250": charmap.Windows1250,
+	"windows-1251": charmap.Windows1251,
+	"windows-1252": charmap.Windows1252,
+	"windows-1253": charmap.Windows1253,
+	"windows-1254": charmap.Windows1254,
+	"windows-1256": charmap.Windows1256,
+	"koi8-r":       charmap.KOI8R,
+	"shift_jis":    japanese.ShiftJIS,
+	"shift-jis":    japanese.ShiftJIS,
+	"sjis":         japanese.ShiftJIS,
+	"euc-jp":       japanese.EUCJP,
+	"iso-2022-jp":  japanese.ISO2022JP,
+	"euc-kr":       korean.EUCKR,
+	"gbk":          simplifiedchinese.GBK,
+	"gb18030":      simplifiedchinese.GB18030,
+	"big5":         traditionalchinese.Big5,
+	"utf-16":       unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+}
+
+var (
+	bomLE = "\xff\xfe"
+	bomBE = "\xfe\xff"
+)
+
+// Charset decodes the text of events from another encoding into UTF-8. Lines are split on the
+// newline byte before decoding, UTF-16 lines are realigned accordingly. With "utf-16" the byte
+// order is taken from the byte order mark starting each source, little endian by default.
+type Charset struct {
+	name string
+	enc  encoding.Encoding
+
+	mu        sync.Mutex
+	bigEndian map[string]bool
+}
+
+// NewCharset creates a processor decoding from the named encoding, e.g. latin1, windows-1252,
+// shift_jis or utf-16
+func NewCharset(name string) (*Charset, error) {
+	name = strings.ToLower(name)
+
+	enc, ok := charsets[name]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported charset %s", name)
+	}
+
+	return &Charset{name: name, enc: enc, bigEndian: map[string]bool{}}, nil
+}
+
+// Process decodes the text of e, it is left unchanged if it cannot be decoded. Empty UTF-16
+// lines are dropped.
+func (p *Charset) Process(e *input.Event) bool {
+	text := e.Text
+	enc := p.enc
+
+	if strings.HasPrefix(p.name, "utf-16") {
+		bigEndian := p.name == "utf-16be"
+		if p.name == "utf-16" {
+			bigEndian = p.byteOrder(e.Source, text)
+			if bigEndian {
+				enc = charsets["utf-16be"]
+			}
+		}
+
+		text = strings.TrimPrefix(strings.TrimPrefix(text, bomLE), bomBE)
+
+		// The newline splitting a line leaves the other byte of its code unit on a side
+		if len(text)%2 == 1 {
+			if bigEndian && text[len(text)-1] == 0 {
+				text = text[:len(text)-1]
+			} else if !bigEndian && text[0] == 0 {
+				text = text[1:]
+			}
+		}
+
+		// Remains of the newline ending the last line
+		if text == "" {
+			return false
+		}
+	}
+
+	decoded, err := enc.NewDecoder().String(text)
+	if err != nil {
+		return true
+	}
+
+	e.Text = strings.TrimSuffix(decoded, "\r")
+
+	return true
+}
+
+// byteOrder tells whether a UTF-16 source is big endian, from the byte order mark of its first
+// line
+func (p *Charset) byteOrder(source, text string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if strings.HasPrefix(text, bomBE) {
+		p.bigEndian[source] = true
+	} else if strings.HasPrefix(text, bomLE) {
+		p.bigEndian[source] = false
+	}
+
+	return p.bigEndian[source]
+}
@@ -0,0 +1,136 @@
+package processor
+
+import (
+	"bufio"
+	"encoding/csv"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// CSV parses delimited lines into fields named by Columns, or by the header row of each source
+// when Columns is empty. The header is read from the first line of the file when the source is
+// a file, otherwise the first line of the source is taken as header. Header lines are dropped.
+type CSV struct {
+	// Comma is the delimiter, ',' by default or '\t' for TSV
+	Comma rune
+	// Columns names the columns of every source instead of the header rows
+	Columns []string
+
+	mu      sync.Mutex
+	headers map[string][]string
+}
+
+// NewCSV creates a CSV processor with comma as delimiter
+func NewCSV(comma rune, columns []string) *CSV {
+	if comma == 0 {
+		comma = ','
+	}
+
+	return &CSV{Comma: comma, Columns: columns, headers: map[string][]string{}}
+}
+
+// Process parses the text of e, lines which cannot be parsed are left unchanged
+func (p *CSV) Process(e *input.Event) bool {
+	values, ok := p.parse(e.Text)
+	if !ok {
+		return true
+	}
+
+	columns := p.Columns
+	if len(columns) == 0 {
+		var isHeader bool
+		if columns, isHeader = p.header(e.Source, values); isHeader {
+			return false
+		}
+	}
+
+	for i, value := range values {
+		name := ""
+		if i < len(columns) {
+			name = columns[i]
+		}
+		if name == "" {
+			continue
+		}
+
+		setField(e, name, value)
+	}
+
+	return true
+}
+
+// header returns the columns of source, and whether values is the header row itself
+func (p *CSV) header(source string, values []string) ([]string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	columns, ok := p.headers[source]
+	if !ok {
+		columns = p.fileHeader(source)
+		if columns == nil {
+			p.headers[source] = values
+			return values, true
+		}
+
+		p.headers[source] = columns
+	}
+
+	// The header row is repeated by every file a rotated log is written to
+	return columns, equal(columns, values)
+}
+
+// fileHeader reads the first line of source when it is a file
+func (p *CSV) fileHeader(source string) []string {
+	f, err := os.Open(source)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil || line == "" {
+		return nil
+	}
+
+	columns, ok := p.parse(strings.TrimRight(line, "\r\n"))
+	if !ok {
+		return nil
+	}
+
+	return columns
+}
+
+func (p *CSV) parse(text string) ([]string, bool) {
+	if strings.TrimSpace(text) == "" {
+		return nil, false
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = p.Comma
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	values, err := reader.Read()
+	if err != nil {
+		return nil, false
+	}
+
+	return values, true
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
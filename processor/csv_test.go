@@ -0,0 +1,94 @@
+package processor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+func TestCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		comma   rune
+		columns []string
+		// file, when set, is the content of the file the lines are read from
+		file  string
+		lines []string
+		// fields are those of each line once processed, "!" standing for a dropped line
+		fields []string
+	}{
+		{
+			name:    "columns",
+			columns: []string{"x", "", "z"},
+			lines:   []string{"a,b,c", "d", "e,f,g,h"},
+			fields:  []string{"map[x:a z:c]", "map[x:d]", "map[x:e z:g]"},
+		},
+		{
+			name:    "tsv",
+			comma:   '\t',
+			columns: []string{"x", "y"},
+			lines:   []string{"a,b\tc"},
+			fields:  []string{"map[x:a,b y:c]"},
+		},
+		{
+			name:    "quoted",
+			columns: []string{"x", "y"},
+			lines:   []string{`"a, ""b""",c`},
+			fields:  []string{`map[x:a, "b" y:c]`},
+		},
+		{
+			name:    "empty line",
+			columns: []string{"x"},
+			lines:   []string{"", " "},
+			fields:  []string{"map[]", "map[]"},
+		},
+		{
+			name:   "header row",
+			lines:  []string{"name,age", "bob,42", "name,age", "ann,7"},
+			fields: []string{"!", "map[age:42 name:bob]", "!", "map[age:7 name:ann]"},
+		},
+		{
+			name:   "header of the file",
+			file:   "name,age\r\nbob,42\r\nann,7\r\n",
+			lines:  []string{"ann,7"},
+			fields: []string{"map[age:7 name:ann]"},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := fmt.Sprintf("source%d", i)
+			if test.file != "" {
+				source = filepath.Join(dir, source)
+				if err := ioutil.WriteFile(source, []byte(test.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p := NewCSV(test.comma, test.columns)
+			var fields []string
+			for _, line := range test.lines {
+				e := &input.Event{Text: line, Source: source}
+				if !p.Process(e) {
+					fields = append(fields, "!")
+					continue
+				}
+				fields = append(fields, fmt.Sprint(e.Fields))
+			}
+
+			if fmt.Sprintf("%q", fields) != fmt.Sprintf("%q", test.fields) {
+				t.Errorf("Parsed %q, expecting %q", fields, test.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+const (
+	// defaultDedupMaxEntries bounds the memory used to remember records, the oldest are
+	// forgotten first
+	defaultDedupMaxEntries = 100000
+)
+
+// dedupEntry is a record remembered by Dedup
+type dedupEntry struct {
+	hash uint64
+	seen time.Time
+}
+
+// Dedup drops events identical to one delivered less than Window ago, e.g. when an application
+// writes each line twice or replayed logs overlap. Events are compared by a hash of their text and
+// fields, the time they were read is ignored.
+type Dedup struct {
+	// Window is how long a delivered event is remembered
+	Window time.Duration
+	// MaxEntries bounds how many events are remembered, 100000 by default
+	MaxEntries int
+
+	mu      sync.Mutex
+	seen    map[uint64]time.Time
+	entries []dedupEntry
+}
+
+// NewDedup creates a dedup processor remembering events for window
+func NewDedup(window time.Duration) *Dedup {
+	return &Dedup{Window: window, MaxEntries: defaultDedupMaxEntries, seen: map[uint64]time.Time{}}
+}
+
+// Process drops e when an identical event was delivered within the window
+func (d *Dedup) Process(e *input.Event) bool {
+	hash := hashEvent(e)
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expire(now)
+
+	if _, ok := d.seen[hash]; ok {
+		return false
+	}
+
+	d.seen[hash] = now
+	d.entries = append(d.entries, dedupEntry{hash: hash, seen: now})
+
+	return true
+}
+
+// expire forgets the events delivered before the window, or beyond MaxEntries
+func (d *Dedup) expire(now time.Time) {
+	n := 0
+	for n < len(d.entries) && (now.Sub(d.entries[n].seen) >= d.Window || (d.MaxEntries > 0 && len(d.entries)-n >= d.MaxEntries)) {
+		delete(d.seen, d.entries[n].hash)
+		n++
+	}
+
+	d.entries = d.entries[n:]
+}
+
+// hashEvent hashes the text and fields of e, fields are serialized with sorted keys
+func hashEvent(e *input.Event) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(e.Text))
+	h.Write([]byte{0})
+
+	if len(e.Fields) > 0 {
+		if buf, err := json.Marshal(e.Fields); err == nil {
+			h.Write(buf)
+		} else {
+			fmt.Fprint(h, e.Fields)
+		}
+	}
+
+	return h.Sum64()
+}
@@ -0,0 +1,58 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+func TestDedup(t *testing.T) {
+	d := NewDedup(time.Hour)
+	event := func(text string, fields map[string]interface{}) *input.Event {
+		return &input.Event{Text: text, Fields: fields, Time: time.Now()}
+	}
+
+	steps := []struct {
+		e    *input.Event
+		kept bool
+	}{
+		{event("hello", map[string]interface{}{"a": 1, "b": "x"}), true},
+		// The time read and the order of fields don't matter
+		{event("hello", map[string]interface{}{"b": "x", "a": 1}), false},
+		{event("hello", map[string]interface{}{"a": 2, "b": "x"}), true},
+		{event("hello", nil), true},
+		{event("hello", nil), false},
+		{event("hello\x00", nil), true},
+	}
+	for i, step := range steps {
+		if kept := d.Process(step.e); kept != step.kept {
+			t.Errorf("Kept event %d %v, expecting %v", i, kept, step.kept)
+		}
+	}
+
+	// Events are forgotten once out of the window
+	d.Window = 0
+	if !d.Process(event("hello", nil)) {
+		t.Error("Dropped an event out of the window")
+	}
+}
+
+func TestDedupMaxEntries(t *testing.T) {
+	d := NewDedup(time.Hour)
+	d.MaxEntries = 2
+
+	for _, text := range []string{"a", "b", "c"} {
+		if !d.Process(&input.Event{Text: text}) {
+			t.Fatalf("Dropped %s", text)
+		}
+	}
+
+	// The oldest event is forgotten first
+	if !d.Process(&input.Event{Text: "a"}) {
+		t.Error("Dropped a forgotten event")
+	}
+	if d.Process(&input.Event{Text: "a"}) {
+		t.Error("Kept a remembered event")
+	}
+}
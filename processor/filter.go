@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// FilterRule matches events by their text, or a field
+type FilterRule struct {
+	// Field is matched instead of the text of the event when set
+	Field string
+	// Match matches the text or field when not nil
+	Match *regexp.Regexp
+	// Contains must be part of the text or field when not empty
+	Contains string
+}
+
+// matches tells whether the rule matches e
+func (r *FilterRule) matches(e *input.Event) bool {
+	text, ok := eventText(e, r.Field)
+	if !ok {
+		return false
+	}
+
+	if r.Contains != "" && !strings.Contains(text, r.Contains) {
+		return false
+	}
+
+	return r.Match == nil || r.Match.MatchString(text)
+}
+
+// Filter keeps the events matching one of its include rules, all when there is none, then drops
+// the events matching one of its exclude rules, e.g. health checks or debug logs
+type Filter struct {
+	include []FilterRule
+	exclude []FilterRule
+}
+
+// NewFilter creates a filter keeping events matching include and dropping events matching exclude
+func NewFilter(include, exclude []FilterRule) *Filter {
+	return &Filter{include: include, exclude: exclude}
+}
+
+// Process drops e when it is filtered out
+func (f *Filter) Process(e *input.Event) bool {
+	if len(f.include) > 0 && !matchesAny(e, f.include) {
+		return false
+	}
+
+	return !matchesAny(e, f.exclude)
+}
+
+func matchesAny(e *input.Event, rules []FilterRule) bool {
+	for i := range rules {
+		if rules[i].matches(e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// eventText returns the text of e, or the value of one of its fields when field is not empty
+func eventText(e *input.Event, field string) (string, bool) {
+	if field == "" {
+		return e.Text, true
+	}
+
+	value, ok := e.Fields[field]
+	if !ok || value == nil {
+		return "", false
+	}
+
+	return fmt.Sprint(value), true
+}
@@ -0,0 +1,205 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// GrokPatterns is the library of grok patterns available to every grok processor. It follows
+// the logstash patterns, adapted to the regular expression syntax of Go which has no lookaround.
+var GrokPatterns = map[string]string{
+	"USERNAME":       `[a-zA-Z0-9._-]+`,
+	"USER":           `%{USERNAME}`,
+	"EMAILLOCALPART": "[a-zA-Z0-9!#$%&'*+/=?^_`{|}~.-]+",
+	"EMAILADDRESS":   `%{EMAILLOCALPART}@%{HOSTNAME}`,
+	"INT":            `[+-]?[0-9]+`,
+	"BASE10NUM":      `[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)`,
+	"NUMBER":         `%{BASE10NUM}`,
+	"BASE16NUM":      `[+-]?(?:0x)?[0-9A-Fa-f]+`,
+	"POSINT":         `\b[1-9][0-9]*\b`,
+	"NONNEGINT":      `\b[0-9]+\b`,
+	"WORD":           `\b\w+\b`,
+	"NOTSPACE":       `\S+`,
+	"SPACE":          `\s*`,
+	"DATA":           `.*?`,
+	"GREEDYDATA":     `.*`,
+	"QUOTEDSTRING":   `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"QS":             `%{QUOTEDSTRING}`,
+	"UUID":           `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"MAC":            `(?:[A-Fa-f0-9]{2}[:-]){5}[A-Fa-f0-9]{2}`,
+
+	"IPV4":     `(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9]?[0-9])`,
+	"IPV6":     `(?:[0-9A-Fa-f]{0,4}:){2,7}(?:%{IPV4}|[0-9A-Fa-f]{0,4})(?:%[0-9A-Za-z]+)?`,
+	"IP":       `%{IPV6}|%{IPV4}`,
+	"HOSTNAME": `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?`,
+	"IPORHOST": `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT": `%{IPORHOST}:%{POSINT}`,
+
+	"UNIXPATH":     `(?:/[\w_%!$@:.,+~-]*)+`,
+	"WINPATH":      `(?:[A-Za-z]+:|\\)(?:\\[^\\?*]*)+`,
+	"PATH":         `%{UNIXPATH}|%{WINPATH}`,
+	"URIPROTO":     `[A-Za-z][A-Za-z0-9+.-]+`,
+	"URIHOST":      `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":      `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":     `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM": `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":          `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATHPARAM})?`,
+
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]un(?:e)?|[Jj]ul(?:y)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHDAY":          `0[1-9]|[12][0-9]|3[01]|[1-9]`,
+	"DAY":               `Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?`,
+	"YEAR":              `(?:\d\d){1,2}`,
+	"HOUR":              `2[0123]|[01]?[0-9]`,
+	"MINUTE":            `[0-5][0-9]`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"DATE_US":           `%{MONTHNUM}[/-]%{MONTHDAY}[/-]%{YEAR}`,
+	"DATE_EU":           `%{MONTHDAY}[./-]%{MONTHNUM}[./-]%{YEAR}`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}(?::?%{MINUTE})`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?(?:%{ISO8601_TIMEZONE})?`,
+	"DATE":              `%{DATE_US}|%{DATE_EU}`,
+	"DATESTAMP":         `%{DATE}[- ]%{TIME}`,
+	"TZ":                `[APMCE][SD]T|UTC`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+
+	"SYSLOGTIMESTAMP": `%{MONTH} +%{MONTHDAY} %{TIME}`,
+	"PROG":            `[\x21-\x5a\x5c\x5e-\x7e]+`,
+	"SYSLOGPROG":      `%{PROG:program}(?:\[%{POSINT:pid}\])?`,
+	"SYSLOGHOST":      `%{IPORHOST}`,
+	"SYSLOGFACILITY":  `<%{NONNEGINT:facility}.%{NONNEGINT:priority}>`,
+	"SYSLOGBASE":      `%{SYSLOGTIMESTAMP:timestamp} (?:%{SYSLOGFACILITY} )?%{SYSLOGHOST:logsource} %{SYSLOGPROG}:`,
+	"SYSLOGLINE":      `%{SYSLOGBASE} %{GREEDYDATA:message}`,
+
+	"HTTPDUSER":         `%{EMAILADDRESS}|%{USER}`,
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{HTTPDUSER:ident} %{HTTPDUSER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response} (?:%{NUMBER:bytes}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+
+	"LOGLEVEL": `[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo(?:rmation)?|INFO(?:RMATION)?|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|[Ee]merg(?:ency)?|EMERG(?:ENCY)?`,
+}
+
+const (
+	grokMaxDepth = 20
+)
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::(int|float))?\}`)
+
+// grokField is a field captured by a grok expression
+type grokField struct {
+	name      string
+	valueType string
+}
+
+// Grok extracts fields with a grok expression such as `%{COMMONAPACHELOG}` or
+// `%{IP:client} %{WORD:method} %{NUMBER:duration:float}`. A reference %{PATTERN:field} captures
+// the text matched by the pattern into field, optionally converted to int or float. Events not
+// matching are left unchanged.
+type Grok struct {
+	re     *regexp.Regexp
+	fields []grokField
+}
+
+// NewGrok compiles a grok expression, patterns adds or overrides patterns of GrokPatterns
+func NewGrok(expr string, patterns map[string]string) (*Grok, error) {
+	g := &Grok{}
+
+	expanded, err := g.expand(expr, patterns, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.re, err = regexp.Compile(expanded); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// expand replaces the pattern references of expr with their regular expressions, captured
+// fields get groups named by their index in g.fields
+func (g *Grok) expand(expr string, patterns map[string]string, depth int) (string, error) {
+	if depth > grokMaxDepth {
+		return "", fmt.Errorf("Grok pattern %s is too deeply nested or recursive", expr)
+	}
+
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(expr, func(reference string) string {
+		if err != nil {
+			return ""
+		}
+
+		match := grokReference.FindStringSubmatch(reference)
+		pattern, ok := patterns[match[1]]
+		if !ok {
+			pattern, ok = GrokPatterns[match[1]]
+		}
+		if !ok {
+			err = fmt.Errorf("Unknown grok pattern %s", match[1])
+			return ""
+		}
+
+		var sub string
+		if sub, err = g.expand(pattern, patterns, depth+1); err != nil {
+			return ""
+		}
+
+		if match[2] == "" {
+			return "(?:" + sub + ")"
+		}
+
+		g.fields = append(g.fields, grokField{name: match[2], valueType: match[3]})
+		return fmt.Sprintf("(?P<_f%d>%s)", len(g.fields)-1, sub)
+	})
+
+	// Groups named with the (?<name>) syntax of other engines are captured as fields too
+	expanded = strings.Replace(expanded, "(?<", "(?P<", -1)
+
+	return expanded, err
+}
+
+// Process extracts fields from the text of e
+func (g *Grok) Process(e *input.Event) bool {
+	match := g.re.FindStringSubmatchIndex(e.Text)
+	if match == nil {
+		return true
+	}
+
+	for i, name := range g.re.SubexpNames() {
+		if name == "" || match[2*i] < 0 {
+			continue
+		}
+
+		value := e.Text[match[2*i]:match[2*i+1]]
+
+		var index int
+		if _, err := fmt.Sscanf(name, "_f%d", &index); err != nil || index >= len(g.fields) {
+			setField(e, name, value)
+			continue
+		}
+
+		field := g.fields[index]
+		setField(e, field.name, convert(value, field.valueType))
+	}
+
+	return true
+}
+
+// convert converts a captured value to int or float, it stays a string if it fails
+func convert(value, valueType string) interface{} {
+	switch valueType {
+	case "int":
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+
+	return value
+}
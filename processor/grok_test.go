@@ -0,0 +1,87 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+func TestGrok(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		patterns map[string]string
+		text     string
+		fields   map[string]interface{}
+	}{
+		{
+			name: "common apache log",
+			expr: "%{COMMONAPACHELOG}",
+			text: `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			fields: map[string]interface{}{
+				"clientip": "127.0.0.1", "ident": "-", "auth": "frank", "timestamp": "10/Oct/2000:13:55:36 -0700",
+				"verb": "GET", "request": "/apache_pb.gif", "httpversion": "1.0", "response": "200", "bytes": "2326",
+			},
+		},
+		{
+			name:   "converted",
+			expr:   "%{IP:client} %{WORD:method} %{NUMBER:duration:float} %{INT:status:int}",
+			text:   "10.0.0.1 GET 1.5 200",
+			fields: map[string]interface{}{"client": "10.0.0.1", "method": "GET", "duration": 1.5, "status": int64(200)},
+		},
+		{
+			name:     "custom pattern",
+			expr:     "order %{ORDER:order}",
+			patterns: map[string]string{"ORDER": `[A-Z]+-%{INT}`},
+			text:     "order AB-42 created",
+			fields:   map[string]interface{}{"order": "AB-42"},
+		},
+		{
+			name:   "named group",
+			expr:   `user (?<user>\w+) from %{IPV4:ip}`,
+			text:   "user alice from 10.1.2.3",
+			fields: map[string]interface{}{"user": "alice", "ip": "10.1.2.3"},
+		},
+		{
+			name:   "no match",
+			expr:   "%{IPV4:ip}",
+			text:   "no address",
+			fields: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := NewGrok(test.expr, test.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e := &input.Event{Text: test.text}
+			if !g.Process(e) {
+				t.Fatal("Expecting the event to be kept")
+			}
+			if !reflect.DeepEqual(e.Fields, test.fields) {
+				t.Errorf("Extracted %#v, expecting %#v", e.Fields, test.fields)
+			}
+			if e.Text != test.text {
+				t.Errorf("Text changed to %q", e.Text)
+			}
+		})
+	}
+}
+
+func TestGrokInvalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"%{UNKNOWN:x}": nil,
+		"%{LOOP}":      {"LOOP": "a%{LOOP}"},
+		"(%{WORD:x}":   nil,
+	}
+
+	for expr, patterns := range tests {
+		if _, err := NewGrok(expr, patterns); err == nil {
+			t.Errorf("Expecting %q to be rejected", expr)
+		}
+	}
+}
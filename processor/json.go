@@ -0,0 +1,61 @@
+package processor
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// DefaultMessageKeys are the keys commonly holding the message of JSON logs
+var DefaultMessageKeys = []string{"message", "msg", "log"}
+
+// JSON parses events whose text is a JSON object, each key becomes a field so columns match the
+// fields logged by the application. Fields already set by the input are kept. The value of the
+// first of MessageKeys found becomes the text of the event, the text is emptied when none is
+// found. Lines that are not JSON objects are left unchanged.
+type JSON struct {
+	MessageKeys []string
+}
+
+// NewJSON creates a JSON processor using DefaultMessageKeys
+func NewJSON() *JSON {
+	return &JSON{MessageKeys: DefaultMessageKeys}
+}
+
+// Process parses the text of e
+func (p *JSON) Process(e *input.Event) bool {
+	text := strings.TrimSpace(e.Text)
+	if !strings.HasPrefix(text, "{") {
+		return true
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(text))
+	// Keeps integers exact instead of converting them to float64
+	decoder.UseNumber()
+
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil || decoder.More() {
+		return true
+	}
+
+	message := ""
+	for _, key := range p.MessageKeys {
+		if value, ok := fields[key]; ok {
+			if s, ok := value.(string); ok {
+				message = s
+				delete(fields, key)
+				break
+			}
+		}
+	}
+
+	// Fields of the event, usually fewer, are kept over the parsed ones which become the fields
+	for key, value := range e.Fields {
+		fields[key] = value
+	}
+	e.Fields = fields
+	e.Text = message
+
+	return true
+}
@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"strconv"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// Logfmt parses events whose text is a sequence of logfmt pairs, e.g.
+// `level=info msg="request done" status=200 cached`, each key becomes a field and a key without
+// value is true. Fields already set by the input are kept. The value of the first of MessageKeys
+// found becomes the text of the event, the text is emptied when none is found. Lines that are not
+// entirely logfmt are left unchanged.
+type Logfmt struct {
+	MessageKeys []string
+}
+
+// NewLogfmt creates a logfmt processor using DefaultMessageKeys
+func NewLogfmt() *Logfmt {
+	return &Logfmt{MessageKeys: DefaultMessageKeys}
+}
+
+// Process parses the text of e
+func (p *Logfmt) Process(e *input.Event) bool {
+	fields, ok := parseLogfmt(e.Text)
+	if !ok {
+		return true
+	}
+
+	message := ""
+	for _, key := range p.MessageKeys {
+		if value, ok := fields[key].(string); ok {
+			message = value
+			delete(fields, key)
+			break
+		}
+	}
+
+	for key, value := range fields {
+		if _, ok := e.Fields[key]; !ok {
+			setField(e, key, value)
+		}
+	}
+	e.Text = message
+
+	return true
+}
+
+// parseLogfmt parses a logfmt line, it fails unless the line has at least one key=value pair and
+// nothing else than pairs and keys
+func parseLogfmt(text string) (map[string]interface{}, bool) {
+	fields := map[string]interface{}{}
+	pairs := 0
+
+	for i := 0; i < len(text); {
+		if text[i] == ' ' || text[i] == '\t' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(text) && text[i] > ' ' && text[i] != '=' && text[i] != '"' {
+			i++
+		}
+		if i == start {
+			return nil, false
+		}
+		key := text[start:i]
+
+		if i == len(text) || text[i] == ' ' || text[i] == '\t' {
+			fields[key] = true
+			continue
+		}
+		if text[i] != '=' {
+			return nil, false
+		}
+		i++
+
+		if i < len(text) && text[i] == '"' {
+			end := i + 1
+			for end < len(text) && text[end] != '"' {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(text) {
+				return nil, false
+			}
+
+			value, err := strconv.Unquote(text[i : end+1])
+			if err != nil {
+				return nil, false
+			}
+			fields[key] = value
+			i = end + 1
+		} else {
+			start = i
+			for i < len(text) && text[i] != ' ' && text[i] != '\t' {
+				i++
+			}
+			fields[key] = text[start:i]
+		}
+
+		if i < len(text) && text[i] != ' ' && text[i] != '\t' {
+			return nil, false
+		}
+		pairs++
+	}
+
+	return fields, pairs > 0
+}
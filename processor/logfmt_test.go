@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+func TestLogfmt(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		// input are the fields set by the input, fields those once processed
+		input  map[string]interface{}
+		fields map[string]interface{}
+		// message is the text of the event once processed
+		message string
+	}{
+		{
+			name:    "pairs",
+			text:    `level=info msg="request done" status=200 cached`,
+			fields:  map[string]interface{}{"level": "info", "status": "200", "cached": true},
+			message: "request done",
+		},
+		{
+			name:    "escaped quote",
+			text:    `msg="say \"hi\"\tnow" path=/a=b`,
+			fields:  map[string]interface{}{"path": "/a=b"},
+			message: "say \"hi\"\tnow",
+		},
+		{
+			name:    "without message",
+			text:    "level=warn  empty=",
+			fields:  map[string]interface{}{"level": "warn", "empty": ""},
+			message: "",
+		},
+		{
+			name:    "input field kept",
+			text:    "source=app level=info",
+			input:   map[string]interface{}{"source": "input"},
+			fields:  map[string]interface{}{"source": "input", "level": "info"},
+			message: "",
+		},
+		{name: "plain text", text: "GET /index.html 200", message: "GET /index.html 200"},
+		{name: "keys only", text: "cached done", message: "cached done"},
+		{name: "unterminated quote", text: `msg="request done`, message: `msg="request done`},
+		{name: "text after quote", text: `msg="a"b`, message: `msg="a"b`},
+		{name: "quoted key", text: `"level"=info`, message: `"level"=info`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &input.Event{Text: test.text, Fields: test.input}
+			if !NewLogfmt().Process(e) {
+				t.Fatal("Expecting the event to be kept")
+			}
+			if !reflect.DeepEqual(e.Fields, test.fields) {
+				t.Errorf("Parsed %#v, expecting %#v", e.Fields, test.fields)
+			}
+			if e.Text != test.message {
+				t.Errorf("Text is %q, expecting %q", e.Text, test.message)
+			}
+		})
+	}
+}
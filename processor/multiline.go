@@ -0,0 +1,133 @@
+package processor
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+const (
+	defaultMultilineTimeout  = time.Second * 2
+	defaultMultilineMaxLines = 500
+)
+
+// MultilineConfig tells how lines are joined into records
+type MultilineConfig struct {
+	// Start matches the first line of a record, lines not matching it continue the previous record
+	Start *regexp.Regexp
+	// Timeout flushes a record when no line follows it for this long, defaults to 2s
+	Timeout time.Duration
+	// MaxLines flushes a record reaching this many lines, defaults to 500
+	MaxLines int
+}
+
+// multiline joins continuation lines, e.g. stack traces, to the line starting their record
+type multiline struct {
+	in     input.Input
+	config MultilineConfig
+	events chan *input.Event
+}
+
+// pendingRecord is a record being joined for a source
+type pendingRecord struct {
+	event *input.Event
+	lines []string
+	// acks are those of the lines joined, called in order once the record is acknowledged
+	acks []func()
+	last time.Time
+}
+
+// Multiline joins the lines of in into records, a record starts with a line matching
+// config.Start. Lines of different sources are joined separately, the fields and time of a
+// record are those of its first line.
+func Multiline(in input.Input, config MultilineConfig) input.Input {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultMultilineTimeout
+	}
+	if config.MaxLines <= 0 {
+		config.MaxLines = defaultMultilineMaxLines
+	}
+
+	m := &multiline{in: in, config: config, events: make(chan *input.Event)}
+	go m.run()
+
+	return m
+}
+
+func (m *multiline) run() {
+	defer close(m.events)
+
+	pending := map[string]*pendingRecord{}
+
+	flush := func(source string) {
+		record := pending[source]
+		delete(pending, source)
+
+		record.event.Text = strings.Join(record.lines, "\n")
+		if len(record.acks) > 0 {
+			acks := record.acks
+			record.event.Ack = func() {
+				for _, ack := range acks {
+					ack()
+				}
+			}
+		}
+		m.events <- record.event
+	}
+
+	ticker := time.NewTicker(m.config.Timeout / 2)
+	defer ticker.Stop()
+
+	events := m.in.Events()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				for source := range pending {
+					flush(source)
+				}
+				return
+			}
+
+			if e.Err != nil {
+				m.events <- e
+				continue
+			}
+
+			record := pending[e.Source]
+			if record != nil && (m.config.Start.MatchString(e.Text) || len(record.lines) >= m.config.MaxLines) {
+				flush(e.Source)
+				record = nil
+			}
+
+			if record == nil {
+				record = &pendingRecord{event: e}
+				pending[e.Source] = record
+			}
+			record.lines = append(record.lines, e.Text)
+			record.last = time.Now()
+			if e.Ack != nil {
+				record.acks = append(record.acks, e.Ack)
+			}
+
+		case now := <-ticker.C:
+			for source, record := range pending {
+				if now.Sub(record.last) >= m.config.Timeout {
+					flush(source)
+				}
+			}
+		}
+	}
+}
+
+// Events returns the channel events are delivered on
+func (m *multiline) Events() <-chan *input.Event {
+	return m.events
+}
+
+// Stop stops the input
+func (m *multiline) Stop() {
+	m.in.Stop()
+}
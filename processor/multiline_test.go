@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// channelInput delivers the events sent on its channel
+type channelInput chan *input.Event
+
+func (c channelInput) Events() <-chan *input.Event {
+	return c
+}
+
+func (c channelInput) Stop() {}
+
+// sendLines sends the lines, "source:text", as events of in and closes it
+func sendLines(in channelInput, lines []string) {
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 2)
+		in <- &input.Event{Source: parts[0], Text: parts[1]}
+	}
+	close(in)
+}
+
+func TestMultiline(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxLines int
+		lines    []string
+		// records are the texts joined, by source
+		records map[string][]string
+	}{
+		{
+			name:    "stack trace",
+			lines:   []string{"a:2018 panic", "a:  at main", "a:  at run", "a:2018 done"},
+			records: map[string][]string{"a": {"2018 panic\n  at main\n  at run", "2018 done"}},
+		},
+		{
+			name:    "continuation first",
+			lines:   []string{"a:  at main", "a:2018 done"},
+			records: map[string][]string{"a": {"  at main", "2018 done"}},
+		},
+		{
+			name:    "sources joined separately",
+			lines:   []string{"a:2018 panic", "b:2018 error", "a:  at main", "b:  at run"},
+			records: map[string][]string{"a": {"2018 panic\n  at main"}, "b": {"2018 error\n  at run"}},
+		},
+		{
+			name:     "max lines",
+			maxLines: 2,
+			lines:    []string{"a:2018 panic", "a:  at main", "a:  at run"},
+			records:  map[string][]string{"a": {"2018 panic\n  at main", "  at run"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := make(channelInput)
+			go sendLines(in, test.lines)
+
+			records := map[string][]string{}
+			m := Multiline(in, MultilineConfig{Start: regexp.MustCompile(`^\d{4} `), MaxLines: test.maxLines})
+			for e := range m.Events() {
+				records[e.Source] = append(records[e.Source], e.Text)
+			}
+
+			if fmt.Sprintf("%q", records) != fmt.Sprintf("%q", test.records) {
+				t.Errorf("Joined %q, expecting %q", records, test.records)
+			}
+		})
+	}
+}
+
+func TestMultilineTimeout(t *testing.T) {
+	in := make(channelInput)
+	m := Multiline(in, MultilineConfig{Start: regexp.MustCompile(`^\d{4} `), Timeout: 20 * time.Millisecond})
+	defer close(in)
+
+	var acked []string
+	for _, text := range []string{"2018 panic", "  at main"} {
+		text := text
+		in <- &input.Event{Source: "a", Text: text, Ack: func() { acked = append(acked, text) }}
+	}
+
+	// The record is flushed without a line starting the next one, acknowledged with all its lines
+	select {
+	case e := <-m.Events():
+		if e.Text != "2018 panic\n  at main" {
+			t.Errorf("Joined %q", e.Text)
+		}
+		e.Ack()
+		if strings.Join(acked, ",") != "2018 panic,  at main" {
+			t.Errorf("Acknowledged %q, expecting the lines in order", acked)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The record was not flushed after the timeout")
+	}
+}
@@ -0,0 +1,111 @@
+// Package processor transforms the events read by inputs before they are uploaded, e.g. to
+// extract structured fields from the text of log lines.
+package processor
+
+import (
+	"path/filepath"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// Processor transforms an event in place, it returns false to drop the event
+type Processor interface {
+	Process(e *input.Event) bool
+}
+
+// Func adapts a function to a Processor
+type Func func(e *input.Event) bool
+
+// Process calls f
+func (f Func) Process(e *input.Event) bool {
+	return f(e)
+}
+
+// sourceFilter runs a processor on events of some sources only
+type sourceFilter struct {
+	patterns  []string
+	processor Processor
+}
+
+// ForSources runs p only on events whose source matches one of the glob patterns, e.g.
+// "/var/log/nginx/*.log", so processors can be configured per input
+func ForSources(patterns []string, p Processor) Processor {
+	if len(patterns) == 0 {
+		return p
+	}
+
+	return &sourceFilter{patterns: patterns, processor: p}
+}
+
+// Process runs the processor if the source of e matches
+func (f *sourceFilter) Process(e *input.Event) bool {
+	for _, pattern := range f.patterns {
+		if ok, _ := filepath.Match(pattern, e.Source); ok || pattern == e.Source {
+			return f.processor.Process(e)
+		}
+	}
+
+	return true
+}
+
+// processed delivers the events of an input transformed by processors
+type processed struct {
+	in     input.Input
+	events chan *input.Event
+}
+
+// Apply runs the events of in through processors in order, events failing to read are passed
+// through unchanged. Events dropped are acknowledged, so inputs don't deliver them again.
+func Apply(in input.Input, processors ...Processor) input.Input {
+	if len(processors) == 0 {
+		return in
+	}
+
+	p := &processed{in: in, events: make(chan *input.Event)}
+
+	go func() {
+		defer close(p.events)
+
+		for e := range in.Events() {
+			if e.Err == nil && !process(e, processors) {
+				if e.Ack != nil {
+					e.Ack()
+				}
+				continue
+			}
+
+			p.events <- e
+		}
+	}()
+
+	return p
+}
+
+func process(e *input.Event, processors []Processor) bool {
+	for _, processor := range processors {
+		if !processor.Process(e) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Events returns the channel events are delivered on
+func (p *processed) Events() <-chan *input.Event {
+	return p.events
+}
+
+// Stop stops the input
+func (p *processed) Stop() {
+	p.in.Stop()
+}
+
+// setField sets a field of an event, creating its fields when needed
+func setField(e *input.Event, name string, value interface{}) {
+	if e.Fields == nil {
+		e.Fields = map[string]interface{}{}
+	}
+
+	e.Fields[name] = value
+}
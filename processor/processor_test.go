@@ -0,0 +1,52 @@
+package processor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yangl900/log2oms/input"
+	"github.com/yangl900/log2oms/tail"
+)
+
+func TestApplyAck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "processor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	content := "started\nDEBUG polling\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoints, err := tail.LoadCheckpoints("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoints.Close()
+	tailer, err := tail.FollowGlob([]string{path}, tail.Config{PollInterval: 10 * time.Millisecond, Checkpoints: checkpoints})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := Apply(input.NewFileInput(tailer, false), NewFilter(nil, []FilterRule{{Contains: "DEBUG"}}))
+	defer in.Stop()
+	e := <-in.Events()
+	if e.Text != "started" {
+		t.Fatalf("Delivered %q, expecting started", e.Text)
+	}
+	e.Ack()
+
+	// The final line is filtered out, it is checkpointed all the same
+	for deadline := time.Now().Add(5 * time.Second); checkpoints.Offsets()[path] != int64(len(content)); {
+		if time.Now().After(deadline) {
+			t.Fatalf("Checkpointed %v, expecting %s at %d", checkpoints.Offsets(), path, len(content))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,136 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+const (
+	// DefaultRedactPlaceholder replaces sensitive data
+	DefaultRedactPlaceholder = "[REDACTED]"
+)
+
+// redactPattern matches sensitive data, valid rejects matches which are not, e.g. numbers failing
+// the checksum of credit cards
+type redactPattern struct {
+	re    *regexp.Regexp
+	valid func(match string) bool
+}
+
+// redactPatterns are the built-in patterns of Redact by name
+var redactPatterns = map[string]redactPattern{
+	"email":       {re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+	"credit_card": {re: regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`), valid: luhn},
+	"ssn":         {re: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	"ipv4":        {re: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)},
+}
+
+// RedactPatterns returns the names of the built-in patterns of Redact
+func RedactPatterns() []string {
+	names := make([]string, 0, len(redactPatterns))
+	for name := range redactPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Redact replaces sensitive data in the text and the string fields of events with a placeholder,
+// so it never reaches the workspace. Fields nested in objects and arrays are redacted too.
+type Redact struct {
+	// Placeholder replaces matches, DefaultRedactPlaceholder by default
+	Placeholder string
+
+	patterns []redactPattern
+}
+
+// NewRedact creates a redaction processor of the built-in patterns named, e.g. "email",
+// "credit_card" or "ssn", and of custom regular expressions
+func NewRedact(names []string, custom []*regexp.Regexp) (*Redact, error) {
+	r := &Redact{Placeholder: DefaultRedactPlaceholder}
+	for _, name := range names {
+		pattern, ok := redactPatterns[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown pattern '%s', expected one of %s", name, strings.Join(RedactPatterns(), ", "))
+		}
+		r.patterns = append(r.patterns, pattern)
+	}
+	for _, re := range custom {
+		r.patterns = append(r.patterns, redactPattern{re: re})
+	}
+
+	return r, nil
+}
+
+// Process redacts e
+func (r *Redact) Process(e *input.Event) bool {
+	e.Text = r.redact(e.Text)
+	for k, v := range e.Fields {
+		e.Fields[k] = r.redactValue(v)
+	}
+
+	return true
+}
+
+func (r *Redact) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.redact(v)
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = r.redactValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = r.redactValue(item)
+		}
+	}
+
+	return value
+}
+
+func (r *Redact) redact(text string) string {
+	for _, pattern := range r.patterns {
+		if pattern.valid == nil {
+			text = pattern.re.ReplaceAllLiteralString(text, r.Placeholder)
+			continue
+		}
+
+		text = pattern.re.ReplaceAllStringFunc(text, func(match string) string {
+			if pattern.valid(match) {
+				return r.Placeholder
+			}
+			return match
+		})
+	}
+
+	return text
+}
+
+// luhn tells whether the digits of number pass the Luhn checksum of card numbers
+func luhn(number string) bool {
+	sum, double := 0, false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+
+	return sum%10 == 0
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+func TestLuhn(t *testing.T) {
+	tests := map[string]bool{
+		"4111111111111111":    true,
+		"4111 1111 1111 1111": true,
+		"5500-0000-0000-0004": true,
+		"378282246310005":     true,
+		"4111111111111112":    false,
+		"1234567812345678":    false,
+		"79927398710":         false,
+		"79927398713":         true,
+	}
+
+	for number, valid := range tests {
+		if luhn(number) != valid {
+			t.Errorf("Luhn of %s is %v, expecting %v", number, !valid, valid)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		custom   string
+		text     string
+		redacted string
+	}{
+		{"email", []string{"email"}, "", "login of bob.smith+x@example.co.uk failed", "login of [REDACTED] failed"},
+		{"card", []string{"credit_card"}, "", "paid with 4111 1111 1111 1111 today", "paid with [REDACTED] today"},
+		{"card failing checksum", []string{"credit_card"}, "", "order 4111111111111112 shipped", "order 4111111111111112 shipped"},
+		{"card too short", []string{"credit_card"}, "", "id 411111111111", "id 411111111111"},
+		{"ssn", []string{"SSN"}, "", "ssn=078-05-1120", "ssn=[REDACTED]"},
+		{"ipv4", []string{"ipv4"}, "", "from 10.0.0.255 and 999.1.1.1", "from [REDACTED] and 999.1.1.1"},
+		{"custom", nil, `token=\w+`, "auth token=abc123 ok", "auth [REDACTED] ok"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var custom []*regexp.Regexp
+			if test.custom != "" {
+				custom = append(custom, regexp.MustCompile(test.custom))
+			}
+			r, err := NewRedact(test.patterns, custom)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e := &input.Event{Text: test.text}
+			r.Process(e)
+			if e.Text != test.redacted {
+				t.Errorf("Redacted %q, expecting %q", e.Text, test.redacted)
+			}
+		})
+	}
+}
+
+func TestRedactFields(t *testing.T) {
+	r, err := NewRedact([]string{"email"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fields nested in objects and arrays are redacted, values which are not strings are kept
+	e := &input.Event{Fields: map[string]interface{}{
+		"user":  "a@example.com",
+		"to":    []interface{}{"b@example.com", 42},
+		"owner": map[string]interface{}{"email": "c@example.com"},
+	}}
+	r.Process(e)
+	expected := map[string]interface{}{
+		"user":  "[REDACTED]",
+		"to":    []interface{}{"[REDACTED]", 42},
+		"owner": map[string]interface{}{"email": "[REDACTED]"},
+	}
+	if !reflect.DeepEqual(e.Fields, expected) {
+		t.Errorf("Redacted %v, expecting %v", e.Fields, expected)
+	}
+
+	if _, err := NewRedact([]string{"phone"}, nil); err == nil {
+		t.Error("Expecting an unknown pattern to be rejected")
+	}
+}
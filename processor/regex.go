@@ -0,0 +1,49 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// Regex extracts the named groups of a regular expression matching the text of events into
+// fields, e.g. `status=(?P<Status>\d+) latency=(?P<Latency>\S+)`. Events not matching are left
+// unchanged, groups not taking part in the match are skipped.
+type Regex struct {
+	re *regexp.Regexp
+}
+
+// NewRegex creates a regex processor, the expression must have named groups
+func NewRegex(expr string) (*Regex, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	named := false
+	for _, name := range re.SubexpNames() {
+		named = named || name != ""
+	}
+	if !named {
+		return nil, fmt.Errorf("Regular expression %s has no named group", expr)
+	}
+
+	return &Regex{re: re}, nil
+}
+
+// Process extracts fields from the text of e
+func (p *Regex) Process(e *input.Event) bool {
+	match := p.re.FindStringSubmatchIndex(e.Text)
+	if match == nil {
+		return true
+	}
+
+	for i, name := range p.re.SubexpNames() {
+		if name != "" && match[2*i] >= 0 {
+			setField(e, name, e.Text[match[2*i]:match[2*i+1]])
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,56 @@
+package processor
+
+import (
+	"regexp"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// RouteRule sends the events it matches to a log type
+type RouteRule struct {
+	// LogType is the log type matching events are sent as
+	LogType string
+	// Field is matched instead of the text of the event when set
+	Field string
+	// Match matches the text or field when not nil
+	Match *regexp.Regexp
+	// Value is the value the field must have when not empty
+	Value string
+}
+
+// matches tells whether the rule matches e
+func (r *RouteRule) matches(e *input.Event) bool {
+	text, ok := eventText(e, r.Field)
+	if !ok {
+		return false
+	}
+
+	if r.Value != "" && text != r.Value {
+		return false
+	}
+
+	return r.Match == nil || r.Match.MatchString(text)
+}
+
+// Route sets the log type of events to the one of the first rule they match, e.g. to send audit
+// lines of an application to their own table. Events matching no rule keep their log type.
+type Route struct {
+	rules []RouteRule
+}
+
+// NewRoute creates a routing processor applying rules in order
+func NewRoute(rules []RouteRule) *Route {
+	return &Route{rules: rules}
+}
+
+// Process sets the log type of e
+func (r *Route) Process(e *input.Event) bool {
+	for i := range r.rules {
+		if r.rules[i].matches(e) {
+			e.LogType = r.rules[i].LogType
+			break
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// Sample keeps a random fraction of events to cut the volume of chatty sources, e.g. 10% of info
+// logs and all errors. The rate of an event is the one of the value of its Field in Rates, or
+// Rate. Kept events get a SampleRate field when their rate is below 1, so counts can be scaled
+// back in queries.
+type Sample struct {
+	// Rate is the fraction of events kept, between 0 and 1
+	Rate float64
+	// Field is the field whose value selects the rate in Rates, SeverityLevel by default
+	Field string
+	// Rates are the fractions of events kept by value of Field, values are compared ignoring case
+	Rates map[string]float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewSample creates a sampling processor keeping rate of events, and rates of the events whose
+// field has one of their values
+func NewSample(rate float64, field string, rates map[string]float64) *Sample {
+	if field == "" {
+		field = "SeverityLevel"
+	}
+
+	lowered := make(map[string]float64, len(rates))
+	for key, r := range rates {
+		lowered[strings.ToLower(key)] = r
+	}
+
+	return &Sample{Rate: rate, Field: field, Rates: lowered, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Process drops e unless it is sampled
+func (s *Sample) Process(e *input.Event) bool {
+	rate := s.Rate
+	if key, ok := eventText(e, s.Field); ok {
+		if r, ok := s.Rates[strings.ToLower(key)]; ok {
+			rate = r
+		}
+	}
+
+	if rate >= 1 {
+		return true
+	}
+
+	s.mu.Lock()
+	keep := s.rand.Float64() < rate
+	s.mu.Unlock()
+
+	if keep {
+		setField(e, "SampleRate", rate)
+	}
+
+	return keep
+}
@@ -0,0 +1,49 @@
+package processor
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+func TestSample(t *testing.T) {
+	s := NewSample(0.25, "", map[string]float64{"Error": 1, "debug": 0})
+	s.rand = rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name  string
+		level interface{}
+		// kept is the fraction of events expected to be kept
+		kept float64
+	}{
+		{"default rate", "info", 0.25},
+		{"without field", nil, 0.25},
+		{"rate of value ignoring case", "ERROR", 1},
+		{"dropped value", "debug", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kept := 0
+			for i := 0; i < 10000; i++ {
+				e := &input.Event{Fields: map[string]interface{}{}}
+				if test.level != nil {
+					e.Fields["SeverityLevel"] = test.level
+				}
+				if !s.Process(e) {
+					continue
+				}
+				kept++
+
+				if rate, ok := e.Fields["SampleRate"]; test.kept < 1 && rate != test.kept || test.kept == 1 && ok {
+					t.Fatalf("Set SampleRate %v, expecting %v", rate, test.kept)
+				}
+			}
+
+			if fraction := float64(kept) / 10000; fraction < test.kept-0.02 || fraction > test.kept+0.02 {
+				t.Errorf("Kept %v of events, expecting %v", fraction, test.kept)
+			}
+		})
+	}
+}
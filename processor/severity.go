@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// Normalized severity levels
+const (
+	SeverityTrace    = "trace"
+	SeverityDebug    = "debug"
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityError    = "error"
+	SeverityCritical = "critical"
+)
+
+const (
+	// severityScanLength is how far into the text level tokens are looked for, a level further
+	// in the line is more likely part of the message than the level of the line
+	severityScanLength = 100
+)
+
+// DefaultSeverityFields are the fields commonly holding the level of structured logs
+var DefaultSeverityFields = []string{"level", "Level", "lvl", "severity", "Severity", "loglevel", "log.level"}
+
+// severityTokens maps level tokens, lower cased, to normalized levels. Syslog names are included.
+var severityTokens = map[string]string{
+	"trace":       SeverityTrace,
+	"verbose":     SeverityTrace,
+	"debug":       SeverityDebug,
+	"dbg":         SeverityDebug,
+	"info":        SeverityInfo,
+	"information": SeverityInfo,
+	"notice":      SeverityInfo,
+	"warn":        SeverityWarning,
+	"warning":     SeverityWarning,
+	"err":         SeverityError,
+	"error":       SeverityError,
+	"severe":      SeverityError,
+	"crit":        SeverityCritical,
+	"critical":    SeverityCritical,
+	"fatal":       SeverityCritical,
+	"panic":       SeverityCritical,
+	"alert":       SeverityCritical,
+	"emerg":       SeverityCritical,
+	"emergency":   SeverityCritical,
+}
+
+// syslogLevels maps numeric syslog severities to normalized levels
+var syslogLevels = []string{
+	SeverityCritical, SeverityCritical, SeverityCritical, SeverityError,
+	SeverityWarning, SeverityInfo, SeverityInfo, SeverityDebug,
+}
+
+var severityToken = regexp.MustCompile(`(?i)\b(trace|verbose|debug|dbg|info|information|notice|warn|warning|err|error|severe|crit|critical|fatal|panic|alert|emerg|emergency)\b`)
+
+// Severity sets a SeverityLevel field, one of trace, debug, info, warning, error or critical, so
+// logs of all sources can be filtered by level. The level is taken from the first of Fields set,
+// otherwise from a level token near the start of the text. Events without level are left
+// unchanged.
+type Severity struct {
+	Fields []string
+}
+
+// NewSeverity creates a severity processor using DefaultSeverityFields
+func NewSeverity() *Severity {
+	return &Severity{Fields: DefaultSeverityFields}
+}
+
+// Process sets the SeverityLevel field of e
+func (p *Severity) Process(e *input.Event) bool {
+	for _, field := range p.Fields {
+		if value, ok := e.Fields[field]; ok {
+			if level := normalizeSeverity(value); level != "" {
+				setField(e, "SeverityLevel", level)
+				return true
+			}
+		}
+	}
+
+	text := e.Text
+	if len(text) > severityScanLength {
+		text = text[:severityScanLength]
+	}
+
+	if token := severityToken.FindString(text); token != "" {
+		setField(e, "SeverityLevel", severityTokens[strings.ToLower(token)])
+	}
+
+	return true
+}
+
+// normalizeSeverity converts a level name or number. Numbers up to 7 are syslog severities,
+// larger ones are bunyan and pino levels (10 trace to 60 fatal).
+func normalizeSeverity(value interface{}) string {
+	text := strings.ToLower(strings.TrimSpace(fmt.Sprint(value)))
+	if level, ok := severityTokens[text]; ok {
+		return level
+	}
+
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return ""
+	}
+
+	switch {
+	case number < 0:
+		return ""
+	case number < float64(len(syslogLevels)):
+		return syslogLevels[int(number)]
+	case number < 20:
+		return SeverityTrace
+	case number < 30:
+		return SeverityDebug
+	case number < 40:
+		return SeverityInfo
+	case number < 50:
+		return SeverityWarning
+	case number < 60:
+		return SeverityError
+	default:
+		return SeverityCritical
+	}
+}
@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		fields map[string]interface{}
+		// level is the SeverityLevel set, empty when none is
+		level string
+	}{
+		{name: "level field", fields: map[string]interface{}{"level": "WARN"}, level: SeverityWarning},
+		{name: "first field", fields: map[string]interface{}{"severity": "error", "level": "info"}, level: SeverityInfo},
+		{name: "unknown field value", text: "ERROR failed", fields: map[string]interface{}{"level": "loud"}, level: SeverityError},
+		{name: "syslog number", fields: map[string]interface{}{"severity": 3}, level: SeverityError},
+		{name: "pino number", fields: map[string]interface{}{"level": float64(30)}, level: SeverityInfo},
+		{name: "text token", text: "2018-06-01 12:00:00 [Debug] starting", level: SeverityDebug},
+		{name: "first text token", text: "fatal: error opening file", level: SeverityCritical},
+		{name: "token in a word", text: "terrorist information", level: SeverityInfo},
+		{name: "token too far", text: strings.Repeat("x", severityScanLength) + " error"},
+		{name: "without level", text: "request done"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &input.Event{Text: test.text, Fields: test.fields}
+			if !NewSeverity().Process(e) {
+				t.Fatal("Dropped the event")
+			}
+
+			level, ok := e.Fields["SeverityLevel"]
+			if test.level == "" {
+				if ok {
+					t.Errorf("Set level %v, expecting none", level)
+				}
+			} else if level != test.level {
+				t.Errorf("Set level %v, expecting %s", level, test.level)
+			}
+		})
+	}
+}
+
+func TestNormalizeSeverity(t *testing.T) {
+	tests := map[interface{}]string{
+		" Information ": SeverityInfo,
+		"crit":          SeverityCritical,
+		0:               SeverityCritical,
+		4:               SeverityWarning,
+		7:               SeverityDebug,
+		10:              SeverityTrace,
+		20:              SeverityDebug,
+		40:              SeverityWarning,
+		50:              SeverityError,
+		60:              SeverityCritical,
+		-1:              "",
+		"loud":          "",
+	}
+
+	for value, expected := range tests {
+		if level := normalizeSeverity(value); level != expected {
+			t.Errorf("Normalized %v to %q, expecting %q", value, level, expected)
+		}
+	}
+}
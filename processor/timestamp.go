@@ -0,0 +1,158 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+// namedLayouts are the layouts which can be given by name, "unix", "unix_ms", "unix_us" and
+// "unix_ns" are epoch timestamps
+var namedLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RubyDate":    time.RubyDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Stamp":       time.Stamp,
+	"StampMilli":  time.StampMilli,
+}
+
+// commonLayouts are tried in order when no layout is configured
+var commonLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05,999",
+	"2006/01/02 15:04:05.999999999",
+	"02/Jan/2006:15:04:05 -0700",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.UnixDate,
+	time.ANSIC,
+	time.Stamp,
+}
+
+// epochUnits are the units of epoch timestamps by layout name
+var epochUnits = map[string]time.Duration{
+	"unix":    time.Second,
+	"unix_ms": time.Millisecond,
+	"unix_us": time.Microsecond,
+	"unix_ns": time.Nanosecond,
+}
+
+// Timestamp sets the time of events, hence their Timestamp column, from the log content instead
+// of the time they were read. The time is taken from Field when set, e.g. a field extracted by
+// another processor, otherwise from the first group of Regex, or its whole match, in the text.
+type Timestamp struct {
+	Field string
+	Regex *regexp.Regexp
+	// Layout is a Go time layout, a named layout such as RFC3339 or an epoch unit such as unix_ms.
+	// Common layouts are tried when empty.
+	Layout string
+	// Location is the time zone of times without zone, local time by default
+	Location *time.Location
+}
+
+// NewTimestamp creates a timestamp processor, regex is only used when field is empty
+func NewTimestamp(field, regex, layout string) (*Timestamp, error) {
+	p := &Timestamp{Field: field, Layout: layout, Location: time.Local}
+
+	if named, ok := namedLayouts[layout]; ok {
+		p.Layout = named
+	}
+
+	if field == "" && regex != "" {
+		re, err := regexp.Compile(regex)
+		if err != nil {
+			return nil, err
+		}
+		p.Regex = re
+	}
+
+	if p.Field == "" && p.Regex == nil {
+		return nil, fmt.Errorf("Timestamp requires a field or a regular expression")
+	}
+
+	return p, nil
+}
+
+// Process sets the time of e, e is left unchanged if no time is found
+func (p *Timestamp) Process(e *input.Event) bool {
+	var value string
+	if p.Field != "" {
+		v, ok := e.Fields[p.Field]
+		if !ok {
+			return true
+		}
+		value = strings.TrimSpace(fmt.Sprint(v))
+	} else {
+		match := p.Regex.FindStringSubmatch(e.Text)
+		if match == nil {
+			return true
+		}
+		value = match[len(match)-1]
+		if len(match) > 1 {
+			value = match[1]
+		}
+	}
+
+	if t, ok := p.parse(value); ok {
+		e.Time = t
+	}
+
+	return true
+}
+
+func (p *Timestamp) parse(value string) (time.Time, bool) {
+	if unit, ok := epochUnits[p.Layout]; ok {
+		if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(0, epoch*int64(unit)), true
+		}
+
+		epoch, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		// The fraction is scaled apart so it keeps the precision of the float
+		whole, fraction := math.Modf(epoch)
+		return time.Unix(0, int64(whole)*int64(unit)+int64(math.Round(fraction*float64(unit)))), true
+	}
+
+	layouts := commonLayouts
+	if p.Layout != "" {
+		layouts = []string{p.Layout}
+	}
+
+	for _, layout := range layouts {
+		t, err := time.ParseInLocation(layout, value, p.Location)
+		if err != nil {
+			continue
+		}
+
+		// Layouts without year, such as syslog timestamps, are in the current year
+		if t.Year() == 0 {
+			now := time.Now().In(p.Location)
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(time.Hour * 24)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+		}
+
+		return t, true
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,135 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yangl900/log2oms/input"
+)
+
+func TestTimestamp(t *testing.T) {
+	tests := []struct {
+		name   string
+		field  string
+		regex  string
+		layout string
+		event  input.Event
+		// time is the time of the event once processed in RFC3339, "" when it is left unchanged
+		time string
+	}{
+		{
+			name:  "field",
+			field: "ts",
+			event: input.Event{Fields: map[string]interface{}{"ts": " 2018-06-01T12:00:00.5+02:00 "}},
+			time:  "2018-06-01T10:00:00.5Z",
+		},
+		{
+			name:   "named layout",
+			field:  "ts",
+			layout: "RFC1123Z",
+			event:  input.Event{Fields: map[string]interface{}{"ts": "Fri, 01 Jun 2018 12:00:00 +0000"}},
+			time:   "2018-06-01T12:00:00Z",
+		},
+		{
+			name:   "regex group",
+			regex:  `\[([^\]]+)\]`,
+			layout: "02/Jan/2006:15:04:05 -0700",
+			event:  input.Event{Text: `127.0.0.1 - - [01/Jun/2018:12:00:00 -0700] "GET / HTTP/1.1" 200`},
+			time:   "2018-06-01T19:00:00Z",
+		},
+		{
+			name:  "regex match without zone",
+			regex: `^\S+ \S+`,
+			event: input.Event{Text: "2018-06-01 12:00:00,123 INFO started"},
+			time:  "2018-06-01T12:00:00.123Z",
+		},
+		{
+			name:   "unix",
+			field:  "ts",
+			layout: "unix",
+			event:  input.Event{Fields: map[string]interface{}{"ts": 1527854400}},
+			time:   "2018-06-01T12:00:00Z",
+		},
+		{
+			name:   "unix with fraction",
+			field:  "ts",
+			layout: "unix",
+			event:  input.Event{Fields: map[string]interface{}{"ts": "1527854400.25"}},
+			time:   "2018-06-01T12:00:00.25Z",
+		},
+		{
+			name:   "unix milliseconds",
+			field:  "ts",
+			layout: "unix_ms",
+			event:  input.Event{Fields: map[string]interface{}{"ts": "1527854400123"}},
+			time:   "2018-06-01T12:00:00.123Z",
+		},
+		{
+			name:  "missing field",
+			field: "ts",
+			event: input.Event{Fields: map[string]interface{}{"time": "2018-06-01T12:00:00Z"}},
+		},
+		{
+			name:  "no match",
+			regex: `^\d{4}-\d{2}-\d{2}`,
+			event: input.Event{Text: "started"},
+		},
+		{
+			name:   "invalid",
+			field:  "ts",
+			layout: "unix",
+			event:  input.Event{Fields: map[string]interface{}{"ts": "yesterday"}},
+		},
+	}
+
+	read := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := NewTimestamp(test.field, test.regex, test.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Location = time.UTC
+
+			e := test.event
+			e.Time = read
+			if !p.Process(&e) {
+				t.Fatal("Expecting the event to be kept")
+			}
+
+			expected := read
+			if test.time != "" {
+				expected, _ = time.Parse(time.RFC3339Nano, test.time)
+			}
+			if !e.Time.Equal(expected) {
+				t.Errorf("Time is %v, expecting %v", e.Time.UTC(), expected)
+			}
+		})
+	}
+}
+
+func TestTimestampWithoutYear(t *testing.T) {
+	p, err := NewTimestamp("ts", "", "Stamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A time without year is in the past year at most, never in the future
+	now := time.Now()
+	for _, value := range []string{now.Format(time.Stamp), now.Add(72 * time.Hour).Format(time.Stamp)} {
+		e := &input.Event{Fields: map[string]interface{}{"ts": value}}
+		p.Process(e)
+		if e.Time.After(now.Add(24*time.Hour)) || e.Time.Before(now.AddDate(-1, 0, -1)) {
+			t.Errorf("Time of %q is %v", value, e.Time)
+		}
+	}
+}
+
+func TestNewTimestampInvalid(t *testing.T) {
+	if _, err := NewTimestamp("", "", ""); err == nil {
+		t.Error("Expecting a field or a regular expression to be required")
+	}
+	if _, err := NewTimestamp("", "(", ""); err == nil {
+		t.Error("Expecting an invalid regular expression to be rejected")
+	}
+}
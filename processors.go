@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yangl900/log2oms/input"
+	"github.com/yangl900/log2oms/processor"
+)
+
+// processing is how events are processed before they are uploaded
+type processing struct {
+	decoders   []processor.Processor
+	multiline  *processor.MultilineConfig
+	processors []processor.Processor
+}
+
+// newProcessing creates the processing configured by c, routes run last
+func newProcessing(c processorsConfig, routes []routeConfig) (*processing, error) {
+	p := &processing{}
+
+	if c.Charset != "" {
+		charset, err := processor.NewCharset(c.Charset)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid charset: %v", err)
+		}
+
+		p.decoders = append(p.decoders, processor.ForSources(c.CharsetSources, charset))
+	}
+
+	if c.StripANSI {
+		p.decoders = append(p.decoders, processor.StripANSI{})
+	}
+
+	if c.Multiline != nil && c.Multiline.Start != "" {
+		re, err := regexp.Compile(c.Multiline.Start)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid multiline start: %v", err)
+		}
+
+		p.multiline = &processor.MultilineConfig{Start: re, Timeout: c.Multiline.Timeout}
+	}
+
+	if c.JSON {
+		p.processors = append(p.processors, processor.NewJSON())
+	}
+
+	if c.Logfmt {
+		p.processors = append(p.processors, processor.NewLogfmt())
+	}
+
+	if c.CSV != nil {
+		comma := ','
+		if c.CSV.Delimiter == `\t` || strings.ToLower(c.CSV.Delimiter) == "tab" {
+			comma = '\t'
+		} else if c.CSV.Delimiter != "" {
+			comma = []rune(c.CSV.Delimiter)[0]
+		}
+
+		csv := processor.NewCSV(comma, c.CSV.Columns)
+		p.processors = append(p.processors, processor.ForSources(c.CSV.Sources, csv))
+	}
+
+	if c.Regex != nil && c.Regex.Expr != "" {
+		regex, err := processor.NewRegex(c.Regex.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regex: %v", err)
+		}
+
+		p.processors = append(p.processors, processor.ForSources(c.Regex.Sources, regex))
+	}
+
+	if c.Grok != nil && c.Grok.Expr != "" {
+		grok, err := processor.NewGrok(c.Grok.Expr, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid grok: %v", err)
+		}
+
+		p.processors = append(p.processors, processor.ForSources(c.Grok.Sources, grok))
+	}
+
+	if c.AccessLog != nil && c.AccessLog.Format != "" {
+		accessLog, err := processor.NewAccessLog(c.AccessLog.Format)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid access log: %v", err)
+		}
+
+		p.processors = append(p.processors, processor.ForSources(c.AccessLog.Sources, accessLog))
+	}
+
+	if c.Timestamp != nil && (c.Timestamp.Field != "" || c.Timestamp.Regex != "") {
+		timestamp, err := processor.NewTimestamp(c.Timestamp.Field, c.Timestamp.Regex, c.Timestamp.Layout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid timestamp regex: %v", err)
+		}
+
+		p.processors = append(p.processors, timestamp)
+	}
+
+	if c.Severity {
+		p.processors = append(p.processors, processor.NewSeverity())
+	}
+
+	if len(c.Include) > 0 || len(c.Exclude) > 0 {
+		include, err := newFilterRules(c.Include)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid include: %v", err)
+		}
+		exclude, err := newFilterRules(c.Exclude)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid exclude: %v", err)
+		}
+
+		p.processors = append(p.processors, processor.NewFilter(include, exclude))
+	}
+
+	if c.Sample != nil {
+		rate := 1.0
+		if c.Sample.Rate != nil {
+			rate = *c.Sample.Rate
+		}
+
+		rates := []float64{rate}
+		for _, r := range c.Sample.Rates {
+			rates = append(rates, r)
+		}
+		for _, r := range rates {
+			if r < 0 || r > 1 {
+				return nil, fmt.Errorf("Invalid sample rate %v, expected between 0 and 1", r)
+			}
+		}
+
+		p.processors = append(p.processors, processor.NewSample(rate, c.Sample.Field, c.Sample.Rates))
+	}
+
+	if c.DedupWindow > 0 {
+		p.processors = append(p.processors, processor.NewDedup(c.DedupWindow))
+	}
+
+	if c.Redact != nil {
+		var custom []*regexp.Regexp
+		for _, expr := range c.Redact.Custom {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid redact pattern: %v", err)
+			}
+			custom = append(custom, re)
+		}
+
+		redact, err := processor.NewRedact(c.Redact.Patterns, custom)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid redact: %v", err)
+		}
+		if c.Redact.Placeholder != "" {
+			redact.Placeholder = c.Redact.Placeholder
+		}
+
+		p.processors = append(p.processors, redact)
+	}
+
+	if len(routes) > 0 {
+		var rules []processor.RouteRule
+		for _, route := range routes {
+			rule := processor.RouteRule{LogType: route.LogType, Field: route.Field, Value: route.Value}
+			if route.Match != "" {
+				re, err := regexp.Compile(route.Match)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid route match: %v", err)
+				}
+				rule.Match = re
+			}
+			rules = append(rules, rule)
+		}
+
+		p.processors = append(p.processors, processor.NewRoute(rules))
+	}
+
+	return p, nil
+}
+
+// newFilterRules compiles the rules of a filter
+func newFilterRules(filters []filterConfig) ([]processor.FilterRule, error) {
+	var rules []processor.FilterRule
+	for _, filter := range filters {
+		if filter.Match == "" && filter.Contains == "" {
+			return nil, fmt.Errorf("Filter has neither match nor contains")
+		}
+
+		rule := processor.FilterRule{Field: filter.Field, Contains: filter.Contains}
+		if filter.Match != "" {
+			re, err := regexp.Compile(filter.Match)
+			if err != nil {
+				return nil, err
+			}
+			rule.Match = re
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// apply decodes the events of in, joins multiline records then runs the processors in order
+func (p *processing) apply(in input.Input) input.Input {
+	in = processor.Apply(in, p.decoders...)
+	if p.multiline != nil {
+		in = processor.Multiline(in, *p.multiline)
+	}
+
+	return processor.Apply(in, p.processors...)
+}
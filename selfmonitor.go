@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/yangl900/log2oms/logclient"
+)
+
+const (
+	defaultHealthInterval = time.Minute
+)
+
+// healthCounters are the counters of an output when its health was last reported
+type healthCounters struct {
+	read  int64
+	stats logclient.BatcherStats
+}
+
+// reportHealth periodically uploads a record per pipeline output with the activity of the
+// output since the previous report, as the health log type of the configuration, until the agent
+// stops. Nothing is reported while the health log type is not set.
+func (a *agent) reportHealth() {
+	previous := map[*pipelineOutput]healthCounters{}
+	last := time.Now()
+
+	a.mu.Lock()
+	interval := a.config.HealthInterval
+	a.mu.Unlock()
+
+	for {
+		if interval <= 0 {
+			interval = defaultHealthInterval
+		}
+		time.Sleep(interval)
+
+		// The configuration may have been reloaded meanwhile
+		a.mu.Lock()
+		logType, stopped := a.config.HealthLogType, a.stopped
+		interval = a.config.HealthInterval
+		a.mu.Unlock()
+		if stopped {
+			return
+		}
+		if logType == "" {
+			continue
+		}
+
+		now := time.Now()
+		seen := map[*pipelineOutput]healthCounters{}
+		for _, p := range a.current() {
+			read := atomic.LoadInt64(&p.counters.read)
+			for _, output := range p.outputs {
+				current := healthCounters{read: read, stats: output.batcher.Stats()}
+				seen[output] = current
+				output.batcher.EnqueueRecord(healthRecord(logType, p, output, current, previous[output], now.Sub(last)))
+			}
+		}
+		previous, last = seen, now
+	}
+}
+
+// healthRecord describes the activity of an output since the previous report
+func healthRecord(logType string, p *pipeline, output *pipelineOutput, current, previous healthCounters, elapsed time.Duration) logclient.Record {
+	record := logclient.Record{
+		logclient.LogTypeField: logType,
+		"Pipeline":             p.config.Name,
+		"Output":               output.settings.name,
+		"IntervalSeconds":      int64(elapsed.Seconds()),
+		"LinesRead":            current.read - previous.read,
+		"RecordsSent":          current.stats.Records - previous.stats.Records,
+		"RecordsFailed":        current.stats.Failed - previous.stats.Failed,
+		"RecordsDropped":       current.stats.Dropped - previous.stats.Dropped,
+		"BytesSent":            current.stats.Bytes - previous.stats.Bytes,
+		"Retries":              current.stats.Retries - previous.stats.Retries,
+		"Queued":               current.stats.Queued,
+		"Retrying":             current.stats.Retrying,
+	}
+	if current.stats.LastError != nil && current.stats.LastErrorTime.After(previous.stats.LastErrorTime) {
+		record["LastError"] = current.stats.LastError.Error()
+	}
+
+	return record
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "fmt"
+
+// serviceCommand fails as services are managed by the init system outside Windows
+func serviceCommand(cmd *command, args []string) error {
+	return fmt.Errorf("Windows services are only supported on Windows, see samples/systemd for systemd")
+}
@@ -0,0 +1,256 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/yangl900/log2oms/logging"
+)
+
+const (
+	serviceName        = "log2oms"
+	serviceDescription = "Ships logs to Azure Log Analytics"
+	// eventSourceKey registers log2oms as source of events in the Application log
+	eventSourceKey = `HKLM\SYSTEM\CurrentControlSet\Services\EventLog\Application\` + serviceName
+	// eventMessageFile holds messages displaying the text of events as is, for event ids 1 to 1000
+	eventMessageFile = `%SystemRoot%\System32\EventCreate.exe`
+	eventID          = 1
+
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped     = 1
+	serviceStopPending = 3
+	serviceRunning     = 4
+
+	serviceAcceptStop     = 0x1
+	serviceAcceptShutdown = 0x4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	errorCallNotImplemented   = 120
+	errorServiceSpecificError = 1066
+	eventlogErrorType         = 0x1
+	eventlogInformationType   = 0x4
+)
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+	procRegisterEventSourceW          = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource         = advapi32.NewProc("DeregisterEventSource")
+	procReportEventW                  = advapi32.NewProc("ReportEventW")
+)
+
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+// service is the state of log2oms run by the service manager
+var service struct {
+	args   []string
+	handle uintptr
+	err    error
+}
+
+// serviceCommand installs, uninstalls, starts or stops the service. The service manager runs the
+// service with "service run" followed by the flags given to install, which are those of run.
+func serviceCommand(cmd *command, args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		return &exitError{exitUsage, fmt.Errorf("Usage: log2oms service install [run flags] | uninstall | start | stop")}
+	}
+
+	switch args[0] {
+	case "install":
+		return installService(args[1:])
+	case "uninstall":
+		return uninstallService()
+	case "start":
+		return runTool("sc.exe", "start", serviceName)
+	case "stop":
+		return runTool("sc.exe", "stop", serviceName)
+	case "run":
+		return runService(args[1:])
+	}
+
+	return &exitError{exitUsage, fmt.Errorf("Unknown service action %s, expecting install, uninstall, start or stop", args[0])}
+}
+
+// installService creates the service, started automatically, running log2oms with runArgs
+func installService(runArgs []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	commandLine := []string{syscall.EscapeArg(exe), "service", "run"}
+	for _, arg := range absoluteConfig(runArgs) {
+		commandLine = append(commandLine, syscall.EscapeArg(arg))
+	}
+
+	if err := runTool("sc.exe", "create", serviceName, "binPath=", strings.Join(commandLine, " "), "start=", "auto", "DisplayName=", serviceName); err != nil {
+		return err
+	}
+	if err := runTool("sc.exe", "description", serviceName, serviceDescription); err != nil {
+		return err
+	}
+	if err := runTool("reg.exe", "add", eventSourceKey, "/v", "EventMessageFile", "/t", "REG_EXPAND_SZ", "/d", eventMessageFile, "/f"); err != nil {
+		return err
+	}
+	if err := runTool("reg.exe", "add", eventSourceKey, "/v", "TypesSupported", "/t", "REG_DWORD", "/d", "7", "/f"); err != nil {
+		return err
+	}
+
+	logging.Infof("Installed service %s: %s", serviceName, strings.Join(commandLine, " "))
+	return nil
+}
+
+// uninstallService deletes the service and its event source, a running service is deleted once
+// stopped
+func uninstallService() error {
+	if err := runTool("sc.exe", "delete", serviceName); err != nil {
+		return err
+	}
+	if err := runTool("reg.exe", "delete", eventSourceKey, "/f"); err != nil {
+		logging.Warnf("%v", err)
+	}
+
+	logging.Infof("Uninstalled service %s", serviceName)
+	return nil
+}
+
+// absoluteConfig makes the path given to --config absolute, services run in the system directory
+func absoluteConfig(args []string) []string {
+	args = append([]string(nil), args...)
+	for i, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		switch {
+		case name == "config" && i+1 < len(args):
+			if path, err := filepath.Abs(args[i+1]); err == nil {
+				args[i+1] = path
+			}
+		case strings.HasPrefix(name, "config=") && arg != name:
+			if path, err := filepath.Abs(strings.TrimPrefix(name, "config=")); err == nil {
+				args[i] = "--config=" + path
+			}
+		}
+	}
+
+	return args
+}
+
+func runTool(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v %s", name, args[0], err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// runService connects to the service manager, which calls serviceMain, and returns once the
+// service stopped
+func runService(args []string) error {
+	service.args = args
+
+	name, _ := syscall.UTF16PtrFromString(serviceName)
+	table := []serviceTableEntry{{name: name, proc: syscall.NewCallback(serviceMain)}, {}}
+	if r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0]))); r == 0 {
+		return fmt.Errorf("Failed to connect to the service manager, 'service run' is only run by it: %v", err)
+	}
+
+	return service.err
+}
+
+// serviceMain runs the pipelines until the service manager stops the service
+func serviceMain(argc, argv uintptr) uintptr {
+	name, _ := syscall.UTF16PtrFromString(serviceName)
+	service.handle, _, _ = procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(name)), syscall.NewCallback(serviceHandler), 0)
+
+	setServiceStatus(serviceRunning, nil)
+	reportEvent(eventlogInformationType, "log2oms service started")
+
+	err := runCommand(commands()[0], service.args)
+	if err != nil {
+		reportEvent(eventlogErrorType, fmt.Sprintf("log2oms service failed: %v", err))
+	} else {
+		reportEvent(eventlogInformationType, "log2oms service stopped")
+	}
+
+	service.err = err
+	setServiceStatus(serviceStopped, err)
+	return 0
+}
+
+// serviceHandler handles the controls of the service manager, stop and shutdown stop reading the
+// inputs as SIGTERM does
+func serviceHandler(control, eventType, eventData, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		setServiceStatus(serviceStopPending, nil)
+		reportEvent(eventlogInformationType, "log2oms service stopping, flushing logs")
+		select {
+		case signals <- syscall.SIGTERM:
+		default:
+		}
+	case serviceControlInterrogate:
+	default:
+		return errorCallNotImplemented
+	}
+
+	return 0
+}
+
+// setServiceStatus reports the state of the service, err is the reason of a stop
+func setServiceStatus(state uint32, err error) {
+	status := serviceStatus{serviceType: serviceWin32OwnProcess, currentState: state}
+	if state == serviceRunning {
+		status.controlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+	}
+	if err != nil {
+		status.win32ExitCode = errorServiceSpecificError
+		status.serviceSpecificExitCode = exitFailure
+		if e, ok := err.(*exitError); ok {
+			status.serviceSpecificExitCode = uint32(e.status)
+		}
+	}
+
+	procSetServiceStatus.Call(service.handle, uintptr(unsafe.Pointer(&status)))
+}
+
+// reportEvent writes an event with message to the Application event log
+func reportEvent(eventType uint16, message string) {
+	name, _ := syscall.UTF16PtrFromString(serviceName)
+	handle, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(name)))
+	if handle == 0 {
+		logging.Warnf("Failed to report event: %v", err)
+		return
+	}
+	defer procDeregisterEventSource.Call(handle)
+
+	text, _ := syscall.UTF16PtrFromString(message)
+	messages := []*uint16{text}
+	procReportEventW.Call(handle, uintptr(eventType), 0, eventID, 0, uintptr(len(messages)), 0, uintptr(unsafe.Pointer(&messages[0])), 0)
+}
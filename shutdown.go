@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/yangl900/log2oms/logging"
+	"github.com/yangl900/log2oms/tail"
+)
+
+const (
+	defaultDrainTimeout = time.Second * 30
+)
+
+// signals receives SIGINT and SIGTERM, and the stop requests of the Windows service manager
+var signals = make(chan os.Signal, 2)
+
+// stopOnSignal calls stop to stop reading the inputs on SIGINT or SIGTERM and returns the deadline for
+// uploading the logs read until then. The process exits without waiting any longer once the
+// deadline passes or on a second signal, saving the checkpoints of the logs uploaded so far.
+func stopOnSignal(stop func(), checkpoints *tail.Checkpoints, drainTimeout time.Duration) <-chan time.Time {
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	deadline := make(chan time.Time, 1)
+	go func() {
+		sig := <-signals
+		logging.Infof("Received %s, flushing logs within %s", sig, drainTimeout)
+		deadline <- time.Now().Add(drainTimeout)
+		stop()
+
+		select {
+		case sig = <-signals:
+			logging.Warnf("Received %s again, exiting without flushing logs", sig)
+		case <-time.After(drainTimeout):
+			logging.Errorf("Logs not flushed within %s, exiting", drainTimeout)
+		}
+
+		checkpoints.Close()
+		removePIDFile()
+		os.Exit(1)
+	}()
+
+	return deadline
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/yangl900/log2oms/input"
+	"github.com/yangl900/log2oms/logging"
+	"github.com/yangl900/log2oms/tail"
+)
+
+// dumpStats logs the counters of each pipeline and output, their queues and last errors, and the
+// offsets checkpointed in the files followed
+func (a *agent) dumpStats(checkpoints *tail.Checkpoints) {
+	for _, p := range a.current() {
+		running := true
+		select {
+		case <-p.done:
+			running = false
+		default:
+		}
+
+		logger := logging.Default().With("pipeline", p.config.Name)
+		logger.With("running", running).
+			With("lines_read", atomic.LoadInt64(&p.counters.read)).
+			With("enqueued", atomic.LoadInt64(&p.counters.enqueued)).
+			Infof("Pipeline stats")
+
+		for _, output := range p.outputs {
+			stats, state := output.batcher.Stats(), output.batcher.RetryState()
+			outputLogger := logger.With("output", output.settings.name).
+				With("queued", stats.Queued).
+				With("retrying", stats.Retrying).
+				With("spool_bytes", state.Bytes).
+				With("dropped", stats.Dropped).
+				With("records_sent", stats.Records).
+				With("records_failed", stats.Failed).
+				With("retries", stats.Retries)
+			if output.settings.batch.Adaptive {
+				outputLogger = outputLogger.With("batch_records", stats.BatchRecords).
+					With("batch_bytes", stats.BatchBytes).
+					With("flush_interval", stats.FlushInterval)
+			}
+			if !stats.LastSuccess.IsZero() {
+				outputLogger = outputLogger.With("last_success", stats.LastSuccess.UTC().Format(time.RFC3339))
+			}
+			if stats.LastError != nil {
+				outputLogger = outputLogger.With("last_error", stats.LastError).With("last_error_time", stats.LastErrorTime.UTC().Format(time.RFC3339))
+			}
+			outputLogger.Infof("Output stats")
+		}
+	}
+
+	offsets := checkpoints.Offsets()
+	paths := make([]string, 0, len(offsets))
+	for path := range offsets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		logging.Default().With("file", path).With("offset", offsets[path]).Infof("Checkpoint")
+	}
+}
+
+// reopen reopens the files read by the pipelines, e.g. once logrotate rotated them
+func (a *agent) reopen() {
+	for _, p := range a.current() {
+		if r, ok := p.source.(input.Reopener); ok {
+			r.Reopen()
+		}
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/yangl900/log2oms/logging"
+	"github.com/yangl900/log2oms/tail"
+)
+
+// handleUserSignals dumps the stats of the agent on SIGUSR1, and reopens the files read on SIGUSR2
+func (a *agent) handleUserSignals(checkpoints *tail.Checkpoints) {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	for sig := range received {
+		switch sig {
+		case syscall.SIGUSR1:
+			a.dumpStats(checkpoints)
+		case syscall.SIGUSR2:
+			logging.Infof("Received %s, reopening files", sig)
+			a.reopen()
+		}
+	}
+}
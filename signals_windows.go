@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "github.com/yangl900/log2oms/tail"
+
+// handleUserSignals does nothing, Windows has no SIGUSR1 and SIGUSR2
+func (a *agent) handleUserSignals(checkpoints *tail.Checkpoints) {
+}
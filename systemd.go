@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/yangl900/log2oms/logging"
+)
+
+// notify sends a state change, e.g. "READY=1", to systemd. It does nothing when log2oms is not
+// run by systemd as a service of Type=notify, failures are only logged.
+func notify(state string) {
+	socket := os.Getenv(envNotifySocket)
+	if socket == "" {
+		return
+	}
+
+	// An address starting with @ is in the abstract namespace, which net handles
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err == nil {
+		_, err = conn.Write([]byte(state))
+		conn.Close()
+	}
+	if err != nil {
+		logging.Warnf("Failed to notify systemd of %s: %v", state, err)
+	}
+}
+
+// watchdogInterval returns the watchdog timeout systemd expects heartbeats within, zero when the
+// watchdog is not enabled for this process
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv(envWatchdogUsec), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv(envWatchdogPID); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// heartbeat sends watchdog heartbeats at half the timeout while the agent is live, so systemd
+// restarts log2oms when an input stops or the agent hangs, e.g. waiting for a lock
+func (a *agent) heartbeat(timeout time.Duration) {
+	for {
+		time.Sleep(timeout / 2)
+
+		if a.health().Live {
+			notify("WATCHDOG=1")
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package tail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	checkpointSaveInterval = time.Second * 5
+)
+
+// checkpoint is the position reached in a file
+type checkpoint struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+}
+
+// Checkpoints persists the offset reached in each followed file, keyed by file identity (device
+// and inode) so a renamed file is still recognized. Files opened by a tailer configured with
+// checkpoints resume at their offset instead of Config.Offset.
+type Checkpoints struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]checkpoint
+	dirty   bool
+	done    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// LoadCheckpoints reads the checkpoints saved in the file at path, if any, and saves them back
+// periodically until Close. Entries of files which no longer exist are dropped. An empty path
+// keeps the checkpoints in memory only, so files reopened by the process resume where they were.
+func LoadCheckpoints(path string) (*Checkpoints, error) {
+	c := &Checkpoints{path: path, entries: map[string]checkpoint{}, done: make(chan struct{})}
+	if path == "" {
+		return c, nil
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read checkpoints: %v", err)
+	}
+	if len(buf) > 0 {
+		var entries map[string]checkpoint
+		if err := json.Unmarshal(buf, &entries); err != nil {
+			return nil, fmt.Errorf("Failed to read checkpoints: %v", err)
+		}
+
+		for key, entry := range entries {
+			if info, err := os.Stat(entry.Path); err == nil && fileKey(info) == key {
+				c.entries[key] = entry
+			}
+		}
+	}
+
+	c.wg.Add(1)
+	go c.savePeriodically()
+
+	return c, nil
+}
+
+// offset returns the checkpoint of a file
+func (c *Checkpoints) offset(info os.FileInfo) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[fileKey(info)]
+	return entry.Offset, ok
+}
+
+// set records the offset reached in a file
+func (c *Checkpoints) set(info os.FileInfo, path string, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[fileKey(info)] = checkpoint{Path: path, Offset: offset}
+	c.dirty = true
+}
+
+// Offsets returns the offset checkpointed in each file by path
+func (c *Checkpoints) Offsets() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	offsets := make(map[string]int64, len(c.entries))
+	for _, entry := range c.entries {
+		offsets[entry.Path] = entry.Offset
+	}
+
+	return offsets
+}
+
+// Close stops saving periodically and saves the checkpoints a last time
+func (c *Checkpoints) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+	})
+	c.wg.Wait()
+
+	return c.save()
+}
+
+func (c *Checkpoints) savePeriodically() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(checkpointSaveInterval):
+			c.save()
+		}
+	}
+}
+
+// save writes the checkpoints if they changed, replacing the file atomically
+func (c *Checkpoints) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty || c.path == "" {
+		return nil
+	}
+
+	buf, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("Failed to save checkpoints: %v", err)
+	}
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return fmt.Errorf("Failed to save checkpoints: %v", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("Failed to save checkpoints: %v", err)
+	}
+
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,106 @@
+package tail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpoints(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	path, checkpointPath := filepath.Join(dir, "app.log"), filepath.Join(dir, "state", "checkpoints.json")
+	appendFile(t, path, "first\nsecond\n")
+
+	checkpoints, err := LoadCheckpoints(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailer := Follow(path, Config{PollInterval: 10 * time.Millisecond, Checkpoints: checkpoints})
+	first, second := <-tailer.Lines, <-tailer.Lines
+	tailer.Stop()
+
+	// Only the lines acknowledged are checkpointed
+	first.Ack()
+	if err := checkpoints.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if second.Text != "second" || checkpoints.Offsets()[path] != first.Offset {
+		t.Fatalf("Checkpointed %v, expecting %s at %d", checkpoints.Offsets(), path, first.Offset)
+	}
+
+	// The file resumes after the line acknowledged instead of the offset configured
+	if checkpoints, err = LoadCheckpoints(checkpointPath); err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoints.Close()
+	tailer = Follow(path, Config{PollInterval: 10 * time.Millisecond, Checkpoints: checkpoints, Offset: second.Offset})
+	defer tailer.Stop()
+	expectLines(t, tailer, "second")
+}
+
+func TestLoadCheckpoints(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	kept, removed := filepath.Join(dir, "kept.log"), filepath.Join(dir, "removed.log")
+	checkpointPath := filepath.Join(dir, "checkpoints.json")
+
+	checkpoints, err := LoadCheckpoints(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{kept, removed} {
+		appendFile(t, path, "line\n")
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkpoints.set(info, path, 5)
+	}
+	if err := checkpoints.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries of files which no longer exist are dropped
+	os.Remove(removed)
+	if checkpoints, err = LoadCheckpoints(checkpointPath); err != nil {
+		t.Fatal(err)
+	}
+	checkpoints.Close()
+	if offsets := checkpoints.Offsets(); len(offsets) != 1 || offsets[kept] != 5 {
+		t.Errorf("Loaded %v, expecting %s at 5", offsets, kept)
+	}
+
+	if err := ioutil.WriteFile(checkpointPath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCheckpoints(checkpointPath); err == nil {
+		t.Error("Expecting a corrupted file to be reported")
+	}
+}
+
+func TestCheckpointsInMemory(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "line\n")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without path, checkpoints are not saved but still resume files reopened by the process
+	checkpoints, err := LoadCheckpoints("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints.set(info, path, 5)
+	if err := checkpoints.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok := checkpoints.offset(info); !ok || offset != 5 {
+		t.Errorf("Checkpointed %d, expecting 5", offset)
+	}
+}
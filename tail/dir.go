@@ -0,0 +1,110 @@
+package tail
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultScanInterval = time.Second * 10
+)
+
+// DirConfig selects the files followed in a directory tree. Patterns use the filepath.Match
+// syntax and are matched against both the slash separated path relative to the root and the
+// file name, so "*.log" matches log files at any depth and "nginx/*.log" only in nginx.
+type DirConfig struct {
+	// Include selects the files to follow, all files when empty
+	Include []string
+	// Exclude skips files and directories matching any pattern, even if they are included
+	Exclude []string
+	// ScanInterval is how often the tree is scanned for new and removed files, defaults to
+	// Config.ScanInterval
+	ScanInterval time.Duration
+	// Filter, when set, is called with the path of each selected file and skips it if it returns false
+	Filter func(path string) bool
+}
+
+// WatchDir follows the files under root matching dirConfig, starting and stopping tailers as
+// files appear and disappear
+func WatchDir(root string, dirConfig DirConfig, config Config) (*MultiTailer, error) {
+	for _, pattern := range append(dirConfig.Include, dirConfig.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if dirConfig.ScanInterval <= 0 {
+		dirConfig.ScanInterval = scanInterval(config)
+	}
+
+	m := newMultiTailer(config)
+	list := func() []string {
+		return scanDir(root, dirConfig)
+	}
+
+	for _, path := range list() {
+		m.Add(path)
+	}
+	m.discover(list, dirConfig.ScanInterval)
+
+	return m, nil
+}
+
+// scanInterval returns the scan interval of config, or its default
+func scanInterval(config Config) time.Duration {
+	if config.ScanInterval <= 0 {
+		return defaultScanInterval
+	}
+
+	return config.ScanInterval
+}
+
+// scanDir lists the files under root selected by dirConfig
+func scanDir(root string, dirConfig DirConfig) []string {
+	var paths []string
+
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == root {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		if matchAny(dirConfig.Exclude, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			// Follow links to files, e.g. kubernetes container logs
+			if target, err := os.Stat(path); err == nil && target.Mode().IsRegular() {
+				info = target
+			}
+		}
+
+		if info.Mode().IsRegular() && (len(dirConfig.Include) == 0 || matchAny(dirConfig.Include, rel)) &&
+			(dirConfig.Filter == nil || dirConfig.Filter(path)) {
+			paths = append(paths, path)
+		}
+
+		return nil
+	})
+
+	return paths
+}
+
+func matchAny(patterns []string, rel string) bool {
+	slashed, name := filepath.ToSlash(rel), filepath.Base(rel)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, slashed); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
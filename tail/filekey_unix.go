@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package tail
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileKey identifies a file by device and inode
+func fileKey(info os.FileInfo) string {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d:%d", stat.Dev, stat.Ino)
+	}
+
+	return info.Name()
+}
@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package tail
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileKey identifies a file by name and creation time, as file indexes are not exposed by
+// os.FileInfo on Windows
+func fileKey(info os.FileInfo) string {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return fmt.Sprintf("%s:%d", info.Name(), data.CreationTime.Nanoseconds())
+	}
+
+	return info.Name()
+}
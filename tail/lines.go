@@ -0,0 +1,102 @@
+package tail
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// DroppedLineError reports a line longer than MaxLineSize skipped by LineDrop
+type DroppedLineError struct {
+	Size        int64
+	MaxLineSize int
+}
+
+func (e *DroppedLineError) Error() string {
+	return fmt.Sprintf("Dropped a line of %d bytes, longer than %d bytes", e.Size, e.MaxLineSize)
+}
+
+// LineReader reads the lines of a stream, e.g. stdin, ending as LineEnd of its config tells and
+// bounded by MaxLineSize as LinePolicy tells, so a stream without delimiters is not held in memory
+type LineReader struct {
+	reader *bufio.Reader
+	config Config
+	line   []byte
+	// rest is what was read after the last piece of a split line, with the error of that read
+	rest    []byte
+	restErr error
+	split   bool
+	// err ends reading once the line read before it is returned
+	err error
+}
+
+// NewLineReader reads the lines of r, Offset, PollInterval, ScanInterval and Checkpoints of
+// config are ignored
+func NewLineReader(r io.Reader, config Config) *LineReader {
+	return &LineReader{reader: bufio.NewReader(r), config: config}
+}
+
+// ReadLine returns the next line without its delimiter, a last line without delimiter is
+// returned before io.EOF. Lines longer than MaxLineSize are truncated or split, or skipped with
+// a *DroppedLineError after which reading goes on.
+func (r *LineReader) ReadLine() (string, error) {
+	if r.err != nil {
+		return "", r.err
+	}
+
+	delimiter, max := r.config.LineEnd.Byte(), r.config.MaxLineSize
+	r.line = r.line[:0]
+	overflow := int64(0)
+	for {
+		chunk, err := r.rest, r.restErr
+		if chunk == nil {
+			chunk, err = r.reader.ReadSlice(delimiter)
+		}
+		r.rest, r.restErr = nil, nil
+
+		text := chunk
+		if err == nil {
+			text = chunk[:len(chunk)-1]
+		}
+		if max > 0 && len(r.line)+len(text) > max {
+			n := max - len(r.line)
+			r.line = append(r.line, text[:n]...)
+			if r.config.LinePolicy == LineSplit {
+				// The rest of the line is the beginning of the next piece
+				r.rest, r.restErr, r.split = append([]byte(nil), chunk[n:]...), err, true
+				return string(r.line), nil
+			}
+			overflow += int64(len(text) - n)
+		} else {
+			r.line = append(r.line, text...)
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if len(r.line) == 0 && overflow == 0 {
+				return "", err
+			}
+			r.err = err
+		}
+		break
+	}
+
+	split := r.split
+	r.split = false
+	switch {
+	case overflow > 0 && r.config.LinePolicy == LineDrop:
+		return "", &DroppedLineError{Size: int64(len(r.line)) + overflow, MaxLineSize: max}
+	case split && len(r.line) == 0:
+		// The line ended right at the end of its last piece
+		return r.ReadLine()
+	}
+
+	line := r.line
+	if r.config.LineEnd.StripCR && len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+
+	return string(line), nil
+}
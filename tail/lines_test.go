@@ -0,0 +1,72 @@
+package tail
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLineReader(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+
+	tests := []struct {
+		name   string
+		input  string
+		config Config
+		// lines are the lines read, "!" standing for a dropped line
+		lines []string
+	}{
+		{"newlines", "a\nb\n\nc", Config{}, []string{"a", "b", "", "c"}},
+		{"crlf", "a\r\nb\r\nc\r", Config{LineEnd: LineEnd{StripCR: true}}, []string{"a", "b", "c"}},
+		{"carriage return kept", "a\r\n", Config{}, []string{"a\r"}},
+		{"nul", "a\x00b\nc\x00", Config{LineEnd: LineEnd{Delimiter: "\x00"}}, []string{"a", "b\nc"}},
+		{"longer than the buffer", long + "\nb\n", Config{}, []string{long, "b"}},
+		{"truncate", "abcdef\nab\nabcd\n", Config{MaxLineSize: 4}, []string{"abcd", "ab", "abcd"}},
+		{"truncate beyond the buffer", long + "\nb\n", Config{MaxLineSize: 5000}, []string{long[:5000], "b"}},
+		{"split", "abcdefghij\nab\n", Config{MaxLineSize: 4, LinePolicy: LineSplit}, []string{"abcd", "efgh", "ij", "ab"}},
+		{"split at the end of a piece", "abcdefgh\nab\n", Config{MaxLineSize: 4, LinePolicy: LineSplit}, []string{"abcd", "efgh", "ab"}},
+		{"split without delimiter", "abcdefghij", Config{MaxLineSize: 4, LinePolicy: LineSplit}, []string{"abcd", "efgh", "ij"}},
+		{"split beyond the buffer", long, Config{MaxLineSize: 6000, LinePolicy: LineSplit}, []string{long[:6000], long[6000:]}},
+		{"drop", "abcdef\nab\nabcdefgh", Config{MaxLineSize: 4, LinePolicy: LineDrop}, []string{"!", "ab", "!"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewLineReader(strings.NewReader(test.input), test.config)
+
+			var lines []string
+			for {
+				line, err := r.ReadLine()
+				if err == io.EOF {
+					break
+				}
+				if dropped, ok := err.(*DroppedLineError); ok {
+					if dropped.MaxLineSize != test.config.MaxLineSize {
+						t.Errorf("Dropped with maximum size %d", dropped.MaxLineSize)
+					}
+					line = "!"
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				lines = append(lines, line)
+			}
+
+			if fmt.Sprintf("%q", lines) != fmt.Sprintf("%q", test.lines) {
+				t.Errorf("Read %.100q, expecting %.100q", lines, test.lines)
+			}
+		})
+	}
+}
+
+func TestLineReaderDroppedSize(t *testing.T) {
+	r := NewLineReader(strings.NewReader(strings.Repeat("x", 10000)+"\n"), Config{MaxLineSize: 100, LinePolicy: LineDrop})
+
+	_, err := r.ReadLine()
+	if dropped, ok := err.(*DroppedLineError); !ok || dropped.Size != 10000 {
+		t.Fatalf("Expecting a dropped line of 10000 bytes, got %v", err)
+	}
+	if _, err := r.ReadLine(); err != io.EOF {
+		t.Fatalf("Expecting the end, got %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package tail
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MultiTailer follows several files concurrently and delivers their lines on a single Lines
+// channel, which is closed after Stop
+type MultiTailer struct {
+	Lines chan *Line
+
+	config  Config
+	mu      sync.Mutex
+	stopped bool
+	tailers map[string]*Tailer
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// FollowGlob follows every file matched by the glob patterns, see filepath.Match for the syntax.
+// A pattern without glob characters is followed even if the file does not exist yet. Patterns
+// are matched again every Config.ScanInterval, so files created later, e.g. date stamped logs,
+// are followed too, and files removed are no longer followed.
+func FollowGlob(patterns []string, config Config) (*MultiTailer, error) {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	m := newMultiTailer(config)
+	list := func() []string {
+		return expand(patterns)
+	}
+
+	for _, path := range list() {
+		m.Add(path)
+	}
+	for _, pattern := range patterns {
+		if hasMeta(pattern) {
+			m.discover(list, scanInterval(config))
+			break
+		}
+	}
+
+	return m, nil
+}
+
+func newMultiTailer(config Config) *MultiTailer {
+	return &MultiTailer{
+		Lines:   make(chan *Line),
+		config:  config,
+		tailers: map[string]*Tailer{},
+		done:    make(chan struct{}),
+	}
+}
+
+// discover keeps the set of followed files in sync with what list returns, checking every
+// interval. A file is only dropped once it is missing from two consecutive lists, so a file
+// being rotated is not dropped before its tailer has reopened it.
+func (m *MultiTailer) discover(list func() []string, interval time.Duration) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		missing := map[string]bool{}
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-time.After(interval):
+			}
+
+			found := map[string]bool{}
+			for _, path := range list() {
+				found[path] = true
+				m.Add(path)
+			}
+
+			for _, path := range m.Files() {
+				if found[path] {
+					delete(missing, path)
+				} else if missing[path] {
+					delete(missing, path)
+					m.Remove(path)
+				} else {
+					missing[path] = true
+				}
+			}
+		}
+	}()
+}
+
+// Add starts following the file at path unless it is already followed
+func (m *MultiTailer) Add(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tailers[path]; ok || m.stopped {
+		return
+	}
+
+	t := Follow(path, m.config)
+	m.tailers[path] = t
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for line := range t.Lines {
+			select {
+			case m.Lines <- line:
+			case <-m.done:
+			}
+		}
+	}()
+}
+
+// Remove stops following the file at path
+func (m *MultiTailer) Remove(path string) {
+	m.mu.Lock()
+	t, ok := m.tailers[path]
+	delete(m.tailers, path)
+	m.mu.Unlock()
+
+	if ok {
+		t.Stop()
+	}
+}
+
+// Files returns the paths currently followed
+func (m *MultiTailer) Files() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make([]string, 0, len(m.tailers))
+	for path := range m.tailers {
+		files = append(files, path)
+	}
+
+	return files
+}
+
+// Reopen reopens all files followed, see Tailer.Reopen
+func (m *MultiTailer) Reopen() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tailers {
+		t.Reopen()
+	}
+}
+
+// Stop stops following all files and closes Lines
+func (m *MultiTailer) Stop() {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return
+	}
+	m.stopped = true
+	tailers := m.tailers
+	m.tailers = map[string]*Tailer{}
+	m.mu.Unlock()
+
+	close(m.done)
+	for _, t := range tailers {
+		t.Stop()
+	}
+
+	m.wg.Wait()
+	close(m.Lines)
+}
+
+// expand resolves glob patterns to file paths, patterns without glob characters are kept as is
+func expand(patterns []string) []string {
+	var paths []string
+	seen := map[string]bool{}
+
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if hasMeta(pattern) {
+			matches, _ = filepath.Glob(pattern)
+		}
+
+		for _, path := range matches {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	return paths
+}
+
+func hasMeta(pattern string) bool {
+	for _, c := range pattern {
+		switch c {
+		case '*', '?', '[':
+			return true
+		}
+	}
+
+	return false
+}
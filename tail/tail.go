@@ -0,0 +1,372 @@
+// Package tail follows a log file the way `tail -F` does: it keeps reading lines as they are
+// appended, and reopens the file when it is rotated (renamed or removed and recreated) or
+// truncated.
+package tail
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	defaultPollInterval = time.Millisecond * 250
+)
+
+// Line is a line read from the followed file
+type Line struct {
+	Text     string
+	Time     time.Time
+	Filename string
+	// Offset is the position in the file right after this line
+	Offset int64
+	Err    error
+	// Ack checkpoints the file right after this line once the line is processed, so it is not
+	// read again after a restart. It is nil without checkpoints.
+	Ack func()
+}
+
+// LinePolicy tells what happens to lines longer than Config.MaxLineSize
+type LinePolicy int
+
+const (
+	// LineTruncate keeps the beginning of the line, up to the maximum size
+	LineTruncate LinePolicy = iota
+	// LineSplit delivers the line in several lines of the maximum size
+	LineSplit
+	// LineDrop skips the line, reporting it with an error
+	LineDrop
+)
+
+// LineEnd tells how lines end
+type LineEnd struct {
+	// Delimiter is the byte ending lines, a newline when empty, e.g. "\x00" for the records
+	// written by find -print0
+	Delimiter string
+	// StripCR removes the carriage return ending lines, e.g. of files written with CRLF
+	StripCR bool
+}
+
+// Byte returns the byte ending lines
+func (e LineEnd) Byte() byte {
+	if e.Delimiter == "" {
+		return '\n'
+	}
+
+	return e.Delimiter[0]
+}
+
+// Config controls how a file is followed
+type Config struct {
+	// Offset to start reading the file at when it is first opened, later files created by
+	// rotation are read from the beginning
+	Offset int64
+	// PollInterval is how often the file is checked for new lines and rotation, defaults to 250ms
+	PollInterval time.Duration
+	// MaxLineSize bounds the size of lines in bytes, LinePolicy applies to longer lines so a
+	// giant line is not held in memory. Lines of any size are read when 0.
+	MaxLineSize int
+	LinePolicy  LinePolicy
+	// LineEnd tells how lines end, with newlines by default
+	LineEnd LineEnd
+	// ScanInterval is how often glob patterns and directories are scanned for new and removed
+	// files, defaults to 10s
+	ScanInterval time.Duration
+	// Checkpoints records the offset of the last line acknowledged in the file, which is
+	// resumed from when the file is opened again, e.g. after a restart
+	Checkpoints *Checkpoints
+}
+
+// Tailer follows a file and delivers its lines on Lines, which is closed after Stop
+type Tailer struct {
+	Lines    chan *Line
+	Filename string
+
+	config Config
+	done   chan struct{}
+	exited chan struct{}
+	// reopen requests the file to be opened again
+	reopen chan struct{}
+
+	file   *os.File
+	info   os.FileInfo
+	reader *bufio.Reader
+	offset int64
+	// partial holds the beginning of a line whose end has not been read yet
+	partial []byte
+	// overflow counts the bytes of the line beyond MaxLineSize which were discarded, and split
+	// tells whether pieces of the line were delivered already
+	overflow int64
+	split    bool
+}
+
+// Follow starts following the file at path, the file does not need to exist yet
+func Follow(path string, config Config) *Tailer {
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+
+	t := &Tailer{
+		Lines:    make(chan *Line),
+		Filename: path,
+		config:   config,
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+		reopen:   make(chan struct{}, 1),
+	}
+
+	go t.run()
+
+	return t
+}
+
+// Stop stops following the file and waits until Lines is closed
+func (t *Tailer) Stop() {
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+
+	<-t.exited
+}
+
+// Reopen closes the file and opens the file at the path again, without waiting for the next check
+// for rotation, e.g. when told by logrotate that the file was rotated. Reading resumes where it
+// was when the path still names the same file.
+func (t *Tailer) Reopen() {
+	select {
+	case t.reopen <- struct{}{}:
+	default:
+	}
+}
+
+func (t *Tailer) run() {
+	defer close(t.exited)
+	defer close(t.Lines)
+	defer t.close()
+
+	if !t.open(t.config.Offset) {
+		return
+	}
+
+	for {
+		if !t.readLines() {
+			return
+		}
+
+		reopen := false
+		select {
+		case <-t.done:
+			return
+		case <-t.reopen:
+			reopen = true
+		case <-time.After(t.config.PollInterval):
+		}
+
+		info, err := os.Stat(t.Filename)
+		switch {
+		case err != nil || !os.SameFile(t.info, info):
+			// Rotated, drain what was written to the old file before reopening
+			if !t.readLines() {
+				return
+			}
+			if len(t.partial) > 0 || t.overflow > 0 {
+				t.endLine()
+			}
+
+			t.close()
+			if !t.open(0) {
+				return
+			}
+		case info.Size() < t.offset:
+			// Truncated in place, start over from the beginning
+			t.file.Seek(0, io.SeekStart)
+			t.reader.Reset(t.file)
+			t.offset = 0
+			t.partial, t.overflow, t.split = t.partial[:0], 0, false
+		case reopen:
+			if !t.readLines() {
+				return
+			}
+			t.reopenSame()
+		}
+	}
+}
+
+// reopenSame opens the file again at the offset reached, when the path names the same file
+func (t *Tailer) reopenSame() {
+	file, err := os.Open(t.Filename)
+	if err != nil {
+		return
+	}
+
+	info, err := file.Stat()
+	if err != nil || !os.SameFile(t.info, info) {
+		file.Close()
+		return
+	}
+	if _, err := file.Seek(t.offset, io.SeekStart); err != nil {
+		file.Close()
+		return
+	}
+
+	t.file.Close()
+	t.file, t.info = file, info
+	t.reader.Reset(file)
+}
+
+// open opens the file at offset, waiting for it to be created. It returns false when stopped.
+func (t *Tailer) open(offset int64) bool {
+	reported := false
+
+	for {
+		file, err := os.Open(t.Filename)
+		if err == nil {
+			info, statErr := file.Stat()
+			if statErr == nil {
+				if t.config.Checkpoints != nil {
+					if checkpoint, ok := t.config.Checkpoints.offset(info); ok {
+						offset = checkpoint
+					}
+				}
+				if offset > info.Size() {
+					offset = 0
+				}
+
+				if _, err = file.Seek(offset, io.SeekStart); err == nil {
+					t.file, t.info, t.offset = file, info, offset
+					t.reader = bufio.NewReader(file)
+					return true
+				}
+			}
+
+			err = statErr
+			file.Close()
+		}
+
+		if !reported {
+			reported = true
+			if !t.send(&Line{Filename: t.Filename, Time: time.Now(), Err: fmt.Errorf("Waiting for file '%s': %v", t.Filename, err)}) {
+				return false
+			}
+		}
+
+		select {
+		case <-t.done:
+			return false
+		case <-time.After(t.config.PollInterval):
+		}
+	}
+}
+
+// readLines delivers all complete lines available in the file. It returns false when stopped.
+func (t *Tailer) readLines() bool {
+	delimiter := t.config.LineEnd.Byte()
+	for {
+		// Reads at most the size of the buffer at once, so long lines are bounded by MaxLineSize
+		text, err := t.reader.ReadSlice(delimiter)
+		t.offset += int64(len(text))
+
+		if err == nil {
+			if !t.appendLine(text[:len(text)-1], 1) || !t.endLine() {
+				return false
+			}
+			continue
+		}
+
+		if !t.appendLine(text, 0) {
+			return false
+		}
+		switch err {
+		case bufio.ErrBufferFull:
+		case io.EOF:
+			return true
+		default:
+			return t.send(&Line{Filename: t.Filename, Time: time.Now(), Offset: t.offset, Err: err})
+		}
+	}
+}
+
+// appendLine adds text to the line being read, applying the line policy beyond MaxLineSize. skip
+// is the number of bytes read after text, the delimiter, to locate where the pieces of a split line
+// end. It returns false when stopped.
+func (t *Tailer) appendLine(text []byte, skip int) bool {
+	max := t.config.MaxLineSize
+	for max > 0 && len(t.partial)+len(text) > max {
+		n := max - len(t.partial)
+		if n < 0 {
+			n = 0
+		}
+		t.partial = append(t.partial, text[:n]...)
+		text = text[n:]
+
+		if t.config.LinePolicy != LineSplit {
+			t.overflow += int64(len(text))
+			return true
+		}
+
+		// The rest of the line starts right after the piece, which is where it is resumed from
+		if !t.emit(string(t.partial), t.offset-int64(len(text)+skip)) {
+			return false
+		}
+		t.partial, t.split = t.partial[:0], true
+	}
+
+	t.partial = append(t.partial, text...)
+	return true
+}
+
+// endLine delivers the line read, according to the line policy when it was too long. It returns
+// false when stopped.
+func (t *Tailer) endLine() bool {
+	partial := t.partial
+	if t.config.LineEnd.StripCR && len(partial) > 0 && partial[len(partial)-1] == '\r' {
+		partial = partial[:len(partial)-1]
+	}
+	text, overflow, split := string(partial), t.overflow, t.split
+	t.partial, t.overflow, t.split = t.partial[:0], 0, false
+
+	switch {
+	case overflow > 0 && t.config.LinePolicy == LineDrop:
+		return t.send(&Line{Filename: t.Filename, Time: time.Now(), Offset: t.offset,
+			Err: &DroppedLineError{Size: int64(len(text)) + overflow, MaxLineSize: t.config.MaxLineSize}})
+	case split && text == "":
+		// The line ended right at the end of its last piece
+		return true
+	}
+
+	return t.emit(text, t.offset)
+}
+
+// emit delivers a line, offset being the position in the file right after it
+func (t *Tailer) emit(text string, offset int64) bool {
+	line := &Line{Text: text, Time: time.Now(), Filename: t.Filename, Offset: offset}
+
+	if checkpoints := t.config.Checkpoints; checkpoints != nil {
+		info, path := t.info, t.Filename
+		line.Ack = func() {
+			checkpoints.set(info, path, offset)
+		}
+	}
+
+	return t.send(line)
+}
+
+func (t *Tailer) send(line *Line) bool {
+	select {
+	case t.Lines <- line:
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+func (t *Tailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
@@ -0,0 +1,201 @@
+package tail
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tempDir creates a directory to be removed by the returned function
+func tempDir(tb testing.TB) (string, func()) {
+	dir, err := ioutil.TempDir("", "tail")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// writeLines writes n generated lines ending with end to the file at path, and returns their size
+func writeLines(tb testing.TB, path string, n int, end string) int64 {
+	f, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	size := 0
+	for i := 0; i < n; i++ {
+		written, _ := fmt.Fprintf(w, "2018-06-01T12:00:00Z INFO GET /api/orders status=200 duration=%dms request=%08x%s", i%250, i, end)
+		size += written
+	}
+	if err := w.Flush(); err != nil {
+		tb.Fatal(err)
+	}
+
+	return int64(size)
+}
+
+// expectLines reads the next lines of tailer and compares them to lines, "!" standing for a
+// dropped line. Errors waiting for the file are skipped.
+func expectLines(t *testing.T, tailer *Tailer, lines ...string) {
+	var read []string
+	for len(read) < len(lines) {
+		select {
+		case line := <-tailer.Lines:
+			if _, ok := line.Err.(*DroppedLineError); ok {
+				read = append(read, "!")
+			} else if line.Err == nil {
+				read = append(read, line.Text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Read %q, expecting %q", read, lines)
+		}
+	}
+
+	if fmt.Sprintf("%q", read) != fmt.Sprintf("%q", lines) {
+		t.Errorf("Read %q, expecting %q", read, lines)
+	}
+}
+
+// appendFile appends text to the file at path, creating it when missing
+func appendFile(t *testing.T, path, text string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowRotation(t *testing.T) {
+	tests := []struct {
+		name   string
+		rotate func(path string) error
+	}{
+		{"renamed", func(path string) error { return os.Rename(path, path+".1") }},
+		{"removed", os.Remove},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, remove := tempDir(t)
+			defer remove()
+			path := filepath.Join(dir, "app.log")
+			appendFile(t, path, "first\n")
+
+			tailer := Follow(path, Config{PollInterval: 10 * time.Millisecond})
+			defer tailer.Stop()
+			expectLines(t, tailer, "first")
+
+			// A line appended while rotating is read before those of the new file
+			old, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer old.Close()
+			if err := test.rotate(path); err != nil {
+				t.Fatal(err)
+			}
+			old.WriteString("second\n")
+			appendFile(t, path, "third\n")
+			expectLines(t, tailer, "second", "third")
+		})
+	}
+}
+
+func TestFollowTruncation(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "first\nsecond\n")
+
+	tailer := Follow(path, Config{PollInterval: 10 * time.Millisecond})
+	defer tailer.Stop()
+	expectLines(t, tailer, "first", "second")
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "third\n")
+	expectLines(t, tailer, "third")
+}
+
+func TestFollowPartialLine(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "fir")
+
+	tailer := Follow(path, Config{PollInterval: 10 * time.Millisecond, Offset: 1})
+	defer tailer.Stop()
+
+	// The line is delivered once its end is written, from the offset
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "st\nsecond\n")
+	expectLines(t, tailer, "irst", "second")
+}
+
+func TestFollowLineEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		lineEnd LineEnd
+		text    string
+		lines   []string
+	}{
+		{"newline", LineEnd{}, "a\r\nb\n", []string{"a\r", "b"}},
+		{"crlf", LineEnd{StripCR: true}, "a\r\nb\n", []string{"a", "b"}},
+		{"nul", LineEnd{Delimiter: "\x00"}, "a\nb\x00c\x00", []string{"a\nb", "c"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, remove := tempDir(t)
+			defer remove()
+			path := filepath.Join(dir, "app.log")
+			appendFile(t, path, test.text)
+
+			tailer := Follow(path, Config{PollInterval: 10 * time.Millisecond, LineEnd: test.lineEnd})
+			defer tailer.Stop()
+			expectLines(t, tailer, test.lines...)
+		})
+	}
+}
+
+func benchmarkTailRead(b *testing.B, config Config) {
+	dir, remove := tempDir(b)
+	defer remove()
+	path := filepath.Join(dir, "bench.log")
+	b.SetBytes(writeLines(b, path, b.N, "\n") / int64(b.N))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	t := Follow(path, config)
+	defer t.Stop()
+	for i := 0; i < b.N; i++ {
+		select {
+		case line := <-t.Lines:
+			if line.Err != nil {
+				b.Fatal(line.Err)
+			}
+		case <-time.After(5 * time.Second):
+			b.Fatalf("Read %d lines of %d", i, b.N)
+		}
+	}
+}
+
+func BenchmarkTailRead(b *testing.B) {
+	benchmarkTailRead(b, Config{})
+}
+
+func BenchmarkTailReadMaxLineSize(b *testing.B) {
+	benchmarkTailRead(b, Config{MaxLineSize: 64, LinePolicy: LineTruncate})
+}